@@ -27,6 +27,7 @@ import (
 	"github.com/knative/pkg/signals"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
@@ -58,7 +59,11 @@ func main() {
 
 	// We are running both the receiver (takes messages in from the Broker) and the dispatcher (send
 	// the messages to the triggers' subscribers) in this binary.
-	receiver, err := broker.New(logger, mgr.GetClient())
+	kc, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		logger.Fatal("Unable to create kubernetes client.", zap.Error(err))
+	}
+	receiver, err := broker.New(logger, mgr.GetClient(), kc.CoreV1())
 	if err != nil {
 		logger.Fatal("Error creating Receiver", zap.Error(err))
 	}
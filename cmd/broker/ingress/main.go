@@ -32,6 +32,8 @@ import (
 	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
 	"github.com/knative/eventing/pkg/broker"
 	"github.com/knative/eventing/pkg/provisioners"
+	"github.com/knative/eventing/pkg/utils"
+	"github.com/knative/eventing/pkg/utils/resolve"
 	"github.com/knative/pkg/signals"
 	"go.uber.org/zap"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
@@ -61,10 +63,10 @@ func main() {
 		logger.Fatal("Unable to add eventingv1alpha1 scheme", zap.Error(err))
 	}
 
-	channelURI := &url.URL{
-		Scheme: "http",
-		Host:   getRequiredEnv("CHANNEL"),
-		Path:   "/",
+	// The Channel's address may include a path if the Channel is path addressed.
+	channelURI, err := url.Parse(resolve.DomainToURL(getRequiredEnv("CHANNEL")))
+	if err != nil {
+		logger.Fatal("Unable to parse the Channel's address", zap.Error(err))
 	}
 
 	// Create an event handler.
@@ -84,7 +86,8 @@ func main() {
 	}
 
 	// Run the event handler with the manager.
-	err = mgr.Add(h)
+	drainingHandler := utils.NewDrainingStart(logger, h)
+	err = mgr.Add(drainingHandler)
 	if err != nil {
 		logger.Fatal("Unable to add handler", zap.Error(err))
 	}
@@ -95,9 +98,10 @@ func main() {
 	if err = mgr.Start(stopCh); err != nil {
 		logger.Error("manager.Start() returned an error", zap.Error(err))
 	}
+	// The handler shuts down the CloudEvents receiver gracefully, let it finish.
+	logger.Info("Draining...")
+	drainingHandler.Wait()
 	logger.Info("Exiting...")
-
-	// TODO Gracefully shutdown the server. CloudEvents SDK doesn't seem to let us do that today.
 }
 
 func getRequiredEnv(envKey string) string {
@@ -26,10 +26,18 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
+	"github.com/knative/eventing/pkg/provisioners/inmemory/channel"
+	"github.com/knative/eventing/pkg/sidecar/channelwatcher"
 	"github.com/knative/eventing/pkg/sidecar/configmap/filesystem"
 	"github.com/knative/eventing/pkg/sidecar/configmap/watcher"
+	"github.com/knative/eventing/pkg/sidecar/durable"
+	"github.com/knative/eventing/pkg/sidecar/fanout"
+	"github.com/knative/eventing/pkg/sidecar/multichannelfanout"
 	"github.com/knative/eventing/pkg/sidecar/swappable"
 	"github.com/knative/eventing/pkg/utils"
 	"github.com/knative/pkg/system"
@@ -57,6 +65,8 @@ var (
 	configMapNoticer   string
 	configMapNamespace string
 	configMapName      string
+	durableLogDir      string
+	durableGracePeriod time.Duration
 )
 
 func init() {
@@ -64,6 +74,8 @@ func init() {
 	flag.StringVar(&configMapNoticer, "config_map_noticer", "", fmt.Sprintf("The system to notice changes to the ConfigMap. Valid values are: %s", configMapNoticerValues()))
 	flag.StringVar(&configMapNamespace, "config_map_namespace", system.Namespace(), "The namespace of the ConfigMap that is watched for configuration.")
 	flag.StringVar(&configMapName, "config_map_name", defaultConfigMapName, "The name of the ConfigMap that is watched for configuration.")
+	flag.StringVar(&durableLogDir, "durable_log_dir", "", "If set, messages are stored in a log under this directory, which should be on a persistent volume, before being acknowledged. Undelivered messages are delivered again after a restart.")
+	flag.DurationVar(&durableGracePeriod, "durable_log_grace_period", durable.DefaultGracePeriod, "How long the log of a Channel, or the progress of a subscriber, is kept after it disappears from the configuration.")
 }
 
 func configMapNoticerValues() string {
@@ -84,19 +96,87 @@ func main() {
 		logger.Fatal("--sidecar_port flag must be set")
 	}
 
-	sh, err := swappable.NewEmptyHandler(logger)
+	configSource, err := channelwatcher.ConfigSourceFromEnv()
 	if err != nil {
-		logger.Fatal("Unable to create swappable.Handler", zap.Error(err))
+		logger.Fatal("Unable to read the config source", zap.Error(err))
+	}
+	receiverOpts, err := provisioners.ReceiverOptionsFromEnv()
+	if err != nil {
+		logger.Fatal("Unable to read the MessageReceiver options", zap.Error(err))
+	}
+	handlerOpts, err := fanout.HandlerOptionsFromEnv()
+	if err != nil {
+		logger.Fatal("Unable to read the fanout.Handler options", zap.Error(err))
+	}
+	shutdownTimeout, err := provisioners.ShutdownTimeoutFromEnv()
+	if err != nil {
+		logger.Fatal("Unable to read the shutdown timeout", zap.Error(err))
 	}
 
-	mgr, err := setupConfigMapNoticer(logger, sh.UpdateConfig)
+	mgr, err := manager.New(config.GetConfigOrDie(), manager.Options{
+		MetricsBindAddress: provisioners.MetricsBindAddress,
+	})
 	if err != nil {
-		logger.Fatal("Unable to create configMap noticer.", zap.Error(err))
+		logger.Fatal("Error starting manager.", zap.Error(err))
+	}
+
+	// All the Channels share a single dispatcher, so that they also share the HTTP clients built
+	// for the subscribers' TLS Secrets.
+	kc, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		logger.Fatal("Unable to create kubernetes client.", zap.Error(err))
+	}
+	dispatcher := provisioners.NewMessageDispatcher(logger.Sugar(), provisioners.WithSecretClient(kc.CoreV1()))
+	// inFlight counts the messages queued by the asynchronous Channels, so that they are dispatched
+	// before exiting.
+	inFlight := fanout.NewInFlight()
+	handlerOpts = append(handlerOpts,
+		fanout.WithReceiverOptions(receiverOpts...),
+		fanout.WithDispatcher(dispatcher),
+		fanout.WithInFlight(inFlight))
+	var store *durable.Store
+	if durableLogDir != "" {
+		if store, err = durable.NewStore(logger, durableLogDir, dispatcher, durable.WithGracePeriod(durableGracePeriod)); err != nil {
+			logger.Fatal("Unable to create the durable store", zap.Error(err))
+		}
+		if err = mgr.Add(store); err != nil {
+			logger.Fatal("Unable to add the durable store", zap.Error(err))
+		}
+		handlerOpts = append(handlerOpts, fanout.WithMessageLog(store))
+	}
+	sh, err := swappable.NewEmptyHandler(logger, handlerOpts...)
+	if err != nil {
+		logger.Fatal("Unable to create swappable.Handler", zap.Error(err))
+	}
+
+	configUpdated := sh.UpdateConfig
+	if store != nil {
+		// The store must know about new Channels before the handler accepts messages for them.
+		configUpdated = func(config *multichannelfanout.Config) error {
+			if err := store.UpdateConfig(config); err != nil {
+				return err
+			}
+			return sh.UpdateConfig(config)
+		}
+	}
+	switch configSource {
+	case channelwatcher.ConfigSourceChannels:
+		// Add the Channel type to the manager's scheme, so that it can be watched.
+		eventingv1alpha1.AddToScheme(mgr.GetScheme())
+		err = channelwatcher.New(mgr, logger, channel.IsControlled, channel.MultiChannelFanoutConfig, configUpdated)
+		if err != nil {
+			logger.Fatal("Unable to create the Channel watcher.", zap.Error(err))
+		}
+	case channelwatcher.ConfigSourceConfigMap:
+		if err = setupConfigMapNoticer(logger, mgr, configUpdated); err != nil {
+			logger.Fatal("Unable to create configMap noticer.", zap.Error(err))
+		}
 	}
 
+	probes := &probeHandler{h: sh}
 	s := &http.Server{
 		Addr:         fmt.Sprintf(":%d", port),
-		Handler:      sh,
+		Handler:      probes,
 		ErrorLog:     zap.NewStdLog(logger),
 		ReadTimeout:  readTimeout,
 		WriteTimeout: writeTimeout,
@@ -116,22 +196,29 @@ func main() {
 	if err = mgr.Start(stopCh); err != nil {
 		logger.Error("manager.Start() returned an error", zap.Error(err))
 	}
-	logger.Info("Exiting...")
+	logger.Info("Draining...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	// The server stops accepting messages and waits for those being received. The messages queued
+	// by the asynchronous Channels then get what is left of the shutdown timeout to be dispatched.
+	probes.setDraining()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 	if err = s.Shutdown(ctx); err != nil {
 		logger.Error("Shutdown returned an error", zap.Error(err))
 	}
-}
-
-func setupConfigMapNoticer(logger *zap.Logger, configUpdated swappable.UpdateConfig) (manager.Manager, error) {
-	mgr, err := manager.New(config.GetConfigOrDie(), manager.Options{})
-	if err != nil {
-		logger.Error("Error starting manager.", zap.Error(err))
-		return nil, err
+	if err = inFlight.Wait(ctx); err != nil {
+		logger.Warn("Messages were still being dispatched at the end of the shutdown timeout", zap.Error(err))
+	}
+	// Nothing appends to the store anymore. The messages it did not deliver yet are delivered
+	// after the restart.
+	if store != nil {
+		store.Close()
 	}
+	logger.Info("Exiting...")
+}
 
+func setupConfigMapNoticer(logger *zap.Logger, mgr manager.Manager, configUpdated swappable.UpdateConfig) error {
+	var err error
 	switch configMapNoticer {
 	case cmnfVolume:
 		err = setupConfigMapVolume(logger, mgr, configUpdated)
@@ -140,11 +227,7 @@ func setupConfigMapNoticer(logger *zap.Logger, configUpdated swappable.UpdateCon
 	default:
 		err = fmt.Errorf("need to provide the --config_map_noticer flag (valid values are %s)", configMapNoticerValues())
 	}
-	if err != nil {
-		return nil, err
-	}
-
-	return mgr, nil
+	return err
 }
 
 func setupConfigMapVolume(logger *zap.Logger, mgr manager.Manager, configUpdated swappable.UpdateConfig) error {
@@ -189,3 +272,30 @@ func (r *runnableServer) Start(<-chan struct{}) error {
 	r.logger.Info("Fanout sidecar listening", zap.String("address", r.s.Addr))
 	return r.s.ListenAndServe()
 }
+
+// probeHandler serves the liveness and readiness probes, and hands the other requests to h. The
+// sidecar is ready until it starts draining.
+type probeHandler struct {
+	h http.Handler
+	// draining is set to 1 once draining started. It is accessed atomically.
+	draining int32
+}
+
+func (p *probeHandler) setDraining() {
+	atomic.StoreInt32(&p.draining, 1)
+}
+
+func (p *probeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case provisioners.LivenessPath:
+		w.WriteHeader(http.StatusOK)
+	case provisioners.ReadinessPath:
+		if atomic.LoadInt32(&p.draining) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		p.h.ServeHTTP(w, r)
+	}
+}
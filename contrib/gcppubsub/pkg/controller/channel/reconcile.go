@@ -25,7 +25,6 @@ import (
 	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
 	"github.com/knative/eventing/pkg/logging"
 	util "github.com/knative/eventing/pkg/provisioners"
-	"github.com/knative/eventing/pkg/reconciler/names"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2/google"
 	v1 "k8s.io/api/core/v1"
@@ -333,6 +332,9 @@ func (r *reconciler) planGcpResources(ctx context.Context, c *eventingv1alpha1.C
 				Ref:           subscriber.Ref,
 				SubscriberURI: subscriber.SubscriberURI,
 				ReplyURI:      subscriber.ReplyURI,
+				TLSSecretRef:  subscriber.TLSSecretRef,
+				AuthType:      subscriber.AuthType,
+				AuthSecretRef: subscriber.AuthSecretRef,
 				Subscription:  subscription,
 			})
 		}
@@ -362,7 +364,7 @@ func (r *reconciler) createK8sService(ctx context.Context, c *eventingv1alpha1.C
 		return nil, err
 	}
 
-	c.Status.SetAddress(names.ServiceHostName(svc.Name, svc.Namespace))
+	c.Status.SetAddress(util.ChannelAddressHostName(c, svc))
 	return svc, nil
 }
 
@@ -25,6 +25,7 @@ import (
 	"github.com/knative/eventing/contrib/gcppubsub/pkg/util"
 	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
 	"github.com/knative/eventing/pkg/provisioners"
+	"github.com/knative/eventing/pkg/utils"
 	"github.com/knative/pkg/signals"
 	"go.uber.org/zap"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
@@ -47,7 +48,9 @@ func main() {
 
 	logger.Info("Starting...")
 
-	mgr, err := manager.New(config.GetConfigOrDie(), manager.Options{})
+	mgr, err := manager.New(config.GetConfigOrDie(), manager.Options{
+		MetricsBindAddress: provisioners.MetricsBindAddress,
+	})
 	if err != nil {
 		logger.Fatal("Error starting up.", zap.Error(err))
 	}
@@ -61,9 +64,16 @@ func main() {
 	// PubSub) and the dispatcher (takes messages in PubSub and sends them in cluster) in this
 	// binary.
 
-	_, runnables := receiver.New(logger.Desugar(), mgr.GetClient(), util.GcpPubSubClientCreator)
+	receiverOpts, err := provisioners.ReceiverOptionsFromEnv()
+	if err != nil {
+		logger.Fatal("Unable to read the MessageReceiver options", zap.Error(err))
+	}
+	_, runnables := receiver.New(logger.Desugar(), mgr.GetClient(), util.GcpPubSubClientCreator, receiverOpts...)
+	drainingRunnables := make([]*utils.DrainingStart, 0, len(runnables))
 	for _, runnable := range runnables {
-		err = mgr.Add(runnable)
+		drainingRunnable := utils.NewDrainingStart(logger.Desugar(), runnable)
+		drainingRunnables = append(drainingRunnables, drainingRunnable)
+		err = mgr.Add(drainingRunnable)
 		if err != nil {
 			logger.Fatal("Unable to start the receivers runnables", zap.Error(err), zap.Any("runnable", runnable))
 		}
@@ -82,4 +92,9 @@ func main() {
 	if err != nil {
 		logger.Fatal("Manager.Start() returned an error", zap.Error(err))
 	}
+	logger.Info("Draining...")
+	for _, drainingRunnable := range drainingRunnables {
+		drainingRunnable.Wait()
+	}
+	logger.Info("Exiting...")
 }
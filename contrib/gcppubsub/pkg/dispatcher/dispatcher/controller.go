@@ -21,6 +21,7 @@ import (
 	"sync"
 	"time"
 
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/util/workqueue"
 
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -57,13 +58,19 @@ func New(mgr manager.Manager, logger *zap.Logger) (controller.Controller, error)
 	// reconcileChan is used when the dispatcher itself needs to force reconciliation of a Channel.
 	reconcileChan := make(chan event.GenericEvent)
 
+	kc, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		logger.Error("Unable to create kubernetes client.", zap.Error(err))
+		return nil, err
+	}
+
 	// Setup a new controller to pull messages from GCP PubSub for Channels that belong to this
 	// Cluster Provisioner (gcp-pubsub).
 	r := &reconciler{
 		recorder: mgr.GetRecorder(controllerAgentName),
 		logger:   logger,
 
-		dispatcher:    provisioners.NewMessageDispatcher(logger.Sugar()),
+		dispatcher:    provisioners.NewMessageDispatcher(logger.Sugar(), provisioners.WithSecretClient(kc.CoreV1())),
 		reconcileChan: reconcileChan,
 
 		pubSubClientCreator: pubsubutil.GcpPubSubClientCreator,
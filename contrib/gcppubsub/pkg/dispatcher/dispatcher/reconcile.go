@@ -301,7 +301,10 @@ func (r *reconciler) createSubscriptionUnderLock(ctx context.Context, enqueueCha
 func (r *reconciler) receiveMessagesBlocking(ctxWithCancel context.Context, enqueueChannelForReconciliation func(), channelKey channelName, sub pubsubutil.GcpPubSubSubscriptionStatus, gcpProject string, psc pubsubutil.PubSubClient) {
 	subscription := psc.SubscriptionInProject(sub.Subscription, gcpProject)
 	defaults := provisioners.DispatchDefaults{
-		Namespace: channelKey.Namespace,
+		Namespace:     channelKey.Namespace,
+		TLSSecretRef:  sub.TLSSecretRef,
+		AuthType:      sub.AuthType,
+		AuthSecretRef: sub.AuthSecretRef,
 	}
 	subKey := subscriptionKey(&sub)
 
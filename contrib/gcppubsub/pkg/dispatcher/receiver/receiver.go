@@ -43,8 +43,8 @@ type Receiver struct {
 }
 
 // New creates a new Receiver and its associated MessageReceiver. The caller is responsible for
-// Start()ing the returned MessageReceiver.
-func New(logger *zap.Logger, client client.Client, pubSubClientCreator util.PubSubClientCreator) (*Receiver, []manager.Runnable) {
+// Start()ing the returned MessageReceiver. receiverOpts are applied to the MessageReceiver.
+func New(logger *zap.Logger, client client.Client, pubSubClientCreator util.PubSubClientCreator, receiverOpts ...provisioners.ReceiverOption) (*Receiver, []manager.Runnable) {
 	r := &Receiver{
 		logger: logger,
 		client: client,
@@ -52,11 +52,11 @@ func New(logger *zap.Logger, client client.Client, pubSubClientCreator util.PubS
 		pubSubClientCreator: pubSubClientCreator,
 		cache:               cache.NewTTL(),
 	}
-	return r, []manager.Runnable{r.newMessageReceiver(), r.cache}
+	return r, []manager.Runnable{r.newMessageReceiver(receiverOpts...), r.cache}
 }
 
-func (r *Receiver) newMessageReceiver() *provisioners.MessageReceiver {
-	return provisioners.NewMessageReceiver(r.sendEventToTopic, r.logger.Sugar())
+func (r *Receiver) newMessageReceiver(opts ...provisioners.ReceiverOption) *provisioners.MessageReceiver {
+	return provisioners.NewMessageReceiver(r.sendEventToTopic, r.logger.Sugar(), opts...)
 }
 
 // sendEventToTopic sends a message to the Cloud Pub/Sub Topic backing the Channel.
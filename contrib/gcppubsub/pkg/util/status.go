@@ -55,6 +55,15 @@ type GcpPubSubSubscriptionStatus struct {
 	// ReplyURI is a copy of the ReplyURI of this Subscription.
 	// +optional
 	ReplyURI string `json:"replyURI,omitempty"`
+	// TLSSecretRef is a copy of the TLSSecretRef of this Subscription.
+	// +optional
+	TLSSecretRef *corev1.LocalObjectReference `json:"tlsSecretRef,omitempty"`
+	// AuthType is a copy of the AuthType of this Subscription.
+	// +optional
+	AuthType string `json:"authType,omitempty"`
+	// AuthSecretRef is a copy of the AuthSecretRef of this Subscription.
+	// +optional
+	AuthSecretRef *corev1.LocalObjectReference `json:"authSecretRef,omitempty"`
 
 	// Subscription is the name of the PubSub Subscription resource in GCP that represents this
 	// Knative Eventing Subscription.
@@ -24,6 +24,9 @@ import (
 
 	provisionerController "github.com/knative/eventing/contrib/kafka/pkg/controller"
 	"github.com/knative/eventing/contrib/kafka/pkg/dispatcher"
+	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
+	"github.com/knative/eventing/pkg/sidecar/channelwatcher"
 	"github.com/knative/eventing/pkg/sidecar/configmap/watcher"
 	"github.com/knative/eventing/pkg/utils"
 	"github.com/knative/pkg/signals"
@@ -43,6 +46,12 @@ func main() {
 	if configMapNamespace == "" {
 		configMapNamespace = system.Namespace()
 	}
+	// When POD_NAME is set, the replicas behind the dispatcher Service share the Subscriptions.
+	podName := os.Getenv("POD_NAME")
+	endpointsName := os.Getenv("DISPATCHER_ENDPOINTS_NAME")
+	if endpointsName == "" {
+		endpointsName = fmt.Sprintf("%s-dispatcher", provisionerController.Name)
+	}
 
 	flag.Parse()
 	logger, err := zap.NewProduction()
@@ -50,35 +59,68 @@ func main() {
 		log.Fatalf("unable to create logger: %v", err)
 	}
 
+	configSource, err := channelwatcher.ConfigSourceFromEnv()
+	if err != nil {
+		logger.Fatal("unable to read the config source", zap.Error(err))
+	}
+
 	provisionerConfig, err := provisionerController.GetProvisionerConfig("/etc/config-provisioner")
 	if err != nil {
 		logger.Fatal("unable to load provisioner config", zap.Error(err))
 	}
 
-	mgr, err := manager.New(config.GetConfigOrDie(), manager.Options{})
+	mgr, err := manager.New(config.GetConfigOrDie(), manager.Options{
+		MetricsBindAddress: provisioners.MetricsBindAddress,
+	})
 	if err != nil {
 		logger.Fatal("unable to create manager.", zap.Error(err))
 	}
 
-	kafkaDispatcher, err := dispatcher.NewDispatcher(provisionerConfig.Brokers, provisionerConfig.ConsumerMode, logger)
+	receiverOpts, err := provisioners.ReceiverOptionsFromEnv()
 	if err != nil {
-		logger.Fatal("unable to create kafka dispatcher.", zap.Error(err))
-	}
-	if err = mgr.Add(kafkaDispatcher); err != nil {
-		logger.Fatal("Unable to add kafkaDispatcher", zap.Error(err))
+		logger.Fatal("unable to read the MessageReceiver options", zap.Error(err))
 	}
 
+	// Add the eventing types to the manager's scheme, so that Channels can be watched and the
+	// Lagging condition of Subscriptions can be updated.
+	eventingv1alpha1.AddToScheme(mgr.GetScheme())
+
 	kc, err := kubernetes.NewForConfig(mgr.GetConfig())
 	if err != nil {
 		logger.Fatal("unable to create kubernetes client.", zap.Error(err))
 	}
-
-	cmw, err := watcher.NewWatcher(logger, kc, configMapNamespace, configMapName, kafkaDispatcher.UpdateConfig)
+	kafkaDispatcher, err := dispatcher.NewDispatcher(provisionerConfig, logger, mgr.GetClient(), kc.CoreV1(), receiverOpts...)
 	if err != nil {
-		logger.Fatal("unable to create configMap watcher", zap.String("configMap", fmt.Sprintf("%s/%s", configMapNamespace, configMapName)))
+		logger.Fatal("unable to create kafka dispatcher.", zap.Error(err))
+	}
+	drainingDispatcher := utils.NewDrainingStart(logger, kafkaDispatcher)
+	if err = mgr.Add(drainingDispatcher); err != nil {
+		logger.Fatal("Unable to add kafkaDispatcher", zap.Error(err))
 	}
-	if err = mgr.Add(utils.NewBlockingStart(logger, cmw)); err != nil {
-		logger.Fatal("Unable to add the configMap watcher to the manager", zap.Error(err))
+
+	if podName != "" {
+		// Sharding must be enabled before the dispatcher is configured.
+		kafkaDispatcher.EnableSharding(podName)
+		mw := dispatcher.NewMembersWatcher(logger, kc, system.Namespace(), endpointsName, kafkaDispatcher.SetMembers)
+		if err = mgr.Add(mw); err != nil {
+			logger.Fatal("Unable to add the dispatcher replicas watcher to the manager", zap.Error(err))
+		}
+	}
+
+	switch configSource {
+	case channelwatcher.ConfigSourceChannels:
+		err = channelwatcher.New(mgr, logger, provisionerController.IsControlled, provisionerController.MultiChannelFanoutConfig, kafkaDispatcher.UpdateConfig)
+		if err != nil {
+			logger.Fatal("unable to create the Channel watcher", zap.Error(err))
+		}
+	case channelwatcher.ConfigSourceConfigMap:
+		cmw, err := watcher.NewWatcher(logger, kc, configMapNamespace, configMapName, kafkaDispatcher.UpdateConfig)
+		if err != nil {
+			logger.Fatal("unable to create configMap watcher", zap.String("configMap", fmt.Sprintf("%s/%s", configMapNamespace, configMapName)))
+		}
+		if err = mgr.Add(utils.NewBlockingStart(logger, cmw)); err != nil {
+			logger.Fatal("Unable to add the configMap watcher to the manager", zap.Error(err))
+		}
 	}
 
 	// set up signals so we handle the first shutdown signal gracefully
@@ -87,5 +129,7 @@ func main() {
 	if err != nil {
 		logger.Fatal("Manager.Start() returned an error", zap.Error(err))
 	}
+	logger.Info("Draining...")
+	drainingDispatcher.Wait()
 	logger.Info("Exiting...")
 }
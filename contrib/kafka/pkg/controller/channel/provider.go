@@ -57,6 +57,9 @@ type reconciler struct {
 	// Using a shared kafkaClusterAdmin does not work currently because of an issue with
 	// Shopify/sarama, see https://github.com/Shopify/sarama/issues/1162.
 	kafkaClusterAdmin sarama.ClusterAdmin
+	// kafkaClient is created on every reconciliation when nil. It is used to pass a fake client in
+	// the tests.
+	kafkaClient kafkaMetadataClient
 }
 
 // Verify the struct implements reconcile.Reconciler
@@ -31,12 +31,11 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/knative/eventing/contrib/kafka/pkg/controller"
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
 	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
 	util "github.com/knative/eventing/pkg/provisioners"
 	topicUtils "github.com/knative/eventing/pkg/provisioners/utils"
-	eventingNames "github.com/knative/eventing/pkg/reconciler/names"
 	"github.com/knative/eventing/pkg/sidecar/configmap"
-	"github.com/knative/eventing/pkg/sidecar/fanout"
 	"github.com/knative/eventing/pkg/sidecar/multichannelfanout"
 	"k8s.io/apimachinery/pkg/api/equality"
 )
@@ -58,6 +57,32 @@ const (
 type channelArgs struct {
 	NumPartitions     int32
 	ReplicationFactor int16
+	// PartitionKey is the CloudEvents attribute, or extension, whose value is the key of the
+	// records published to the topic, so that the events having the same value are delivered in
+	// order. Without it, events are spread across the partitions.
+	PartitionKey string
+	// RetentionMs is how long records are retained, in milliseconds, -1 retaining them forever.
+	// It sets the retention.ms topic config.
+	RetentionMs int64
+	// CleanupPolicy is "delete", "compact" or "compact,delete". It sets the cleanup.policy topic
+	// config.
+	CleanupPolicy string
+	// MinInSyncReplicas sets the min.insync.replicas topic config.
+	MinInSyncReplicas int32
+	// TopicConfig holds any other topic configs, by name. The configs set by the fields above
+	// take precedence.
+	TopicConfig map[string]string
+	// Topic names the topic of the Channel, instead of the name derived from the Channel's. It
+	// requires ExternalTopic, and can not name the topic of a Channel.
+	Topic string
+	// ExternalTopic marks Topic as an existing topic managed outside of Knative, for instance
+	// fed by other systems. It is neither created, altered nor deleted with the Channel.
+	ExternalTopic bool
+	// EventType and EventSource are the type and source of the CloudEvents wrapping the records
+	// of the topic that are not CloudEvents. They default to controller.DefaultEventType and
+	// to the name of the topic.
+	EventType   string
+	EventSource string
 }
 
 // Reconcile compares the actual state with the desired, and attempts to
@@ -172,17 +197,33 @@ func (r *reconciler) reconcile(ctx context.Context, channel *eventingv1alpha1.Ch
 		return true, nil
 	}
 
-	if err := r.provisionChannel(channel, kafkaClusterAdmin); err != nil {
+	kafkaClient := r.kafkaClient
+	if kafkaClient == nil {
+		var err error
+		kafkaClient, err = createKafkaClient(r.config)
+		if err != nil {
+			r.logger.Error("unable to build kafka client", zap.Error(err))
+			return false, err
+		}
+		defer kafkaClient.Close()
+	}
+
+	if err := r.provisionChannel(channel, kafkaClusterAdmin, kafkaClient); err != nil {
 		channel.Status.MarkNotProvisioned("NotProvisioned", "error while provisioning: %s", err)
 		return false, err
 	}
+	unsupported := unsupportedStarts(channel)
+	if len(unsupported) > 0 {
+		r.logger.Warn("subscribers with unsupported positions are not consumed", zap.Strings("unsupported", unsupported))
+	}
+	markStartSupported(&channel.Status, unsupported)
 
 	svc, err := util.CreateK8sService(ctx, r.client, channel)
 	if err != nil {
 		r.logger.Info("error creating the Channel's K8s Service", zap.Error(err))
 		return false, err
 	}
-	channel.Status.SetAddress(eventingNames.ServiceHostName(svc.Name, svc.Namespace))
+	channel.Status.SetAddress(util.ChannelAddressHostName(channel, svc))
 
 	_, err = util.CreateVirtualService(ctx, r.client, channel, svc)
 	if err != nil {
@@ -206,10 +247,10 @@ func (r *reconciler) shouldReconcile(channel *eventingv1alpha1.Channel, clusterC
 	return channel.Spec.Provisioner.Name == clusterChannelProvisioner.Name
 }
 
-func (r *reconciler) provisionChannel(channel *eventingv1alpha1.Channel, kafkaClusterAdmin sarama.ClusterAdmin) error {
-	topicName := topicUtils.TopicName(controller.KafkaChannelSeparator, channel.Namespace, channel.Name)
-	r.logger.Info("creating topic on kafka cluster", zap.String("topic", topicName))
-
+// provisionChannel creates the topics of channel, or updates them if they already exist. The
+// differences between the topics and the Channel's arguments that can not be fixed are reported
+// by its ChannelConditionTopicConfigured condition.
+func (r *reconciler) provisionChannel(channel *eventingv1alpha1.Channel, kafkaClusterAdmin sarama.ClusterAdmin, kafkaClient kafkaMetadataClient) error {
 	var arguments channelArgs
 
 	if channel.Spec.Arguments != nil {
@@ -228,24 +269,163 @@ func (r *reconciler) provisionChannel(channel *eventingv1alpha1.Channel, kafkaCl
 		arguments.ReplicationFactor = DefaultReplicationFactor
 	}
 
-	err := kafkaClusterAdmin.CreateTopic(topicName, &sarama.TopicDetail{
+	if err := validateTopicArguments(arguments, arguments.ReplicationFactor); err != nil {
+		return err
+	}
+
+	detail := &sarama.TopicDetail{
 		ReplicationFactor: arguments.ReplicationFactor,
 		NumPartitions:     arguments.NumPartitions,
-	}, false)
-	if err == sarama.ErrTopicAlreadyExists {
-		return nil
-	} else if err != nil {
+		ConfigEntries:     arguments.topicConfig(),
+	}
+	// The topic is recorded before it is created, so that it is deleted with the Channel even if
+	// provisioning fails.
+	topicName, err := r.desiredTopicName(channel, arguments)
+	if err != nil {
+		return err
+	}
+	if arguments.ExternalTopic {
+		if err := checkExternalTopic(topicName, kafkaClient); err != nil {
+			return err
+		}
+	}
+	kcs, err := controller.GetInternalStatus(channel)
+	if err != nil {
+		return err
+	}
+	if err := controller.SetInternalStatus(channel, &controller.KafkaChannelStatus{
+		Topic:          topicName,
+		ExternalTopic:  arguments.ExternalTopic,
+		DeliveryTopics: appendMissing(kcs.DeliveryTopics, deliveryTopics(channel, topicName)...),
+	}); err != nil {
+		return err
+	}
+	var drift []string
+	for _, topic := range managedTopics(channel, topicName, arguments.ExternalTopic) {
+		err := r.createTopic(topic, detail, kafkaClusterAdmin)
+		if err == sarama.ErrTopicAlreadyExists {
+			var topicDrift []string
+			topicDrift, err = r.updateTopic(topic, arguments, kafkaClusterAdmin, kafkaClient)
+			drift = append(drift, topicDrift...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(drift) > 0 {
+		r.logger.Warn("topics do not match the channel arguments", zap.Strings("drift", drift))
+	}
+	markTopicConfigured(&channel.Status, drift)
+	return nil
+}
+
+// createTopic creates topicName, it returns sarama.ErrTopicAlreadyExists if it already exists.
+func (r *reconciler) createTopic(topicName string, detail *sarama.TopicDetail, kafkaClusterAdmin sarama.ClusterAdmin) error {
+	r.logger.Info("creating topic on kafka cluster", zap.String("topic", topicName))
+	err := kafkaClusterAdmin.CreateTopic(topicName, detail, false)
+	switch {
+	case err == sarama.ErrTopicAlreadyExists:
+	case err != nil:
 		r.logger.Error("error creating topic", zap.String("topic", topicName), zap.Error(err))
-	} else {
+	default:
 		r.logger.Info("successfully created topic", zap.String("topic", topicName))
 	}
 	return err
 }
 
+// topicName returns the name of the topic of channel: the one recorded in its status, or the one
+// named according to the configured naming if none is recorded yet.
+func (r *reconciler) topicName(channel *eventingv1alpha1.Channel) string {
+	if topic := controller.RecordedTopic(channel); topic != "" {
+		return topic
+	}
+	return controller.NewChannelTopic(channel, r.config.TopicNaming)
+}
+
+// desiredTopicName returns the name of the topic channel is provisioned with: the one named by its
+// arguments, or its own topic. It returns an error if channel was provisioned with another topic,
+// as moving the Channel would leave its managed topics behind, or take over the external topic it
+// stopped naming.
+func (r *reconciler) desiredTopicName(channel *eventingv1alpha1.Channel, arguments channelArgs) (string, error) {
+	topic := arguments.Topic
+	if topic == "" {
+		topic = r.topicName(channel)
+		if kcs, err := controller.GetInternalStatus(channel); err == nil && kcs.ExternalTopic {
+			topic = controller.NewChannelTopic(channel, r.config.TopicNaming)
+		}
+	}
+	if provisioned := provisionedTopic(channel); provisioned != "" && provisioned != topic {
+		return "", fmt.Errorf("the topic of the Channel can not be changed from %s to %s, recreate the Channel to use another topic", provisioned, topic)
+	}
+	return topic, nil
+}
+
+// provisionedTopic returns the topic channel was provisioned with, or "" if it was not provisioned
+// yet.
+func provisionedTopic(channel *eventingv1alpha1.Channel) string {
+	if topic := controller.RecordedTopic(channel); topic != "" {
+		return topic
+	}
+	if channel.Status.Address.Hostname != "" {
+		// Provisioned before topic names were recorded.
+		return controller.ChannelTopic(channel)
+	}
+	return ""
+}
+
+// checkExternalTopic returns an error if the external topic does not exist, as it is not created
+// by the controller.
+func checkExternalTopic(topic string, kafkaClient kafkaMetadataClient) error {
+	err := kafkaClient.RefreshMetadata(topic)
+	var partitions []int32
+	if err == nil {
+		partitions, err = kafkaClient.Partitions(topic)
+	}
+	if err == nil && len(partitions) == 0 {
+		err = sarama.ErrUnknownTopicOrPartition
+	}
+	if err != nil {
+		return fmt.Errorf("external topic %s is not available: %s", topic, err)
+	}
+	return nil
+}
+
+// managedTopics returns the topics of channel, whose topic is channelTopic, that are created and
+// deleted by the controller. The Channel's topic is not if it is external.
+func managedTopics(channel *eventingv1alpha1.Channel, channelTopic string, externalTopic bool) []string {
+	topics := deliveryTopics(channel, channelTopic)
+	if externalTopic {
+		return topics
+	}
+	return append([]string{channelTopic}, topics...)
+}
+
+// deprovisionChannel deletes the topics managed for channel, including the delivery topics recorded
+// for the subscribers removed from it.
 func (r *reconciler) deprovisionChannel(channel *eventingv1alpha1.Channel, kafkaClusterAdmin sarama.ClusterAdmin) error {
-	topicName := topicUtils.TopicName(controller.KafkaChannelSeparator, channel.Namespace, channel.Name)
-	r.logger.Info("deleting topic on kafka cluster", zap.String("topic", topicName))
+	kcs, err := controller.GetInternalStatus(channel)
+	if err != nil {
+		return err
+	}
+	channelTopic := r.topicName(channel)
+	var topics []string
+	if !kcs.ExternalTopic {
+		topics = append(topics, channelTopic)
+	}
+	// Channels provisioned before the delivery topics were recorded only have their current
+	// subscribers' topics.
+	topics = appendMissing(topics, kcs.DeliveryTopics...)
+	topics = appendMissing(topics, deliveryTopics(channel, channelTopic)...)
+	for _, topic := range topics {
+		if err := r.deleteTopic(topic, kafkaClusterAdmin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+func (r *reconciler) deleteTopic(topicName string, kafkaClusterAdmin sarama.ClusterAdmin) error {
+	r.logger.Info("deleting topic on kafka cluster", zap.String("topic", topicName))
 	err := kafkaClusterAdmin.DeleteTopic(topicName)
 	if err == sarama.ErrUnknownTopicOrPartition {
 		return nil
@@ -257,6 +437,46 @@ func (r *reconciler) deprovisionChannel(channel *eventingv1alpha1.Channel, kafka
 	return err
 }
 
+// deliveryTopics returns the retry and dead-letter topics used by the subscribers of channel, whose
+// topic is channelTopic. They are created along with the Channel's topic, recorded in its status
+// and deleted with it. The topics of Subscriptions removed from the Channel are kept until then, so
+// that their dead-lettered events are not lost.
+func deliveryTopics(channel *eventingv1alpha1.Channel, channelTopic string) []string {
+	if channel.Spec.Subscribable == nil {
+		return nil
+	}
+	var topics []string
+	for _, sub := range channel.Spec.Subscribable.Subscribers {
+		if sub.Ref == nil || sub.Delivery == nil {
+			continue
+		}
+		if sub.Delivery.Strategy == eventingduck.DeliveryStrategyRequeue {
+			topics = append(topics, controller.RetryTopicName(channelTopic, sub.Ref.Name))
+		}
+		if sub.Delivery.DeadLetter {
+			topics = append(topics, controller.DeadLetterTopicName(channelTopic, sub.Ref.Name))
+		}
+	}
+	return topics
+}
+
+// appendMissing appends to topics the ones of added it does not hold yet.
+func appendMissing(topics []string, added ...string) []string {
+	for _, topic := range added {
+		present := false
+		for _, t := range topics {
+			if t == topic {
+				present = true
+				break
+			}
+		}
+		if !present {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
+
 func (r *reconciler) getClusterChannelProvisioner() (*eventingv1alpha1.ClusterChannelProvisioner, error) {
 	clusterChannelProvisioner := &eventingv1alpha1.ClusterChannelProvisioner{}
 	objKey := client.ObjectKey{
@@ -274,7 +494,7 @@ func (r *reconciler) syncChannelConfig(ctx context.Context) error {
 		r.logger.Info("Unable to list channels", zap.Error(err))
 		return err
 	}
-	config := multiChannelFanoutConfig(channels)
+	config := controller.MultiChannelFanoutConfig(channels)
 	return r.writeConfigMap(ctx, config)
 }
 
@@ -321,25 +541,6 @@ func (r *reconciler) createNewConfigMap(data map[string]string) *corev1.ConfigMa
 	}
 }
 
-func multiChannelFanoutConfig(channels []eventingv1alpha1.Channel) *multichannelfanout.Config {
-	cc := make([]multichannelfanout.ChannelConfig, 0)
-	for _, c := range channels {
-		channelConfig := multichannelfanout.ChannelConfig{
-			Namespace: c.Namespace,
-			Name:      c.Name,
-		}
-		if c.Spec.Subscribable != nil {
-			channelConfig.FanoutConfig = fanout.Config{
-				Subscriptions: c.Spec.Subscribable.Subscribers,
-			}
-		}
-		cc = append(cc, channelConfig)
-	}
-	return &multichannelfanout.Config{
-		ChannelConfigs: cc,
-	}
-}
-
 func (r *reconciler) listAllChannels(ctx context.Context) ([]eventingv1alpha1.Channel, error) {
 	clusterChannelProvisioner, err := r.getClusterChannelProvisioner()
 	if err != nil {
@@ -376,6 +577,7 @@ func createKafkaAdminClient(config *controller.KafkaProvisionerConfig) (sarama.C
 	saramaConf := sarama.NewConfig()
 	saramaConf.Version = sarama.V1_1_0_0
 	saramaConf.ClientID = controllerAgentName
+	config.ConfigureNet(saramaConf)
 	return sarama.NewClusterAdmin(config.Brokers, saramaConf)
 }
 
@@ -387,5 +589,20 @@ func unmarshalArguments(bytes []byte) (channelArgs, error) {
 			return arguments, fmt.Errorf("error unmarshalling arguments: %s", err)
 		}
 	}
+	if arguments.PartitionKey != "" && !controller.IsValidPartitionKey(arguments.PartitionKey) {
+		return arguments, fmt.Errorf("invalid PartitionKey %q: it must be made of lower-case letters and digits", arguments.PartitionKey)
+	}
+	if arguments.Topic != "" && !controller.IsValidTopicName(arguments.Topic) {
+		return arguments, fmt.Errorf("invalid Topic %q: it must be made of at most 249 letters, digits, '.', '_' and '-'", arguments.Topic)
+	}
+	if arguments.Topic != "" && topicUtils.IsChannelTopicName(arguments.Topic) {
+		return arguments, fmt.Errorf("invalid Topic %q: it can not name the topic of a Channel", arguments.Topic)
+	}
+	if arguments.ExternalTopic && arguments.Topic == "" {
+		return arguments, fmt.Errorf("ExternalTopic requires the Topic argument to name the external topic")
+	}
+	if arguments.Topic != "" && !arguments.ExternalTopic {
+		return arguments, fmt.Errorf("Topic can only name an external topic, it requires ExternalTopic")
+	}
 	return arguments, nil
 }
@@ -27,6 +27,7 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/google/go-cmp/cmp"
 	"github.com/knative/eventing/contrib/kafka/pkg/controller"
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
 	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
 	"github.com/knative/eventing/pkg/provisioners"
 	util "github.com/knative/eventing/pkg/provisioners"
@@ -50,6 +51,11 @@ const (
 	testUID                       = "test-uid"
 	argumentNumPartitions         = "NumPartitions"
 	argumentReplicationFactor     = "ReplicationFactor"
+	argumentPartitionKey          = "PartitionKey"
+	argumentRetentionMs           = "RetentionMs"
+	argumentCleanupPolicy         = "CleanupPolicy"
+	argumentMinInSyncReplicas     = "MinInSyncReplicas"
+	argumentTopicConfig           = "TopicConfig"
 )
 
 var (
@@ -86,8 +92,11 @@ var mockFetchError = controllertesting.Mocks{
 }
 
 type mockClusterAdmin struct {
-	mockCreateTopicFunc func(topic string, detail *sarama.TopicDetail, validateOnly bool) error
-	mockDeleteTopicFunc func(topic string) error
+	mockCreateTopicFunc      func(topic string, detail *sarama.TopicDetail, validateOnly bool) error
+	mockDeleteTopicFunc      func(topic string) error
+	mockCreatePartitionsFunc func(topic string, count int32) error
+	mockDescribeConfigFunc   func(resource sarama.ConfigResource) ([]sarama.ConfigEntry, error)
+	mockAlterConfigFunc      func(name string, entries map[string]*string) error
 }
 
 func (ca *mockClusterAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
@@ -109,6 +118,9 @@ func (ca *mockClusterAdmin) DeleteTopic(topic string) error {
 }
 
 func (ca *mockClusterAdmin) CreatePartitions(topic string, count int32, assignment [][]int32, validateOnly bool) error {
+	if ca.mockCreatePartitionsFunc != nil {
+		return ca.mockCreatePartitionsFunc(topic, count)
+	}
 	return nil
 }
 
@@ -117,10 +129,42 @@ func (ca *mockClusterAdmin) DeleteRecords(topic string, partitionOffsets map[int
 }
 
 func (ca *mockClusterAdmin) DescribeConfig(resource sarama.ConfigResource) ([]sarama.ConfigEntry, error) {
+	if ca.mockDescribeConfigFunc != nil {
+		return ca.mockDescribeConfigFunc(resource)
+	}
 	return nil, nil
 }
 
 func (ca *mockClusterAdmin) AlterConfig(resourceType sarama.ConfigResourceType, name string, entries map[string]*string, validateOnly bool) error {
+	if ca.mockAlterConfigFunc != nil {
+		return ca.mockAlterConfigFunc(name, entries)
+	}
+	return nil
+}
+
+// mockKafkaClient reports that every topic has partitions partitions, replicated replicas times.
+type mockKafkaClient struct {
+	partitions int32
+	replicas   int
+}
+
+func (c *mockKafkaClient) RefreshMetadata(topics ...string) error {
+	return nil
+}
+
+func (c *mockKafkaClient) Partitions(topic string) ([]int32, error) {
+	partitions := make([]int32, c.partitions)
+	for i := range partitions {
+		partitions[i] = int32(i)
+	}
+	return partitions, nil
+}
+
+func (c *mockKafkaClient) Replicas(topic string, partitionID int32) ([]int32, error) {
+	return make([]int32, c.replicas), nil
+}
+
+func (c *mockKafkaClient) Close() error {
 	return nil
 }
 
@@ -136,6 +180,30 @@ func (ca *mockClusterAdmin) DeleteACL(filter sarama.AclFilter, validateOnly bool
 	return nil, nil
 }
 
+func (ca *mockClusterAdmin) ListTopics() (map[string]sarama.TopicDetail, error) {
+	return nil, nil
+}
+
+func (ca *mockClusterAdmin) DescribeTopics(topics []string) ([]*sarama.TopicMetadata, error) {
+	return nil, nil
+}
+
+func (ca *mockClusterAdmin) ListConsumerGroups() (map[string]string, error) {
+	return nil, nil
+}
+
+func (ca *mockClusterAdmin) DescribeConsumerGroups(groups []string) ([]*sarama.GroupDescription, error) {
+	return nil, nil
+}
+
+func (ca *mockClusterAdmin) ListConsumerGroupOffsets(group string, topicPartitions map[string][]int32) (*sarama.OffsetFetchResponse, error) {
+	return nil, nil
+}
+
+func (ca *mockClusterAdmin) DescribeCluster() ([]*sarama.Broker, int32, error) {
+	return nil, 0, nil
+}
+
 var testCases = []controllertesting.TestCase{
 	{
 		Name: "new channel with valid provisioner: adds finalizer",
@@ -247,6 +315,7 @@ func TestAllCases(t *testing.T) {
 			logger:            logger.Desugar(),
 			config:            getControllerConfig(),
 			kafkaClusterAdmin: &mockClusterAdmin{},
+			kafkaClient:       &mockKafkaClient{partitions: 1, replicas: 1},
 		}
 		t.Logf("Running test %s", tc.Name)
 		t.Run(tc.Name, tc.Runner(t, r, c, recorder))
@@ -311,6 +380,40 @@ func TestProvisionChannel(t *testing.T) {
 			}(),
 			wantError: "error unmarshalling arguments: invalid character 'i' looking for beginning of value",
 		},
+		{
+			name:      "provision with invalid partition key - errors",
+			c:         getNewChannelWithArgs(channelName, map[string]interface{}{argumentPartitionKey: "Subject"}),
+			wantError: `invalid PartitionKey "Subject": it must be made of lower-case letters and digits`,
+		},
+		{
+			name:      "provision with invalid topic - errors",
+			c:         getNewChannelWithArgs(channelName, map[string]interface{}{"Topic": "my topic"}),
+			wantError: `invalid Topic "my topic": it must be made of at most 249 letters, digits, '.', '_' and '-'`,
+		},
+		{
+			name:      "provision with external topic without topic - errors",
+			c:         getNewChannelWithArgs(channelName, map[string]interface{}{"ExternalTopic": true}),
+			wantError: "ExternalTopic requires the Topic argument to name the external topic",
+		},
+		{
+			name:      "provision with topic that is not external - errors",
+			c:         getNewChannelWithArgs(channelName, map[string]interface{}{"Topic": "my-topic"}),
+			wantError: "Topic can only name an external topic, it requires ExternalTopic",
+		},
+		{
+			name:      "provision with topic of another channel - errors",
+			c:         getNewChannelWithArgs(channelName, map[string]interface{}{"Topic": "knative-eventing-channel.other-ns.other", "ExternalTopic": true}),
+			wantError: `invalid Topic "knative-eventing-channel.other-ns.other": it can not name the topic of a Channel`,
+		},
+		{
+			name:          "provision with partition key",
+			c:             getNewChannelWithArgs(channelName, map[string]interface{}{argumentPartitionKey: "subject"}),
+			wantTopicName: fmt.Sprintf("%s.%s.%s", topicPrefix, testNS, channelName),
+			wantTopicDetail: &sarama.TopicDetail{
+				ReplicationFactor: 1,
+				NumPartitions:     1,
+			},
+		},
 		{
 			name:          "provision with valid channel arguments",
 			c:             getNewChannelWithArgs(channelName, map[string]interface{}{argumentNumPartitions: 2}),
@@ -320,6 +423,47 @@ func TestProvisionChannel(t *testing.T) {
 				NumPartitions:     2,
 			},
 		},
+		{
+			name: "provision with topic configs",
+			c: getNewChannelWithArgs(channelName, map[string]interface{}{
+				argumentReplicationFactor: 3,
+				argumentRetentionMs:       -1,
+				argumentCleanupPolicy:     "compact",
+				argumentMinInSyncReplicas: 2,
+				argumentTopicConfig:       map[string]string{"segment.bytes": "1048576", "cleanup.policy": "delete"},
+			}),
+			wantTopicName: fmt.Sprintf("%s.%s.%s", topicPrefix, testNS, channelName),
+			wantTopicDetail: &sarama.TopicDetail{
+				ReplicationFactor: 3,
+				NumPartitions:     1,
+				ConfigEntries: map[string]*string{
+					"retention.ms":        stringPtr("-1"),
+					"cleanup.policy":      stringPtr("compact"),
+					"min.insync.replicas": stringPtr("2"),
+					"segment.bytes":       stringPtr("1048576"),
+				},
+			},
+		},
+		{
+			name:      "provision with invalid retention - errors",
+			c:         getNewChannelWithArgs(channelName, map[string]interface{}{argumentRetentionMs: -2}),
+			wantError: "invalid RetentionMs -2: it must be -1, to retain records forever, or positive",
+		},
+		{
+			name:      "provision with invalid cleanup policy - errors",
+			c:         getNewChannelWithArgs(channelName, map[string]interface{}{argumentCleanupPolicy: "forever"}),
+			wantError: `invalid CleanupPolicy "forever": it must be one of delete, compact, compact,delete`,
+		},
+		{
+			name:      "provision with more in-sync replicas than replicas - errors",
+			c:         getNewChannelWithArgs(channelName, map[string]interface{}{argumentMinInSyncReplicas: 2}),
+			wantError: "invalid MinInSyncReplicas 2: it must be between 1 and the ReplicationFactor 1",
+		},
+		{
+			name:      "provision with empty topic config name - errors",
+			c:         getNewChannelWithArgs(channelName, map[string]interface{}{argumentTopicConfig: map[string]string{"": "value"}}),
+			wantError: "invalid TopicConfig: config names must not be empty",
+		},
 		{
 			name:          "provision but topic already exists - no error",
 			c:             getNewChannelWithArgs(channelName, map[string]interface{}{argumentNumPartitions: 2}),
@@ -346,6 +490,7 @@ func TestProvisionChannel(t *testing.T) {
 		t.Logf("running test %s", tc.name)
 		logger := provisioners.NewProvisionerLoggerFromConfig(provisioners.NewLoggingConfig())
 		r := &reconciler{
+			config: getControllerConfig(),
 			logger: logger.Desugar(),
 		}
 		kafkaClusterAdmin := &mockClusterAdmin{
@@ -353,9 +498,12 @@ func TestProvisionChannel(t *testing.T) {
 				if topic != tc.wantTopicName {
 					t.Errorf("expected topic name: %+v got: %+v", tc.wantTopicName, topic)
 				}
+				if diff := cmp.Diff(tc.wantTopicDetail, detail); diff != "" {
+					t.Errorf("unexpected topic detail (-want, +got) = %v", diff)
+				}
 				return tc.mockError
 			}}
-		err := r.provisionChannel(tc.c, kafkaClusterAdmin)
+		err := r.provisionChannel(tc.c, kafkaClusterAdmin, &mockKafkaClient{partitions: 2, replicas: 1})
 		var got string
 		if err != nil {
 			got = err.Error()
@@ -397,6 +545,7 @@ func TestDeprovisionChannel(t *testing.T) {
 		t.Logf("running test %s", tc.name)
 		logger := provisioners.NewProvisionerLoggerFromConfig(provisioners.NewLoggingConfig())
 		r := &reconciler{
+			config: getControllerConfig(),
 			logger: logger.Desugar()}
 		kafkaClusterAdmin := &mockClusterAdmin{
 			mockDeleteTopicFunc: func(topic string) error {
@@ -417,6 +566,328 @@ func TestDeprovisionChannel(t *testing.T) {
 	}
 }
 
+func TestDeliveryTopics(t *testing.T) {
+	c := getNewChannel(channelName, clusterChannelProvisionerName)
+	c.Spec.Subscribable = &eventingduck.Subscribable{
+		Subscribers: []eventingduck.ChannelSubscriberSpec{{
+			Ref:           &corev1.ObjectReference{Name: "default-delivery"},
+			SubscriberURI: "default",
+		}, {
+			Ref:           &corev1.ObjectReference{Name: "requeue"},
+			SubscriberURI: "requeue",
+			Delivery: &eventingduck.DeliverySpec{
+				Strategy:   eventingduck.DeliveryStrategyRequeue,
+				DeadLetter: true,
+			},
+		}, {
+			Ref:           &corev1.ObjectReference{Name: "retry"},
+			SubscriberURI: "retry",
+			Delivery: &eventingduck.DeliverySpec{
+				Strategy:   eventingduck.DeliveryStrategyRetry,
+				DeadLetter: true,
+			},
+		}},
+	}
+	want := []string{
+		fmt.Sprintf("%s.%s.%s", topicPrefix, testNS, channelName),
+		fmt.Sprintf("%s.%s.%s.requeue.retry", topicPrefix, testNS, channelName),
+		fmt.Sprintf("%s.%s.%s.requeue.dlq", topicPrefix, testNS, channelName),
+		fmt.Sprintf("%s.%s.%s.retry.dlq", topicPrefix, testNS, channelName),
+	}
+
+	logger := provisioners.NewProvisionerLoggerFromConfig(provisioners.NewLoggingConfig())
+	r := &reconciler{
+		config: getControllerConfig(),
+		logger: logger.Desugar(),
+	}
+	var created, deleted []string
+	kafkaClusterAdmin := &mockClusterAdmin{
+		mockCreateTopicFunc: func(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+			created = append(created, topic)
+			return nil
+		},
+		mockDeleteTopicFunc: func(topic string) error {
+			deleted = append(deleted, topic)
+			return nil
+		},
+	}
+
+	if err := r.provisionChannel(c, kafkaClusterAdmin, &mockKafkaClient{partitions: 1, replicas: 1}); err != nil {
+		t.Fatalf("unexpected error provisioning: %v", err)
+	}
+	if diff := cmp.Diff(want, created); diff != "" {
+		t.Errorf("unexpected created topics (-want, +got) = %v", diff)
+	}
+	if err := r.deprovisionChannel(c, kafkaClusterAdmin); err != nil {
+		t.Fatalf("unexpected error deprovisioning: %v", err)
+	}
+	if diff := cmp.Diff(want, deleted); diff != "" {
+		t.Errorf("unexpected deleted topics (-want, +got) = %v", diff)
+	}
+}
+
+func TestDeliveryTopics_RemovedSubscriber(t *testing.T) {
+	c := getNewChannel(channelName, clusterChannelProvisionerName)
+	c.Spec.Subscribable = &eventingduck.Subscribable{
+		Subscribers: []eventingduck.ChannelSubscriberSpec{{
+			Ref:           &corev1.ObjectReference{Name: "kept"},
+			SubscriberURI: "kept",
+			Delivery:      &eventingduck.DeliverySpec{DeadLetter: true},
+		}, {
+			Ref:           &corev1.ObjectReference{Name: "removed"},
+			SubscriberURI: "removed",
+			Delivery: &eventingduck.DeliverySpec{
+				Strategy:   eventingduck.DeliveryStrategyRequeue,
+				DeadLetter: true,
+			},
+		}},
+	}
+	want := []string{
+		fmt.Sprintf("%s.%s.%s", topicPrefix, testNS, channelName),
+		fmt.Sprintf("%s.%s.%s.kept.dlq", topicPrefix, testNS, channelName),
+		fmt.Sprintf("%s.%s.%s.removed.retry", topicPrefix, testNS, channelName),
+		fmt.Sprintf("%s.%s.%s.removed.dlq", topicPrefix, testNS, channelName),
+	}
+
+	logger := provisioners.NewProvisionerLoggerFromConfig(provisioners.NewLoggingConfig())
+	r := &reconciler{
+		config: getControllerConfig(),
+		logger: logger.Desugar(),
+	}
+	var deleted []string
+	kafkaClusterAdmin := &mockClusterAdmin{
+		mockDeleteTopicFunc: func(topic string) error {
+			deleted = append(deleted, topic)
+			return nil
+		},
+	}
+
+	if err := r.provisionChannel(c, kafkaClusterAdmin, &mockKafkaClient{partitions: 1, replicas: 1}); err != nil {
+		t.Fatalf("unexpected error provisioning: %v", err)
+	}
+	c.Spec.Subscribable.Subscribers = c.Spec.Subscribable.Subscribers[:1]
+	if err := r.provisionChannel(c, kafkaClusterAdmin, &mockKafkaClient{partitions: 1, replicas: 1}); err != nil {
+		t.Fatalf("unexpected error provisioning: %v", err)
+	}
+	kcs, err := controller.GetInternalStatus(c)
+	if err != nil {
+		t.Fatalf("unexpected error reading the internal status: %v", err)
+	}
+	if diff := cmp.Diff(want[1:], kcs.DeliveryTopics); diff != "" {
+		t.Errorf("unexpected recorded delivery topics (-want, +got) = %v", diff)
+	}
+	if err := r.deprovisionChannel(c, kafkaClusterAdmin); err != nil {
+		t.Fatalf("unexpected error deprovisioning: %v", err)
+	}
+	if diff := cmp.Diff(want, deleted); diff != "" {
+		t.Errorf("unexpected deleted topics (-want, +got) = %v", diff)
+	}
+}
+
+func TestTopicNaming(t *testing.T) {
+	uidTopic := fmt.Sprintf("%s.%s.%s", topicPrefix, channelName, testUID)
+	nameTopic := fmt.Sprintf("%s.%s.%s", topicPrefix, testNS, channelName)
+	testCases := []struct {
+		name      string
+		c         *eventingv1alpha1.Channel
+		naming    controller.TopicNaming
+		wantTopic string
+	}{{
+		name:      "new channel named after its name",
+		c:         getNewChannel(channelName, clusterChannelProvisionerName),
+		wantTopic: nameTopic,
+	}, {
+		name:      "new channel named after its uid",
+		c:         getNewChannel(channelName, clusterChannelProvisionerName),
+		naming:    controller.TopicNamingUID,
+		wantTopic: uidTopic,
+	}, {
+		name: "channel provisioned before topics were recorded keeps its topic",
+		c: func() *eventingv1alpha1.Channel {
+			c := getNewChannelProvisionedStatus(channelName, clusterChannelProvisionerName)
+			c.Status.Internal = nil
+			return c
+		}(),
+		naming:    controller.TopicNamingUID,
+		wantTopic: nameTopic,
+	}, {
+		name: "recorded topic is kept when the naming changes",
+		c: func() *eventingv1alpha1.Channel {
+			c := getNewChannelProvisionedStatus(channelName, clusterChannelProvisionerName)
+			controller.SetInternalStatus(c, &controller.KafkaChannelStatus{Topic: uidTopic})
+			return c
+		}(),
+		wantTopic: uidTopic,
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.c.UID = testUID
+			tc.c.Spec.Subscribable = &eventingduck.Subscribable{
+				Subscribers: []eventingduck.ChannelSubscriberSpec{{
+					Ref:      &corev1.ObjectReference{Name: "sub"},
+					Delivery: &eventingduck.DeliverySpec{DeadLetter: true},
+				}},
+			}
+			logger := provisioners.NewProvisionerLoggerFromConfig(provisioners.NewLoggingConfig())
+			r := &reconciler{
+				config: &controller.KafkaProvisionerConfig{TopicNaming: tc.naming},
+				logger: logger.Desugar(),
+			}
+			var created, deleted []string
+			kafkaClusterAdmin := &mockClusterAdmin{
+				mockCreateTopicFunc: func(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+					created = append(created, topic)
+					return nil
+				},
+				mockDeleteTopicFunc: func(topic string) error {
+					deleted = append(deleted, topic)
+					return nil
+				},
+			}
+			want := []string{tc.wantTopic, tc.wantTopic + ".sub.dlq"}
+
+			if err := r.provisionChannel(tc.c, kafkaClusterAdmin, &mockKafkaClient{partitions: 1, replicas: 1}); err != nil {
+				t.Fatalf("unexpected error provisioning: %v", err)
+			}
+			if diff := cmp.Diff(want, created); diff != "" {
+				t.Errorf("unexpected created topics (-want, +got) = %v", diff)
+			}
+			if got := controller.RecordedTopic(tc.c); got != tc.wantTopic {
+				t.Errorf("unexpected recorded topic, want %q, got %q", tc.wantTopic, got)
+			}
+
+			// The recorded topic is deleted, whatever the naming.
+			r.config.TopicNaming = controller.TopicNamingName
+			if err := r.deprovisionChannel(tc.c, kafkaClusterAdmin); err != nil {
+				t.Fatalf("unexpected error deprovisioning: %v", err)
+			}
+			if diff := cmp.Diff(want, deleted); diff != "" {
+				t.Errorf("unexpected deleted topics (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestExternalTopic(t *testing.T) {
+	const externalTopic = "orders"
+	ownTopic := fmt.Sprintf("%s.%s.%s", topicPrefix, testNS, channelName)
+	external := map[string]interface{}{"Topic": externalTopic, "ExternalTopic": true}
+	testCases := []struct {
+		name     string
+		args     map[string]interface{}
+		recorded *controller.KafkaChannelStatus
+		// legacy marks a Channel provisioned before topic names were recorded.
+		legacy      bool
+		partitions  int32
+		wantTopic   string
+		wantCreated []string
+		wantDeleted []string
+		wantError   string
+	}{{
+		name:        "external topic is neither created nor deleted",
+		args:        external,
+		partitions:  1,
+		wantTopic:   externalTopic,
+		wantCreated: []string{"orders.sub.dlq"},
+		wantDeleted: []string{"orders.sub.dlq"},
+	}, {
+		name:       "missing external topic",
+		args:       external,
+		partitions: 0,
+		wantError:  "external topic orders is not available: kafka server: Request was for a topic or partition that does not exist on this broker.",
+	}, {
+		name:        "external topic kept",
+		args:        external,
+		recorded:    &controller.KafkaChannelStatus{Topic: externalTopic, ExternalTopic: true},
+		partitions:  1,
+		wantTopic:   externalTopic,
+		wantCreated: []string{"orders.sub.dlq"},
+		wantDeleted: []string{"orders.sub.dlq"},
+	}, {
+		name:       "channel no longer naming an external topic",
+		args:       map[string]interface{}{},
+		recorded:   &controller.KafkaChannelStatus{Topic: externalTopic, ExternalTopic: true},
+		partitions: 1,
+		wantError:  "the topic of the Channel can not be changed from orders to " + ownTopic + ", recreate the Channel to use another topic",
+	}, {
+		name:       "channel naming an external topic instead of its own",
+		args:       external,
+		recorded:   &controller.KafkaChannelStatus{Topic: ownTopic},
+		partitions: 1,
+		wantError:  "the topic of the Channel can not be changed from " + ownTopic + " to orders, recreate the Channel to use another topic",
+	}, {
+		name:       "channel provisioned before topics were recorded naming an external topic",
+		args:       external,
+		legacy:     true,
+		partitions: 1,
+		wantError:  "the topic of the Channel can not be changed from " + ownTopic + " to orders, recreate the Channel to use another topic",
+	}, {
+		name:       "channel naming another external topic",
+		args:       map[string]interface{}{"Topic": "payments", "ExternalTopic": true},
+		recorded:   &controller.KafkaChannelStatus{Topic: externalTopic, ExternalTopic: true},
+		partitions: 1,
+		wantError:  "the topic of the Channel can not be changed from orders to payments, recreate the Channel to use another topic",
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := getNewChannelWithArgs(channelName, tc.args)
+			c.Spec.Subscribable = &eventingduck.Subscribable{
+				Subscribers: []eventingduck.ChannelSubscriberSpec{{
+					Ref:      &corev1.ObjectReference{Name: "sub"},
+					Delivery: &eventingduck.DeliverySpec{DeadLetter: true},
+				}},
+			}
+			if tc.recorded != nil {
+				controller.SetInternalStatus(c, tc.recorded)
+			}
+			if tc.legacy {
+				c.Status.SetAddress("legacy.example.com")
+			}
+			logger := provisioners.NewProvisionerLoggerFromConfig(provisioners.NewLoggingConfig())
+			r := &reconciler{
+				config: getControllerConfig(),
+				logger: logger.Desugar(),
+			}
+			var created, deleted []string
+			kafkaClusterAdmin := &mockClusterAdmin{
+				mockCreateTopicFunc: func(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+					created = append(created, topic)
+					return nil
+				},
+				mockDeleteTopicFunc: func(topic string) error {
+					deleted = append(deleted, topic)
+					return nil
+				},
+			}
+
+			err := r.provisionChannel(c, kafkaClusterAdmin, &mockKafkaClient{partitions: tc.partitions, replicas: 1})
+			var got string
+			if err != nil {
+				got = err.Error()
+			}
+			if diff := cmp.Diff(tc.wantError, got); diff != "" {
+				t.Fatalf("unexpected error (-want, +got) = %v", diff)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tc.wantCreated, created); diff != "" {
+				t.Errorf("unexpected created topics (-want, +got) = %v", diff)
+			}
+			if got := controller.RecordedTopic(c); got != tc.wantTopic {
+				t.Errorf("unexpected recorded topic, want %q, got %q", tc.wantTopic, got)
+			}
+
+			if err := r.deprovisionChannel(c, kafkaClusterAdmin); err != nil {
+				t.Fatalf("unexpected error deprovisioning: %v", err)
+			}
+			if diff := cmp.Diff(tc.wantDeleted, deleted); diff != "" {
+				t.Errorf("unexpected deleted topics (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
 func getNewChannelNoProvisioner(name string) *eventingv1alpha1.Channel {
 	channel := &eventingv1alpha1.Channel{
 		TypeMeta:   channelType(),
@@ -457,6 +928,10 @@ func getNewChannelWithStatusAndFinalizer(name, provisioner string) *eventingv1al
 	return c
 }
 
+func stringPtr(s string) *string {
+	return &s
+}
+
 func getNewChannelWithArgs(name string, args map[string]interface{}) *eventingv1alpha1.Channel {
 	c := getNewChannelNoProvisioner(name)
 	bytes, _ := json.Marshal(args)
@@ -471,6 +946,11 @@ func getNewChannelProvisionedStatus(name, provisioner string) *eventingv1alpha1.
 	c.Status.InitializeConditions()
 	c.Status.SetAddress(serviceAddress)
 	c.Status.MarkProvisioned()
+	markTopicConfigured(&c.Status, nil)
+	markStartSupported(&c.Status, nil)
+	controller.SetInternalStatus(c, &controller.KafkaChannelStatus{
+		Topic: fmt.Sprintf("%s.%s.%s", topicPrefix, testNS, name),
+	})
 	c.Finalizers = []string{finalizerName}
 	return c
 }
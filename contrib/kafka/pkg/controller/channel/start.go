@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package channel
+
+import (
+	"fmt"
+	"strings"
+
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+
+	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+)
+
+const (
+	// ChannelConditionStartSupported has status True when the start and reset positions of all
+	// the Channel's subscribers are supported. Kafka topics do not number their events in a single
+	// sequence, so the subscribers starting or reset at a sequence number are not consumed by the
+	// dispatcher. Like ChannelConditionTopicConfigured, it does not affect the Channel's readiness.
+	ChannelConditionStartSupported duckv1alpha1.ConditionType = "StartSupported"
+
+	sequenceNotSupported = "SequenceNotSupported"
+)
+
+// unsupportedStarts describes the start and reset positions of the subscribers of channel that
+// are sequence numbers.
+func unsupportedStarts(channel *eventingv1alpha1.Channel) []string {
+	if channel.Spec.Subscribable == nil {
+		return nil
+	}
+	var unsupported []string
+	for _, sub := range channel.Spec.Subscribable.Subscribers {
+		name := sub.SubscriberURI
+		if sub.Ref != nil {
+			name = sub.Ref.Name
+		}
+		if sub.Start != nil && sub.Start.Sequence > 0 {
+			unsupported = append(unsupported, fmt.Sprintf("subscriber %s starts at sequence %d", name, sub.Start.Sequence))
+		}
+		if sub.Reset != nil && sub.Reset.Sequence > 0 {
+			unsupported = append(unsupported, fmt.Sprintf("subscriber %s is reset to sequence %d", name, sub.Reset.Sequence))
+		}
+	}
+	return unsupported
+}
+
+// markStartSupported sets ChannelConditionStartSupported to True, or to False if there are
+// unsupported positions.
+func markStartSupported(status *eventingv1alpha1.ChannelStatus, unsupported []string) {
+	condition := duckv1alpha1.Condition{
+		Type:     ChannelConditionStartSupported,
+		Status:   corev1.ConditionTrue,
+		Severity: duckv1alpha1.ConditionSeverityInfo,
+	}
+	if len(unsupported) > 0 {
+		condition.Status = corev1.ConditionFalse
+		condition.Severity = duckv1alpha1.ConditionSeverityWarning
+		condition.Reason = sequenceNotSupported
+		condition.Message = strings.Join(unsupported, "; ")
+	}
+	topicCondSet.Manage(status).SetCondition(condition)
+}
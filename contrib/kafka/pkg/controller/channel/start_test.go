@@ -0,0 +1,74 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package channel
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+)
+
+func TestMarkStartSupported(t *testing.T) {
+	testCases := []struct {
+		name          string
+		subscribers   []eventingduck.ChannelSubscriberSpec
+		wantCondition corev1.ConditionStatus
+		wantMessage   string
+	}{{
+		name:          "no subscribers",
+		wantCondition: corev1.ConditionTrue,
+	}, {
+		name: "supported positions",
+		subscribers: []eventingduck.ChannelSubscriberSpec{{
+			Ref:   &corev1.ObjectReference{Name: "earliest"},
+			Start: &eventingduck.StartSpec{Position: eventingduck.StartPositionEarliest},
+		}},
+		wantCondition: corev1.ConditionTrue,
+	}, {
+		name: "sequence numbers",
+		subscribers: []eventingduck.ChannelSubscriberSpec{{
+			Ref:   &corev1.ObjectReference{Name: "start"},
+			Start: &eventingduck.StartSpec{Sequence: 42},
+		}, {
+			SubscriberURI: "http://reset.example.com",
+			Reset:         &eventingduck.StartSpec{Sequence: 7},
+		}},
+		wantCondition: corev1.ConditionFalse,
+		wantMessage:   "subscriber start starts at sequence 42; subscriber http://reset.example.com is reset to sequence 7",
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := getNewChannel(channelName, clusterChannelProvisionerName)
+			c.Status.InitializeConditions()
+			c.Spec.Subscribable = &eventingduck.Subscribable{Subscribers: tc.subscribers}
+
+			markStartSupported(&c.Status, unsupportedStarts(c))
+			cond := c.Status.GetCondition(ChannelConditionStartSupported)
+			if cond == nil {
+				t.Fatalf("expected the %s condition to be set", ChannelConditionStartSupported)
+			}
+			if cond.Status != tc.wantCondition || cond.Message != tc.wantMessage {
+				t.Errorf("unexpected condition. Expected %s %q. Actual %s %q", tc.wantCondition, tc.wantMessage, cond.Status, cond.Message)
+			}
+			if c.Status.IsReady() {
+				t.Errorf("the start condition must not make the Channel ready")
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package channel
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/knative/eventing/contrib/kafka/pkg/controller"
+	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+)
+
+const (
+	// ChannelConditionTopicConfigured has status True when the Channel's topics match the
+	// configuration in its arguments. It does not affect the Channel's readiness: a topic that
+	// can not be updated in place, for instance because partitions can not be removed, still
+	// carries events.
+	ChannelConditionTopicConfigured duckv1alpha1.ConditionType = "TopicConfigured"
+
+	topicConfigDrift = "TopicConfigDrift"
+
+	retentionMsConfig       = "retention.ms"
+	cleanupPolicyConfig     = "cleanup.policy"
+	minInSyncReplicasConfig = "min.insync.replicas"
+)
+
+// validCleanupPolicies are the values of the CleanupPolicy argument.
+var validCleanupPolicies = []string{"delete", "compact", "compact,delete"}
+
+// topicCondSet only sets ChannelConditionTopicConfigured and ChannelConditionStartSupported,
+// which are not among the Channel's dependent conditions.
+var topicCondSet = duckv1alpha1.NewLivingConditionSet()
+
+// kafkaMetadataClient reads the partitions and replicas of topics, which the vendored
+// sarama.ClusterAdmin does not expose. It is implemented by sarama.Client.
+type kafkaMetadataClient interface {
+	RefreshMetadata(topics ...string) error
+	Partitions(topic string) ([]int32, error)
+	Replicas(topic string, partitionID int32) ([]int32, error)
+	Close() error
+}
+
+func createKafkaClient(config *controller.KafkaProvisionerConfig) (kafkaMetadataClient, error) {
+	saramaConf := sarama.NewConfig()
+	saramaConf.Version = sarama.V1_1_0_0
+	saramaConf.ClientID = controllerAgentName
+	config.ConfigureNet(saramaConf)
+	return sarama.NewClient(config.Brokers, saramaConf)
+}
+
+// topicConfig returns the topic configs set by arguments, by name.
+func (arguments channelArgs) topicConfig() map[string]*string {
+	config := make(map[string]*string)
+	set := func(name, value string) {
+		config[name] = &value
+	}
+	for name, value := range arguments.TopicConfig {
+		set(name, value)
+	}
+	if arguments.RetentionMs != 0 {
+		set(retentionMsConfig, strconv.FormatInt(arguments.RetentionMs, 10))
+	}
+	if arguments.CleanupPolicy != "" {
+		set(cleanupPolicyConfig, arguments.CleanupPolicy)
+	}
+	if arguments.MinInSyncReplicas != 0 {
+		set(minInSyncReplicasConfig, strconv.FormatInt(int64(arguments.MinInSyncReplicas), 10))
+	}
+	if len(config) == 0 {
+		return nil
+	}
+	return config
+}
+
+// updateTopic brings the existing topic in line with arguments: partitions are added, and
+// configs that differ are altered. It returns the differences that can not be fixed in place.
+func (r *reconciler) updateTopic(topic string, arguments channelArgs, kafkaClusterAdmin sarama.ClusterAdmin, kafkaClient kafkaMetadataClient) ([]string, error) {
+	var drift []string
+
+	if err := kafkaClient.RefreshMetadata(topic); err != nil {
+		return nil, err
+	}
+	partitions, err := kafkaClient.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+	if count := int32(len(partitions)); count < arguments.NumPartitions {
+		r.logger.Info("adding partitions to topic", zap.String("topic", topic), zap.Int32("from", count), zap.Int32("to", arguments.NumPartitions))
+		if err := kafkaClusterAdmin.CreatePartitions(topic, arguments.NumPartitions, nil, false); err != nil {
+			r.logger.Error("error adding partitions to topic", zap.String("topic", topic), zap.Error(err))
+			return nil, err
+		}
+	} else if count > arguments.NumPartitions {
+		drift = append(drift, fmt.Sprintf("topic %s has %d partitions instead of %d, partitions can not be removed", topic, count, arguments.NumPartitions))
+	}
+	if len(partitions) > 0 {
+		replicas, err := kafkaClient.Replicas(topic, partitions[0])
+		if err != nil {
+			return nil, err
+		}
+		if factor := int16(len(replicas)); factor != arguments.ReplicationFactor {
+			drift = append(drift, fmt.Sprintf("topic %s has a replication factor of %d instead of %d, it can not be changed in place", topic, factor, arguments.ReplicationFactor))
+		}
+	}
+
+	desired := arguments.topicConfig()
+	if len(desired) == 0 {
+		return drift, nil
+	}
+	// AlterConfig replaces all the configs set on the topic, resetting the ones missing from the
+	// request to their defaults. All the configs are described, so that the ones set on the topic
+	// outside of the Channel's arguments, for instance by an operator, are sent again and kept.
+	entries, err := kafkaClusterAdmin.DescribeConfig(sarama.ConfigResource{
+		Type: sarama.TopicResource,
+		Name: topic,
+	})
+	if err != nil {
+		return nil, err
+	}
+	actual := make(map[string]string, len(entries))
+	altered := make(map[string]*string, len(desired))
+	for _, entry := range entries {
+		actual[entry.Name] = entry.Value
+		if isTopicConfig(entry) {
+			value := entry.Value
+			altered[entry.Name] = &value
+		}
+	}
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var changed []string
+	for _, name := range names {
+		if value, present := actual[name]; !present || value != *desired[name] {
+			changed = append(changed, name)
+		}
+		altered[name] = desired[name]
+	}
+	if len(changed) == 0 {
+		return drift, nil
+	}
+	r.logger.Info("altering topic configs", zap.String("topic", topic), zap.Strings("changed", changed))
+	if err := kafkaClusterAdmin.AlterConfig(sarama.TopicResource, topic, altered, false); err != nil {
+		r.logger.Error("error altering topic configs", zap.String("topic", topic), zap.Error(err))
+		return nil, err
+	}
+	return drift, nil
+}
+
+// isTopicConfig returns true if entry is set on the topic itself, rather than inherited from the
+// broker. Brokers answering the first version of DescribeConfigs, which the vendored
+// sarama.ClusterAdmin sends, do not report the source of the configs, only whether they are
+// defaults.
+func isTopicConfig(entry sarama.ConfigEntry) bool {
+	if entry.ReadOnly || entry.Sensitive {
+		return false
+	}
+	if entry.Source != sarama.SourceUnknown {
+		return entry.Source == sarama.SourceTopic
+	}
+	return !entry.Default
+}
+
+// markTopicConfigured sets ChannelConditionTopicConfigured to True, or to False if there is drift.
+func markTopicConfigured(status *eventingv1alpha1.ChannelStatus, drift []string) {
+	condition := duckv1alpha1.Condition{
+		Type:     ChannelConditionTopicConfigured,
+		Status:   corev1.ConditionTrue,
+		Severity: duckv1alpha1.ConditionSeverityInfo,
+	}
+	if len(drift) > 0 {
+		condition.Status = corev1.ConditionFalse
+		condition.Severity = duckv1alpha1.ConditionSeverityWarning
+		condition.Reason = topicConfigDrift
+		condition.Message = strings.Join(drift, "; ")
+	}
+	topicCondSet.Manage(status).SetCondition(condition)
+}
+
+// validateTopicArguments checks the topic configs set by arguments. replicationFactor is the
+// replication factor the topic is created with.
+func validateTopicArguments(arguments channelArgs, replicationFactor int16) error {
+	if arguments.RetentionMs < -1 {
+		return fmt.Errorf("invalid RetentionMs %d: it must be -1, to retain records forever, or positive", arguments.RetentionMs)
+	}
+	if arguments.CleanupPolicy != "" {
+		valid := false
+		for _, policy := range validCleanupPolicies {
+			valid = valid || arguments.CleanupPolicy == policy
+		}
+		if !valid {
+			return fmt.Errorf("invalid CleanupPolicy %q: it must be one of %s", arguments.CleanupPolicy, strings.Join(validCleanupPolicies, ", "))
+		}
+	}
+	if arguments.MinInSyncReplicas < 0 || arguments.MinInSyncReplicas > int32(replicationFactor) {
+		return fmt.Errorf("invalid MinInSyncReplicas %d: it must be between 1 and the ReplicationFactor %d", arguments.MinInSyncReplicas, replicationFactor)
+	}
+	for name := range arguments.TopicConfig {
+		if name == "" {
+			return fmt.Errorf("invalid TopicConfig: config names must not be empty")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package channel
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/knative/eventing/pkg/provisioners"
+)
+
+func TestProvisionChannel_UpdatesExistingTopic(t *testing.T) {
+	topicName := fmt.Sprintf("%s.%s.%s", topicPrefix, testNS, channelName)
+	testCases := []struct {
+		name           string
+		args           map[string]interface{}
+		partitions     int32
+		replicas       int
+		actualConfig   []sarama.ConfigEntry
+		describeError  error
+		wantPartitions int32
+		wantAltered    map[string]*string
+		wantCondition  corev1.ConditionStatus
+		wantMessage    string
+		wantError      string
+	}{
+		{
+			name:          "up to date",
+			args:          map[string]interface{}{argumentNumPartitions: 2, argumentRetentionMs: 60000},
+			partitions:    2,
+			replicas:      1,
+			actualConfig:  []sarama.ConfigEntry{{Name: "retention.ms", Value: "60000"}},
+			wantCondition: corev1.ConditionTrue,
+		},
+		{
+			name:           "partitions added",
+			args:           map[string]interface{}{argumentNumPartitions: 4},
+			partitions:     2,
+			replicas:       1,
+			wantPartitions: 4,
+			wantCondition:  corev1.ConditionTrue,
+		},
+		{
+			name:          "partitions can not be removed",
+			args:          map[string]interface{}{argumentNumPartitions: 2},
+			partitions:    4,
+			replicas:      1,
+			wantCondition: corev1.ConditionFalse,
+			wantMessage:   fmt.Sprintf("topic %s has 4 partitions instead of 2, partitions can not be removed", topicName),
+		},
+		{
+			name:          "replication factor can not be changed",
+			args:          map[string]interface{}{argumentReplicationFactor: 3},
+			partitions:    1,
+			replicas:      1,
+			wantCondition: corev1.ConditionFalse,
+			wantMessage:   fmt.Sprintf("topic %s has a replication factor of 1 instead of 3, it can not be changed in place", topicName),
+		},
+		{
+			name:         "config altered",
+			args:         map[string]interface{}{argumentRetentionMs: 60000, argumentCleanupPolicy: "compact"},
+			partitions:   1,
+			replicas:     1,
+			actualConfig: []sarama.ConfigEntry{{Name: "retention.ms", Value: "60000"}, {Name: "cleanup.policy", Value: "delete", Default: true}},
+			wantAltered: map[string]*string{
+				"retention.ms":   stringPtr("60000"),
+				"cleanup.policy": stringPtr("compact"),
+			},
+			wantCondition: corev1.ConditionTrue,
+		},
+		{
+			name:       "config altered keeping the other configs of the topic",
+			args:       map[string]interface{}{argumentRetentionMs: 60000},
+			partitions: 1,
+			replicas:   1,
+			actualConfig: []sarama.ConfigEntry{
+				{Name: "retention.ms", Value: "120000"},
+				{Name: "segment.ms", Value: "3600000"},
+				{Name: "max.message.bytes", Value: "2097152", Source: sarama.SourceTopic},
+				{Name: "flush.ms", Value: "1000", Source: sarama.SourceStaticBroker},
+				{Name: "cleanup.policy", Value: "delete", Default: true},
+				{Name: "message.format.version", Value: "1.1", ReadOnly: true},
+			},
+			wantAltered: map[string]*string{
+				"retention.ms":      stringPtr("60000"),
+				"segment.ms":        stringPtr("3600000"),
+				"max.message.bytes": stringPtr("2097152"),
+			},
+			wantCondition: corev1.ConditionTrue,
+		},
+		{
+			name:          "describe error",
+			args:          map[string]interface{}{argumentRetentionMs: 60000},
+			partitions:    1,
+			replicas:      1,
+			describeError: errors.New("describe failed"),
+			wantError:     "describe failed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := provisioners.NewProvisionerLoggerFromConfig(provisioners.NewLoggingConfig())
+			r := &reconciler{
+				config: getControllerConfig(),
+				logger: logger.Desugar(),
+			}
+			var gotPartitions int32
+			var gotAltered map[string]*string
+			kafkaClusterAdmin := &mockClusterAdmin{
+				mockCreateTopicFunc: func(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+					return sarama.ErrTopicAlreadyExists
+				},
+				mockCreatePartitionsFunc: func(topic string, count int32) error {
+					gotPartitions = count
+					return nil
+				},
+				mockDescribeConfigFunc: func(resource sarama.ConfigResource) ([]sarama.ConfigEntry, error) {
+					if resource.Type != sarama.TopicResource || resource.Name != topicName {
+						t.Errorf("unexpected config resource: %+v", resource)
+					}
+					return tc.actualConfig, tc.describeError
+				},
+				mockAlterConfigFunc: func(name string, entries map[string]*string) error {
+					gotAltered = entries
+					return nil
+				},
+			}
+			c := getNewChannelWithArgs(channelName, tc.args)
+			err := r.provisionChannel(c, kafkaClusterAdmin, &mockKafkaClient{partitions: tc.partitions, replicas: tc.replicas})
+
+			var gotError string
+			if err != nil {
+				gotError = err.Error()
+			}
+			if diff := cmp.Diff(tc.wantError, gotError); diff != "" {
+				t.Errorf("unexpected error (-want, +got) = %v", diff)
+			}
+			if gotPartitions != tc.wantPartitions {
+				t.Errorf("unexpected partitions created. Expected %d. Actual %d", tc.wantPartitions, gotPartitions)
+			}
+			if diff := cmp.Diff(tc.wantAltered, gotAltered); diff != "" {
+				t.Errorf("unexpected altered configs (-want, +got) = %v", diff)
+			}
+			if tc.wantError != "" {
+				return
+			}
+			cond := c.Status.GetCondition(ChannelConditionTopicConfigured)
+			if cond == nil {
+				t.Fatalf("expected the %s condition to be set", ChannelConditionTopicConfigured)
+			}
+			if cond.Status != tc.wantCondition || cond.Message != tc.wantMessage {
+				t.Errorf("unexpected condition. Expected %s %q. Actual %s %q", tc.wantCondition, tc.wantMessage, cond.Status, cond.Message)
+			}
+			if c.Status.IsReady() {
+				t.Errorf("the topic condition must not make the Channel ready")
+			}
+		})
+	}
+}
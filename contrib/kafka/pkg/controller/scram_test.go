@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/xdg/scram"
+)
+
+func TestSCRAMClient(t *testing.T) {
+	for name, hashGenerator := range map[string]scram.HashGeneratorFcn{
+		"SCRAM-SHA-256": scram.SHA256,
+		"SCRAM-SHA-512": scramSHA512,
+	} {
+		t.Run(name, func(t *testing.T) {
+			credentials, err := hashGenerator.NewClient("knative", "secret", "")
+			if err != nil {
+				t.Fatalf("Unable to create the credentials: %v", err)
+			}
+			stored := credentials.GetStoredCredentials(scram.KeyFactors{Salt: "salt", Iters: 4096})
+			server, err := hashGenerator.NewServer(func(user string) (scram.StoredCredentials, error) {
+				return stored, nil
+			})
+			if err != nil {
+				t.Fatalf("Unable to create the server: %v", err)
+			}
+
+			client := newSCRAMClientGenerator(hashGenerator)()
+			if err := client.Begin("knative", "secret", ""); err != nil {
+				t.Fatalf("Begin failed: %v", err)
+			}
+			serverConversation := server.NewConversation()
+			challenge := ""
+			for !client.Done() {
+				response, err := client.Step(challenge)
+				if err != nil {
+					t.Fatalf("Client step failed: %v", err)
+				}
+				if client.Done() {
+					break
+				}
+				if challenge, err = serverConversation.Step(response); err != nil {
+					t.Fatalf("Server step failed: %v", err)
+				}
+			}
+			if !serverConversation.Valid() {
+				t.Error("The server did not authenticate the client")
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+
+	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+	topicUtils "github.com/knative/eventing/pkg/provisioners/utils"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// KafkaChannelStatus is the struct saved to Channel's status.internal if the Channel's provisioner
+// is kafka. It is used to send data to the dispatcher from the controller.
+type KafkaChannelStatus struct {
+	// Topic is the name of the Kafka topic holding the events of this Channel.
+	Topic string `json:"topic,omitempty"`
+	// ExternalTopic is true if Topic is managed outside of Knative, in which case it is neither
+	// created nor deleted with the Channel.
+	ExternalTopic bool `json:"externalTopic,omitempty"`
+	// DeliveryTopics are the retry and dead-letter topics created for the subscribers of this
+	// Channel. They are recorded before they are created, and kept once their subscriber is
+	// removed, so that they are all deleted with the Channel.
+	DeliveryTopics []string `json:"deliveryTopics,omitempty"`
+}
+
+// SetInternalStatus saves KafkaChannelStatus to the given Channel, which should only be one whose
+// provisioner is kafka.
+func SetInternalStatus(c *eventingv1alpha1.Channel, kcs *KafkaChannelStatus) error {
+	jb, err := json.Marshal(kcs)
+	if err != nil {
+		return err
+	}
+	c.Status.Internal = &runtime.RawExtension{
+		Raw: jb,
+	}
+	return nil
+}
+
+// GetInternalStatus reads KafkaChannelStatus from the given Channel, which should only be one whose
+// provisioner is kafka. If the internal status is not set, then the empty KafkaChannelStatus is
+// returned.
+func GetInternalStatus(c *eventingv1alpha1.Channel) (*KafkaChannelStatus, error) {
+	if c.Status.Internal == nil || len(c.Status.Internal.Raw) == 0 {
+		return &KafkaChannelStatus{}, nil
+	}
+	var kcs KafkaChannelStatus
+	if err := json.Unmarshal(c.Status.Internal.Raw, &kcs); err != nil {
+		return nil, err
+	}
+	return &kcs, nil
+}
+
+// RecordedTopic returns the topic recorded in the status of c, or "" if there is none.
+func RecordedTopic(c *eventingv1alpha1.Channel) string {
+	kcs, err := GetInternalStatus(c)
+	if err != nil {
+		return ""
+	}
+	return kcs.Topic
+}
+
+// ChannelTopic returns the name of the topic of c. Channels provisioned before topic names were
+// recorded in their status use the topic named after their namespace and name.
+func ChannelTopic(c *eventingv1alpha1.Channel) string {
+	if topic := RecordedTopic(c); topic != "" {
+		return topic
+	}
+	return topicUtils.TopicName(KafkaChannelSeparator, c.Namespace, c.Name)
+}
+
+// NewChannelTopic returns the name of the topic provisioned for c when none is recorded yet.
+// Channels that were provisioned before topic names were recorded keep their name-based topic,
+// whatever naming.
+func NewChannelTopic(c *eventingv1alpha1.Channel, naming TopicNaming) string {
+	if naming == TopicNamingUID && c.Status.Address.Hostname == "" {
+		return topicUtils.TopicNameWithUID(KafkaChannelSeparator, c.Name, c.UID)
+	}
+	return topicUtils.TopicName(KafkaChannelSeparator, c.Namespace, c.Name)
+}
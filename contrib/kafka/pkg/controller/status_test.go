@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+)
+
+func TestChannelTopic(t *testing.T) {
+	channel := func(internal string, hostname string) *eventingv1alpha1.Channel {
+		c := &eventingv1alpha1.Channel{}
+		c.Namespace = "ns"
+		c.Name = "channel"
+		c.UID = "uid"
+		if internal != "" {
+			c.Status.Internal = &runtime.RawExtension{Raw: []byte(internal)}
+		}
+		c.Status.SetAddress(hostname)
+		return c
+	}
+	testCases := map[string]struct {
+		channel      *eventingv1alpha1.Channel
+		naming       TopicNaming
+		recorded     string
+		topic        string
+		newTopicName string
+	}{
+		"new channel": {
+			channel:      channel("", ""),
+			topic:        "knative-eventing-channel.ns.channel",
+			newTopicName: "knative-eventing-channel.ns.channel",
+		},
+		"new channel named after its uid": {
+			channel:      channel("", ""),
+			naming:       TopicNamingUID,
+			topic:        "knative-eventing-channel.ns.channel",
+			newTopicName: "knative-eventing-channel.channel.uid",
+		},
+		"channel provisioned before topics were recorded": {
+			channel:      channel("", "channel.ns.svc.cluster.local"),
+			naming:       TopicNamingUID,
+			topic:        "knative-eventing-channel.ns.channel",
+			newTopicName: "knative-eventing-channel.ns.channel",
+		},
+		"recorded topic": {
+			channel:      channel(`{"topic":"knative-eventing-channel.channel.uid"}`, "channel.ns.svc.cluster.local"),
+			recorded:     "knative-eventing-channel.channel.uid",
+			topic:        "knative-eventing-channel.channel.uid",
+			newTopicName: "knative-eventing-channel.ns.channel",
+		},
+		"invalid internal status": {
+			channel:      channel(`{"topic":`, ""),
+			topic:        "knative-eventing-channel.ns.channel",
+			newTopicName: "knative-eventing-channel.ns.channel",
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			if got := RecordedTopic(tc.channel); got != tc.recorded {
+				t.Errorf("Unexpected recorded topic. Expected '%v'. Actual '%v'", tc.recorded, got)
+			}
+			if got := ChannelTopic(tc.channel); got != tc.topic {
+				t.Errorf("Unexpected topic. Expected '%v'. Actual '%v'", tc.topic, got)
+			}
+			if got := NewChannelTopic(tc.channel, tc.naming); got != tc.newTopicName {
+				t.Errorf("Unexpected new topic. Expected '%v'. Actual '%v'", tc.newTopicName, got)
+			}
+		})
+	}
+}
+
+func TestSetInternalStatus(t *testing.T) {
+	c := &eventingv1alpha1.Channel{}
+	if err := SetInternalStatus(c, &KafkaChannelStatus{Topic: "topic"}); err != nil {
+		t.Fatalf("Unexpected error setting the internal status: %v", err)
+	}
+	kcs, err := GetInternalStatus(c)
+	if err != nil {
+		t.Fatalf("Unexpected error getting the internal status: %v", err)
+	}
+	if kcs.Topic != "topic" {
+		t.Errorf("Unexpected topic. Expected 'topic'. Actual '%v'", kcs.Topic)
+	}
+}
@@ -1,8 +1,77 @@
 package controller
 
-import cluster "github.com/bsm/sarama-cluster"
+import (
+	"crypto/tls"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg/scram"
+)
+
+// ConsumerMode is how the dispatcher consumes the partitions claimed by a subscription.
+type ConsumerMode int
+
+const (
+	// ConsumerModeMultiplex dispatches the messages of all the claimed partitions one at a time.
+	ConsumerModeMultiplex ConsumerMode = iota
+	// ConsumerModePartitions dispatches the messages of each claimed partition concurrently.
+	ConsumerModePartitions
+)
+
+// TopicNaming is how the topics of new Channels are named.
+type TopicNaming int
+
+const (
+	// TopicNamingName names topics after the namespace and name of their Channel.
+	TopicNamingName TopicNaming = iota
+	// TopicNamingUID names topics after the name and UID of their Channel, so that a Channel
+	// recreated with the same name never reuses the topic of the deleted one.
+	TopicNamingUID
+)
 
 type KafkaProvisionerConfig struct {
 	Brokers      []string
-	ConsumerMode cluster.ConsumerMode
+	ConsumerMode ConsumerMode
+	// TopicNaming is how the topics of new Channels are named. Existing Channels keep the topic
+	// recorded in their status.
+	TopicNaming TopicNaming
+	// SyncPublish makes the dispatcher wait for Kafka to acknowledge each message before
+	// accepting it.
+	SyncPublish bool
+	// LagThreshold is the number of messages a subscriber can be behind before its Subscription
+	// is marked as lagging. 0 disables the Lagging condition.
+	LagThreshold int64
+	// TLS encrypts the connections to the brokers. It is nil if they are in plaintext.
+	TLS *tls.Config
+	// SASL authenticates the connections to the brokers. It is nil if they are not authenticated.
+	SASL *SASLConfig
+}
+
+// SASLConfig holds the SASL credentials presented to the brokers.
+type SASLConfig struct {
+	// Mechanism is PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512.
+	Mechanism string
+	User      string
+	Password  string
+}
+
+// ConfigureNet applies the TLS and SASL settings of c to conf. Every admin client, producer and
+// consumer connecting to the brokers must be configured with it.
+func (c *KafkaProvisionerConfig) ConfigureNet(conf *sarama.Config) {
+	if c.TLS != nil {
+		conf.Net.TLS.Enable = true
+		conf.Net.TLS.Config = c.TLS
+	}
+	if c.SASL != nil {
+		conf.Net.SASL.Enable = true
+		conf.Net.SASL.Handshake = true
+		conf.Net.SASL.User = c.SASL.User
+		conf.Net.SASL.Password = c.SASL.Password
+		conf.Net.SASL.Mechanism = sarama.SASLMechanism(c.SASL.Mechanism)
+		switch c.SASL.Mechanism {
+		case SASLMechanismSCRAMSHA256Value:
+			conf.Net.SASL.SCRAMClientGeneratorFunc = newSCRAMClientGenerator(scram.SHA256)
+		case SASLMechanismSCRAMSHA512Value:
+			conf.Net.SASL.SCRAMClientGeneratorFunc = newSCRAMClientGenerator(scramSHA512)
+		}
+	}
 }
@@ -1,21 +1,61 @@
 package controller
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 
-	cluster "github.com/bsm/sarama-cluster"
-
+	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
+	"github.com/knative/eventing/pkg/sidecar/multichannelfanout"
 	"github.com/knative/pkg/configmap"
+	corev1 "k8s.io/api/core/v1"
 )
 
 const (
 	BrokerConfigMapKey                 = "bootstrap_servers"
 	ConsumerModeConfigMapKey           = "consumer_mode"
 	ConsumerModePartitionConsumerValue = "partitions"
+	PublishModeConfigMapKey            = "publish_mode"
+	PublishModeSyncValue               = "sync"
 	KafkaChannelSeparator              = "."
+	TLSEnabledConfigMapKey             = "tls_enabled"
+	TLSSecretPathConfigMapKey          = "tls_secret_path"
+	SASLMechanismConfigMapKey          = "sasl_mechanism"
+	SASLSecretPathConfigMapKey         = "sasl_secret_path"
+	SASLMechanismPlainValue            = "PLAIN"
+	SASLMechanismSCRAMSHA256Value      = "SCRAM-SHA-256"
+	SASLMechanismSCRAMSHA512Value      = "SCRAM-SHA-512"
+	LagThresholdConfigMapKey           = "lag_threshold"
+	TopicNamingConfigMapKey            = "topic_naming"
+	TopicNamingUIDValue                = "uid"
+	// SASLUserKey and SASLPasswordKey are the keys of the SASL Secret holding the credentials.
+	SASLUserKey     = "user"
+	SASLPasswordKey = "password"
+	// DefaultTLSSecretPath and DefaultSASLSecretPath are where the TLS and SASL Secrets are
+	// mounted, unless the ConfigMap says otherwise.
+	DefaultTLSSecretPath  = "/etc/kafka-tls"
+	DefaultSASLSecretPath = "/etc/kafka-sasl"
+	// DefaultLagThreshold is the number of messages a subscriber can be behind before its
+	// Subscription is marked as lagging.
+	DefaultLagThreshold = 1000
+	// DefaultEventType is the type of the CloudEvents wrapping the records that are not
+	// CloudEvents, unless the EventType argument of their Channel says otherwise.
+	DefaultEventType = "dev.knative.kafka.record"
+
+	retryTopicSuffix      = "retry"
+	deadLetterTopicSuffix = "dlq"
 )
 
+// partitionKeyRegexp matches the names of CloudEvents attributes and extensions.
+var partitionKeyRegexp = regexp.MustCompile("^[a-z0-9]+$")
+
+// topicNameRegexp matches the names Kafka accepts for topics.
+var topicNameRegexp = regexp.MustCompile("^[a-zA-Z0-9._-]{1,249}$")
+
 // GetProvisionerConfig returns the details of the associated ClusterChannelProvisioner object
 func GetProvisionerConfig(path string) (*KafkaProvisionerConfig, error) {
 	configMap, err := configmap.Load(path)
@@ -41,11 +81,192 @@ func GetProvisionerConfig(path string) (*KafkaProvisionerConfig, error) {
 		return nil, fmt.Errorf("missing key %s in provisioner configuration", BrokerConfigMapKey)
 	}
 
-	config.ConsumerMode = cluster.ConsumerModeMultiplex
+	config.ConsumerMode = ConsumerModeMultiplex
 	if mode, ok := configMap[ConsumerModeConfigMapKey]; ok {
 		if strings.ToLower(mode) == ConsumerModePartitionConsumerValue {
-			config.ConsumerMode = cluster.ConsumerModePartitions
+			config.ConsumerMode = ConsumerModePartitions
+		}
+	}
+
+	config.TopicNaming = TopicNamingName
+	if naming, ok := configMap[TopicNamingConfigMapKey]; ok {
+		if strings.ToLower(naming) == TopicNamingUIDValue {
+			config.TopicNaming = TopicNamingUID
+		}
+	}
+
+	if mode, ok := configMap[PublishModeConfigMapKey]; ok {
+		config.SyncPublish = strings.ToLower(mode) == PublishModeSyncValue
+	}
+
+	config.LagThreshold = DefaultLagThreshold
+	if threshold, ok := configMap[LagThresholdConfigMapKey]; ok {
+		if config.LagThreshold, err = strconv.ParseInt(threshold, 10, 64); err != nil || config.LagThreshold < 0 {
+			return nil, fmt.Errorf("invalid %s value %q in provisioner configuration, it must be a non-negative number of messages", LagThresholdConfigMapKey, threshold)
+		}
+	}
+
+	if enabled, ok := configMap[TLSEnabledConfigMapKey]; ok && strings.ToLower(enabled) == "true" {
+		path := configMap[TLSSecretPathConfigMapKey]
+		if path == "" {
+			path = DefaultTLSSecretPath
+		}
+		if config.TLS, err = loadTLSConfig(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if mechanism, ok := configMap[SASLMechanismConfigMapKey]; ok && mechanism != "" {
+		path := configMap[SASLSecretPathConfigMapKey]
+		if path == "" {
+			path = DefaultSASLSecretPath
+		}
+		if config.SASL, err = loadSASLConfig(mechanism, path); err != nil {
+			return nil, err
 		}
 	}
 	return config, nil
 }
+
+// loadTLSConfig builds the TLS configuration from the Secret mounted at path. The Secret is laid
+// out like the subscriber TLS Secrets, see provisioners.TLSConfigFromSecret. An empty Secret
+// verifies the brokers with the system roots.
+func loadTLSConfig(path string) (*tls.Config, error) {
+	data, err := loadSecret(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading TLS secret: %s", err)
+	}
+	tlsConfig, err := provisioners.TLSConfigFromSecret(&corev1.Secret{Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS secret: %s", err)
+	}
+	return tlsConfig, nil
+}
+
+// loadSASLConfig reads the credentials of mechanism from the Secret mounted at path. PLAIN,
+// SCRAM-SHA-256 and SCRAM-SHA-512 are supported.
+func loadSASLConfig(mechanism, path string) (*SASLConfig, error) {
+	mechanism = strings.ToUpper(mechanism)
+	switch mechanism {
+	case SASLMechanismPlainValue, SASLMechanismSCRAMSHA256Value, SASLMechanismSCRAMSHA512Value:
+	default:
+		return nil, fmt.Errorf("unsupported %s %q, only %s, %s and %s are supported", SASLMechanismConfigMapKey, mechanism,
+			SASLMechanismPlainValue, SASLMechanismSCRAMSHA256Value, SASLMechanismSCRAMSHA512Value)
+	}
+	data, err := loadSecret(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading SASL secret: %s", err)
+	}
+	user, password := string(data[SASLUserKey]), string(data[SASLPasswordKey])
+	if user == "" || password == "" {
+		return nil, fmt.Errorf("missing key %s or %s in SASL secret", SASLUserKey, SASLPasswordKey)
+	}
+	return &SASLConfig{
+		Mechanism: mechanism,
+		User:      user,
+		Password:  password,
+	}, nil
+}
+
+// loadSecret reads the keys of the Secret mounted at path.
+func loadSecret(path string) (map[string][]byte, error) {
+	files, err := configmap.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string][]byte, len(files))
+	for k, v := range files {
+		data[k] = []byte(v)
+	}
+	return data, nil
+}
+
+// IsControlled returns true if c is provisioned by the kafka ClusterChannelProvisioner.
+func IsControlled(c *eventingv1alpha1.Channel) bool {
+	return c.Spec.Provisioner != nil && c.Spec.Provisioner.Name == Name
+}
+
+// IsValidPartitionKey returns true if name can be used as the PartitionKey argument of a Channel.
+// It must be the name of a CloudEvents attribute or extension, made of lower-case letters and
+// digits.
+func IsValidPartitionKey(name string) bool {
+	return partitionKeyRegexp.MatchString(name)
+}
+
+// PartitionKey returns the name of the CloudEvents attribute, or extension, whose value is the key
+// of the records published to c's topic. It is empty if c's arguments do not name a valid one.
+func PartitionKey(c *eventingv1alpha1.Channel) string {
+	if c.Spec.Arguments == nil || len(c.Spec.Arguments.Raw) == 0 {
+		return ""
+	}
+	var args struct {
+		PartitionKey string
+	}
+	if err := json.Unmarshal(c.Spec.Arguments.Raw, &args); err != nil || !IsValidPartitionKey(args.PartitionKey) {
+		return ""
+	}
+	return args.PartitionKey
+}
+
+// IsValidTopicName returns true if name can name a Kafka topic.
+func IsValidTopicName(name string) bool {
+	return topicNameRegexp.MatchString(name) && name != "." && name != ".."
+}
+
+// WrappedEvent returns the type and source of the CloudEvents wrapping the records of c's topic
+// that are not CloudEvents, written to the topic by other systems. They are set by the EventType
+// and EventSource arguments of c, and default to DefaultEventType and to the name of the topic.
+func WrappedEvent(c *eventingv1alpha1.Channel) (eventType, eventSource string) {
+	var args struct {
+		EventType   string
+		EventSource string
+	}
+	if c.Spec.Arguments != nil && len(c.Spec.Arguments.Raw) > 0 {
+		if err := json.Unmarshal(c.Spec.Arguments.Raw, &args); err != nil {
+			// Invalid arguments are reported by the Channel controller, the defaults apply
+			// meanwhile.
+			args.EventType, args.EventSource = "", ""
+		}
+	}
+	if args.EventType == "" {
+		args.EventType = DefaultEventType
+	}
+	if args.EventSource == "" {
+		args.EventSource = ChannelTopic(c)
+	}
+	return args.EventType, args.EventSource
+}
+
+// MultiChannelFanoutConfig creates a multichannelfanout.Config for the kafka channels. The
+// partition key of each Channel is recorded as its PartitionKeyExtension, its topic as its Topic,
+// and, for the Channels of external topics, the CloudEvents wrapping the records that are not
+// CloudEvents as its EventType and EventSource.
+func MultiChannelFanoutConfig(channels []eventingv1alpha1.Channel) *multichannelfanout.Config {
+	config := multichannelfanout.NewConfigFromChannels(channels)
+	for i := range channels {
+		cc := &config.ChannelConfigs[i]
+		cc.FanoutConfig.PartitionKeyExtension = PartitionKey(&channels[i])
+		cc.Topic = ChannelTopic(&channels[i])
+		if kcs, err := GetInternalStatus(&channels[i]); err == nil && kcs.ExternalTopic {
+			cc.ExternalTopic = true
+			cc.EventType, cc.EventSource = WrappedEvent(&channels[i])
+		}
+	}
+	return config
+}
+
+// RetryTopicName returns the name of the topic holding the events waiting to be redelivered to the
+// Subscription named subscription of the Channel whose topic is channelTopic.
+func RetryTopicName(channelTopic, subscription string) string {
+	return subscriptionTopicName(channelTopic, subscription, retryTopicSuffix)
+}
+
+// DeadLetterTopicName returns the name of the topic holding the events that could not be delivered
+// to the Subscription named subscription of the Channel whose topic is channelTopic.
+func DeadLetterTopicName(channelTopic, subscription string) string {
+	return subscriptionTopicName(channelTopic, subscription, deadLetterTopicSuffix)
+}
+
+func subscriptionTopicName(channelTopic, subscription, suffix string) string {
+	return strings.Join([]string{channelTopic, subscription, suffix}, KafkaChannelSeparator)
+}
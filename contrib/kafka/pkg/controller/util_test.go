@@ -1,15 +1,29 @@
 package controller
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"io/ioutil"
+	"math/big"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
-	cluster "github.com/bsm/sarama-cluster"
-
+	"github.com/Shopify/sarama"
 	"github.com/google/go-cmp/cmp"
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+	"github.com/knative/eventing/pkg/sidecar/fanout"
+	"github.com/knative/eventing/pkg/sidecar/multichannelfanout"
 	_ "github.com/knative/pkg/system/testing"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 func TestGetProvisionerConfigBrokers(t *testing.T) {
@@ -45,14 +59,16 @@ func TestGetProvisionerConfigBrokers(t *testing.T) {
 			name: "single bootstrap_servers",
 			data: map[string]string{"bootstrap_servers": "kafkabroker.kafka:9092"},
 			expected: &KafkaProvisionerConfig{
-				Brokers: []string{"kafkabroker.kafka:9092"},
+				Brokers:      []string{"kafkabroker.kafka:9092"},
+				LagThreshold: DefaultLagThreshold,
 			},
 		},
 		{
 			name: "multiple bootstrap_servers",
 			data: map[string]string{"bootstrap_servers": "kafkabroker1.kafka:9092,kafkabroker2.kafka:9092"},
 			expected: &KafkaProvisionerConfig{
-				Brokers: []string{"kafkabroker1.kafka:9092", "kafkabroker2.kafka:9092"},
+				Brokers:      []string{"kafkabroker1.kafka:9092", "kafkabroker2.kafka:9092"},
+				LagThreshold: DefaultLagThreshold,
 			},
 		},
 		{
@@ -60,7 +76,8 @@ func TestGetProvisionerConfigBrokers(t *testing.T) {
 			data: map[string]string{"bootstrap_servers": "kafkabroker.kafka:9092", "consumer_mode": "partitions"},
 			expected: &KafkaProvisionerConfig{
 				Brokers:      []string{"kafkabroker.kafka:9092"},
-				ConsumerMode: cluster.ConsumerModePartitions,
+				LagThreshold: DefaultLagThreshold,
+				ConsumerMode: ConsumerModePartitions,
 			},
 		},
 		{
@@ -68,7 +85,46 @@ func TestGetProvisionerConfigBrokers(t *testing.T) {
 			data: map[string]string{"bootstrap_servers": "kafkabroker.kafka:9092", "consumer_mode": "multiplex"},
 			expected: &KafkaProvisionerConfig{
 				Brokers:      []string{"kafkabroker.kafka:9092"},
-				ConsumerMode: cluster.ConsumerModeMultiplex,
+				LagThreshold: DefaultLagThreshold,
+				ConsumerMode: ConsumerModeMultiplex,
+			},
+		},
+		{
+			name: "sync publish",
+			data: map[string]string{"bootstrap_servers": "kafkabroker.kafka:9092", "publish_mode": "sync"},
+			expected: &KafkaProvisionerConfig{
+				Brokers:      []string{"kafkabroker.kafka:9092"},
+				LagThreshold: DefaultLagThreshold,
+				SyncPublish:  true,
+			},
+		},
+		{
+			name: "lag threshold",
+			data: map[string]string{"bootstrap_servers": "kafkabroker.kafka:9092", "lag_threshold": "0"},
+			expected: &KafkaProvisionerConfig{
+				Brokers: []string{"kafkabroker.kafka:9092"},
+			},
+		},
+		{
+			name:     "invalid lag threshold",
+			data:     map[string]string{"bootstrap_servers": "kafkabroker.kafka:9092", "lag_threshold": "-1"},
+			getError: `invalid lag_threshold value "-1" in provisioner configuration, it must be a non-negative number of messages`,
+		},
+		{
+			name: "uid topic naming",
+			data: map[string]string{"bootstrap_servers": "kafkabroker.kafka:9092", "topic_naming": "UID"},
+			expected: &KafkaProvisionerConfig{
+				Brokers:      []string{"kafkabroker.kafka:9092"},
+				LagThreshold: DefaultLagThreshold,
+				TopicNaming:  TopicNamingUID,
+			},
+		},
+		{
+			name: "default async publish",
+			data: map[string]string{"bootstrap_servers": "kafkabroker.kafka:9092", "publish_mode": "async"},
+			expected: &KafkaProvisionerConfig{
+				Brokers:      []string{"kafkabroker.kafka:9092"},
+				LagThreshold: DefaultLagThreshold,
 			},
 		},
 	}
@@ -116,3 +172,295 @@ func TestGetProvisionerConfigBrokers(t *testing.T) {
 	}
 
 }
+
+func TestGetProvisionerConfigSecurity(t *testing.T) {
+	cert, key := generateCertificate(t)
+
+	testCases := []struct {
+		name       string
+		data       map[string]string
+		tlsSecret  map[string]string
+		saslSecret map[string]string
+		getError   string
+		wantCA     bool
+		wantCert   bool
+		wantSASL   *SASLConfig
+	}{
+		{
+			name: "tls disabled",
+			data: map[string]string{"tls_enabled": "false"},
+		},
+		{
+			name:      "tls with system roots",
+			data:      map[string]string{"tls_enabled": "true"},
+			tlsSecret: map[string]string{},
+		},
+		{
+			name:      "tls with CA",
+			data:      map[string]string{"tls_enabled": "true"},
+			tlsSecret: map[string]string{"ca.crt": string(cert)},
+			wantCA:    true,
+		},
+		{
+			name:      "mutual tls",
+			data:      map[string]string{"tls_enabled": "true"},
+			tlsSecret: map[string]string{"ca.crt": string(cert), "tls.crt": string(cert), "tls.key": string(key)},
+			wantCA:    true,
+			wantCert:  true,
+		},
+		{
+			name:      "invalid CA",
+			data:      map[string]string{"tls_enabled": "true"},
+			tlsSecret: map[string]string{"ca.crt": "garbage"},
+			getError:  `invalid TLS secret: "ca.crt" does not contain a PEM encoded certificate`,
+		},
+		{
+			name:     "missing TLS secret",
+			data:     map[string]string{"tls_enabled": "true", "tls_secret_path": "/tmp/does_not_exist"},
+			getError: "error loading TLS secret: lstat /tmp/does_not_exist: no such file or directory",
+		},
+		{
+			name:       "sasl plain",
+			data:       map[string]string{"sasl_mechanism": "PLAIN"},
+			saslSecret: map[string]string{"user": "knative", "password": "secret"},
+			wantSASL:   &SASLConfig{Mechanism: "PLAIN", User: "knative", Password: "secret"},
+		},
+		{
+			name:       "sasl missing password",
+			data:       map[string]string{"sasl_mechanism": "PLAIN"},
+			saslSecret: map[string]string{"user": "knative"},
+			getError:   "missing key user or password in SASL secret",
+		},
+		{
+			name:       "sasl scram",
+			data:       map[string]string{"sasl_mechanism": "scram-sha-512"},
+			saslSecret: map[string]string{"user": "knative", "password": "secret"},
+			wantSASL:   &SASLConfig{Mechanism: "SCRAM-SHA-512", User: "knative", Password: "secret"},
+		},
+		{
+			name:       "sasl unsupported",
+			data:       map[string]string{"sasl_mechanism": "GSSAPI"},
+			saslSecret: map[string]string{"user": "knative", "password": "secret"},
+			getError:   `unsupported sasl_mechanism "GSSAPI", only PLAIN, SCRAM-SHA-256 and SCRAM-SHA-512 are supported`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "util_test")
+			if err != nil {
+				t.Fatalf("error creating tmp directory: %v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			data := map[string]string{"bootstrap_servers": "kafkabroker.kafka:9092"}
+			for k, v := range tc.data {
+				data[k] = v
+			}
+			if tc.tlsSecret != nil {
+				data["tls_secret_path"] = writeFiles(t, filepath.Join(dir, "tls"), tc.tlsSecret)
+			}
+			if tc.saslSecret != nil {
+				data["sasl_secret_path"] = writeFiles(t, filepath.Join(dir, "sasl"), tc.saslSecret)
+			}
+			got, err := GetProvisionerConfig(writeFiles(t, filepath.Join(dir, "config"), data))
+
+			if tc.getError != "" {
+				if err == nil || err.Error() != tc.getError {
+					t.Errorf("Unexpected Config error. Expected '%v'. Actual '%v'", tc.getError, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Unexpected Config error. Expected nil. Actual '%v'", err)
+			}
+
+			if tc.tlsSecret == nil {
+				if got.TLS != nil {
+					t.Errorf("Expected plaintext. Actual %v", got.TLS)
+				}
+			} else if got.TLS == nil {
+				t.Errorf("Expected TLS. Actual nil")
+			} else {
+				if hasCA := got.TLS.RootCAs != nil; hasCA != tc.wantCA {
+					t.Errorf("Unexpected CA. Expected %v. Actual %v", tc.wantCA, hasCA)
+				}
+				if hasCert := len(got.TLS.Certificates) > 0; hasCert != tc.wantCert {
+					t.Errorf("Unexpected client certificate. Expected %v. Actual %v", tc.wantCert, hasCert)
+				}
+			}
+			if diff := cmp.Diff(tc.wantSASL, got.SASL); diff != "" {
+				t.Errorf("unexpected SASL (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestConfigureNet(t *testing.T) {
+	tlsConfig := &tls.Config{}
+	config := &KafkaProvisionerConfig{
+		TLS:  tlsConfig,
+		SASL: &SASLConfig{Mechanism: "PLAIN", User: "knative", Password: "secret"},
+	}
+	conf := sarama.NewConfig()
+	config.ConfigureNet(conf)
+
+	if !conf.Net.TLS.Enable || conf.Net.TLS.Config != tlsConfig {
+		t.Errorf("Expected TLS to be enabled with the provisioner's configuration")
+	}
+	if !conf.Net.SASL.Enable || !conf.Net.SASL.Handshake || conf.Net.SASL.User != "knative" || conf.Net.SASL.Password != "secret" {
+		t.Errorf("Unexpected SASL configuration: %+v", conf.Net.SASL)
+	}
+
+	if conf.Net.SASL.Mechanism != sarama.SASLTypePlaintext || conf.Net.SASL.SCRAMClientGeneratorFunc != nil {
+		t.Errorf("Expected SASL/PLAIN: %+v", conf.Net.SASL)
+	}
+
+	config.SASL.Mechanism = "SCRAM-SHA-256"
+	scramConf := sarama.NewConfig()
+	config.ConfigureNet(scramConf)
+	if scramConf.Net.SASL.Mechanism != sarama.SASLTypeSCRAMSHA256 || scramConf.Net.SASL.SCRAMClientGeneratorFunc == nil {
+		t.Errorf("Expected SASL/SCRAM-SHA-256: %+v", scramConf.Net.SASL)
+	}
+	if err := scramConf.Validate(); err != nil {
+		t.Errorf("Invalid SCRAM configuration: %v", err)
+	}
+
+	plain := sarama.NewConfig()
+	(&KafkaProvisionerConfig{}).ConfigureNet(plain)
+	if plain.Net.TLS.Enable || plain.Net.SASL.Enable {
+		t.Errorf("Expected a plaintext unauthenticated configuration")
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) string {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatalf("error creating directory %s: %v", dir, err)
+	}
+	for k, v := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, k), []byte(v), 0600); err != nil {
+			t.Fatalf("error writing file %s: %v", k, err)
+		}
+	}
+	return dir
+}
+
+func generateCertificate(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate a key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unable to create a certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unable to marshal the key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestSubscriptionTopicNames(t *testing.T) {
+	if got, want := RetryTopicName("knative-eventing-channel.ns.channel", "sub"), "knative-eventing-channel.ns.channel.sub.retry"; got != want {
+		t.Errorf("Unexpected retry topic. Expected '%v'. Actual '%v'", want, got)
+	}
+	if got, want := DeadLetterTopicName("knative-eventing-channel.ns.channel", "sub"), "knative-eventing-channel.ns.channel.sub.dlq"; got != want {
+		t.Errorf("Unexpected dead-letter topic. Expected '%v'. Actual '%v'", want, got)
+	}
+}
+
+func TestIsValidTopicName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"orders":                           true,
+		"knative-eventing-channel.ns.name": true,
+		"Orders_2019":                      true,
+		"":                                 false,
+		".":                                false,
+		"..":                               false,
+		"my topic":                         false,
+		"orders/2019":                      false,
+		strings.Repeat("a", 249):           true,
+		strings.Repeat("a", 250):           false,
+	} {
+		if got := IsValidTopicName(name); got != want {
+			t.Errorf("IsValidTopicName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestMultiChannelFanoutConfig(t *testing.T) {
+	channel := func(name, args string) eventingv1alpha1.Channel {
+		c := eventingv1alpha1.Channel{}
+		c.Namespace = "ns"
+		c.Name = name
+		c.Spec.Subscribable = &eventingduck.Subscribable{
+			Subscribers: []eventingduck.ChannelSubscriberSpec{{SubscriberURI: "sub"}},
+		}
+		if args != "" {
+			c.Spec.Arguments = &runtime.RawExtension{Raw: []byte(args)}
+		}
+		return c
+	}
+	channels := []eventingv1alpha1.Channel{
+		channel("no-args", ""),
+		channel("subject", `{"PartitionKey":"subject"}`),
+		channel("lower-case", `{"partitionKey":"partitionkey"}`),
+		channel("invalid", `{"PartitionKey":"Not Valid"}`),
+		channel("recorded", ""),
+		channel("external", `{"Topic":"orders","ExternalTopic":true}`),
+		channel("wrapped", `{"Topic":"orders","ExternalTopic":true,"EventType":"com.example.order","EventSource":"/orders"}`),
+		channel("not-external", `{"EventType":"com.example.order","EventSource":"/orders"}`),
+	}
+	for i, kcs := range map[int]*KafkaChannelStatus{
+		4: {Topic: "recorded-topic"},
+		5: {Topic: "orders", ExternalTopic: true},
+		6: {Topic: "orders", ExternalTopic: true},
+	} {
+		if err := SetInternalStatus(&channels[i], kcs); err != nil {
+			t.Fatalf("Unable to set the internal status: %v", err)
+		}
+	}
+	fanoutConfig := func(partitionKey string) fanout.Config {
+		return fanout.Config{
+			Subscriptions:         []eventingduck.ChannelSubscriberSpec{{SubscriberURI: "sub"}},
+			PartitionKeyExtension: partitionKey,
+		}
+	}
+	channelConfig := func(name, partitionKey, topic, eventType, eventSource string) multichannelfanout.ChannelConfig {
+		return multichannelfanout.ChannelConfig{
+			Namespace:     "ns",
+			Name:          name,
+			FanoutConfig:  fanoutConfig(partitionKey),
+			Topic:         topic,
+			ExternalTopic: eventType != "",
+			EventType:     eventType,
+			EventSource:   eventSource,
+		}
+	}
+	want := &multichannelfanout.Config{
+		ChannelConfigs: []multichannelfanout.ChannelConfig{
+			channelConfig("no-args", "", "knative-eventing-channel.ns.no-args", "", ""),
+			channelConfig("subject", "subject", "knative-eventing-channel.ns.subject", "", ""),
+			channelConfig("lower-case", "partitionkey", "knative-eventing-channel.ns.lower-case", "", ""),
+			channelConfig("invalid", "", "knative-eventing-channel.ns.invalid", "", ""),
+			channelConfig("recorded", "", "recorded-topic", "", ""),
+			channelConfig("external", "", "orders", DefaultEventType, "orders"),
+			channelConfig("wrapped", "", "orders", "com.example.order", "/orders"),
+			channelConfig("not-external", "", "knative-eventing-channel.ns.not-external", "", ""),
+		},
+	}
+	if diff := cmp.Diff(want, MultiChannelFanoutConfig(channels)); diff != "" {
+		t.Errorf("unexpected config (-want, +got) = %v", diff)
+	}
+}
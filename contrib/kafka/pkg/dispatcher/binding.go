@@ -0,0 +1,61 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"strings"
+)
+
+// The records of the Channels' topics follow the CloudEvents Kafka protocol binding, so that they
+// can be read as CloudEvents by any Kafka consumer:
+//
+//   - Binary mode events, received with ce- prefixed HTTP headers, are written with their
+//     attributes in ce_ prefixed Kafka headers, their content type in the content-type header and
+//     their data as the record value.
+//   - Structured mode events, received as application/cloudevents+json bodies, are written as is,
+//     with the content-type header.
+//
+// The other headers forwarded by the dispatcher, such as the tracing headers, keep their names.
+// Previous versions of the dispatcher wrote the HTTP header names instead, with their original
+// case, and these records are still read.
+const (
+	// kafkaAttributePrefix prefixes the Kafka headers of the CloudEvents attributes.
+	kafkaAttributePrefix = "ce_"
+	// httpAttributePrefix prefixes the HTTP headers of the CloudEvents attributes, and the Kafka
+	// headers of the records written by previous versions of the dispatcher.
+	httpAttributePrefix = "ce-"
+)
+
+// kafkaHeaderName returns the name of the Kafka header carrying the HTTP header name.
+func kafkaHeaderName(name string) string {
+	name = strings.ToLower(name)
+	if strings.HasPrefix(name, httpAttributePrefix) {
+		return kafkaAttributePrefix + strings.TrimPrefix(name, httpAttributePrefix)
+	}
+	return name
+}
+
+// httpHeaderName returns the name of the HTTP header carried by the Kafka header name. legacy is
+// true if name is a CloudEvents attribute in the format of the previous versions of the
+// dispatcher.
+func httpHeaderName(name string) (string, bool) {
+	name = strings.ToLower(name)
+	if strings.HasPrefix(name, kafkaAttributePrefix) {
+		return httpAttributePrefix + strings.TrimPrefix(name, kafkaAttributePrefix), false
+	}
+	return name, strings.HasPrefix(name, httpAttributePrefix)
+}
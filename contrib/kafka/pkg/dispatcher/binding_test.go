@@ -0,0 +1,151 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/knative/eventing/pkg/provisioners"
+)
+
+func TestKafkaHeaderName(t *testing.T) {
+	for http, kafka := range map[string]string{
+		"ce-type":           "ce_type",
+		"Ce-Specversion":    "ce_specversion",
+		"ce-knativehistory": "ce_knativehistory",
+		"Content-Type":      "content-type",
+		"X-B3-Traceid":      "x-b3-traceid",
+		attemptsHeader:      attemptsHeader,
+	} {
+		if got := kafkaHeaderName(http); got != kafka {
+			t.Errorf("kafkaHeaderName(%q) = %q, want %q", http, got, kafka)
+		}
+	}
+}
+
+func TestProducerMessage_Binding(t *testing.T) {
+	testCases := map[string]struct {
+		message *provisioners.Message
+		want    map[string]string
+	}{
+		"binary": {
+			message: &provisioners.Message{
+				Headers: map[string]string{
+					"Ce-Specversion": "0.2",
+					"Ce-Id":          "1",
+					"Ce-Type":        "dev.knative.test",
+					"Ce-Source":      "/test",
+					"Content-Type":   "application/json",
+					"X-B3-Traceid":   "trace",
+				},
+				Payload: []byte(`{"hello":"world"}`),
+			},
+			want: map[string]string{
+				"ce_specversion": "0.2",
+				"ce_id":          "1",
+				"ce_type":        "dev.knative.test",
+				"ce_source":      "/test",
+				"content-type":   "application/json",
+				"x-b3-traceid":   "trace",
+			},
+		},
+		"structured": {
+			message: &provisioners.Message{
+				Headers: map[string]string{
+					"Content-Type": "application/cloudevents+json",
+				},
+				Payload: []byte(`{"specversion":"0.2","id":"1","type":"dev.knative.test","source":"/test"}`),
+			},
+			want: map[string]string{
+				"content-type": "application/cloudevents+json",
+			},
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			produced := newProducerMessage("topic", tc.message)
+			got := make(map[string]string)
+			for _, h := range produced.Headers {
+				got[string(h.Key)] = string(h.Value)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected Kafka headers (-want, +got) = %v", diff)
+			}
+			if value, _ := produced.Value.Encode(); string(value) != string(tc.message.Payload) {
+				t.Errorf("unexpected value %q", value)
+			}
+
+			// The record is read back with the HTTP header names.
+			consumed := fromKafkaMessage(toConsumerMessage(produced))
+			want := make(map[string]string)
+			for name, value := range tc.want {
+				name, _ = httpHeaderName(name)
+				want[name] = value
+			}
+			if diff := cmp.Diff(want, consumed.Headers); diff != "" {
+				t.Errorf("unexpected headers read back (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestFromKafkaMessage_Legacy(t *testing.T) {
+	testCases := map[string]struct {
+		headers [][2]string
+		want    map[string]string
+	}{
+		"legacy": {
+			headers: [][2]string{
+				{"Ce-Type", "dev.knative.test"},
+				{"Content-Type", "application/json"},
+			},
+			want: map[string]string{
+				"ce-type":      "dev.knative.test",
+				"content-type": "application/json",
+			},
+		},
+		"binding after legacy": {
+			headers: [][2]string{
+				{"ce-type", "legacy"},
+				{"ce_type", "binding"},
+			},
+			want: map[string]string{"ce-type": "binding"},
+		},
+		"binding before legacy": {
+			headers: [][2]string{
+				{"ce_type", "binding"},
+				{"Ce-Type", "legacy"},
+			},
+			want: map[string]string{"ce-type": "binding"},
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			msg := &sarama.ConsumerMessage{}
+			for _, h := range tc.headers {
+				msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(h[0]), Value: []byte(h[1])})
+			}
+			got := fromKafkaMessage(msg).Headers
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected headers (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
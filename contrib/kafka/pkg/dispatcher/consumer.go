@@ -0,0 +1,355 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+)
+
+// consumeRetryBackoff is the delay before joining the consumer group again after failing to.
+const consumeRetryBackoff = 2 * time.Second
+
+// PartitionConsumer consumes a single partition claimed by a KafkaConsumer in
+// controller.ConsumerModePartitions.
+type PartitionConsumer interface {
+	Topic() string
+	Partition() int32
+	InitialOffset() int64
+	HighWaterMarkOffset() int64
+	Messages() <-chan *sarama.ConsumerMessage
+}
+
+// ConsumerPositions are where a KafkaConsumer starts consuming the partitions of its topics.
+// Positions are sarama.OffsetOldest, sarama.OffsetNewest or a time in milliseconds since the
+// epoch, which stands for the first message sent at or after it.
+type ConsumerPositions struct {
+	// Start is the position of the partitions the consumer group never committed an offset for.
+	Start int64
+	// Reset is the position every partition is moved to, once per ResetID. It is ignored if
+	// ResetID is empty.
+	Reset int64
+	// ResetID identifies the reset. It is committed as the metadata of the group's offsets, so
+	// that the reset is applied once, even if the consumer is restarted.
+	ResetID string
+}
+
+// groupOffsets reads the offsets committed by a consumer group, and resolves positions to
+// offsets.
+type groupOffsets interface {
+	// Committed returns the offset committed by the group for a partition, -1 if none was, and
+	// its metadata.
+	Committed(topic string, partition int32) (int64, string, error)
+	offsetResolver
+	// Close is called once the group is closed.
+	Close() error
+}
+
+// clientOffsets is a groupOffsets fetching the committed offsets from the group's coordinator.
+type clientOffsets struct {
+	sarama.Client
+	group string
+}
+
+// Committed implements groupOffsets.
+func (o *clientOffsets) Committed(topic string, partition int32) (int64, string, error) {
+	coordinator, err := o.Coordinator(o.group)
+	if err != nil {
+		return 0, "", err
+	}
+	req := &sarama.OffsetFetchRequest{ConsumerGroup: o.group, Version: 1}
+	req.AddPartition(topic, partition)
+	resp, err := coordinator.FetchOffset(req)
+	if err != nil {
+		return 0, "", err
+	}
+	block := resp.GetBlock(topic, partition)
+	if block == nil {
+		return 0, "", sarama.ErrIncompleteResponse
+	}
+	if block.Err != sarama.ErrNoError {
+		return 0, "", block.Err
+	}
+	return block.Offset, block.Metadata, nil
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// groupConsumer is a KafkaConsumer consuming topics as a member of a sarama.ConsumerGroup. It
+// joins the group again after every rebalance, until it is closed.
+//
+// The messages of a claimed partition are handed over one at a time: the next one is only handed
+// over once the previous one was marked. When the group rebalances, or the consumer is closed,
+// the session therefore waits for the message in flight in each partition to be marked, and
+// commits its offset, before releasing the partitions. A message that is not marked before the
+// consumer is closed is consumed again by the next member claiming its partition.
+//
+// Claimed partitions are moved to the positions of the consumer when the session starts, before
+// any message is consumed.
+type groupConsumer struct {
+	group     sarama.ConsumerGroup
+	offsets   groupOffsets
+	topics    []string
+	positions ConsumerPositions
+	logger    *zap.Logger
+
+	// messages receives the messages of all the claimed partitions in multiplex mode.
+	messages chan *sarama.ConsumerMessage
+	// partitions receives a PartitionConsumer per claimed partition in partitions mode, it is
+	// nil in multiplex mode.
+	partitions chan PartitionConsumer
+
+	lock   sync.Mutex
+	claims map[topicPartition]*claimConsumer
+
+	cancel    context.CancelFunc
+	closing   chan struct{}
+	closeOnce sync.Once
+	// done is closed once the consumer left its last session.
+	done chan struct{}
+}
+
+var _ KafkaConsumer = &groupConsumer{}
+var _ sarama.ConsumerGroupHandler = &groupConsumer{}
+
+// claimConsumer hands the messages of a claimed partition over to the dispatcher.
+type claimConsumer struct {
+	sarama.ConsumerGroupClaim
+	session sarama.ConsumerGroupSession
+	// messages receives the messages of the partition in partitions mode.
+	messages chan *sarama.ConsumerMessage
+	// marked is signalled when the message in flight is marked.
+	marked chan struct{}
+}
+
+var _ PartitionConsumer = &claimConsumer{}
+
+// Messages implements PartitionConsumer.
+func (c *claimConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func newGroupConsumer(group sarama.ConsumerGroup, offsets groupOffsets, topics []string, positions ConsumerPositions, partitions bool, logger *zap.Logger) *groupConsumer {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &groupConsumer{
+		group:     group,
+		offsets:   offsets,
+		topics:    topics,
+		positions: positions,
+		logger:    logger,
+		messages:  make(chan *sarama.ConsumerMessage),
+		claims:    make(map[topicPartition]*claimConsumer),
+		cancel:    cancel,
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if partitions {
+		c.partitions = make(chan PartitionConsumer)
+	}
+	go func() {
+		for err := range group.Errors() {
+			c.logger.Warn("Consumer group error", zap.Error(err))
+		}
+	}()
+	go c.consume(ctx)
+	return c
+}
+
+// consume joins the group, and joins it again after every rebalance, until ctx is done.
+func (c *groupConsumer) consume(ctx context.Context) {
+	defer func() {
+		// Every session was released, nothing is sent anymore.
+		close(c.messages)
+		if c.partitions != nil {
+			close(c.partitions)
+		}
+		close(c.done)
+	}()
+	for {
+		err := c.group.Consume(ctx, c.topics, c)
+		if err == sarama.ErrClosedConsumerGroup {
+			return
+		}
+		if err != nil {
+			c.logger.Warn("Unable to consume from the consumer group, retrying", zap.Duration("backoff", consumeRetryBackoff), zap.Error(err))
+			select {
+			case <-time.After(consumeRetryBackoff):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Setup implements sarama.ConsumerGroupHandler. The claimed partitions are consumed from the
+// offsets marked here.
+func (c *groupConsumer) Setup(session sarama.ConsumerGroupSession) error {
+	c.logger.Info("Consumer group session started", zap.Int32("generation", session.GenerationID()), zap.Any("claims", session.Claims()))
+	for topic, partitions := range session.Claims() {
+		for _, partition := range partitions {
+			if err := c.position(session, topic, partition); err != nil {
+				return fmt.Errorf("unable to position partition %d of topic %q: %v", partition, topic, err)
+			}
+		}
+	}
+	return nil
+}
+
+// position moves a claimed partition to c.positions.Reset if the reset was not applied to it yet,
+// or to c.positions.Start if the group never committed an offset for it.
+func (c *groupConsumer) position(session sarama.ConsumerGroupSession, topic string, partition int32) error {
+	committed, metadata, err := c.offsets.Committed(topic, partition)
+	if err != nil {
+		return err
+	}
+	var position int64
+	switch {
+	case c.positions.ResetID != "" && metadata != c.positions.ResetID:
+		position = c.positions.Reset
+	case committed < 0:
+		position = c.positions.Start
+	default:
+		return nil
+	}
+	offset, err := c.offsets.GetOffset(topic, partition, position)
+	if err != nil {
+		return err
+	}
+	if offset < 0 {
+		// No message was sent at or after the time, start after the last one.
+		if offset, err = c.offsets.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+			return err
+		}
+	}
+	c.logger.Info("Positioning partition", zap.String("topic", topic), zap.Int32("partition", partition),
+		zap.Int64("committed", committed), zap.Int64("offset", offset), zap.String("reset", c.positions.ResetID))
+	// ResetOffset only moves the offset backwards, and MarkOffset only forwards.
+	session.ResetOffset(topic, partition, offset, c.positions.ResetID)
+	session.MarkOffset(topic, partition, offset, c.positions.ResetID)
+	return nil
+}
+
+// Cleanup implements sarama.ConsumerGroupHandler. It runs once all the messages in flight were
+// marked, right before the marked offsets are committed.
+func (c *groupConsumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	c.logger.Info("Consumer group session ended", zap.Int32("generation", session.GenerationID()))
+	return nil
+}
+
+// ConsumeClaim implements sarama.ConsumerGroupHandler.
+func (c *groupConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	cc := &claimConsumer{
+		ConsumerGroupClaim: claim,
+		session:            session,
+		marked:             make(chan struct{}, 1),
+	}
+	out := c.messages
+	if c.partitions != nil {
+		cc.messages = make(chan *sarama.ConsumerMessage)
+		defer close(cc.messages)
+		select {
+		case c.partitions <- cc:
+		case <-session.Context().Done():
+			return nil
+		case <-c.closing:
+			return nil
+		}
+		out = cc.messages
+	}
+
+	key := topicPartition{topic: claim.Topic(), partition: claim.Partition()}
+	c.lock.Lock()
+	c.claims[key] = cc
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		delete(c.claims, key)
+		c.lock.Unlock()
+	}()
+
+	for msg := range claim.Messages() {
+		select {
+		case out <- msg:
+		case <-session.Context().Done():
+			// The partition is being revoked, its next member consumes msg.
+			return nil
+		case <-c.closing:
+			return nil
+		}
+		// Wait for msg to be processed, even if the session ends meanwhile, so that its offset is
+		// committed before the partition is released.
+		select {
+		case <-cc.marked:
+		case <-c.closing:
+			return nil
+		}
+	}
+	return nil
+}
+
+// Messages implements KafkaConsumer.
+func (c *groupConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// Partitions implements KafkaConsumer.
+func (c *groupConsumer) Partitions() <-chan PartitionConsumer {
+	return c.partitions
+}
+
+// MarkOffset implements KafkaConsumer. The offset is committed periodically, and when the session
+// ends. metadata is ignored, the offset is committed with the ResetID of the consumer.
+func (c *groupConsumer) MarkOffset(msg *sarama.ConsumerMessage, metadata string) {
+	c.lock.Lock()
+	cc, present := c.claims[topicPartition{topic: msg.Topic, partition: msg.Partition}]
+	c.lock.Unlock()
+	if !present {
+		// The partition was released, msg will be consumed again.
+		return
+	}
+	cc.session.MarkMessage(msg, c.positions.ResetID)
+	select {
+	case cc.marked <- struct{}{}:
+	default:
+	}
+}
+
+// Close implements KafkaConsumer. It leaves the group once the current session committed the
+// marked offsets. Messages in flight are not waited for.
+func (c *groupConsumer) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closing)
+		c.cancel()
+		<-c.done
+		err = c.group.Close()
+		if offsetsErr := c.offsets.Close(); err == nil {
+			err = offsetsErr
+		}
+	})
+	return err
+}
@@ -0,0 +1,440 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+)
+
+// fakeConsumerGroup is a sarama.ConsumerGroup whose sessions are provided by the test.
+type fakeConsumerGroup struct {
+	sessions chan *fakeSession
+	errors   chan error
+	closed   chan struct{}
+}
+
+func newFakeConsumerGroup() *fakeConsumerGroup {
+	return &fakeConsumerGroup{
+		sessions: make(chan *fakeSession, 10),
+		errors:   make(chan error),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Consume runs the next session like sarama does: it hands the claims over to handler until the
+// session ends, then waits for handler to return before committing the marked offsets.
+func (g *fakeConsumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	var s *fakeSession
+	select {
+	case s = <-g.sessions:
+	case <-ctx.Done():
+		return nil
+	case <-g.closed:
+		return sarama.ErrClosedConsumerGroup
+	}
+	var cancel context.CancelFunc
+	s.ctx, cancel = context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-s.rebalance:
+		case <-s.ctx.Done():
+		}
+		cancel()
+	}()
+
+	if err := handler.Setup(s); err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for _, claim := range s.claims {
+		wg.Add(1)
+		go func(claim *fakeClaim) {
+			defer wg.Done()
+			handler.ConsumeClaim(s, claim)
+		}(claim)
+	}
+	<-s.ctx.Done()
+	for _, claim := range s.claims {
+		close(claim.messages)
+	}
+	wg.Wait()
+	handler.Cleanup(s)
+
+	s.lock.Lock()
+	for p, offset := range s.marked {
+		s.committed[p] = offset
+	}
+	s.lock.Unlock()
+	close(s.released)
+	return nil
+}
+
+func (g *fakeConsumerGroup) Errors() <-chan error {
+	return g.errors
+}
+
+func (g *fakeConsumerGroup) Close() error {
+	close(g.closed)
+	close(g.errors)
+	return nil
+}
+
+type fakeSession struct {
+	ctx    context.Context
+	claims []*fakeClaim
+	// rebalance ends the session when closed.
+	rebalance chan struct{}
+	released  chan struct{}
+
+	lock      sync.Mutex
+	marked    map[int32]int64
+	metadata  map[int32]string
+	committed map[int32]int64
+}
+
+func newFakeSession(claims ...*fakeClaim) *fakeSession {
+	return &fakeSession{
+		claims:    claims,
+		rebalance: make(chan struct{}),
+		released:  make(chan struct{}),
+		marked:    make(map[int32]int64),
+		metadata:  make(map[int32]string),
+		committed: make(map[int32]int64),
+	}
+}
+
+func (s *fakeSession) Claims() map[string][]int32 {
+	claims := make(map[string][]int32)
+	for _, c := range s.claims {
+		claims[c.Topic()] = append(claims[c.Topic()], c.Partition())
+	}
+	return claims
+}
+
+func (s *fakeSession) MemberID() string         { return "member" }
+func (s *fakeSession) GenerationID() int32      { return 1 }
+func (s *fakeSession) Context() context.Context { return s.ctx }
+
+func (s *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.marked[partition] = offset
+	s.metadata[partition] = metadata
+}
+
+func (s *fakeSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	s.MarkOffset(topic, partition, offset, metadata)
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *fakeSession) committedOffset(partition int32) (int64, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	offset, present := s.committed[partition]
+	return offset, present
+}
+
+// fakeOffsets is a groupOffsets for the partitions of topic "topic".
+type fakeOffsets struct {
+	// committed are the committed offsets, partitions that are not present have offset 0
+	// committed. -1 stands for no committed offset.
+	committed map[int32]int64
+	metadata  map[int32]string
+	// offsets resolves positions to offsets, positions that are not present resolve to -1.
+	offsets map[int64]int64
+}
+
+func (o *fakeOffsets) Committed(topic string, partition int32) (int64, string, error) {
+	return o.committed[partition], o.metadata[partition], nil
+}
+
+func (o *fakeOffsets) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	if offset, present := o.offsets[time]; present {
+		return offset, nil
+	}
+	return -1, nil
+}
+
+func (o *fakeOffsets) Close() error {
+	return nil
+}
+
+type fakeClaim struct {
+	partition int32
+	messages  chan *sarama.ConsumerMessage
+}
+
+// newFakeClaim claims partition of topic "topic", holding messages with offsets.
+func newFakeClaim(partition int32, offsets ...int64) *fakeClaim {
+	c := &fakeClaim{
+		partition: partition,
+		messages:  make(chan *sarama.ConsumerMessage, len(offsets)),
+	}
+	for _, offset := range offsets {
+		c.messages <- &sarama.ConsumerMessage{Topic: "topic", Partition: partition, Offset: offset}
+	}
+	return c
+}
+
+func (c *fakeClaim) Topic() string                            { return "topic" }
+func (c *fakeClaim) Partition() int32                         { return c.partition }
+func (c *fakeClaim) InitialOffset() int64                     { return 0 }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return int64(cap(c.messages)) }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func receive(t *testing.T, messages <-chan *sarama.ConsumerMessage) *sarama.ConsumerMessage {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for a message")
+		return nil
+	}
+}
+
+func assertNoMessage(t *testing.T, messages <-chan *sarama.ConsumerMessage) {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		t.Fatalf("Unexpected message %v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func waitReleased(t *testing.T, s *fakeSession) {
+	t.Helper()
+	select {
+	case <-s.released:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the session to be released")
+	}
+}
+
+func TestGroupConsumer_HandsOverOneMessageAtATime(t *testing.T) {
+	g := newFakeConsumerGroup()
+	s := newFakeSession(newFakeClaim(0, 0, 1))
+	g.sessions <- s
+	c := newGroupConsumer(g, &fakeOffsets{}, []string{"topic"}, ConsumerPositions{Start: sarama.OffsetNewest}, false, zap.NewNop())
+	defer c.Close()
+
+	first := receive(t, c.Messages())
+	assertNoMessage(t, c.Messages())
+	c.MarkOffset(first, "")
+	if second := receive(t, c.Messages()); second.Offset != 1 {
+		t.Errorf("Unexpected offset. Expected 1. Actual %d", second.Offset)
+	}
+}
+
+func TestGroupConsumer_RebalanceCommitsInFlightMessage(t *testing.T) {
+	g := newFakeConsumerGroup()
+	s1 := newFakeSession(newFakeClaim(0, 0, 1))
+	g.sessions <- s1
+	c := newGroupConsumer(g, &fakeOffsets{}, []string{"topic"}, ConsumerPositions{Start: sarama.OffsetNewest}, false, zap.NewNop())
+	defer c.Close()
+
+	msg := receive(t, c.Messages())
+	close(s1.rebalance)
+	select {
+	case <-s1.released:
+		t.Fatalf("The session was released before the message in flight was marked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.MarkOffset(msg, "")
+	waitReleased(t, s1)
+	if offset, _ := s1.committedOffset(0); offset != 1 {
+		t.Errorf("Unexpected committed offset. Expected 1. Actual %d", offset)
+	}
+
+	// The consumer joins the group again, and resumes from the committed offset.
+	g.sessions <- newFakeSession(newFakeClaim(0, 1))
+	if msg := receive(t, c.Messages()); msg.Offset != 1 {
+		t.Errorf("Unexpected offset. Expected 1. Actual %d", msg.Offset)
+	}
+}
+
+func TestGroupConsumer_Close(t *testing.T) {
+	g := newFakeConsumerGroup()
+	s := newFakeSession(newFakeClaim(0, 0, 1), newFakeClaim(1, 0))
+	g.sessions <- s
+	c := newGroupConsumer(g, &fakeOffsets{}, []string{"topic"}, ConsumerPositions{Start: sarama.OffsetNewest}, false, zap.NewNop())
+
+	marked := receive(t, c.Messages())
+	c.MarkOffset(marked, "")
+	// Leave a message in flight, closing does not wait for it.
+	receive(t, c.Messages())
+
+	closed := make(chan error)
+	go func() {
+		closed <- c.Close()
+	}()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Unexpected error closing the consumer: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out closing the consumer")
+	}
+	waitReleased(t, s)
+	if offset, present := s.committedOffset(marked.Partition); !present || offset != marked.Offset+1 {
+		t.Errorf("Unexpected committed offset. Expected %d. Actual %d", marked.Offset+1, offset)
+	}
+	if _, more := <-c.Messages(); more {
+		t.Errorf("Expected the messages channel to be closed")
+	}
+}
+
+func TestGroupConsumer_Partitions(t *testing.T) {
+	g := newFakeConsumerGroup()
+	s := newFakeSession(newFakeClaim(0, 0), newFakeClaim(1, 0))
+	g.sessions <- s
+	c := newGroupConsumer(g, &fakeOffsets{}, []string{"topic"}, ConsumerPositions{Start: sarama.OffsetNewest}, true, zap.NewNop())
+
+	claimed := make(map[int32]bool)
+	for i := 0; i < 2; i++ {
+		var pc PartitionConsumer
+		select {
+		case pc = <-c.Partitions():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for a partition")
+		}
+		claimed[pc.Partition()] = true
+		msg := receive(t, pc.Messages())
+		c.MarkOffset(msg, "")
+	}
+	if !claimed[0] || !claimed[1] {
+		t.Errorf("Expected partitions 0 and 1 to be claimed. Actual %v", claimed)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("Unexpected error closing the consumer: %v", err)
+	}
+	for _, p := range []int32{0, 1} {
+		if offset, _ := s.committedOffset(p); offset != 1 {
+			t.Errorf("Unexpected committed offset of partition %d. Expected 1. Actual %d", p, offset)
+		}
+	}
+	if _, more := <-c.Partitions(); more {
+		t.Errorf("Expected the partitions channel to be closed")
+	}
+}
+
+func TestGroupConsumer_Positions(t *testing.T) {
+	const resetTime = 1551398400000
+	testCases := []struct {
+		name      string
+		offsets   *fakeOffsets
+		positions ConsumerPositions
+		// want is the offset partition 0 is moved to, -1 if it is not moved.
+		want int64
+	}{{
+		name:      "committed",
+		offsets:   &fakeOffsets{committed: map[int32]int64{0: 7}},
+		positions: ConsumerPositions{Start: sarama.OffsetOldest},
+		want:      -1,
+	}, {
+		name: "start earliest",
+		offsets: &fakeOffsets{
+			committed: map[int32]int64{0: -1},
+			offsets:   map[int64]int64{sarama.OffsetOldest: 3, sarama.OffsetNewest: 9},
+		},
+		positions: ConsumerPositions{Start: sarama.OffsetOldest},
+		want:      3,
+	}, {
+		name: "start after the last message",
+		offsets: &fakeOffsets{
+			committed: map[int32]int64{0: -1},
+			offsets:   map[int64]int64{sarama.OffsetNewest: 9},
+		},
+		positions: ConsumerPositions{Start: resetTime},
+		want:      9,
+	}, {
+		name: "reset",
+		offsets: &fakeOffsets{
+			committed: map[int32]int64{0: 7},
+			offsets:   map[int64]int64{resetTime: 2},
+		},
+		positions: ConsumerPositions{Start: sarama.OffsetNewest, Reset: resetTime, ResetID: "2019-03-01T00:00:00Z"},
+		want:      2,
+	}, {
+		name: "reset already applied",
+		offsets: &fakeOffsets{
+			committed: map[int32]int64{0: 7},
+			metadata:  map[int32]string{0: "2019-03-01T00:00:00Z"},
+			offsets:   map[int64]int64{resetTime: 2},
+		},
+		positions: ConsumerPositions{Start: sarama.OffsetNewest, Reset: resetTime, ResetID: "2019-03-01T00:00:00Z"},
+		want:      -1,
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newFakeConsumerGroup()
+			s := newFakeSession(newFakeClaim(0, 0))
+			g.sessions <- s
+			c := newGroupConsumer(g, tc.offsets, []string{"topic"}, tc.positions, false, zap.NewNop())
+			// Leave the message unmarked, only the offset marked by Setup is committed.
+			receive(t, c.Messages())
+			if err := c.Close(); err != nil {
+				t.Errorf("Unexpected error closing the consumer: %v", err)
+			}
+			waitReleased(t, s)
+			offset, present := s.committedOffset(0)
+			if tc.want < 0 {
+				if present {
+					t.Errorf("Unexpected committed offset %d", offset)
+				}
+				return
+			}
+			if offset != tc.want {
+				t.Errorf("Unexpected committed offset. Expected %d. Actual %d", tc.want, offset)
+			}
+			if s.metadata[0] != tc.positions.ResetID {
+				t.Errorf("Unexpected committed metadata. Expected %q. Actual %q", tc.positions.ResetID, s.metadata[0])
+			}
+		})
+	}
+}
+
+func TestGroupConsumer_MarkOffsetCommitsResetID(t *testing.T) {
+	g := newFakeConsumerGroup()
+	s := newFakeSession(newFakeClaim(0, 0))
+	g.sessions <- s
+	offsets := &fakeOffsets{metadata: map[int32]string{0: "Earliest"}}
+	c := newGroupConsumer(g, offsets, []string{"topic"}, ConsumerPositions{Start: sarama.OffsetNewest, Reset: sarama.OffsetOldest, ResetID: "Earliest"}, false, zap.NewNop())
+
+	c.MarkOffset(receive(t, c.Messages()), "")
+	if err := c.Close(); err != nil {
+		t.Errorf("Unexpected error closing the consumer: %v", err)
+	}
+	waitReleased(t, s)
+	if offset, _ := s.committedOffset(0); offset != 1 {
+		t.Errorf("Unexpected committed offset. Expected 1. Actual %d", offset)
+	}
+	if s.metadata[0] != "Earliest" {
+		t.Errorf("Unexpected committed metadata. Expected %q. Actual %q", "Earliest", s.metadata[0])
+	}
+}
@@ -0,0 +1,354 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+
+	"github.com/knative/eventing/contrib/kafka/pkg/controller"
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultBackoff     = 1 * time.Second
+	// maxBackoff caps the delay between two delivery attempts, and between two attempts to
+	// publish to a retry or dead-letter topic.
+	maxBackoff        = 5 * time.Minute
+	minPublishBackoff = 100 * time.Millisecond
+
+	// The headers set on the messages published to retry and dead-letter topics. They are
+	// removed before the message is dispatched, and only read from the messages of the retry
+	// topic: senders can set them on the messages published to the Channel, so they are also
+	// removed from those before the messages are written.
+	deliveryHeaderPrefix = "knative-kafka-"
+	attemptsHeader       = "knative-kafka-attempts"
+	retryAtHeader        = "knative-kafka-retry-at"
+	errorHeader          = "knative-kafka-error"
+)
+
+// errStopped is returned when a subscription is unsubscribed while one of its messages is being
+// handled. The message's offset must not be marked, so that it is consumed again.
+var errStopped = errors.New("subscription stopped")
+
+// errorStrategy decides what happens to the messages that fail to be delivered to a subscription.
+type errorStrategy interface {
+	// deliver delivers m, which already failed to be delivered attempts times. It returns nil once
+	// m was delivered, handed over to be retried later, or given up on, so that its offset can be
+	// marked.
+	deliver(dl *delivery, m *provisioners.Message, attempts int32) error
+}
+
+// inPlaceRetry retries delivering a message until it is delivered or the maximum number of
+// attempts is reached, holding back the messages behind it.
+type inPlaceRetry struct{}
+
+func (inPlaceRetry) deliver(dl *delivery, m *provisioners.Message, attempts int32) error {
+	for {
+		err := dl.dispatch(m)
+		if err == nil {
+			return nil
+		}
+		attempts++
+		if attempts >= dl.maxAttempts {
+			return dl.deadLetter(m, attempts, err)
+		}
+		dl.logger.Warn("Unable to deliver message, retrying", zap.Int32("attempts", attempts), zap.Error(err))
+		if err := dl.sleep(dl.backoffAfter(attempts)); err != nil {
+			return err
+		}
+	}
+}
+
+// retryTopic republishes a message that failed to be delivered to the subscription's retry topic,
+// so that the messages behind it are not held back. The retry topic is consumed by its own
+// consumer, which waits for the backoff before delivering the message again.
+type retryTopic struct{}
+
+func (retryTopic) deliver(dl *delivery, m *provisioners.Message, attempts int32) error {
+	err := dl.dispatch(m)
+	if err == nil {
+		return nil
+	}
+	attempts++
+	if attempts >= dl.maxAttempts {
+		return dl.deadLetter(m, attempts, err)
+	}
+	retryAt := time.Now().Add(dl.backoffAfter(attempts))
+	dl.logger.Warn("Unable to deliver message, requeuing it", zap.Int32("attempts", attempts), zap.Time("retryAt", retryAt), zap.Error(err))
+	return dl.publish(controller.RetryTopicName(dl.topic, dl.sub.Name), withDeliveryHeaders(m, attempts, retryAt, err))
+}
+
+// delivery delivers the messages of a Channel to one of its subscriptions, applying the
+// subscription's error strategy.
+type delivery struct {
+	dispatcher  *KafkaDispatcher
+	channelRef  provisioners.ChannelReference
+	sub         subscription
+	strategy    errorStrategy
+	maxAttempts int32
+	backoff     time.Duration
+	// retryConsumer consumes the subscription's retry topic, if its strategy uses one.
+	retryConsumer KafkaConsumer
+	stopCh        chan struct{}
+	logger        *zap.Logger
+
+	// stopped is set once stopCh is closed. No message is handled after that.
+	stopped  bool
+	stopLock sync.Mutex
+	// handling counts the messages being handled, which may still publish to Kafka.
+	handling sync.WaitGroup
+
+	// topic is the Channel's topic.
+	topic string
+	// nextOffsets are the offsets of the next message to process in each partition of topic. It
+	// is set to nil once the subscription is stopped.
+	nextOffsets map[int32]int64
+	// lagPartitions are the partitions of topic whose lag is recorded in the metrics.
+	lagPartitions map[int32]bool
+	lagLock       sync.Mutex
+}
+
+func newDelivery(d *KafkaDispatcher, channelRef provisioners.ChannelReference, sub subscription) *delivery {
+	dl := &delivery{
+		dispatcher:    d,
+		channelRef:    channelRef,
+		sub:           sub,
+		strategy:      inPlaceRetry{},
+		maxAttempts:   defaultMaxAttempts,
+		backoff:       defaultBackoff,
+		stopCh:        make(chan struct{}),
+		logger:        d.logger.With(zap.Any("channelRef", channelRef), zap.Any("subscription", sub)),
+		topic:         channelTopic(channelRef, sub.Topic),
+		nextOffsets:   make(map[int32]int64),
+		lagPartitions: make(map[int32]bool),
+	}
+	if sub.Delivery.Strategy == eventingduck.DeliveryStrategyRequeue {
+		dl.strategy = retryTopic{}
+	}
+	if sub.Delivery.MaxAttempts > 0 {
+		dl.maxAttempts = sub.Delivery.MaxAttempts
+	}
+	if backoff, err := time.ParseDuration(sub.Delivery.Backoff); err == nil && backoff > 0 {
+		dl.backoff = backoff
+	}
+	return dl
+}
+
+// handle delivers a message consumed from the Channel's topic, or from the subscription's retry
+// topic if retried is true. Only the messages of the retry topic carry the number of failed
+// attempts and the retry time. The records of external topics that are not CloudEvents are
+// wrapped in one. It returns errStopped if the subscription was stopped before the message was
+// handled.
+func (dl *delivery) handle(msg *sarama.ConsumerMessage, retried bool) error {
+	m := fromKafkaMessage(msg)
+	attempts, retryAt := takeDeliveryHeaders(m)
+	if !retried {
+		attempts, retryAt = 0, time.Time{}
+	}
+	if dl.sub.ExternalTopic && !isCloudEvent(m) {
+		eventType, eventSource := dl.wrappedEvent()
+		wrapRecord(m, msg, eventType, eventSource)
+	}
+	if err := dl.sleep(time.Until(retryAt)); err != nil {
+		return err
+	}
+	return dl.strategy.deliver(dl, m, attempts)
+}
+
+func (dl *delivery) dispatch(m *provisioners.Message) error {
+	start := time.Now()
+	err := dl.dispatcher.dispatchMessage(dl.channelRef, m, dl.sub)
+	observeDispatch(dl.channelRef, dl.sub.Name, start, err)
+	return err
+}
+
+// deadLetter publishes m to the subscription's dead-letter topic, or drops it if the subscription
+// does not have one.
+func (dl *delivery) deadLetter(m *provisioners.Message, attempts int32, err error) error {
+	if !dl.sub.Delivery.DeadLetter {
+		dl.logger.Error("Unable to deliver message, dropping it", zap.Int32("attempts", attempts), zap.Error(err))
+		return nil
+	}
+	dl.logger.Warn("Unable to deliver message, dead-lettering it", zap.Int32("attempts", attempts), zap.Error(err))
+	return dl.publish(controller.DeadLetterTopicName(dl.topic, dl.sub.Name), withDeliveryHeaders(m, attempts, time.Time{}, err))
+}
+
+// publish publishes m to topic, retrying until it succeeds or the subscription is stopped.
+func (dl *delivery) publish(topic string, m *provisioners.Message) error {
+	backoff := minPublishBackoff
+	for {
+		_, _, err := dl.dispatcher.kafkaSyncProducer.SendMessage(newProducerMessage(topic, m))
+		if err == nil {
+			return nil
+		}
+		dl.logger.Error("Unable to publish message, retrying", zap.String("topic", topic), zap.Duration("backoff", backoff), zap.Error(err))
+		if err := dl.sleep(backoff); err != nil {
+			return err
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// backoffAfter returns the delay before the next attempt, once attempts attempts failed.
+func (dl *delivery) backoffAfter(attempts int32) time.Duration {
+	backoff := dl.backoff
+	for i := int32(1); i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// sleep waits for duration. It returns errStopped if the subscription is stopped first.
+func (dl *delivery) sleep(duration time.Duration) error {
+	if duration <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-dl.stopCh:
+		return errStopped
+	}
+}
+
+// stop interrupts the retries in progress. The messages being retried are not marked, so that they
+// are consumed again.
+func (dl *delivery) stop() {
+	dl.stopLock.Lock()
+	if !dl.stopped {
+		dl.stopped = true
+		close(dl.stopCh)
+	}
+	dl.stopLock.Unlock()
+	dl.clearLag()
+}
+
+// begin registers a message being handled. It returns false if the subscription is stopped, in
+// which case the message must be left unprocessed.
+func (dl *delivery) begin() bool {
+	dl.stopLock.Lock()
+	defer dl.stopLock.Unlock()
+	if dl.stopped {
+		return false
+	}
+	dl.handling.Add(1)
+	return true
+}
+
+// end unregisters a message registered by begin.
+func (dl *delivery) end() {
+	dl.handling.Done()
+}
+
+// wait waits for the messages being handled when the subscription was stopped, until ctx is
+// done. It returns whether they all were handled.
+func (dl *delivery) wait(ctx context.Context) bool {
+	handled := make(chan struct{})
+	go func() {
+		dl.handling.Wait()
+		close(handled)
+	}()
+	select {
+	case <-handled:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// withDeliveryHeaders returns a copy of m recording the number of failed attempts, the last error
+// and, if not zero, the time the message should be retried at.
+func withDeliveryHeaders(m *provisioners.Message, attempts int32, retryAt time.Time, err error) *provisioners.Message {
+	headers := make(map[string]string, len(m.Headers)+3)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	headers[attemptsHeader] = strconv.Itoa(int(attempts))
+	headers[errorHeader] = err.Error()
+	if !retryAt.IsZero() {
+		headers[retryAtHeader] = retryAt.UTC().Format(time.RFC3339Nano)
+	}
+	return &provisioners.Message{
+		Headers: headers,
+		Payload: m.Payload,
+	}
+}
+
+// takeDeliveryHeaders removes the headers set by withDeliveryHeaders, and any other header
+// starting with deliveryHeaderPrefix, from m. It returns the number of failed attempts and the
+// retry time they hold.
+func takeDeliveryHeaders(m *provisioners.Message) (int32, time.Time) {
+	var attempts int32
+	var retryAt time.Time
+	if v, ok := m.Headers[attemptsHeader]; ok {
+		if n, err := strconv.ParseInt(v, 10, 32); err == nil && n > 0 {
+			attempts = int32(n)
+		}
+	}
+	if v, ok := m.Headers[retryAtHeader]; ok {
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			retryAt = t
+		}
+	}
+	for h := range m.Headers {
+		if strings.HasPrefix(strings.ToLower(h), deliveryHeaderPrefix) {
+			delete(m.Headers, h)
+		}
+	}
+	return attempts, retryAt
+}
+
+// withoutDeliveryHeaders returns m, or a copy of m without the headers starting with
+// deliveryHeaderPrefix if it has any.
+func withoutDeliveryHeaders(m *provisioners.Message) *provisioners.Message {
+	var headers map[string]string
+	for h := range m.Headers {
+		if strings.HasPrefix(strings.ToLower(h), deliveryHeaderPrefix) {
+			headers = make(map[string]string, len(m.Headers))
+			break
+		}
+	}
+	if headers == nil {
+		return m
+	}
+	for h, v := range m.Headers {
+		if !strings.HasPrefix(strings.ToLower(h), deliveryHeaderPrefix) {
+			headers[h] = v
+		}
+	}
+	return &provisioners.Message{
+		Headers: headers,
+		Payload: m.Payload,
+	}
+}
@@ -0,0 +1,444 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
+)
+
+var deliveryChannelRef = provisioners.ChannelReference{
+	Name:      "test-channel",
+	Namespace: "test-ns",
+}
+
+// failingHandler fails the first failures requests it receives, and accepts the others.
+type failingHandler struct {
+	failures int32
+	requests int32
+}
+
+func (h *failingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.AddInt32(&h.requests, 1) <= h.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *failingHandler) requestCount() int32 {
+	return atomic.LoadInt32(&h.requests)
+}
+
+// fakeSyncProducer records the messages it sends, after failing the first failures ones.
+type fakeSyncProducer struct {
+	lock     sync.Mutex
+	failures int
+	sent     []*sarama.ProducerMessage
+}
+
+func (p *fakeSyncProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return 0, 0, errors.New("kafka unavailable")
+	}
+	p.sent = append(p.sent, msg)
+	return 0, int64(len(p.sent) - 1), nil
+}
+
+func (p *fakeSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		if _, _, err := p.SendMessage(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *fakeSyncProducer) Close() error {
+	return nil
+}
+
+func (p *fakeSyncProducer) sentMessages() []*sarama.ProducerMessage {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]*sarama.ProducerMessage(nil), p.sent...)
+}
+
+// markingConsumer records the offsets of the messages marked as processed.
+type markingConsumer struct {
+	mockConsumer
+	lock   sync.Mutex
+	marked []int64
+}
+
+func (c *markingConsumer) MarkOffset(msg *sarama.ConsumerMessage, metadata string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.marked = append(c.marked, msg.Offset)
+}
+
+func newTestDelivery(server *httptest.Server, producer *fakeSyncProducer, spec eventingduck.DeliverySpec) *delivery {
+	d := &KafkaDispatcher{
+		dispatcher:        provisioners.NewMessageDispatcher(zap.NewNop().Sugar()),
+		kafkaSyncProducer: producer,
+		logger:            zap.NewNop(),
+	}
+	return newDelivery(d, deliveryChannelRef, subscription{
+		Name:          "test-sub",
+		Namespace:     "test-ns",
+		SubscriberURI: server.URL[7:],
+		Delivery:      spec,
+	})
+}
+
+func producerHeader(msg *sarama.ProducerMessage, key string) (string, bool) {
+	for _, h := range msg.Headers {
+		if string(h.Key) == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+// toConsumerMessage returns msg as it is consumed back from its topic.
+func toConsumerMessage(msg *sarama.ProducerMessage) *sarama.ConsumerMessage {
+	value, _ := msg.Value.Encode()
+	consumed := &sarama.ConsumerMessage{
+		Topic: msg.Topic,
+		Value: value,
+	}
+	for i := range msg.Headers {
+		consumed.Headers = append(consumed.Headers, &msg.Headers[i])
+	}
+	return consumed
+}
+
+func testConsumerMessage() *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{
+		Headers: []*sarama.RecordHeader{{
+			Key:   []byte("ce_specversion"),
+			Value: []byte("0.2"),
+		}, {
+			Key:   []byte("k1"),
+			Value: []byte("v1"),
+		}},
+		Value:  []byte("data"),
+		Offset: 42,
+	}
+}
+
+func TestInPlaceRetry(t *testing.T) {
+	testCases := map[string]struct {
+		spec          eventingduck.DeliverySpec
+		failures      int32
+		wantRequests  int32
+		wantTopic     string
+		wantAttempts  string
+		producerFails int
+	}{
+		"delivered after retries": {
+			spec:         eventingduck.DeliverySpec{Backoff: "1ms"},
+			failures:     2,
+			wantRequests: 3,
+		},
+		"dropped": {
+			spec:         eventingduck.DeliverySpec{Backoff: "1ms"},
+			failures:     100,
+			wantRequests: defaultMaxAttempts,
+		},
+		"dead-lettered": {
+			spec: eventingduck.DeliverySpec{
+				Strategy:    eventingduck.DeliveryStrategyRetry,
+				MaxAttempts: 2,
+				Backoff:     "1ms",
+				DeadLetter:  true,
+			},
+			failures:      100,
+			wantRequests:  2,
+			wantTopic:     "knative-eventing-channel.test-ns.test-channel.test-sub.dlq",
+			wantAttempts:  "2",
+			producerFails: 1,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			handler := &failingHandler{failures: tc.failures}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+			producer := &fakeSyncProducer{failures: tc.producerFails}
+			dl := newTestDelivery(server, producer, tc.spec)
+
+			if err := dl.handle(testConsumerMessage(), false); err != nil {
+				t.Fatalf("Unexpected error handling the message: %v", err)
+			}
+			if got := handler.requestCount(); got != tc.wantRequests {
+				t.Errorf("Unexpected number of requests. Expected %d. Actual %d", tc.wantRequests, got)
+			}
+			sent := producer.sentMessages()
+			if tc.wantTopic == "" {
+				if len(sent) != 0 {
+					t.Errorf("Unexpected published messages: %v", sent)
+				}
+				return
+			}
+			if len(sent) != 1 || sent[0].Topic != tc.wantTopic {
+				t.Fatalf("Expected a single message published to %q. Actual %v", tc.wantTopic, sent)
+			}
+			if attempts, _ := producerHeader(sent[0], attemptsHeader); attempts != tc.wantAttempts {
+				t.Errorf("Unexpected attempts header. Expected %q. Actual %q", tc.wantAttempts, attempts)
+			}
+			if _, ok := producerHeader(sent[0], errorHeader); !ok {
+				t.Errorf("Expected the %s header to be set", errorHeader)
+			}
+			if v, _ := producerHeader(sent[0], "k1"); v != "v1" {
+				t.Errorf("Expected the original headers to be kept. Actual %v", sent[0].Headers)
+			}
+		})
+	}
+}
+
+func TestRetryTopic(t *testing.T) {
+	handler := &failingHandler{failures: 1}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	producer := &fakeSyncProducer{}
+	dl := newTestDelivery(server, producer, eventingduck.DeliverySpec{
+		Strategy: eventingduck.DeliveryStrategyRequeue,
+		Backoff:  "50ms",
+	})
+
+	if err := dl.handle(testConsumerMessage(), false); err != nil {
+		t.Fatalf("Unexpected error handling the message: %v", err)
+	}
+	sent := producer.sentMessages()
+	wantTopic := "knative-eventing-channel.test-ns.test-channel.test-sub.retry"
+	if len(sent) != 1 || sent[0].Topic != wantTopic {
+		t.Fatalf("Expected a single message published to %q. Actual %v", wantTopic, sent)
+	}
+	if attempts, _ := producerHeader(sent[0], attemptsHeader); attempts != "1" {
+		t.Errorf("Unexpected attempts header. Expected %q. Actual %q", "1", attempts)
+	}
+	v, _ := producerHeader(sent[0], retryAtHeader)
+	retryAt, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		t.Fatalf("Unable to parse the %s header %q: %v", retryAtHeader, v, err)
+	}
+
+	// Consuming the message back from the retry topic waits for the backoff, then delivers it.
+	if err := dl.handle(toConsumerMessage(sent[0]), true); err != nil {
+		t.Fatalf("Unexpected error handling the retried message: %v", err)
+	}
+	if time.Now().Before(retryAt) {
+		t.Errorf("The message was retried before %v", retryAt)
+	}
+	if got := handler.requestCount(); got != 2 {
+		t.Errorf("Unexpected number of requests. Expected 2. Actual %d", got)
+	}
+	if sent := producer.sentMessages(); len(sent) != 1 {
+		t.Errorf("Unexpected published messages: %v", sent)
+	}
+}
+
+func TestRetryTopic_DeadLetter(t *testing.T) {
+	handler := &failingHandler{failures: 100}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	producer := &fakeSyncProducer{}
+	dl := newTestDelivery(server, producer, eventingduck.DeliverySpec{
+		Strategy:    eventingduck.DeliveryStrategyRequeue,
+		MaxAttempts: 3,
+		DeadLetter:  true,
+	})
+
+	msg := testConsumerMessage()
+	msg.Headers = append(msg.Headers, &sarama.RecordHeader{
+		Key:   []byte(attemptsHeader),
+		Value: []byte("2"),
+	})
+	if err := dl.handle(msg, true); err != nil {
+		t.Fatalf("Unexpected error handling the message: %v", err)
+	}
+	sent := producer.sentMessages()
+	wantTopic := "knative-eventing-channel.test-ns.test-channel.test-sub.dlq"
+	if len(sent) != 1 || sent[0].Topic != wantTopic {
+		t.Fatalf("Expected a single message published to %q. Actual %v", wantTopic, sent)
+	}
+	if attempts, _ := producerHeader(sent[0], attemptsHeader); attempts != "3" {
+		t.Errorf("Unexpected attempts header. Expected %q. Actual %q", "3", attempts)
+	}
+}
+
+func TestHandle_DeliveryHeadersFromChannel(t *testing.T) {
+	handler := &failingHandler{failures: 1}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	producer := &fakeSyncProducer{}
+	dl := newTestDelivery(server, producer, eventingduck.DeliverySpec{
+		Strategy:    eventingduck.DeliveryStrategyRequeue,
+		MaxAttempts: 3,
+		Backoff:     "1ms",
+		DeadLetter:  true,
+	})
+
+	// Headers of a message consumed from the Channel's topic are set by its sender, they neither
+	// delay the message nor count as failed attempts.
+	msg := testConsumerMessage()
+	msg.Headers = append(msg.Headers, &sarama.RecordHeader{
+		Key:   []byte(attemptsHeader),
+		Value: []byte("2"),
+	}, &sarama.RecordHeader{
+		Key:   []byte(retryAtHeader),
+		Value: []byte(time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)),
+	})
+	done := make(chan error, 1)
+	go func() { done <- dl.handle(msg, false) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Unexpected error handling the message: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		dl.stop()
+		t.Fatalf("The message was delayed by its %s header", retryAtHeader)
+	}
+	sent := producer.sentMessages()
+	wantTopic := "knative-eventing-channel.test-ns.test-channel.test-sub.retry"
+	if len(sent) != 1 || sent[0].Topic != wantTopic {
+		t.Fatalf("Expected a single message published to %q. Actual %v", wantTopic, sent)
+	}
+	if attempts, _ := producerHeader(sent[0], attemptsHeader); attempts != "1" {
+		t.Errorf("Unexpected attempts header. Expected %q. Actual %q", "1", attempts)
+	}
+}
+
+func TestDispatch_MarksOffset(t *testing.T) {
+	handler := &failingHandler{}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	dl := newTestDelivery(server, &fakeSyncProducer{}, eventingduck.DeliverySpec{})
+	consumer := &markingConsumer{}
+
+	if err := dl.dispatcher.dispatch(dl, consumer, testConsumerMessage()); err != nil {
+		t.Fatalf("Unexpected error dispatching: %v", err)
+	}
+	if len(consumer.marked) != 1 || consumer.marked[0] != 42 {
+		t.Errorf("Expected offset 42 to be marked. Actual %v", consumer.marked)
+	}
+}
+
+func TestDispatch_Stopped(t *testing.T) {
+	handler := &failingHandler{failures: 100}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	dl := newTestDelivery(server, &fakeSyncProducer{}, eventingduck.DeliverySpec{Backoff: "1h"})
+	consumer := &markingConsumer{}
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- dl.dispatcher.dispatch(dl, consumer, testConsumerMessage())
+	}()
+	for handler.requestCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	dl.stop()
+
+	select {
+	case err := <-errCh:
+		if err != errStopped {
+			t.Errorf("Unexpected error. Expected %v. Actual %v", errStopped, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the dispatch to be interrupted")
+	}
+	consumer.lock.Lock()
+	defer consumer.lock.Unlock()
+	if len(consumer.marked) != 0 {
+		t.Errorf("Expected no offset to be marked. Actual %v", consumer.marked)
+	}
+}
+
+func TestDispatch_WaitsForInFlight(t *testing.T) {
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+	}))
+	defer server.Close()
+	dl := newTestDelivery(server, &fakeSyncProducer{}, eventingduck.DeliverySpec{})
+	consumer := &markingConsumer{}
+
+	go dl.dispatcher.dispatch(dl, consumer, testConsumerMessage())
+	<-received
+	dl.stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if dl.wait(ctx) {
+		t.Fatal("wait reported no message being handled while one was")
+	}
+	close(release)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !dl.wait(ctx) {
+		t.Fatal("Timed out waiting for the message being handled")
+	}
+	consumer.lock.Lock()
+	marked := append([]int64(nil), consumer.marked...)
+	consumer.lock.Unlock()
+	if len(marked) != 1 || marked[0] != 42 {
+		t.Errorf("Expected offset 42 to be marked. Actual %v", marked)
+	}
+
+	// Messages are no longer handled once stopped.
+	if err := dl.dispatcher.dispatch(dl, consumer, testConsumerMessage()); err != errStopped {
+		t.Errorf("Unexpected error. Expected %v. Actual %v", errStopped, err)
+	}
+	select {
+	case <-received:
+		t.Error("Message delivered after the subscription was stopped")
+	default:
+	}
+}
+
+func TestBackoffAfter(t *testing.T) {
+	dl := &delivery{backoff: time.Second}
+	for attempts, want := range map[int32]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		20: maxBackoff,
+	} {
+		if got := dl.backoffAfter(attempts); got != want {
+			t.Errorf("Unexpected backoff after %d attempts. Expected %v. Actual %v", attempts, want, got)
+		}
+	}
+}
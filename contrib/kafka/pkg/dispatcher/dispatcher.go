@@ -16,65 +16,108 @@ limitations under the License.
 package dispatcher
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/Shopify/sarama"
-	cluster "github.com/bsm/sarama-cluster"
 	"github.com/google/go-cmp/cmp"
 	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/knative/eventing/contrib/kafka/pkg/controller"
 	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
 	"github.com/knative/eventing/pkg/provisioners"
 	topicUtils "github.com/knative/eventing/pkg/provisioners/utils"
+	"github.com/knative/eventing/pkg/sidecar/fanout"
 	"github.com/knative/eventing/pkg/sidecar/multichannelfanout"
 )
 
 type KafkaDispatcher struct {
-	config     atomic.Value
+	config atomic.Value
+	// partitionKeys maps the Channels of config to the CloudEvents attribute keying their records.
+	partitionKeys atomic.Value
+	// topics maps the Channels of config to the topics recorded in their status.
+	topics     atomic.Value
 	updateLock sync.Mutex
 
 	receiver   *provisioners.MessageReceiver
 	dispatcher *provisioners.MessageDispatcher
 
+	kafkaClient        sarama.Client
 	kafkaAsyncProducer sarama.AsyncProducer
-	kafkaConsumers     map[provisioners.ChannelReference]map[subscription]KafkaConsumer
-	kafkaCluster       KafkaCluster
+	// syncPublish makes the receiver wait for Kafka to acknowledge each message, published with
+	// kafkaSyncProducer, before accepting it.
+	syncPublish bool
+	// kafkaSyncProducer publishes the messages that failed to be delivered to retry and
+	// dead-letter topics, and the messages received in sync mode.
+	kafkaSyncProducer sarama.SyncProducer
+	kafkaConsumers    map[provisioners.ChannelReference]map[subscription]KafkaConsumer
+	kafkaCluster      KafkaCluster
+	// deliveries is only accessed with updateLock held.
+	deliveries map[provisioners.ChannelReference]map[subscription]*delivery
+
+	// kubeClient updates the Lagging condition of the Subscriptions whose lag crosses
+	// lagThreshold. The condition is not set if lagThreshold is 0.
+	kubeClient   client.Client
+	lagThreshold int64
+
+	// shard restricts the subscriptions consumed by this replica when several replicas share
+	// them. It is nil, and every subscription is consumed, unless sharding is enabled.
+	shard *shard
+	// stopped is set, with updateLock held, once the dispatcher closed its consumers on shutdown.
+	// No consumer is created afterwards.
+	stopped bool
 
 	logger *zap.Logger
 }
 
 type KafkaConsumer interface {
 	Messages() <-chan *sarama.ConsumerMessage
-	Partitions() <-chan cluster.PartitionConsumer
+	Partitions() <-chan PartitionConsumer
 	MarkOffset(msg *sarama.ConsumerMessage, metadata string)
 	Close() (err error)
 }
 
 type KafkaCluster interface {
-	NewConsumer(groupID string, topics []string) (KafkaConsumer, error)
+	NewConsumer(groupID string, topics []string, positions ConsumerPositions) (KafkaConsumer, error)
 
-	GetConsumerMode() cluster.ConsumerMode
+	GetConsumerMode() controller.ConsumerMode
 }
 
+// saramaCluster creates KafkaConsumers backed by sarama consumer groups.
 type saramaCluster struct {
-	kafkaBrokers []string
-
-	consumerMode cluster.ConsumerMode
+	config *controller.KafkaProvisionerConfig
+	logger *zap.Logger
 }
 
-func (c *saramaCluster) NewConsumer(groupID string, topics []string) (KafkaConsumer, error) {
-	consumerConfig := cluster.NewConfig()
-	consumerConfig.Version = sarama.V1_1_0_0
-	consumerConfig.Group.Mode = c.consumerMode
-	return cluster.NewConsumer(c.kafkaBrokers, groupID, topics, consumerConfig)
+func (c *saramaCluster) NewConsumer(groupID string, topics []string, positions ConsumerPositions) (KafkaConsumer, error) {
+	conf := sarama.NewConfig()
+	conf.Version = sarama.V1_1_0_0
+	conf.ClientID = controller.Name + "-dispatcher"
+	conf.Consumer.Return.Errors = true
+	c.config.ConfigureNet(conf)
+	client, err := sarama.NewClient(c.config.Brokers, conf)
+	if err != nil {
+		return nil, err
+	}
+	group, err := sarama.NewConsumerGroupFromClient(groupID, client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	partitions := c.config.ConsumerMode == controller.ConsumerModePartitions
+	offsets := &clientOffsets{Client: client, group: groupID}
+	return newGroupConsumer(group, offsets, topics, positions, partitions, c.logger.With(zap.String("group", groupID))), nil
 }
 
-func (c *saramaCluster) GetConsumerMode() cluster.ConsumerMode {
-	return c.consumerMode
+func (c *saramaCluster) GetConsumerMode() controller.ConsumerMode {
+	return c.config.ConsumerMode
 }
 
 type subscription struct {
@@ -82,6 +125,87 @@ type subscription struct {
 	Name          string
 	SubscriberURI string
 	ReplyURI      string
+	// TLSSecretRef and AuthSecretRef are held by value, rather than by pointer, so that
+	// subscription can be compared and used as a map key. They are empty if the subscriber does
+	// not use TLS or authentication.
+	TLSSecretRef  corev1.LocalObjectReference
+	AuthType      string
+	AuthSecretRef corev1.LocalObjectReference
+	// Delivery is empty if the subscriber uses the default error strategy.
+	Delivery eventingduck.DeliverySpec
+	// Start and Reset are the positions the subscriber starts from and is reset to, in the form
+	// of eventingduck.StartSpec.String. They are empty if not set.
+	Start string
+	Reset string
+	// Topic is the topic of the subscription's channel, so that the channel is consumed again when
+	// it is recreated with a new topic. It is empty if the topic is not recorded in the config.
+	Topic string
+	// ExternalTopic is true if Topic is written by other systems, whose records that are not
+	// CloudEvents are wrapped in one.
+	ExternalTopic bool
+	// EventType and EventSource are the type and source of the CloudEvents wrapping the records
+	// of Topic that are not CloudEvents. They are empty if not set in the config.
+	EventType   string
+	EventSource string
+}
+
+// channelTopic returns topic, the topic recorded for channelRef, or the topic named after the
+// Channel's namespace and name if none is recorded, as is the case of the Channels provisioned
+// before topics were recorded.
+func channelTopic(channelRef provisioners.ChannelReference, topic string) string {
+	if topic != "" {
+		return topic
+	}
+	return topicUtils.TopicName(controller.KafkaChannelSeparator, channelRef.Namespace, channelRef.Name)
+}
+
+// group returns the consumer group of the subscription.
+func (s subscription) group() string {
+	return fmt.Sprintf("%s.%s.%s", controller.Name, s.Namespace, s.Name)
+}
+
+// consumerPositions returns the positions of the consumer of the subscription's channel. It
+// returns an error if a position is a sequence number, which Kafka topics do not have.
+func (s subscription) consumerPositions() (ConsumerPositions, error) {
+	p := ConsumerPositions{
+		Start:   sarama.OffsetNewest,
+		ResetID: s.Reset,
+	}
+	var err error
+	if s.Start != "" {
+		if p.Start, err = positionOffset(s.Start); err != nil {
+			return p, fmt.Errorf("invalid start: %v", err)
+		}
+	}
+	if s.Reset != "" {
+		if p.Reset, err = positionOffset(s.Reset); err != nil {
+			return p, fmt.Errorf("invalid reset: %v", err)
+		}
+	}
+	return p, nil
+}
+
+// errSequenceNotSupported is returned for the positions that are sequence numbers. The Channel
+// controller reports them in the Channel's status.
+var errSequenceNotSupported = errors.New("Kafka topics do not number their events in a single sequence")
+
+// positionOffset converts an eventingduck.StartSpec in its String form to a ConsumerPositions
+// position. Invalid positions, that the webhook rejects, are treated as Latest.
+func positionOffset(position string) (int64, error) {
+	start, err := eventingduck.ParseStartSpec(position)
+	if err != nil {
+		return sarama.OffsetNewest, nil
+	}
+	switch {
+	case start.Sequence > 0:
+		return 0, errSequenceNotSupported
+	case start.Time != nil:
+		return start.Time.UnixNano() / int64(time.Millisecond), nil
+	case start.Position == eventingduck.StartPositionEarliest:
+		return sarama.OffsetOldest, nil
+	default:
+		return sarama.OffsetNewest, nil
+	}
 }
 
 // ConfigDiff diffs the new config with the existing config. If there are no differences, then the
@@ -102,39 +226,57 @@ func (d *KafkaDispatcher) UpdateConfig(config *multichannelfanout.Config) error
 	if diff := d.ConfigDiff(config); diff != "" {
 		d.logger.Info("Updating config (-old +new)", zap.String("diff", diff))
 
-		newSubs := make(map[subscription]bool)
+		d.updateConsumers(config)
+
+		// Update the config so that it can be used for comparison during next sync
+		d.setConfig(config)
+		d.partitionKeys.Store(newPartitionKeys(config))
+		d.topics.Store(newTopics(config))
+	}
+	return nil
+}
 
-		// Subscribe to new subscriptions
-		for _, cc := range config.ChannelConfigs {
-			channelRef := provisioners.ChannelReference{
-				Name:      cc.Name,
-				Namespace: cc.Namespace,
+// updateConsumers consumes the subscriptions of config owned by this replica, and stops consuming
+// the others. It must be called with updateLock held.
+func (d *KafkaDispatcher) updateConsumers(config *multichannelfanout.Config) {
+	if d.stopped {
+		return
+	}
+	newSubs := make(map[subscription]bool)
+
+	// Subscribe to new subscriptions
+	for _, cc := range config.ChannelConfigs {
+		channelRef := provisioners.ChannelReference{
+			Name:      cc.Name,
+			Namespace: cc.Namespace,
+		}
+		for _, subSpec := range cc.FanoutConfig.Subscriptions {
+			sub := newSubscription(subSpec)
+			sub.Topic = cc.Topic
+			sub.ExternalTopic = cc.ExternalTopic
+			sub.EventType, sub.EventSource = cc.EventType, cc.EventSource
+			if !d.shard.owns(sub) {
+				// another replica consumes it
+				continue
 			}
-			for _, subSpec := range cc.FanoutConfig.Subscriptions {
-				sub := newSubscription(subSpec)
-				if _, ok := d.kafkaConsumers[channelRef][sub]; !ok {
-					// only subscribe when not exists in channel-subscriptions map
-					// do not need to resubscribe every time channel fanout config is updated
-					d.subscribe(channelRef, sub)
-				}
-
-				newSubs[sub] = true
+			if _, ok := d.kafkaConsumers[channelRef][sub]; !ok {
+				// only subscribe when not exists in channel-subscriptions map
+				// do not need to resubscribe every time channel fanout config is updated
+				d.subscribe(channelRef, sub)
 			}
+
+			newSubs[sub] = true
 		}
+	}
 
-		// Unsubscribe and close consumer for any deleted subscriptions
-		for channelRef, subMap := range d.kafkaConsumers {
-			for sub := range subMap {
-				if ok := newSubs[sub]; !ok {
-					d.unsubscribe(channelRef, sub)
-				}
+	// Unsubscribe and close consumer for any deleted subscriptions
+	for channelRef, subMap := range d.kafkaConsumers {
+		for sub := range subMap {
+			if ok := newSubs[sub]; !ok {
+				d.unsubscribe(channelRef, sub)
 			}
 		}
-
-		// Update the config so that it can be used for comparison during next sync
-		d.setConfig(config)
 	}
-	return nil
 }
 
 // Start starts the kafka dispatcher's message processing.
@@ -152,24 +294,209 @@ func (d *KafkaDispatcher) Start(stopCh <-chan struct{}) error {
 			select {
 			case e := <-d.kafkaAsyncProducer.Errors():
 				d.logger.Warn("Got", zap.Error(e))
+				observeAsyncPublish(e.Msg, e.Err)
 			case s := <-d.kafkaAsyncProducer.Successes():
-				d.logger.Info("Sent", zap.Any("success", s))
+				d.logger.Debug("Sent", zap.Any("success", s))
+				observeAsyncPublish(s, nil)
 			case <-stopCh:
 				return
 			}
 		}
 	}()
 
-	return d.receiver.Start(stopCh)
+	if d.kafkaClient != nil {
+		go d.lagLoop(stopCh)
+	}
+
+	err := d.receiver.Start(stopCh)
+
+	// The receiver has drained. Closing the consumers commits the offsets they marked and leaves
+	// their groups, so that the other members take over their partitions right away. The messages
+	// being handled get what is left of the shutdown timeout to finish.
+	ctx, cancel := d.receiver.DrainContext()
+	defer cancel()
+	drained := d.stopConsumers(ctx)
+
+	// No more messages will be produced. Closing the producer flushes any buffered messages to
+	// Kafka.
+	if closeErr := d.kafkaAsyncProducer.Close(); closeErr != nil {
+		d.logger.Error("Error flushing the kafka producer", zap.Error(closeErr))
+		if err == nil {
+			err = closeErr
+		}
+	}
+	// Messages still being handled may publish to the retry or dead letter topics, closing the
+	// sync producer under them would make them panic.
+	if d.kafkaSyncProducer != nil && drained {
+		if closeErr := d.kafkaSyncProducer.Close(); closeErr != nil {
+			d.logger.Error("Error closing the kafka sync producer", zap.Error(closeErr))
+		}
+	}
+	if d.kafkaClient != nil {
+		if closeErr := d.kafkaClient.Close(); closeErr != nil {
+			d.logger.Error("Error closing the kafka client", zap.Error(closeErr))
+		}
+	}
+	return err
+}
+
+// stopConsumers closes the consumers of every subscription, including their retry consumers, for
+// good. It waits for the messages being handled until ctx is done, and returns whether they all
+// were, in which case the producers can be closed safely. The offsets of the messages left
+// behind are not committed, so they are consumed again by the next member of their group.
+func (d *KafkaDispatcher) stopConsumers(ctx context.Context) bool {
+	d.updateLock.Lock()
+	defer d.updateLock.Unlock()
+	d.stopped = true
+	// Interrupt the retries in progress, and wait for the messages being handled, which may still
+	// publish to the retry or dead letter topics, and mark their offsets.
+	for _, deliveryMap := range d.deliveries {
+		for _, dl := range deliveryMap {
+			dl.stop()
+		}
+	}
+	drained := true
+	for _, deliveryMap := range d.deliveries {
+		for _, dl := range deliveryMap {
+			if drained && !dl.wait(ctx) {
+				d.logger.Warn("Messages were still being handled at the end of the shutdown timeout")
+				drained = false
+			}
+		}
+	}
+	for channelRef, deliveryMap := range d.deliveries {
+		for sub := range deliveryMap {
+			if err := d.unsubscribe(channelRef, sub); err != nil {
+				d.logger.Error("Error closing the consumer", zap.Any("channelRef", channelRef), zap.Any("subscription", sub), zap.Error(err))
+			}
+		}
+	}
+	for channelRef, subMap := range d.kafkaConsumers {
+		for sub := range subMap {
+			if err := d.unsubscribe(channelRef, sub); err != nil {
+				d.logger.Error("Error closing the consumer", zap.Any("channelRef", channelRef), zap.Any("subscription", sub), zap.Error(err))
+			}
+		}
+	}
+	return drained
+}
+
+// publish publishes message to the topic of channel. In sync mode, it only returns once Kafka
+// acknowledged the message, or failed to. The headers reserved for the delivery of the messages
+// are not written.
+func (d *KafkaDispatcher) publish(channel provisioners.ChannelReference, message *provisioners.Message) error {
+	message = withoutDeliveryHeaders(message)
+	kafkaMessage := d.toKafkaMessage(channel, message)
+	if attribute := d.partitionKey(channel); attribute != "" {
+		// Records having the same key are published to the same partition, whose records are
+		// dispatched sequentially, preserving their order.
+		if key := fanout.PartitionKey(message, attribute); key != "" {
+			kafkaMessage.Key = sarama.StringEncoder(key)
+		}
+	}
+	start := time.Now()
+	if !d.syncPublish {
+		kafkaMessage.Metadata = publishMetadata{channel: channel, start: start}
+		d.kafkaAsyncProducer.Input() <- kafkaMessage
+		return nil
+	}
+	_, _, err := d.kafkaSyncProducer.SendMessage(kafkaMessage)
+	observePublish(channel, start, err)
+	if err != nil {
+		d.logger.Warn("Unable to publish message", zap.Any("channel", channel), zap.Error(err))
+		return publishError(err)
+	}
+	return nil
+}
+
+// partitionKey returns the CloudEvents attribute keying the records of channel's topic, or the
+// empty string if they are not keyed.
+func (d *KafkaDispatcher) partitionKey(channel provisioners.ChannelReference) string {
+	keys, _ := d.partitionKeys.Load().(map[provisioners.ChannelReference]string)
+	return keys[channel]
+}
+
+// newPartitionKeys indexes the partition keys of the Channels in config.
+func newPartitionKeys(config *multichannelfanout.Config) map[provisioners.ChannelReference]string {
+	keys := make(map[provisioners.ChannelReference]string)
+	for _, cc := range config.ChannelConfigs {
+		if cc.FanoutConfig.PartitionKeyExtension != "" {
+			keys[provisioners.ChannelReference{Namespace: cc.Namespace, Name: cc.Name}] = cc.FanoutConfig.PartitionKeyExtension
+		}
+	}
+	return keys
+}
+
+// topicName returns the topic of channel: the one recorded in the config, or the one named after
+// the Channel's namespace and name.
+func (d *KafkaDispatcher) topicName(channel provisioners.ChannelReference) string {
+	topics, _ := d.topics.Load().(map[provisioners.ChannelReference]string)
+	return channelTopic(channel, topics[channel])
+}
+
+// newTopics indexes the topics of the Channels in config that record one.
+func newTopics(config *multichannelfanout.Config) map[provisioners.ChannelReference]string {
+	topics := make(map[provisioners.ChannelReference]string)
+	for _, cc := range config.ChannelConfigs {
+		if cc.Topic != "" {
+			topics[provisioners.ChannelReference{Namespace: cc.Namespace, Name: cc.Name}] = cc.Topic
+		}
+	}
+	return topics
+}
+
+// observeAsyncPublish records the latency of a message published by kafkaAsyncProducer.
+func observeAsyncPublish(msg *sarama.ProducerMessage, err error) {
+	if msg == nil {
+		return
+	}
+	if metadata, ok := msg.Metadata.(publishMetadata); ok {
+		observePublish(metadata.channel, metadata.start, err)
+	}
+}
+
+// publishError maps an error returned by the producer to the error returned to the sender. Errors
+// caused by the message itself tell the sender not to send it again, the others ask the sender to
+// retry later.
+func publishError(err error) error {
+	if pe, ok := err.(*sarama.ProducerError); ok {
+		err = pe.Err
+	}
+	switch err {
+	case sarama.ErrMessageSizeTooLarge, sarama.ErrInvalidMessageSize:
+		return provisioners.ErrMessageTooLarge
+	case sarama.ErrInvalidMessage:
+		return provisioners.ErrInvalidMessage
+	}
+	return provisioners.ErrUnavailable
+}
+
+// checkKafkaClient reports an error if the Kafka client is closed or does not know of any broker.
+func (d *KafkaDispatcher) checkKafkaClient() error {
+	if d.kafkaClient == nil {
+		return errors.New("kafka client is not set")
+	}
+	if d.kafkaClient.Closed() {
+		return errors.New("kafka client is closed")
+	}
+	if len(d.kafkaClient.Brokers()) == 0 {
+		return errors.New("kafka client has no available brokers")
+	}
+	return nil
 }
 
 func (d *KafkaDispatcher) subscribe(channelRef provisioners.ChannelReference, sub subscription) error {
 	d.logger.Info("Subscribing", zap.Any("channelRef", channelRef), zap.Any("subscription", sub))
 
-	topicName := topicUtils.TopicName(controller.KafkaChannelSeparator, channelRef.Namespace, channelRef.Name)
+	topicName := channelTopic(channelRef, sub.Topic)
 
-	group := fmt.Sprintf("%s.%s.%s", controller.Name, sub.Namespace, sub.Name)
-	consumer, err := d.kafkaCluster.NewConsumer(group, []string{topicName})
+	positions, err := sub.consumerPositions()
+	if err != nil {
+		d.logger.Error("Unable to consume the channel", zap.Any("channelRef", channelRef), zap.Any("subscription", sub), zap.Error(err))
+		return err
+	}
+	group := sub.group()
+	consumer, err := d.kafkaCluster.NewConsumer(group, []string{topicName}, positions)
 
 	if err != nil {
 		// we can not create a consumer - logging that, with reason
@@ -177,6 +504,17 @@ func (d *KafkaDispatcher) subscribe(channelRef provisioners.ChannelReference, su
 		return err
 	}
 
+	dl := newDelivery(d, channelRef, sub)
+	if sub.Delivery.Strategy == eventingduck.DeliveryStrategyRequeue {
+		retryTopicName := controller.RetryTopicName(topicName, sub.Name)
+		dl.retryConsumer, err = d.kafkaCluster.NewConsumer(group+".retry", []string{retryTopicName}, ConsumerPositions{Start: sarama.OffsetNewest})
+		if err != nil {
+			d.logger.Info("Could not create proper retry consumer", zap.Error(err))
+			consumer.Close()
+			return err
+		}
+	}
+
 	channelMap, ok := d.kafkaConsumers[channelRef]
 	if !ok {
 		channelMap = make(map[subscription]KafkaConsumer)
@@ -184,59 +522,92 @@ func (d *KafkaDispatcher) subscribe(channelRef provisioners.ChannelReference, su
 	}
 	channelMap[sub] = consumer
 
-	if cluster.ConsumerModePartitions == d.kafkaCluster.GetConsumerMode() {
-		go d.partitionConsumerLoop(consumer, channelRef, sub)
-	} else {
-		go d.multiplexConsumerLoop(consumer, channelRef, sub)
+	if d.deliveries == nil {
+		d.deliveries = make(map[provisioners.ChannelReference]map[subscription]*delivery)
+	}
+	deliveryMap, ok := d.deliveries[channelRef]
+	if !ok {
+		deliveryMap = make(map[subscription]*delivery)
+		d.deliveries[channelRef] = deliveryMap
+	}
+	deliveryMap[sub] = dl
+
+	d.startConsumerLoop(consumer, dl)
+	if dl.retryConsumer != nil {
+		d.startConsumerLoop(dl.retryConsumer, dl)
 	}
 	return nil
 }
 
-func (d *KafkaDispatcher) partitionConsumerLoop(consumer KafkaConsumer, channelRef provisioners.ChannelReference, sub subscription) {
-	d.logger.Info("Partition Consumer for subscription started", zap.Any("channelRef", channelRef), zap.Any("subscription", sub))
+func (d *KafkaDispatcher) startConsumerLoop(consumer KafkaConsumer, dl *delivery) {
+	if controller.ConsumerModePartitions == d.kafkaCluster.GetConsumerMode() {
+		go d.partitionConsumerLoop(consumer, dl)
+	} else {
+		go d.multiplexConsumerLoop(consumer, dl)
+	}
+}
+
+func (d *KafkaDispatcher) partitionConsumerLoop(consumer KafkaConsumer, dl *delivery) {
+	d.logger.Info("Partition Consumer for subscription started", zap.Any("channelRef", dl.channelRef), zap.Any("subscription", dl.sub))
 	for {
 		pc, more := <-consumer.Partitions()
 		if !more {
 			break
 		}
-		go func(pc cluster.PartitionConsumer) {
+		go func(pc PartitionConsumer) {
 			for msg := range pc.Messages() {
-				d.dispatch(channelRef, sub, consumer, msg)
+				d.dispatch(dl, consumer, msg)
 			}
 		}(pc)
 	}
-	d.logger.Info("Partition Consumer for subscription stopped", zap.Any("channelRef", channelRef), zap.Any("subscription", sub))
+	d.logger.Info("Partition Consumer for subscription stopped", zap.Any("channelRef", dl.channelRef), zap.Any("subscription", dl.sub))
 }
 
-func (d *KafkaDispatcher) multiplexConsumerLoop(consumer KafkaConsumer, channelRef provisioners.ChannelReference, sub subscription) {
-	d.logger.Info("Consumer for subscription started", zap.Any("channelRef", channelRef), zap.Any("subscription", sub))
+func (d *KafkaDispatcher) multiplexConsumerLoop(consumer KafkaConsumer, dl *delivery) {
+	d.logger.Info("Consumer for subscription started", zap.Any("channelRef", dl.channelRef), zap.Any("subscription", dl.sub))
 	for {
 		msg, more := <-consumer.Messages()
 		if more {
-			d.dispatch(channelRef, sub, consumer, msg)
+			d.dispatch(dl, consumer, msg)
 		} else {
 			break
 		}
 	}
-	d.logger.Info("Consumer for subscription stopped", zap.Any("channelRef", channelRef), zap.Any("subscription", sub))
+	d.logger.Info("Consumer for subscription stopped", zap.Any("channelRef", dl.channelRef), zap.Any("subscription", dl.sub))
 }
 
-func (d *KafkaDispatcher) dispatch(channelRef provisioners.ChannelReference, sub subscription, consumer KafkaConsumer,
-	msg *sarama.ConsumerMessage) error {
-	d.logger.Info("Dispatching a message for subscription", zap.Any("channelRef", channelRef),
-		zap.Any("subscription", sub), zap.Any("partition", msg.Partition), zap.Any("offset", msg.Offset))
-	message := fromKafkaMessage(msg)
-	err := d.dispatchMessage(message, sub)
-	if err != nil {
-		d.logger.Warn("Got error trying to dispatch message", zap.Error(err))
+// dispatch delivers msg according to the subscription's error strategy, and marks it as processed
+// once it was delivered, handed over to be retried later, or given up on.
+func (d *KafkaDispatcher) dispatch(dl *delivery, consumer KafkaConsumer, msg *sarama.ConsumerMessage) error {
+	if !dl.begin() {
+		return errStopped
+	}
+	defer dl.end()
+	d.logger.Info("Dispatching a message for subscription", zap.Any("channelRef", dl.channelRef),
+		zap.Any("subscription", dl.sub), zap.Any("partition", msg.Partition), zap.Any("offset", msg.Offset))
+	dl.track(msg, msg.Offset)
+	if err := dl.handle(msg, dl.retryConsumer != nil && consumer == dl.retryConsumer); err != nil {
+		// The subscription was stopped, the message will be consumed again by its next consumer.
+		d.logger.Info("Message left unprocessed", zap.Any("partition", msg.Partition), zap.Any("offset", msg.Offset), zap.Error(err))
+		return err
 	}
-	// TODO: handle errors with pluggable strategy
 	consumer.MarkOffset(msg, "") // Mark message as processed
-	return err
+	dl.track(msg, msg.Offset+1)
+	return nil
 }
 
 func (d *KafkaDispatcher) unsubscribe(channel provisioners.ChannelReference, sub subscription) error {
 	d.logger.Info("Unsubscribing from channel", zap.Any("channel", channel), zap.Any("subscription", sub))
+	if dl, ok := d.deliveries[channel][sub]; ok {
+		delete(d.deliveries[channel], sub)
+		// Interrupt the retries in progress before closing the consumers.
+		dl.stop()
+		if dl.retryConsumer != nil {
+			if err := dl.retryConsumer.Close(); err != nil {
+				d.logger.Error("Error closing the retry consumer", zap.Error(err))
+			}
+		}
+	}
 	if consumer, ok := d.kafkaConsumers[channel][sub]; ok {
 		delete(d.kafkaConsumers[channel], sub)
 		return consumer.Close()
@@ -244,10 +615,19 @@ func (d *KafkaDispatcher) unsubscribe(channel provisioners.ChannelReference, sub
 	return nil
 }
 
-// dispatchMessage sends the request to exactly one subscription. It handles both the `call` and
-// the `sink` portions of the subscription.
-func (d *KafkaDispatcher) dispatchMessage(m *provisioners.Message, sub subscription) error {
-	return d.dispatcher.DispatchMessage(m, sub.SubscriberURI, sub.ReplyURI, provisioners.DispatchDefaults{})
+// dispatchMessage sends the request to exactly one subscription of channel. It handles both the
+// `call` and the `sink` portions of the subscription. The subscription's Secrets are read in the
+// namespace of channel.
+func (d *KafkaDispatcher) dispatchMessage(channel provisioners.ChannelReference, m *provisioners.Message, sub subscription) error {
+	defaults := provisioners.DispatchDefaults{Namespace: channel.Namespace}
+	if sub.TLSSecretRef.Name != "" {
+		defaults.TLSSecretRef = &sub.TLSSecretRef
+	}
+	if sub.AuthSecretRef.Name != "" {
+		defaults.AuthType = sub.AuthType
+		defaults.AuthSecretRef = &sub.AuthSecretRef
+	}
+	return d.dispatcher.DispatchMessage(m, sub.SubscriberURI, sub.ReplyURI, defaults)
 }
 
 func (d *KafkaDispatcher) getConfig() *multichannelfanout.Config {
@@ -258,12 +638,12 @@ func (d *KafkaDispatcher) setConfig(config *multichannelfanout.Config) {
 	d.config.Store(config)
 }
 
-func NewDispatcher(brokers []string, consumerMode cluster.ConsumerMode, logger *zap.Logger) (*KafkaDispatcher, error) {
+// NewDispatcher creates a KafkaDispatcher connected to the brokers of config. kubeClient is used to
+// update the Lagging condition of Subscriptions, and secrets to read the TLS and credentials
+// Secrets referenced by subscribers. receiverOpts are applied to the dispatcher's MessageReceiver.
+func NewDispatcher(config *controller.KafkaProvisionerConfig, logger *zap.Logger, kubeClient client.Client, secrets corev1client.SecretsGetter, receiverOpts ...provisioners.ReceiverOption) (*KafkaDispatcher, error) {
 
-	conf := sarama.NewConfig()
-	conf.Version = sarama.V1_1_0_0
-	conf.ClientID = controller.Name + "-dispatcher"
-	client, err := sarama.NewClient(brokers, conf)
+	client, err := sarama.NewClient(config.Brokers, newProducerConfig(config))
 	if err != nil {
 		return nil, fmt.Errorf("unable to create kafka client: %v", err)
 	}
@@ -273,29 +653,62 @@ func NewDispatcher(brokers []string, consumerMode cluster.ConsumerMode, logger *
 		return nil, fmt.Errorf("unable to create kafka producer: %v", err)
 	}
 
+	syncProducer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create kafka sync producer: %v", err)
+	}
+
 	dispatcher := &KafkaDispatcher{
-		dispatcher: provisioners.NewMessageDispatcher(logger.Sugar()),
+		dispatcher: provisioners.NewMessageDispatcher(logger.Sugar(), provisioners.WithSecretClient(secrets)),
 
-		kafkaCluster:       &saramaCluster{kafkaBrokers: brokers, consumerMode: consumerMode},
+		kafkaCluster:       &saramaCluster{config: config, logger: logger},
 		kafkaConsumers:     make(map[provisioners.ChannelReference]map[subscription]KafkaConsumer),
+		kafkaClient:        client,
 		kafkaAsyncProducer: producer,
+		kafkaSyncProducer:  syncProducer,
+		syncPublish:        config.SyncPublish,
+		kubeClient:         kubeClient,
+		lagThreshold:       config.LagThreshold,
 
 		logger: logger,
 	}
-	receiverFunc := provisioners.NewMessageReceiver(
-		func(channel provisioners.ChannelReference, message *provisioners.Message) error {
-			dispatcher.kafkaAsyncProducer.Input() <- toKafkaMessage(channel, message)
-			return nil
-		}, logger.Sugar())
+	receiverFunc := provisioners.NewMessageReceiver(dispatcher.publish, logger.Sugar(),
+		append(receiverOpts, provisioners.WithReadinessCheck(dispatcher.checkKafkaClient))...)
 	dispatcher.receiver = receiverFunc
 	dispatcher.setConfig(&multichannelfanout.Config{})
 	return dispatcher, nil
 }
 
+// newProducerConfig returns the configuration of the client shared by the producers.
+func newProducerConfig(config *controller.KafkaProvisionerConfig) *sarama.Config {
+	conf := sarama.NewConfig()
+	conf.Version = sarama.V1_1_0_0
+	conf.ClientID = controller.Name + "-dispatcher"
+	// Required by the sync producer.
+	conf.Producer.Return.Successes = true
+	if config.SyncPublish {
+		// Wait for all the in-sync replicas, and keep a single request in flight to each broker
+		// so that the producer's retries do not reorder messages. The idempotent producer keeps
+		// them from writing a message twice.
+		conf.Producer.RequiredAcks = sarama.WaitForAll
+		conf.Net.MaxOpenRequests = 1
+		conf.Producer.Idempotent = true
+	}
+	config.ConfigureNet(conf)
+	return conf
+}
+
+// fromKafkaMessage reads a record written according to the CloudEvents Kafka protocol binding, or
+// in the format of the previous versions of the dispatcher.
 func fromKafkaMessage(kafkaMessage *sarama.ConsumerMessage) *provisioners.Message {
 	headers := make(map[string]string)
 	for _, header := range kafkaMessage.Headers {
-		headers[string(header.Key)] = string(header.Value)
+		name, legacy := httpHeaderName(string(header.Key))
+		if _, set := headers[name]; set && legacy {
+			// The attributes in the binding's format take precedence.
+			continue
+		}
+		headers[name] = string(header.Value)
 	}
 	message := provisioners.Message{
 		Headers: headers,
@@ -304,14 +717,19 @@ func fromKafkaMessage(kafkaMessage *sarama.ConsumerMessage) *provisioners.Messag
 	return &message
 }
 
-func toKafkaMessage(channel provisioners.ChannelReference, message *provisioners.Message) *sarama.ProducerMessage {
+func (d *KafkaDispatcher) toKafkaMessage(channel provisioners.ChannelReference, message *provisioners.Message) *sarama.ProducerMessage {
+	return newProducerMessage(d.topicName(channel), message)
+}
+
+// newProducerMessage writes message to topic according to the CloudEvents Kafka protocol binding.
+func newProducerMessage(topic string, message *provisioners.Message) *sarama.ProducerMessage {
 	kafkaMessage := sarama.ProducerMessage{
-		Topic: topicUtils.TopicName(controller.KafkaChannelSeparator, channel.Namespace, channel.Name),
+		Topic: topic,
 		Value: sarama.ByteEncoder(message.Payload),
 	}
 	for h, v := range message.Headers {
 		kafkaMessage.Headers = append(kafkaMessage.Headers, sarama.RecordHeader{
-			Key:   []byte(h),
+			Key:   []byte(kafkaHeaderName(h)),
 			Value: []byte(v),
 		})
 	}
@@ -319,10 +737,27 @@ func toKafkaMessage(channel provisioners.ChannelReference, message *provisioners
 }
 
 func newSubscription(spec eventingduck.ChannelSubscriberSpec) subscription {
-	return subscription{
+	s := subscription{
 		Name:          spec.Ref.Name,
 		Namespace:     spec.Ref.Namespace,
 		SubscriberURI: spec.SubscriberURI,
 		ReplyURI:      spec.ReplyURI,
 	}
+	if spec.TLSSecretRef != nil {
+		s.TLSSecretRef = *spec.TLSSecretRef
+	}
+	if spec.AuthSecretRef != nil {
+		s.AuthType = spec.AuthType
+		s.AuthSecretRef = *spec.AuthSecretRef
+	}
+	if spec.Delivery != nil {
+		s.Delivery = *spec.Delivery
+	}
+	if spec.Start != nil {
+		s.Start = spec.Start.String()
+	}
+	if spec.Reset != nil {
+		s.Reset = spec.Reset.String()
+	}
+	return s
 }
@@ -1,6 +1,7 @@
 package dispatcher
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -9,16 +10,17 @@ import (
 	"sync"
 	"sync/atomic"
 	"testing"
-
-	cluster "github.com/bsm/sarama-cluster"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 
+	"github.com/knative/eventing/contrib/kafka/pkg/controller"
 	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
 	"github.com/knative/eventing/pkg/provisioners"
 	"github.com/knative/eventing/pkg/sidecar/fanout"
@@ -28,14 +30,14 @@ import (
 
 type mockConsumer struct {
 	message    chan *sarama.ConsumerMessage
-	partitions chan cluster.PartitionConsumer
+	partitions chan PartitionConsumer
 }
 
 func (c *mockConsumer) Messages() <-chan *sarama.ConsumerMessage {
 	return c.message
 }
 
-func (c *mockConsumer) Partitions() <-chan cluster.PartitionConsumer {
+func (c *mockConsumer) Partitions() <-chan PartitionConsumer {
 	return c.partitions
 }
 
@@ -144,20 +146,32 @@ type mockSaramaCluster struct {
 	// Handle to the latest created consumer, useful to access underlying message chan
 	consumerChannel chan *sarama.ConsumerMessage
 	// Handle to the latest created partition consumer, useful to access underlying message chan
-	partitionConsumerChannel chan cluster.PartitionConsumer
+	partitionConsumerChannel chan PartitionConsumer
 	// createErr will return an error when creating a consumer
 	createErr bool
 	// consumer mode
-	consumerMode cluster.ConsumerMode
+	consumerMode controller.ConsumerMode
+	// positions of the consumers created, by group
+	positions map[string]ConsumerPositions
+	// topics consumed by the consumers created, by group
+	topics map[string][]string
 }
 
-func (c *mockSaramaCluster) NewConsumer(groupID string, topics []string) (KafkaConsumer, error) {
+func (c *mockSaramaCluster) NewConsumer(groupID string, topics []string, positions ConsumerPositions) (KafkaConsumer, error) {
 	if c.createErr {
 		return nil, errors.New("error creating consumer")
 	}
+	if c.positions == nil {
+		c.positions = make(map[string]ConsumerPositions)
+	}
+	c.positions[groupID] = positions
+	if c.topics == nil {
+		c.topics = make(map[string][]string)
+	}
+	c.topics[groupID] = topics
 
 	var consumer *mockConsumer
-	if c.consumerMode != cluster.ConsumerModePartitions {
+	if c.consumerMode != controller.ConsumerModePartitions {
 		consumer = &mockConsumer{
 			message: make(chan *sarama.ConsumerMessage),
 		}
@@ -167,7 +181,7 @@ func (c *mockSaramaCluster) NewConsumer(groupID string, topics []string) (KafkaC
 		c.consumerChannel = consumer.message
 	} else {
 		consumer = &mockConsumer{
-			partitions: make(chan cluster.PartitionConsumer),
+			partitions: make(chan PartitionConsumer),
 		}
 		if c.closed {
 			close(consumer.partitions)
@@ -177,7 +191,7 @@ func (c *mockSaramaCluster) NewConsumer(groupID string, topics []string) (KafkaC
 	return consumer, nil
 }
 
-func (c *mockSaramaCluster) GetConsumerMode() cluster.ConsumerMode {
+func (c *mockSaramaCluster) GetConsumerMode() controller.ConsumerMode {
 	return c.consumerMode
 }
 
@@ -456,10 +470,326 @@ func TestToKafkaMessage(t *testing.T) {
 		},
 		Value: sarama.ByteEncoder(data),
 	}
-	got := toKafkaMessage(channelRef, msg)
+	d := &KafkaDispatcher{}
+	got := d.toKafkaMessage(channelRef, msg)
 	if diff := cmp.Diff(want, got, cmpopts.IgnoreUnexported(sarama.ProducerMessage{})); diff != "" {
 		t.Errorf("unexpected message (-want, +got) = %s", diff)
 	}
+
+	d.topics.Store(newTopics(&multichannelfanout.Config{
+		ChannelConfigs: []multichannelfanout.ChannelConfig{{
+			Namespace: "test-ns",
+			Name:      "test-channel",
+			Topic:     "knative-eventing-channel.test-channel.uid",
+		}},
+	}))
+	want.Topic = "knative-eventing-channel.test-channel.uid"
+	got = d.toKafkaMessage(channelRef, msg)
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreUnexported(sarama.ProducerMessage{})); diff != "" {
+		t.Errorf("unexpected message with recorded topic (-want, +got) = %s", diff)
+	}
+}
+
+func TestPublish_Sync(t *testing.T) {
+	channelRef := provisioners.ChannelReference{
+		Name:      "test-channel",
+		Namespace: "test-ns",
+	}
+	msg := &provisioners.Message{
+		Payload: []byte("data"),
+	}
+	testCases := map[string]struct {
+		producerErr error
+		want        error
+	}{
+		"acknowledged": {},
+		"leader not available": {
+			producerErr: sarama.ErrLeaderNotAvailable,
+			want:        provisioners.ErrUnavailable,
+		},
+		"out of brokers": {
+			producerErr: sarama.ErrOutOfBrokers,
+			want:        provisioners.ErrUnavailable,
+		},
+		"message too large": {
+			producerErr: &sarama.ProducerError{Err: sarama.ErrMessageSizeTooLarge},
+			want:        provisioners.ErrMessageTooLarge,
+		},
+		"invalid message": {
+			producerErr: sarama.ErrInvalidMessage,
+			want:        provisioners.ErrInvalidMessage,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			producer := &erroringSyncProducer{err: tc.producerErr}
+			d := &KafkaDispatcher{
+				syncPublish:       true,
+				kafkaSyncProducer: producer,
+				logger:            zap.NewNop(),
+			}
+			if got := d.publish(channelRef, msg); got != tc.want {
+				t.Errorf("Unexpected error. Expected %v. Actual %v", tc.want, got)
+			}
+			if producer.topic != "knative-eventing-channel.test-ns.test-channel" {
+				t.Errorf("Unexpected topic %q", producer.topic)
+			}
+		})
+	}
+}
+
+func TestPublish_DeliveryHeaders(t *testing.T) {
+	producer := &fakeSyncProducer{}
+	d := &KafkaDispatcher{
+		syncPublish:       true,
+		kafkaSyncProducer: producer,
+		logger:            zap.NewNop(),
+	}
+	msg := &provisioners.Message{
+		Headers: map[string]string{
+			"Knative-Kafka-Attempts": "100",
+			"Knative-Kafka-Retry-At": "2099-01-01T00:00:00Z",
+			"K1":                     "v1",
+		},
+		Payload: []byte("data"),
+	}
+	if err := d.publish(deliveryChannelRef, msg); err != nil {
+		t.Fatalf("Unexpected error publishing the message: %v", err)
+	}
+	sent := producer.sentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("Expected a single published message. Actual %v", sent)
+	}
+	for _, h := range []string{attemptsHeader, retryAtHeader} {
+		if _, ok := producerHeader(sent[0], h); ok {
+			t.Errorf("Expected the %s header not to be published", h)
+		}
+	}
+	if v, _ := producerHeader(sent[0], "k1"); v != "v1" {
+		t.Errorf("Expected the other headers to be published. Actual %v", sent[0].Headers)
+	}
+	if len(msg.Headers) != 3 {
+		t.Errorf("Expected the published message not to be modified. Actual %v", msg.Headers)
+	}
+}
+
+func TestNewProducerConfig(t *testing.T) {
+	for _, syncPublish := range []bool{false, true} {
+		conf := newProducerConfig(&controller.KafkaProvisionerConfig{SyncPublish: syncPublish})
+		if err := conf.Validate(); err != nil {
+			t.Errorf("Invalid producer configuration with SyncPublish %v: %v", syncPublish, err)
+		}
+		if conf.Producer.Idempotent != syncPublish {
+			t.Errorf("Unexpected Idempotent with SyncPublish %v: %v", syncPublish, conf.Producer.Idempotent)
+		}
+	}
+}
+
+// erroringSyncProducer fails every message with err, if set. It records the last message sent.
+type erroringSyncProducer struct {
+	fakeSyncProducer
+	err   error
+	topic string
+	key   sarama.Encoder
+}
+
+func (p *erroringSyncProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.topic = msg.Topic
+	p.key = msg.Key
+	return 0, 0, p.err
+}
+
+func TestPublish_PartitionKey(t *testing.T) {
+	keyed := provisioners.ChannelReference{Namespace: "test-ns", Name: "keyed"}
+	unkeyed := provisioners.ChannelReference{Namespace: "test-ns", Name: "unkeyed"}
+	producer := &erroringSyncProducer{}
+	d := &KafkaDispatcher{
+		syncPublish:       true,
+		kafkaSyncProducer: producer,
+		kafkaConsumers:    make(map[provisioners.ChannelReference]map[subscription]KafkaConsumer),
+		logger:            zap.NewNop(),
+	}
+	d.setConfig(&multichannelfanout.Config{})
+	err := d.UpdateConfig(&multichannelfanout.Config{
+		ChannelConfigs: []multichannelfanout.ChannelConfig{{
+			Namespace: keyed.Namespace,
+			Name:      keyed.Name,
+			FanoutConfig: fanout.Config{
+				PartitionKeyExtension: "subject",
+			},
+		}, {
+			Namespace: unkeyed.Namespace,
+			Name:      unkeyed.Name,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error updating the config: %v", err)
+	}
+
+	withSubject := &provisioners.Message{
+		Headers: map[string]string{"ce-subject": "order-42"},
+		Payload: []byte("data"),
+	}
+	testCases := map[string]struct {
+		channel provisioners.ChannelReference
+		message *provisioners.Message
+		want    sarama.Encoder
+	}{
+		"keyed": {
+			channel: keyed,
+			message: withSubject,
+			want:    sarama.StringEncoder("order-42"),
+		},
+		"missing attribute": {
+			channel: keyed,
+			message: &provisioners.Message{Payload: []byte("data")},
+		},
+		"unkeyed channel": {
+			channel: unkeyed,
+			message: withSubject,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			if err := d.publish(tc.channel, tc.message); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, producer.key); diff != "" {
+				t.Errorf("unexpected key (-want, +got) = %s", diff)
+			}
+		})
+	}
+}
+
+// orderTestHandler records the payloads it receives, and fails the test if two messages with the
+// same prefix, identifying their partition, are delivered concurrently.
+type orderTestHandler struct {
+	t        *testing.T
+	lock     sync.Mutex
+	inFlight map[string]bool
+	received map[string][]string
+	done     chan struct{}
+	expected int
+	count    int
+}
+
+func (h *orderTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	payload := string(body)
+	partition := payload[:2]
+
+	h.lock.Lock()
+	if h.inFlight[partition] {
+		h.t.Errorf("Concurrent deliveries for partition %s", partition)
+	}
+	h.inFlight[partition] = true
+	h.lock.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	h.lock.Lock()
+	h.inFlight[partition] = false
+	h.received[partition] = append(h.received[partition], payload)
+	h.count++
+	if h.count == h.expected {
+		close(h.done)
+	}
+	h.lock.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func TestPartitionConsumer_SequentialPerPartition(t *testing.T) {
+	sc := &mockSaramaCluster{consumerMode: controller.ConsumerModePartitions}
+	d := &KafkaDispatcher{
+		kafkaCluster:   sc,
+		kafkaConsumers: make(map[provisioners.ChannelReference]map[subscription]KafkaConsumer),
+		dispatcher:     provisioners.NewMessageDispatcher(zap.NewNop().Sugar()),
+		logger:         zap.NewNop(),
+	}
+	const perPartition = 5
+	handler := &orderTestHandler{
+		t:        t,
+		inFlight: make(map[string]bool),
+		received: make(map[string][]string),
+		done:     make(chan struct{}),
+		expected: 2 * perPartition,
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	channelRef := provisioners.ChannelReference{
+		Name:      "test-channel",
+		Namespace: "test-ns",
+	}
+	if err := d.subscribe(channelRef, subscription{
+		Name:          "test-sub",
+		Namespace:     "test-ns",
+		SubscriberURI: server.URL[7:],
+	}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer close(sc.partitionConsumerChannel)
+
+	want := make(map[string][]string)
+	for p := int32(0); p < 2; p++ {
+		pc := &mockPartitionConsumer{
+			topic:     channelRef.Name,
+			partition: p,
+			messages:  make(chan *sarama.ConsumerMessage, perPartition),
+		}
+		prefix := fmt.Sprintf("p%d", p)
+		for i := 0; i < perPartition; i++ {
+			payload := fmt.Sprintf("%s-%d", prefix, i)
+			want[prefix] = append(want[prefix], payload)
+			pc.messages <- &sarama.ConsumerMessage{
+				Topic:     channelRef.Name,
+				Partition: p,
+				Offset:    int64(i),
+				Value:     []byte(payload),
+			}
+		}
+		sc.partitionConsumerChannel <- pc
+	}
+
+	select {
+	case <-handler.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for the messages to be delivered")
+	}
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	if diff := cmp.Diff(want, handler.received); diff != "" {
+		t.Errorf("unexpected delivery order (-want, +got) = %s", diff)
+	}
+}
+
+func TestPublish_Async(t *testing.T) {
+	producer := &asyncProducerInput{input: make(chan *sarama.ProducerMessage, 1)}
+	d := &KafkaDispatcher{
+		kafkaAsyncProducer: producer,
+		logger:             zap.NewNop(),
+	}
+	channelRef := provisioners.ChannelReference{
+		Name:      "test-channel",
+		Namespace: "test-ns",
+	}
+	if err := d.publish(channelRef, &provisioners.Message{Payload: []byte("data")}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sent := <-producer.input
+	if metadata, ok := sent.Metadata.(publishMetadata); !ok || metadata.channel != channelRef {
+		t.Errorf("Unexpected metadata %v", sent.Metadata)
+	}
+}
+
+// asyncProducerInput is a sarama.AsyncProducer only implementing Input.
+type asyncProducerInput struct {
+	sarama.AsyncProducer
+	input chan *sarama.ProducerMessage
+}
+
+func (p *asyncProducerInput) Input() chan<- *sarama.ProducerMessage {
+	return p.input
 }
 
 type dispatchTestHandler struct {
@@ -528,8 +858,138 @@ func TestSubscribe(t *testing.T) {
 
 }
 
+func TestDispatcher_UpdateConfigTopic(t *testing.T) {
+	sc := &mockSaramaCluster{closed: true}
+	d := &KafkaDispatcher{
+		kafkaCluster:   sc,
+		kafkaConsumers: make(map[provisioners.ChannelReference]map[subscription]KafkaConsumer),
+		dispatcher:     provisioners.NewMessageDispatcher(zap.NewNop().Sugar()),
+		logger:         zap.NewNop(),
+	}
+	d.setConfig(&multichannelfanout.Config{})
+	config := func(topic string) *multichannelfanout.Config {
+		return &multichannelfanout.Config{
+			ChannelConfigs: []multichannelfanout.ChannelConfig{{
+				Namespace: "test-ns",
+				Name:      "test-channel",
+				FanoutConfig: fanout.Config{
+					Subscriptions: []eventingduck.ChannelSubscriberSpec{{
+						Ref: &v1.ObjectReference{Name: "test-sub", Namespace: "test-ns"},
+						Delivery: &eventingduck.DeliverySpec{
+							Strategy: eventingduck.DeliveryStrategyRequeue,
+						},
+					}},
+				},
+				Topic: topic,
+			}},
+		}
+	}
+	channelRef := provisioners.ChannelReference{Name: "test-channel", Namespace: "test-ns"}
+	group := fmt.Sprintf("%s.test-ns.test-sub", controller.Name)
+
+	for _, topic := range []string{"", "knative-eventing-channel.test-channel.uid-1", "knative-eventing-channel.test-channel.uid-2"} {
+		if err := d.UpdateConfig(config(topic)); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		want := channelTopic(channelRef, topic)
+		if diff := cmp.Diff([]string{want}, sc.topics[group]); diff != "" {
+			t.Errorf("unexpected topics for %q (-want, +got) = %v", topic, diff)
+		}
+		if diff := cmp.Diff([]string{want + ".test-sub.retry"}, sc.topics[group+".retry"]); diff != "" {
+			t.Errorf("unexpected retry topics for %q (-want, +got) = %v", topic, diff)
+		}
+		if got := d.topicName(channelRef); got != want {
+			t.Errorf("unexpected published topic for %q, want %q, got %q", topic, want, got)
+		}
+		if got := len(d.kafkaConsumers[channelRef]); got != 1 {
+			t.Errorf("unexpected number of consumers for %q, want 1, got %d", topic, got)
+		}
+	}
+}
+
+func TestSubscribe_Positions(t *testing.T) {
+	sc := &mockSaramaCluster{closed: true}
+	d := &KafkaDispatcher{
+		kafkaCluster:   sc,
+		kafkaConsumers: make(map[provisioners.ChannelReference]map[subscription]KafkaConsumer),
+		dispatcher:     provisioners.NewMessageDispatcher(zap.NewNop().Sugar()),
+		logger:         zap.NewNop(),
+	}
+	channelRef := provisioners.ChannelReference{
+		Name:      "test-channel",
+		Namespace: "test-ns",
+	}
+	resetTime := time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name    string
+		spec    eventingduck.ChannelSubscriberSpec
+		want    ConsumerPositions
+		wantErr string
+	}{{
+		name: "default",
+		spec: eventingduck.ChannelSubscriberSpec{
+			Ref: &v1.ObjectReference{Name: "default", Namespace: "test-ns"},
+		},
+		want: ConsumerPositions{Start: sarama.OffsetNewest},
+	}, {
+		name: "earliest",
+		spec: eventingduck.ChannelSubscriberSpec{
+			Ref:   &v1.ObjectReference{Name: "earliest", Namespace: "test-ns"},
+			Start: &eventingduck.StartSpec{Position: eventingduck.StartPositionEarliest},
+		},
+		want: ConsumerPositions{Start: sarama.OffsetOldest},
+	}, {
+		name: "reset",
+		spec: eventingduck.ChannelSubscriberSpec{
+			Ref:   &v1.ObjectReference{Name: "reset", Namespace: "test-ns"},
+			Reset: &eventingduck.StartSpec{Time: &metav1.Time{Time: resetTime}},
+		},
+		want: ConsumerPositions{
+			Start:   sarama.OffsetNewest,
+			Reset:   resetTime.UnixNano() / int64(time.Millisecond),
+			ResetID: "2019-03-01T00:00:00Z",
+		},
+	}, {
+		name: "sequence start",
+		spec: eventingduck.ChannelSubscriberSpec{
+			Ref:   &v1.ObjectReference{Name: "sequence-start", Namespace: "test-ns"},
+			Start: &eventingduck.StartSpec{Sequence: 42},
+		},
+		wantErr: "invalid start: " + errSequenceNotSupported.Error(),
+	}, {
+		name: "sequence reset",
+		spec: eventingduck.ChannelSubscriberSpec{
+			Ref:   &v1.ObjectReference{Name: "sequence-reset", Namespace: "test-ns"},
+			Reset: &eventingduck.StartSpec{Sequence: 42},
+		},
+		wantErr: "invalid reset: " + errSequenceNotSupported.Error(),
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := d.subscribe(channelRef, newSubscription(tc.spec))
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Errorf("unexpected error, want %q, got %v", tc.wantErr, err)
+				}
+				if _, ok := d.kafkaConsumers[channelRef][newSubscription(tc.spec)]; ok {
+					t.Error("unexpected consumer for the subscription")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			group := fmt.Sprintf("%s.test-ns.%s", controller.Name, tc.spec.Ref.Name)
+			if diff := cmp.Diff(tc.want, sc.positions[group]); diff != "" {
+				t.Errorf("unexpected positions (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
 func TestPartitionConsumer(t *testing.T) {
-	sc := &mockSaramaCluster{consumerMode: cluster.ConsumerModePartitions}
+	sc := &mockSaramaCluster{consumerMode: controller.ConsumerModePartitions}
 	data := []byte("data")
 	d := &KafkaDispatcher{
 		kafkaCluster:   sc,
@@ -624,6 +1084,91 @@ func TestUnsubscribeUnknownSub(t *testing.T) {
 	}
 }
 
+// closeRecordingCluster records the consumers it creates, which record whether they were closed.
+type closeRecordingCluster struct {
+	mockSaramaCluster
+	consumers map[string]*closeRecordingConsumer
+}
+
+type closeRecordingConsumer struct {
+	KafkaConsumer
+	closed bool
+}
+
+func (c *closeRecordingConsumer) Close() error {
+	c.closed = true
+	return c.KafkaConsumer.Close()
+}
+
+func (c *closeRecordingCluster) NewConsumer(groupID string, topics []string, positions ConsumerPositions) (KafkaConsumer, error) {
+	consumer, err := c.mockSaramaCluster.NewConsumer(groupID, topics, positions)
+	if err != nil {
+		return nil, err
+	}
+	if c.consumers == nil {
+		c.consumers = make(map[string]*closeRecordingConsumer)
+	}
+	c.consumers[groupID] = &closeRecordingConsumer{KafkaConsumer: consumer}
+	return c.consumers[groupID], nil
+}
+
+func TestDispatcher_StopConsumers(t *testing.T) {
+	sc := &closeRecordingCluster{mockSaramaCluster: mockSaramaCluster{closed: true}}
+	d := &KafkaDispatcher{
+		kafkaCluster:   sc,
+		kafkaConsumers: make(map[provisioners.ChannelReference]map[subscription]KafkaConsumer),
+		dispatcher:     provisioners.NewMessageDispatcher(zap.NewNop().Sugar()),
+		logger:         zap.NewNop(),
+	}
+	d.setConfig(&multichannelfanout.Config{})
+	config := &multichannelfanout.Config{
+		ChannelConfigs: []multichannelfanout.ChannelConfig{{
+			Namespace: "default",
+			Name:      "test-channel",
+			FanoutConfig: fanout.Config{
+				Subscriptions: []eventingduck.ChannelSubscriberSpec{{
+					Ref:           &v1.ObjectReference{Name: "retry", Namespace: "default"},
+					SubscriberURI: "retry",
+				}, {
+					Ref:           &v1.ObjectReference{Name: "requeue", Namespace: "default"},
+					SubscriberURI: "requeue",
+					Delivery:      &eventingduck.DeliverySpec{Strategy: eventingduck.DeliveryStrategyRequeue},
+				}},
+			},
+		}},
+	}
+	if err := d.UpdateConfig(config); err != nil {
+		t.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if len(sc.consumers) != 3 {
+		t.Fatalf("Expected 3 consumers, including a retry consumer. Actual %v", sc.consumers)
+	}
+
+	if !d.stopConsumers(context.Background()) {
+		t.Error("Expected the consumers to be drained")
+	}
+	for group, consumer := range sc.consumers {
+		if !consumer.closed {
+			t.Errorf("Consumer %s was not closed", group)
+		}
+	}
+	for channelRef, subs := range d.kafkaConsumers {
+		if len(subs) != 0 {
+			t.Errorf("Consumers of %v left after stopping: %v", channelRef, subs)
+		}
+	}
+
+	// The consumers are not recreated afterwards.
+	sc.consumers = nil
+	d.setConfig(&multichannelfanout.Config{})
+	if err := d.UpdateConfig(config); err != nil {
+		t.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if len(sc.consumers) != 0 {
+		t.Errorf("Consumers created after stopping: %v", sc.consumers)
+	}
+}
+
 func TestKafkaDispatcher_Start(t *testing.T) {
 	d := &KafkaDispatcher{}
 	err := d.Start(make(chan struct{}))
@@ -0,0 +1,204 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+)
+
+const (
+	// lagCheckInterval is how often the lag of the subscriptions is measured.
+	lagCheckInterval = 15 * time.Second
+
+	// lagAboveThreshold is the reason of the Lagging condition of the Subscriptions whose lag is
+	// above the threshold.
+	lagAboveThreshold = "LagAboveThreshold"
+)
+
+// offsetResolver resolves positions to offsets, like sarama.Client.GetOffset.
+type offsetResolver interface {
+	GetOffset(topic string, partition int32, time int64) (int64, error)
+}
+
+// lagReader reads the partitions of the Channel's topic and the offsets committed by the group of
+// a subscription. It is implemented by the clientOffsets of the group.
+type lagReader interface {
+	Partitions(topic string) ([]int32, error)
+	Committed(topic string, partition int32) (int64, string, error)
+	offsetResolver
+}
+
+// track records next as the offset of the next message to process in msg's partition, if msg was
+// consumed from the Channel's topic.
+func (dl *delivery) track(msg *sarama.ConsumerMessage, next int64) {
+	if msg.Topic != dl.topic {
+		return
+	}
+	dl.lagLock.Lock()
+	defer dl.lagLock.Unlock()
+	if dl.nextOffsets != nil {
+		dl.nextOffsets[msg.Partition] = next
+	}
+}
+
+// measureLag returns the number of messages of the Channel's topic that the subscription has not
+// processed yet, and records it for each partition. Every partition of the topic is measured from
+// the offset committed by the subscription's group, whichever process consumes it, or from the
+// offset processed since by this process if it is further. The partitions the group never
+// committed an offset for are measured from the subscription's start position.
+func (dl *delivery) measureLag(reader lagReader) (int64, error) {
+	partitions, err := reader.Partitions(dl.topic)
+	if err != nil {
+		return 0, err
+	}
+	committed := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		offset, _, err := reader.Committed(dl.topic, partition)
+		if err != nil {
+			return 0, err
+		}
+		if offset >= 0 {
+			committed[partition] = offset
+		}
+	}
+	dl.lagLock.Lock()
+	for partition, offset := range dl.nextOffsets {
+		if current, ok := committed[partition]; !ok || offset > current {
+			committed[partition] = offset
+		}
+	}
+	dl.lagLock.Unlock()
+	start := sarama.OffsetNewest
+	if positions, err := dl.sub.consumerPositions(); err == nil {
+		start = positions.Start
+	}
+
+	lags := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		highWaterMark, err := reader.GetOffset(dl.topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return 0, err
+		}
+		offset, ok := committed[partition]
+		if !ok {
+			if offset, err = reader.GetOffset(dl.topic, partition, start); err != nil {
+				return 0, err
+			}
+			if offset < 0 {
+				// No message was sent after the start time.
+				offset = highWaterMark
+			}
+		}
+		if lags[partition] = highWaterMark - offset; lags[partition] < 0 {
+			lags[partition] = 0
+		}
+	}
+
+	var total int64
+	dl.lagLock.Lock()
+	defer dl.lagLock.Unlock()
+	if dl.nextOffsets == nil {
+		// The subscription was stopped meanwhile, its lag was cleared.
+		return 0, nil
+	}
+	for partition, lag := range lags {
+		consumerLag.WithLabelValues(dl.channelRef.Namespace, dl.channelRef.Name, dl.sub.Name, strconv.Itoa(int(partition))).Set(float64(lag))
+		dl.lagPartitions[partition] = true
+		total += lag
+	}
+	return total, nil
+}
+
+// clearLag stops tracking the lag of the subscription, and removes it from the metrics.
+func (dl *delivery) clearLag() {
+	dl.lagLock.Lock()
+	defer dl.lagLock.Unlock()
+	for partition := range dl.lagPartitions {
+		consumerLag.DeleteLabelValues(dl.channelRef.Namespace, dl.channelRef.Name, dl.sub.Name, strconv.Itoa(int(partition)))
+	}
+	dl.nextOffsets = nil
+	dl.lagPartitions = nil
+}
+
+// lagLoop measures the lag of the subscriptions every lagCheckInterval, until stopCh is closed.
+func (d *KafkaDispatcher) lagLoop(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(lagCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.checkLag()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// checkLag measures the lag of the subscriptions, and updates their Lagging condition.
+func (d *KafkaDispatcher) checkLag() {
+	d.updateLock.Lock()
+	var deliveries []*delivery
+	for _, deliveryMap := range d.deliveries {
+		for _, dl := range deliveryMap {
+			deliveries = append(deliveries, dl)
+		}
+	}
+	d.updateLock.Unlock()
+
+	for _, dl := range deliveries {
+		lag, err := dl.measureLag(&clientOffsets{Client: d.kafkaClient, group: dl.sub.group()})
+		if err != nil {
+			dl.logger.Warn("Unable to measure the subscription's lag", zap.Error(err))
+			continue
+		}
+		if d.lagThreshold <= 0 {
+			continue
+		}
+		if err := d.updateLagging(dl.sub, lag); err != nil {
+			dl.logger.Warn("Unable to update the subscription's Lagging condition", zap.Int64("lag", lag), zap.Error(err))
+		}
+	}
+}
+
+// updateLagging sets the Lagging condition of sub's Subscription from its lag, unless it is
+// already set accordingly.
+func (d *KafkaDispatcher) updateLagging(sub subscription, lag int64) error {
+	ctx := context.TODO()
+	s := &eventingv1alpha1.Subscription{}
+	if err := d.kubeClient.Get(ctx, client.ObjectKey{Namespace: sub.Namespace, Name: sub.Name}, s); err != nil {
+		return err
+	}
+	lagging := lag > d.lagThreshold
+	cond := s.Status.GetCondition(eventingv1alpha1.SubscriptionConditionLagging)
+	if (lagging && cond.IsTrue()) || (!lagging && cond.IsFalse()) {
+		return nil
+	}
+	if lagging {
+		s.Status.MarkLagging(lagAboveThreshold, "%d messages are not processed yet, more than the threshold of %d", lag, d.lagThreshold)
+	} else {
+		s.Status.MarkNotLagging()
+	}
+	return d.kubeClient.Status().Update(ctx, s)
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
+)
+
+// fakeLagReader is a lagReader of a topic whose partitions hold the messages from oldest to
+// newest, the high water mark.
+type fakeLagReader struct {
+	oldest    map[int32]int64
+	newest    map[int32]int64
+	committed map[int32]int64
+}
+
+func (r *fakeLagReader) Partitions(topic string) ([]int32, error) {
+	var partitions []int32
+	for partition := range r.newest {
+		partitions = append(partitions, partition)
+	}
+	return partitions, nil
+}
+
+func (r *fakeLagReader) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	if time == sarama.OffsetOldest {
+		return r.oldest[partition], nil
+	}
+	return r.newest[partition], nil
+}
+
+func (r *fakeLagReader) Committed(topic string, partition int32) (int64, string, error) {
+	if offset, ok := r.committed[partition]; ok {
+		return offset, "", nil
+	}
+	return -1, "", nil
+}
+
+func lagValue(t *testing.T, dl *delivery, partition int32) float64 {
+	t.Helper()
+	m := &dto.Metric{}
+	gauge := consumerLag.WithLabelValues(dl.channelRef.Namespace, dl.channelRef.Name, dl.sub.Name, strconv.Itoa(int(partition)))
+	if err := gauge.Write(m); err != nil {
+		t.Fatalf("Unable to read the lag: %v", err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestDelivery_MeasureLag(t *testing.T) {
+	d := &KafkaDispatcher{logger: zap.NewNop()}
+	channelRef := provisioners.ChannelReference{Namespace: "test-ns", Name: "lag-channel"}
+	reader := &fakeLagReader{
+		oldest:    map[int32]int64{0: 0, 1: 0, 2: 0, 3: 3},
+		newest:    map[int32]int64{0: 10, 1: 10, 2: 10, 3: 10},
+		committed: map[int32]int64{0: 2, 1: 8, 2: 5},
+	}
+	testCases := map[string]struct {
+		start        string
+		wantLag      int64
+		wantLastPart float64
+	}{
+		"latest":   {wantLag: 13, wantLastPart: 0},
+		"earliest": {start: "Earliest", wantLag: 20, wantLastPart: 7},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			dl := newDelivery(d, channelRef, subscription{Namespace: "test-ns", Name: "lag-sub-" + n, Start: tc.start})
+
+			// The message in flight in partition 0 is further than the committed offset.
+			dl.track(&sarama.ConsumerMessage{Topic: dl.topic, Partition: 0, Offset: 4}, 4)
+			// Messages of the retry topic are not tracked.
+			dl.track(&sarama.ConsumerMessage{Topic: "retry", Partition: 1, Offset: 0}, 1)
+
+			lag, err := dl.measureLag(reader)
+			if err != nil {
+				t.Fatalf("Unexpected error measuring the lag: %v", err)
+			}
+			if lag != tc.wantLag {
+				t.Errorf("Unexpected lag. Expected %d. Actual %d", tc.wantLag, lag)
+			}
+			for partition, want := range map[int32]float64{0: 6, 1: 2, 2: 5, 3: tc.wantLastPart} {
+				if v := lagValue(t, dl, partition); v != want {
+					t.Errorf("Unexpected lag of partition %d. Expected %v. Actual %v", partition, want, v)
+				}
+			}
+
+			dl.stop()
+			dl.track(&sarama.ConsumerMessage{Topic: dl.topic, Partition: 0, Offset: 5}, 5)
+			if lag, err := dl.measureLag(reader); err != nil || lag != 0 {
+				t.Errorf("Unexpected lag of a stopped subscription. Expected 0. Actual %d, %v", lag, err)
+			}
+		})
+	}
+}
+
+func TestDispatcher_UpdateLagging(t *testing.T) {
+	eventingv1alpha1.AddToScheme(scheme.Scheme)
+	sub := &eventingv1alpha1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-ns", Name: "lag-sub"},
+	}
+	d := &KafkaDispatcher{
+		kubeClient:   fake.NewFakeClient(sub),
+		lagThreshold: 10,
+		logger:       zap.NewNop(),
+	}
+
+	testCases := []struct {
+		name string
+		lag  int64
+		want func(*eventingv1alpha1.SubscriptionStatus) bool
+	}{{
+		name: "below threshold",
+		lag:  10,
+		want: func(s *eventingv1alpha1.SubscriptionStatus) bool {
+			return s.GetCondition(eventingv1alpha1.SubscriptionConditionLagging).IsFalse()
+		},
+	}, {
+		name: "above threshold",
+		lag:  11,
+		want: func(s *eventingv1alpha1.SubscriptionStatus) bool {
+			cond := s.GetCondition(eventingv1alpha1.SubscriptionConditionLagging)
+			return cond.IsTrue() && cond.Reason == lagAboveThreshold
+		},
+	}, {
+		name: "caught up",
+		lag:  0,
+		want: func(s *eventingv1alpha1.SubscriptionStatus) bool {
+			return s.GetCondition(eventingv1alpha1.SubscriptionConditionLagging).IsFalse()
+		},
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := d.updateLagging(subscription{Namespace: "test-ns", Name: "lag-sub"}, tc.lag); err != nil {
+				t.Fatalf("Unexpected error updating the Lagging condition: %v", err)
+			}
+			got := &eventingv1alpha1.Subscription{}
+			if err := d.kubeClient.Get(context.TODO(), client.ObjectKey{Namespace: "test-ns", Name: "lag-sub"}, got); err != nil {
+				t.Fatalf("Unable to get the Subscription: %v", err)
+			}
+			if !tc.want(&got.Status) {
+				t.Errorf("Unexpected Lagging condition %v", got.Status.GetCondition(eventingv1alpha1.SubscriptionConditionLagging))
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"sort"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// NewMembersWatcher creates a manager.Runnable watching the Endpoints namespace/name of the Service
+// in front of the dispatcher replicas. membersUpdated is called with the names of the ready Pods
+// behind the Service whenever they change.
+func NewMembersWatcher(logger *zap.Logger, kc kubernetes.Interface, namespace, name string, membersUpdated func([]string)) manager.Runnable {
+	factory := informers.NewSharedInformerFactoryWithOptions(kc, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	update := func(obj interface{}) {
+		if ep, ok := obj.(*corev1.Endpoints); ok {
+			membersUpdated(readyPods(ep))
+		}
+	}
+	factory.Core().V1().Endpoints().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, obj interface{}) { update(obj) },
+		DeleteFunc: func(interface{}) { membersUpdated(nil) },
+	})
+	return &membersWatcher{
+		factory: factory,
+		logger:  logger.With(zap.String("endpoints", namespace+"/"+name)),
+	}
+}
+
+type membersWatcher struct {
+	factory informers.SharedInformerFactory
+	logger  *zap.Logger
+}
+
+// Start implements manager.Runnable.
+func (w *membersWatcher) Start(stopCh <-chan struct{}) error {
+	w.logger.Info("Watching the dispatcher replicas")
+	w.factory.Start(stopCh)
+	<-stopCh
+	return nil
+}
+
+// readyPods returns the sorted names of the Pods behind the ready addresses of ep.
+func readyPods(ep *corev1.Endpoints) []string {
+	var pods []string
+	for _, subset := range ep.Subsets {
+		for _, addr := range subset.Addresses {
+			if addr.TargetRef != nil && addr.TargetRef.Kind == "Pod" {
+				pods = append(pods, addr.TargetRef.Name)
+			}
+		}
+	}
+	sort.Strings(pods)
+	return pods
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestReadyPods(t *testing.T) {
+	pod := func(name string) corev1.EndpointAddress {
+		return corev1.EndpointAddress{
+			IP:        "10.0.0.1",
+			TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: name},
+		}
+	}
+	ep := &corev1.Endpoints{
+		Subsets: []corev1.EndpointSubset{{
+			Addresses:         []corev1.EndpointAddress{pod("dispatcher-2"), pod("dispatcher-0"), {IP: "10.0.0.2"}},
+			NotReadyAddresses: []corev1.EndpointAddress{pod("dispatcher-1")},
+		}, {
+			Addresses: []corev1.EndpointAddress{pod("dispatcher-3")},
+		}},
+	}
+	want := []string{"dispatcher-0", "dispatcher-2", "dispatcher-3"}
+	if diff := cmp.Diff(want, readyPods(ep)); diff != "" {
+		t.Errorf("unexpected ready pods (-want, +got) = %v", diff)
+	}
+	if got := readyPods(&corev1.Endpoints{}); len(got) != 0 {
+		t.Errorf("unexpected ready pods without subsets: %v", got)
+	}
+}
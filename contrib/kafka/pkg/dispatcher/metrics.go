@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/knative/eventing/pkg/provisioners"
+)
+
+const (
+	resultSucceeded = "success"
+	resultFailed    = "error"
+)
+
+var (
+	// publishLatency is the time taken by Kafka to acknowledge, or to fail, the messages published
+	// to the Channels' topics.
+	publishLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "knative_eventing",
+			Subsystem: "kafka_dispatcher",
+			Name:      "publish_latency_seconds",
+			Help:      "Time taken by Kafka to acknowledge the messages published to the channel's topic.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"namespace", "channel", "result"},
+	)
+
+	// dispatchLatency is the time taken by the subscribers to handle the messages consumed from
+	// the Channels' topics, including the delivery of their replies. Each attempt is observed.
+	dispatchLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "knative_eventing",
+			Subsystem: "kafka_dispatcher",
+			Name:      "dispatch_latency_seconds",
+			Help:      "Time taken to dispatch a message of the channel's topic to the subscriber.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"namespace", "channel", "subscription", "result"},
+	)
+
+	// dispatchedMessages counts the attempts to dispatch the messages consumed from the Channels'
+	// topics, by result.
+	dispatchedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "knative_eventing",
+			Subsystem: "kafka_dispatcher",
+			Name:      "dispatched_messages_total",
+			Help:      "Number of attempts to dispatch a message of the channel's topic to the subscriber.",
+		},
+		[]string{"namespace", "channel", "subscription", "result"},
+	)
+
+	// consumerLag is the number of messages of a partition of a Channel's topic that a
+	// subscription has not processed yet.
+	consumerLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "knative_eventing",
+			Subsystem: "kafka_dispatcher",
+			Name:      "consumer_lag_messages",
+			Help:      "Number of messages of the channel's topic partition not processed by the subscriber yet.",
+		},
+		[]string{"namespace", "channel", "subscription", "partition"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(publishLatency, dispatchLatency, dispatchedMessages, consumerLag)
+}
+
+// publishMetadata is attached to the messages published asynchronously, so that their latency can
+// be observed once Kafka acknowledged them.
+type publishMetadata struct {
+	channel provisioners.ChannelReference
+	start   time.Time
+}
+
+// observePublish records the latency of a message published to channel's topic at start, that
+// failed if err is not nil.
+func observePublish(channel provisioners.ChannelReference, start time.Time, err error) {
+	result := resultSucceeded
+	if err != nil {
+		result = resultFailed
+	}
+	publishLatency.WithLabelValues(channel.Namespace, channel.Name, result).Observe(time.Since(start).Seconds())
+}
+
+// observeDispatch records an attempt, started at start, to dispatch a message of channel's topic to
+// the subscription named sub. It failed if err is not nil.
+func observeDispatch(channel provisioners.ChannelReference, sub string, start time.Time, err error) {
+	result := resultSucceeded
+	if err != nil {
+		result = resultFailed
+	}
+	dispatchLatency.WithLabelValues(channel.Namespace, channel.Name, sub, result).Observe(time.Since(start).Seconds())
+	dispatchedMessages.WithLabelValues(channel.Namespace, channel.Name, sub, result).Inc()
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"hash/fnv"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// shard decides which subscriptions this replica consumes when several dispatcher replicas share
+// them. Each subscription is assigned to one of the ready replicas by rendezvous hashing, so that
+// only the subscriptions of the replicas that come or go move when the replicas change.
+type shard struct {
+	// self is the name of this replica's Pod.
+	self string
+	// members are the sorted names of the Pods of the ready replicas.
+	members []string
+}
+
+// owns returns true if sub is consumed by this replica. A nil shard owns every subscription, and a
+// replica that is not one of the members owns none.
+func (s *shard) owns(sub subscription) bool {
+	if s == nil {
+		return true
+	}
+	return s.owner(sub) == s.self
+}
+
+// owner returns the member consuming sub, or the empty string if there are no members.
+func (s *shard) owner(sub subscription) string {
+	key := sub.Namespace + "/" + sub.Name
+	var owner string
+	var max uint64
+	for _, m := range s.members {
+		h := fnv.New64a()
+		h.Write([]byte(m))
+		h.Write([]byte{'/'})
+		h.Write([]byte(key))
+		if w := mix(h.Sum64()); owner == "" || w > max {
+			owner, max = m, w
+		}
+	}
+	return owner
+}
+
+// EnableSharding makes the dispatcher only consume its share of the subscriptions, self being the
+// name of its Pod. It owns no subscriptions until SetMembers is called, and must be called before
+// the dispatcher is configured.
+func (d *KafkaDispatcher) EnableSharding(self string) {
+	d.updateLock.Lock()
+	defer d.updateLock.Unlock()
+	d.shard = &shard{self: self}
+}
+
+// SetMembers sets the names of the Pods of the ready dispatcher replicas, then starts consuming the
+// subscriptions now assigned to this replica and stops consuming the ones moved to other replicas.
+// It does nothing unless sharding is enabled.
+func (d *KafkaDispatcher) SetMembers(members []string) {
+	members = append([]string(nil), members...)
+	sort.Strings(members)
+
+	d.updateLock.Lock()
+	defer d.updateLock.Unlock()
+
+	if d.shard == nil || equalStrings(d.shard.members, members) {
+		return
+	}
+	d.logger.Info("Updating dispatcher replicas", zap.Strings("members", members))
+	d.shard.members = members
+	d.updateConsumers(d.getConfig())
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mix is the finalizer of MurmurHash3, spreading the FNV hashes of similar names, such as the
+// names of the Pods of a StatefulSet, across the whole range.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"k8s.io/api/core/v1"
+
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
+	"github.com/knative/eventing/pkg/sidecar/fanout"
+	"github.com/knative/eventing/pkg/sidecar/multichannelfanout"
+)
+
+func testSubscriptions(n int) []subscription {
+	subs := make([]subscription, n)
+	for i := range subs {
+		subs[i] = subscription{Namespace: "test-ns", Name: fmt.Sprintf("sub-%d", i)}
+	}
+	return subs
+}
+
+func TestShard_Owner(t *testing.T) {
+	subs := testSubscriptions(300)
+	three := &shard{members: []string{"dispatcher-0", "dispatcher-1", "dispatcher-2"}}
+	four := &shard{members: []string{"dispatcher-0", "dispatcher-1", "dispatcher-2", "dispatcher-3"}}
+
+	counts := make(map[string]int)
+	for _, sub := range subs {
+		owner := three.owner(sub)
+		if again := (&shard{members: three.members}).owner(sub); again != owner {
+			t.Fatalf("owner of %v is not deterministic: %q and %q", sub, owner, again)
+		}
+		counts[owner]++
+
+		// Adding a member only moves subscriptions to it.
+		if moved := four.owner(sub); moved != owner && moved != "dispatcher-3" {
+			t.Errorf("%v moved from %q to %q", sub, owner, moved)
+		}
+	}
+	for _, m := range three.members {
+		if counts[m] < 50 {
+			t.Errorf("%q only owns %d subscriptions out of %d", m, counts[m], len(subs))
+		}
+	}
+
+	if got := (&shard{}).owner(subs[0]); got != "" {
+		t.Errorf("unexpected owner without members: %q", got)
+	}
+}
+
+func TestShard_Owns(t *testing.T) {
+	sub := testSubscriptions(1)[0]
+	var disabled *shard
+	if !disabled.owns(sub) {
+		t.Errorf("a nil shard should own every subscription")
+	}
+	if (&shard{self: "dispatcher-0"}).owns(sub) {
+		t.Errorf("a replica should not own subscriptions without members")
+	}
+	if (&shard{self: "dispatcher-1", members: []string{"dispatcher-0"}}).owns(sub) {
+		t.Errorf("a replica that is not a member should not own subscriptions")
+	}
+	if !(&shard{self: "dispatcher-0", members: []string{"dispatcher-0"}}).owns(sub) {
+		t.Errorf("a single member should own every subscription")
+	}
+}
+
+func TestDispatcher_SetMembers(t *testing.T) {
+	subs := testSubscriptions(20)
+	var specs []eventingduck.ChannelSubscriberSpec
+	for _, sub := range subs {
+		specs = append(specs, eventingduck.ChannelSubscriberSpec{
+			Ref: &v1.ObjectReference{Name: sub.Name, Namespace: sub.Namespace},
+		})
+	}
+	config := &multichannelfanout.Config{
+		ChannelConfigs: []multichannelfanout.ChannelConfig{{
+			Namespace:    "test-ns",
+			Name:         "test-channel",
+			FanoutConfig: fanout.Config{Subscriptions: specs},
+		}},
+	}
+	channelRef := provisioners.ChannelReference{Name: "test-channel", Namespace: "test-ns"}
+
+	d := &KafkaDispatcher{
+		kafkaCluster:   &mockSaramaCluster{closed: true},
+		kafkaConsumers: make(map[provisioners.ChannelReference]map[subscription]KafkaConsumer),
+		dispatcher:     provisioners.NewMessageDispatcher(zap.NewNop().Sugar()),
+		logger:         zap.NewNop(),
+	}
+	d.setConfig(&multichannelfanout.Config{})
+	d.EnableSharding("dispatcher-0")
+
+	consumed := func() []string {
+		var names []string
+		for _, sub := range subs {
+			if _, ok := d.kafkaConsumers[channelRef][sub]; ok {
+				names = append(names, sub.Name)
+			}
+		}
+		return names
+	}
+	owned := func(members ...string) []string {
+		s := &shard{self: "dispatcher-0", members: members}
+		var names []string
+		for _, sub := range subs {
+			if s.owns(sub) {
+				names = append(names, sub.Name)
+			}
+		}
+		return names
+	}
+
+	if err := d.UpdateConfig(config); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got := consumed(); len(got) != 0 {
+		t.Errorf("subscriptions consumed before the members are known: %v", got)
+	}
+
+	for _, members := range [][]string{
+		{"dispatcher-0"},
+		{"dispatcher-1", "dispatcher-0"},
+		{"dispatcher-0", "dispatcher-1", "dispatcher-2"},
+		{"dispatcher-2", "dispatcher-0"},
+		{"dispatcher-1"},
+		{"dispatcher-0"},
+	} {
+		d.SetMembers(members)
+		want := owned(d.shard.members...)
+		if diff := cmp.Diff(want, consumed()); diff != "" {
+			t.Errorf("unexpected subscriptions consumed with members %v (-want, +got) = %v", members, diff)
+		}
+	}
+	if got := len(consumed()); got != len(subs) {
+		t.Errorf("a single member should consume every subscription, got %d out of %d", got, len(subs))
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/knative/eventing/contrib/kafka/pkg/controller"
+	"github.com/knative/eventing/pkg/provisioners"
+)
+
+const (
+	// structuredContentType is the media type of a CloudEvent in structured mode.
+	structuredContentType = "application/cloudevents+json"
+	// wrappedSpecVersion is the CloudEvents version of the events wrapping records.
+	wrappedSpecVersion = "0.2"
+)
+
+// specVersionHeaders are the headers carrying the version of binary CloudEvents, v0.2 and v0.1.
+var specVersionHeaders = []string{"ce-specversion", "ce-cloudeventsversion"}
+
+// isCloudEvent returns true if m carries a CloudEvent, in binary or structured mode. The records
+// written by other systems to external topics usually do not.
+func isCloudEvent(m *provisioners.Message) bool {
+	for name, value := range m.Headers {
+		name = strings.ToLower(name)
+		for _, header := range specVersionHeaders {
+			if name == header && value != "" {
+				return true
+			}
+		}
+		if name == "content-type" {
+			if mediaType, _, err := mime.ParseMediaType(value); err == nil && mediaType == structuredContentType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wrapRecord turns m, read from msg, into a binary CloudEvent of type eventType from eventSource.
+// Its ID identifies the record, so that the redeliveries of the record carry the same event. The
+// content type of the record, if any, is kept and its other headers are dropped.
+func wrapRecord(m *provisioners.Message, msg *sarama.ConsumerMessage, eventType, eventSource string) {
+	headers := map[string]string{
+		"ce-specversion": wrappedSpecVersion,
+		"ce-id":          fmt.Sprintf("%s-%d-%d", msg.Topic, msg.Partition, msg.Offset),
+		"ce-type":        eventType,
+		"ce-source":      eventSource,
+	}
+	if !msg.Timestamp.IsZero() {
+		headers["ce-time"] = msg.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	for name, value := range m.Headers {
+		if strings.ToLower(name) == "content-type" {
+			headers["content-type"] = value
+		}
+	}
+	m.Headers = headers
+}
+
+// wrappedEvent returns the type and source of the CloudEvents wrapping the records of the
+// delivery's topic that are not CloudEvents. The configs written by controllers that do not set
+// them get the defaults.
+func (dl *delivery) wrappedEvent() (string, string) {
+	eventType, eventSource := dl.sub.EventType, dl.sub.EventSource
+	if eventType == "" {
+		eventType = controller.DefaultEventType
+	}
+	if eventSource == "" {
+		eventSource = dl.topic
+	}
+	return eventType, eventSource
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/knative/eventing/contrib/kafka/pkg/controller"
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
+)
+
+func TestIsCloudEvent(t *testing.T) {
+	testCases := map[string]struct {
+		headers map[string]string
+		want    bool
+	}{
+		"binary v0.2": {
+			headers: map[string]string{"ce-specversion": "0.2"},
+			want:    true,
+		},
+		"binary v0.1": {
+			headers: map[string]string{"CE-CloudEventsVersion": "0.1"},
+			want:    true,
+		},
+		"structured": {
+			headers: map[string]string{"Content-Type": "application/cloudevents+json; charset=utf-8"},
+			want:    true,
+		},
+		"json record": {
+			headers: map[string]string{"content-type": "application/json"},
+		},
+		"no headers": {},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			if got := isCloudEvent(&provisioners.Message{Headers: tc.headers}); got != tc.want {
+				t.Errorf("Unexpected isCloudEvent. Expected %v. Actual %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWrapRecord(t *testing.T) {
+	msg := &sarama.ConsumerMessage{
+		Topic:     "orders",
+		Partition: 2,
+		Offset:    42,
+		Timestamp: time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC),
+		Headers: []*sarama.RecordHeader{{
+			Key:   []byte("Content-Type"),
+			Value: []byte("application/json"),
+		}, {
+			Key:   []byte("producer"),
+			Value: []byte("legacy"),
+		}},
+		Value: []byte(`{"order":1}`),
+	}
+	m := fromKafkaMessage(msg)
+	wrapRecord(m, msg, "com.example.order", "/orders")
+	want := map[string]string{
+		"ce-specversion": "0.2",
+		"ce-id":          "orders-2-42",
+		"ce-type":        "com.example.order",
+		"ce-source":      "/orders",
+		"ce-time":        "2019-03-01T12:00:00Z",
+		"content-type":   "application/json",
+	}
+	if diff := cmp.Diff(want, m.Headers); diff != "" {
+		t.Errorf("Unexpected headers (-want, +got) = %v", diff)
+	}
+	if string(m.Payload) != `{"order":1}` {
+		t.Errorf("Unexpected payload %q", m.Payload)
+	}
+}
+
+// headerRecorder records the CloudEvents headers of the requests it receives.
+type headerRecorder struct {
+	lock    sync.Mutex
+	headers []http.Header
+}
+
+func (h *headerRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.headers = append(h.headers, r.Header)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func TestDelivery_WrapsRecords(t *testing.T) {
+	testCases := map[string]struct {
+		sub        subscription
+		headers    []*sarama.RecordHeader
+		wantType   string
+		wantSource string
+		wantID     string
+	}{
+		"record with defaults": {
+			sub:        subscription{ExternalTopic: true},
+			wantType:   controller.DefaultEventType,
+			wantSource: "knative-eventing-channel.test-ns.test-channel",
+			wantID:     "orders-0-42",
+		},
+		"record with configured type and source": {
+			sub:        subscription{ExternalTopic: true, EventType: "com.example.order", EventSource: "/orders"},
+			wantType:   "com.example.order",
+			wantSource: "/orders",
+			wantID:     "orders-0-42",
+		},
+		"record of a topic created by Knative": {},
+		"cloud event": {
+			sub: subscription{ExternalTopic: true, EventType: "com.example.order", EventSource: "/orders"},
+			headers: []*sarama.RecordHeader{
+				{Key: []byte("ce-specversion"), Value: []byte("0.2")},
+				{Key: []byte("ce-id"), Value: []byte("1")},
+				{Key: []byte("ce-type"), Value: []byte("dev.knative.test")},
+				{Key: []byte("ce-source"), Value: []byte("/test")},
+			},
+			wantType:   "dev.knative.test",
+			wantSource: "/test",
+			wantID:     "1",
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			handler := &headerRecorder{}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+			dl := newTestDelivery(server, &fakeSyncProducer{}, eventingduck.DeliverySpec{})
+			dl.sub.ExternalTopic = tc.sub.ExternalTopic
+			dl.sub.EventType, dl.sub.EventSource = tc.sub.EventType, tc.sub.EventSource
+
+			if err := dl.handle(&sarama.ConsumerMessage{Topic: "orders", Offset: 42, Headers: tc.headers, Value: []byte("data")}, false); err != nil {
+				t.Fatalf("Unexpected error handling the message: %v", err)
+			}
+			if len(handler.headers) != 1 {
+				t.Fatalf("Expected a single request. Actual %d", len(handler.headers))
+			}
+			got := handler.headers[0]
+			if got.Get("ce-type") != tc.wantType || got.Get("ce-source") != tc.wantSource || got.Get("ce-id") != tc.wantID {
+				t.Errorf("Unexpected event. Expected type %q, source %q and id %q. Actual %v", tc.wantType, tc.wantSource, tc.wantID, got)
+			}
+		})
+	}
+}
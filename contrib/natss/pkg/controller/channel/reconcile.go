@@ -21,7 +21,6 @@ import (
 	"fmt"
 
 	"github.com/knative/eventing/pkg/provisioners"
-	"github.com/knative/eventing/pkg/reconciler/names"
 	"go.uber.org/zap"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/tools/record"
@@ -29,6 +28,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	ccpcontroller "github.com/knative/eventing/contrib/natss/pkg/controller/clusterchannelprovisioner"
+	"github.com/knative/eventing/contrib/natss/pkg/stanutil"
 	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
 )
 
@@ -122,12 +122,18 @@ func (r *reconciler) reconcile(ctx context.Context, c *eventingv1alpha1.Channel)
 		return nil
 	}
 
+	// The arguments are applied by the dispatcher when subscribing.
+	if _, err := stanutil.ChannelOptions(c.Spec.Arguments); err != nil {
+		c.Status.MarkNotProvisioned("InvalidArguments", "invalid arguments: %s", err)
+		return err
+	}
+
 	svc, err := provisioners.CreateK8sService(ctx, r.client, c)
 	if err != nil {
 		r.logger.Info("Error creating the Channel's K8s Service", zap.Error(err))
 		return err
 	}
-	c.Status.SetAddress(names.ServiceHostName(svc.Name, svc.Namespace))
+	c.Status.SetAddress(provisioners.ChannelAddressHostName(c, svc))
 
 	_, err = provisioners.CreateVirtualService(ctx, r.client, c, svc)
 	if err != nil {
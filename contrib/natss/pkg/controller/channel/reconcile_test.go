@@ -113,6 +113,25 @@ var testCases = []controllertesting.TestCase{
 		},
 		IgnoreTimes: true,
 	},
+	{
+		Name: "new channel with invalid arguments: error",
+		InitialState: []runtime.Object{
+			makeNewClusterChannelProvisioner(clusterChannelProvisionerName, true),
+			makeNewChannelWithArguments(channelName, clusterChannelProvisionerName, `{"AckWait":"soon"}`),
+		},
+		ReconcileKey: fmt.Sprintf("%s/%s", testNS, channelName),
+		WantResult:   reconcile.Result{},
+		WantErrMsg:   `invalid AckWait "soon": it must be a duration of at least 1s, such as 30s`,
+		WantPresent: []runtime.Object{
+			func() *eventingv1alpha1.Channel {
+				c := makeNewChannelWithArguments(channelName, clusterChannelProvisionerName, `{"AckWait":"soon"}`)
+				c.Status.InitializeConditions()
+				c.Status.MarkNotProvisioned("InvalidArguments", "invalid arguments: %s", `invalid AckWait "soon": it must be a duration of at least 1s, such as 30s`)
+				return c
+			}(),
+		},
+		IgnoreTimes: true,
+	},
 }
 
 func TestAllCases(t *testing.T) {
@@ -164,6 +183,12 @@ func makeNewChannel(name, provisioner string) *eventingv1alpha1.Channel {
 	return channel
 }
 
+func makeNewChannelWithArguments(name, provisioner, arguments string) *eventingv1alpha1.Channel {
+	c := makeNewChannel(name, provisioner)
+	c.Spec.Arguments = &runtime.RawExtension{Raw: []byte(arguments)}
+	return c
+}
+
 func makeNewChannelProvisionedStatus(name, provisioner string) *eventingv1alpha1.Channel {
 	c := makeNewChannel(name, provisioner)
 	c.Status.InitializeConditions()
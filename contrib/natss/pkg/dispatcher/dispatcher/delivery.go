@@ -0,0 +1,159 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/knative/eventing/contrib/natss/pkg/stanutil"
+	"github.com/knative/eventing/pkg/provisioners"
+	stan "github.com/nats-io/go-nats-streaming"
+	"go.uber.org/zap"
+)
+
+const (
+	// AttemptHeader is the CloudEvents extension holding the number of the delivery attempt,
+	// starting at 1, set on the messages sent to subscribers.
+	// This is an experimental header, like provisioners.MessageHistoryHeader.
+	AttemptHeader = "ce-knativeattempt"
+
+	// The headers set on the messages published to dead-letter subjects.
+	attemptsHeader = "knative-natss-attempts"
+	errorHeader    = "knative-natss-error"
+
+	deadLetterSubjectSuffix = "dlq"
+)
+
+// delivery delivers the messages of a NATSS subscription to its subscriber, and gives up on them
+// after the subscription's maximum number of attempts.
+type delivery struct {
+	s       *SubscriptionsSupervisor
+	channel provisioners.ChannelReference
+	sub     subscriptionReference
+	// attempts counts the failed attempts to deliver the messages that were not acknowledged
+	// yet, by sequence number. NATSS calls handle for one message of a subscription at a time,
+	// so it is not locked. It is lost when the subscription is recreated, the messages
+	// redelivered then count as attempted once.
+	attempts map[uint64]int32
+}
+
+func newDelivery(s *SubscriptionsSupervisor, channel provisioners.ChannelReference, sub subscriptionReference) *delivery {
+	return &delivery{
+		s:        s,
+		channel:  channel,
+		sub:      sub,
+		attempts: make(map[uint64]int32),
+	}
+}
+
+// handle delivers msg to the subscriber. It returns true if msg must be acknowledged, because it
+// was delivered or given up on, and false to have NATSS redeliver it after AckWait.
+func (dl *delivery) handle(msg *stan.Msg) bool {
+	logger := dl.s.logger
+	logger.Sugar().Infof("NATSS message received from subject: %v; sequence: %v; timestamp: %v, data: %s", msg.Subject, msg.Sequence, msg.Timestamp, string(msg.Data))
+	message := provisioners.Message{}
+	if err := json.Unmarshal(msg.Data, &message); err != nil {
+		logger.Error("Failed to unmarshal message: ", zap.Error(err))
+		// The raw data is dead-lettered as the payload, so that it can be inspected.
+		return dl.fail(msg, &provisioners.Message{Payload: msg.Data}, err, true)
+	}
+
+	if message.Headers == nil {
+		message.Headers = make(map[string]string, 1)
+	}
+	message.Headers[AttemptHeader] = strconv.Itoa(int(dl.previousAttempts(msg) + 1))
+
+	err := dl.s.dispatcher.DispatchMessage(&message, dl.sub.SubscriberURI, dl.sub.ReplyURI, dl.sub.dispatchDefaults(dl.channel))
+	if err == nil {
+		delete(dl.attempts, msg.Sequence)
+		return true
+	}
+	return dl.fail(msg, &message, err, false)
+}
+
+// previousAttempts returns the number of failed attempts to handle msg.
+func (dl *delivery) previousAttempts(msg *stan.Msg) int32 {
+	attempts := dl.attempts[msg.Sequence]
+	if attempts == 0 && msg.Redelivered {
+		attempts = 1
+	}
+	return attempts
+}
+
+// fail counts a failed attempt to handle msg, whose content is m, and gives up on it once the
+// subscription's maximum number of attempts is reached. malformed messages can never be
+// delivered, so they are given up on right away if the number of attempts is unlimited. It
+// returns whether msg must be acknowledged.
+func (dl *delivery) fail(msg *stan.Msg, m *provisioners.Message, err error, malformed bool) bool {
+	logger := dl.s.logger
+	attempts := dl.previousAttempts(msg) + 1
+	max := dl.sub.Options.MaxAttempts
+	if (max == 0 && !malformed) || (max != 0 && attempts < max) {
+		logger.Error("Failed to dispatch message: ", zap.Int32("attempts", attempts), zap.Error(err))
+		dl.attempts[msg.Sequence] = attempts
+		return false
+	}
+	if err := dl.deadLetter(m, attempts, err); err != nil {
+		logger.Error("Failed to dead-letter message: ", zap.Int32("attempts", attempts), zap.Error(err))
+		dl.attempts[msg.Sequence] = attempts
+		return false
+	}
+	delete(dl.attempts, msg.Sequence)
+	return true
+}
+
+// deadLetter publishes m to the subscription's dead-letter subject, or drops it if the
+// subscription does not have one.
+func (dl *delivery) deadLetter(m *provisioners.Message, attempts int32, err error) error {
+	logger := dl.s.logger
+	if !dl.sub.Options.DeadLetter {
+		logger.Error("Unable to deliver message, dropping it", zap.Int32("attempts", attempts), zap.Error(err))
+		return nil
+	}
+	logger.Warn("Unable to deliver message, dead-lettering it", zap.Int32("attempts", attempts), zap.Error(err))
+	headers := make(map[string]string, len(m.Headers)+1)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	delete(headers, AttemptHeader)
+	headers[attemptsHeader] = strconv.Itoa(int(attempts))
+	headers[errorHeader] = err.Error()
+	data, err := json.Marshal(provisioners.Message{Headers: headers, Payload: m.Payload})
+	if err != nil {
+		return err
+	}
+	return dl.s.publish(deadLetterSubject(dl.channel, dl.sub), data)
+}
+
+// deadLetterSubject returns the NATSS subject holding the messages of channel that could not be
+// delivered to sub.
+func deadLetterSubject(channel provisioners.ChannelReference, sub subscriptionReference) string {
+	return getSubject(channel) + "." + sub.Name + "." + deadLetterSubjectSuffix
+}
+
+// publish publishes data to subject on the current connection.
+func (s *SubscriptionsSupervisor) publish(subject string, data []byte) error {
+	s.natssConnMux.Lock()
+	currentNatssConn := s.natssConn
+	s.natssConnMux.Unlock()
+	if currentNatssConn == nil {
+		return errors.New("No Connection to NATSS")
+	}
+	return stanutil.Publish(currentNatssConn, subject, &data, s.logger.Sugar())
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/knative/eventing/contrib/natss/pkg/stanutil"
+	"github.com/knative/eventing/pkg/provisioners"
+	stan "github.com/nats-io/go-nats-streaming"
+	"github.com/nats-io/go-nats-streaming/pb"
+	"go.uber.org/zap"
+)
+
+// failingSubscriber answers with an error to the first failures requests, recording the attempt
+// extension of each request.
+type failingSubscriber struct {
+	mux      sync.Mutex
+	failures int
+	attempts []string
+}
+
+func (f *failingSubscriber) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.attempts = append(f.attempts, r.Header.Get(AttemptHeader))
+	if len(f.attempts) <= f.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func TestDeliveryHandle(t *testing.T) {
+	testCases := map[string]struct {
+		options     stanutil.SubscriptionOptions
+		failures    int
+		redelivered bool
+		// wantAcks is the result of handle for each delivery of the message.
+		wantAcks     []bool
+		wantAttempts []string
+		wantDead     bool
+	}{
+		"delivered": {
+			wantAcks:     []bool{true},
+			wantAttempts: []string{"1"},
+		},
+		"retried forever by default": {
+			failures:     5,
+			wantAcks:     []bool{false, false, false, false, false, true},
+			wantAttempts: []string{"1", "2", "3", "4", "5", "6"},
+		},
+		"delivered before the maximum": {
+			options:      stanutil.SubscriptionOptions{MaxAttempts: 3},
+			failures:     2,
+			wantAcks:     []bool{false, false, true},
+			wantAttempts: []string{"1", "2", "3"},
+		},
+		"dropped": {
+			options:      stanutil.SubscriptionOptions{MaxAttempts: 3},
+			failures:     3,
+			wantAcks:     []bool{false, false, true},
+			wantAttempts: []string{"1", "2", "3"},
+		},
+		"dead-lettered": {
+			options:      stanutil.SubscriptionOptions{MaxAttempts: 2, DeadLetter: true},
+			failures:     2,
+			wantAcks:     []bool{false, true},
+			wantAttempts: []string{"1", "2"},
+			wantDead:     true,
+		},
+		"redelivered after a restart": {
+			options:      stanutil.SubscriptionOptions{MaxAttempts: 2, DeadLetter: true},
+			failures:     1,
+			redelivered:  true,
+			wantAcks:     []bool{true},
+			wantAttempts: []string{"2"},
+			wantDead:     true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			subscriber := &failingSubscriber{failures: tc.failures}
+			server := httptest.NewServer(subscriber)
+			defer server.Close()
+			subscriberURL, _ := url.Parse(server.URL)
+
+			conns := &fakeServer{}
+			d, err := NewDispatcher(natssTestURL, zap.NewNop(), nil)
+			if err != nil {
+				t.Fatalf("Unable to create NATSS dispatcher: %v", err)
+			}
+			d.natssConn, _ = conns.connect(nil)
+
+			cRef := provisioners.ChannelReference{Namespace: "ns", Name: "channel"}
+			sub := subscriptionReference{Name: "sub", Namespace: "ns", SubscriberURI: subscriberURL.Host, Options: tc.options}
+			dl := newDelivery(d, cRef, sub)
+
+			data, _ := json.Marshal(provisioners.Message{
+				Headers: map[string]string{"ce-eventid": "1"},
+				Payload: []byte(`{"hello":"world"}`),
+			})
+			msg := &stan.Msg{MsgProto: pb.MsgProto{Sequence: 7, Data: data, Redelivered: tc.redelivered}}
+			var acks []bool
+			for i := 0; i < len(tc.wantAcks); i++ {
+				acks = append(acks, dl.handle(msg))
+				msg.Redelivered = true
+			}
+			if !reflect.DeepEqual(acks, tc.wantAcks) {
+				t.Errorf("Unexpected acknowledgements. Expected %v. Actual %v", tc.wantAcks, acks)
+			}
+			if !reflect.DeepEqual(subscriber.attempts, tc.wantAttempts) {
+				t.Errorf("Unexpected attempts. Expected %v. Actual %v", tc.wantAttempts, subscriber.attempts)
+			}
+			if len(dl.attempts) != 0 && tc.wantAcks[len(tc.wantAcks)-1] {
+				t.Errorf("Attempts of acknowledged message kept: %v", dl.attempts)
+			}
+
+			published := conns.conn(0).published
+			if !tc.wantDead {
+				if len(published) != 0 {
+					t.Errorf("Unexpected messages published: %v", published)
+				}
+				return
+			}
+			if len(published) != 1 {
+				t.Fatalf("Expected one dead-lettered message. Actual %v", published)
+			}
+			if want := "channel.ns.sub.dlq"; published[0].subject != want {
+				t.Errorf("Unexpected dead-letter subject. Expected %q. Actual %q", want, published[0].subject)
+			}
+			var dead provisioners.Message
+			if err := json.Unmarshal(published[0].data, &dead); err != nil {
+				t.Fatalf("Unable to unmarshal dead-lettered message: %v", err)
+			}
+			if got := dead.Headers[attemptsHeader]; got != "2" {
+				t.Errorf("Unexpected attempts header. Expected 2. Actual %q", got)
+			}
+			if dead.Headers[errorHeader] == "" {
+				t.Error("Missing error header")
+			}
+			if _, ok := dead.Headers[AttemptHeader]; ok {
+				t.Errorf("Unexpected attempt extension on dead-lettered message: %v", dead.Headers)
+			}
+			if dead.Headers["ce-eventid"] != "1" || string(dead.Payload) != `{"hello":"world"}` {
+				t.Errorf("Dead-lettered message differs from the original: %+v", dead)
+			}
+		})
+	}
+}
+
+func TestDeliveryHandle_Malformed(t *testing.T) {
+	testCases := map[string]struct {
+		options  stanutil.SubscriptionOptions
+		wantAcks []bool
+		wantDead bool
+	}{
+		"dropped right away by default": {
+			wantAcks: []bool{true},
+		},
+		"dropped": {
+			options:  stanutil.SubscriptionOptions{MaxAttempts: 2},
+			wantAcks: []bool{false, true},
+		},
+		"dead-lettered": {
+			options:  stanutil.SubscriptionOptions{MaxAttempts: 2, DeadLetter: true},
+			wantAcks: []bool{false, true},
+			wantDead: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			conns := &fakeServer{}
+			d, err := NewDispatcher(natssTestURL, zap.NewNop(), nil)
+			if err != nil {
+				t.Fatalf("Unable to create NATSS dispatcher: %v", err)
+			}
+			d.natssConn, _ = conns.connect(nil)
+
+			cRef := provisioners.ChannelReference{Namespace: "ns", Name: "channel"}
+			sub := subscriptionReference{Name: "sub", Namespace: "ns", SubscriberURI: "subscriber", Options: tc.options}
+			dl := newDelivery(d, cRef, sub)
+
+			msg := &stan.Msg{MsgProto: pb.MsgProto{Sequence: 7, Data: []byte("not json")}}
+			var acks []bool
+			for i := 0; i < len(tc.wantAcks); i++ {
+				acks = append(acks, dl.handle(msg))
+				msg.Redelivered = true
+			}
+			if !reflect.DeepEqual(acks, tc.wantAcks) {
+				t.Errorf("Unexpected acknowledgements. Expected %v. Actual %v", tc.wantAcks, acks)
+			}
+			if len(dl.attempts) != 0 {
+				t.Errorf("Attempts of acknowledged message kept: %v", dl.attempts)
+			}
+
+			published := conns.conn(0).published
+			if !tc.wantDead {
+				if len(published) != 0 {
+					t.Errorf("Unexpected messages published: %v", published)
+				}
+				return
+			}
+			if len(published) != 1 {
+				t.Fatalf("Expected one dead-lettered message. Actual %v", published)
+			}
+			var dead provisioners.Message
+			if err := json.Unmarshal(published[0].data, &dead); err != nil {
+				t.Fatalf("Unable to unmarshal dead-lettered message: %v", err)
+			}
+			if string(dead.Payload) != "not json" || dead.Headers[errorHeader] == "" {
+				t.Errorf("Dead-lettered message does not hold the raw data and the error: %+v", dead)
+			}
+		})
+	}
+}
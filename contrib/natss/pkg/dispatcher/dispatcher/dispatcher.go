@@ -17,7 +17,9 @@ limitations under the License.
 package dispatcher
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -27,6 +29,7 @@ import (
 	"github.com/knative/eventing/pkg/provisioners"
 	stan "github.com/nats-io/go-nats-streaming"
 	"go.uber.org/zap"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 
 	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
 )
@@ -38,6 +41,9 @@ const (
 )
 
 var (
+	// errStopped is returned when the dispatcher is stopped while connecting.
+	errStopped = errors.New("dispatcher stopped")
+
 	// retryInterval defines delay in seconds for the next attempt to reconnect to NATSS streaming server
 	retryInterval = 1 * time.Second
 )
@@ -52,25 +58,37 @@ type SubscriptionsSupervisor struct {
 	subscriptionsMux sync.Mutex
 	subscriptions    map[provisioners.ChannelReference]map[subscriptionReference]*stan.Subscription
 
-	connect  chan struct{}
-	natssURL string
+	connect chan struct{}
+	// connectFunc opens a new connection to NATSS, calling lost when the connection is later
+	// lost. It is replaced by tests.
+	connectFunc func(lost stan.ConnectionLostHandler) (*stan.Conn, error)
 	// natConnMux is used to protect natssConn and natssConnInProgress during
 	// the transition from not connected to connected states.
 	natssConnMux        sync.Mutex
 	natssConn           *stan.Conn
 	natssConnInProgress bool
+
+	// handlingMux makes registering a message being handled atomic with respect to draining,
+	// which sets draining. handling counts the messages being handled.
+	handlingMux sync.Mutex
+	draining    bool
+	handling    sync.WaitGroup
 }
 
-// NewDispatcher returns a new SubscriptionsSupervisor.
-func NewDispatcher(natssUrl string, logger *zap.Logger) (*SubscriptionsSupervisor, error) {
+// NewDispatcher returns a new SubscriptionsSupervisor. secrets is used to read the TLS and
+// credentials Secrets referenced by subscribers. receiverOpts are applied to its MessageReceiver.
+func NewDispatcher(natssUrl string, logger *zap.Logger, secrets corev1client.SecretsGetter, receiverOpts ...provisioners.ReceiverOption) (*SubscriptionsSupervisor, error) {
 	d := &SubscriptionsSupervisor{
 		logger:        logger,
-		dispatcher:    provisioners.NewMessageDispatcher(logger.Sugar()),
+		dispatcher:    provisioners.NewMessageDispatcher(logger.Sugar(), provisioners.WithSecretClient(secrets)),
 		connect:       make(chan struct{}, maxElements),
-		natssURL:      natssUrl,
 		subscriptions: make(map[provisioners.ChannelReference]map[subscriptionReference]*stan.Subscription),
 	}
-	d.receiver = provisioners.NewMessageReceiver(createReceiverFunction(d, logger.Sugar()), logger.Sugar())
+	d.connectFunc = func(lost stan.ConnectionLostHandler) (*stan.Conn, error) {
+		return stanutil.Connect(clusterchannelprovisioner.ClusterId, clientID, natssUrl, logger.Sugar(), stan.SetConnectionLostHandler(lost))
+	}
+	d.receiver = provisioners.NewMessageReceiver(createReceiverFunction(d, logger.Sugar()), logger.Sugar(),
+		append(receiverOpts, provisioners.WithReadinessCheck(d.checkConnection))...)
 
 	return d, nil
 }
@@ -120,22 +138,123 @@ func (s *SubscriptionsSupervisor) Start(stopCh <-chan struct{}) error {
 	go s.Connect(stopCh)
 	// Trigger Connect to establish connection with NATS
 	s.signalReconnect()
-	return s.receiver.Start(stopCh)
+	err := s.receiver.Start(stopCh)
+
+	// The receiver has drained, so nothing more will be published. The messages being dispatched
+	// get what is left of the shutdown timeout to be acknowledged, those that are not will be
+	// redelivered to the durable subscriptions.
+	ctx, cancel := s.receiver.DrainContext()
+	defer cancel()
+	s.drainSubscriptions(ctx)
+	s.natssConnMux.Lock()
+	currentNatssConn := s.natssConn
+	s.natssConn = nil
+	s.natssConnMux.Unlock()
+	if currentNatssConn != nil {
+		stanutil.Close(currentNatssConn, s.logger.Sugar())
+	}
+	return err
+}
+
+// drainSubscriptions closes the subscriptions, so that no more messages are handled, and waits
+// for the messages being handled until ctx is done.
+func (s *SubscriptionsSupervisor) drainSubscriptions(ctx context.Context) {
+	s.handlingMux.Lock()
+	s.draining = true
+	s.handlingMux.Unlock()
+
+	s.subscriptionsMux.Lock()
+	for cRef, chMap := range s.subscriptions {
+		for subRef, stanSub := range chMap {
+			if err := (*stanSub).Close(); err != nil {
+				s.logger.Warn("Closing NATSS Streaming subscription failed", zap.Any("channel", cRef), zap.Any("subscription", subRef), zap.Error(err))
+			}
+		}
+	}
+	s.subscriptionsMux.Unlock()
+
+	handled := make(chan struct{})
+	go func() {
+		s.handling.Wait()
+		close(handled)
+	}()
+	select {
+	case <-handled:
+	case <-ctx.Done():
+		s.logger.Warn("Messages were still being handled at the end of the shutdown timeout")
+	}
+}
+
+// beginHandling registers a message being handled. It returns false once draining, in which case
+// the message must be left unacknowledged.
+func (s *SubscriptionsSupervisor) beginHandling() bool {
+	s.handlingMux.Lock()
+	defer s.handlingMux.Unlock()
+	if s.draining {
+		return false
+	}
+	s.handling.Add(1)
+	return true
+}
+
+// checkConnection reports an error if there is no established connection to NATSS, or if the
+// subscriptions are not restored yet after reconnecting.
+func (s *SubscriptionsSupervisor) checkConnection() error {
+	s.natssConnMux.Lock()
+	currentNatssConn := s.natssConn
+	inProgress := s.natssConnInProgress
+	s.natssConnMux.Unlock()
+	if currentNatssConn == nil {
+		return errors.New("no connection to NATSS")
+	}
+	if inProgress {
+		return errors.New("subscriptions to NATSS are being restored")
+	}
+	if nc := (*currentNatssConn).NatsConn(); nc != nil && !nc.IsConnected() {
+		return errors.New("connection to NATSS is not established")
+	}
+	return nil
+}
+
+// connectionLost is called by the NATSS client when it gives up on a connection, for instance
+// because the server stopped answering its pings.
+func (s *SubscriptionsSupervisor) connectionLost(conn stan.Conn, reason error) {
+	s.natssConnMux.Lock()
+	if s.natssConn != nil && *s.natssConn == conn {
+		s.natssConn = nil
+	}
+	s.natssConnMux.Unlock()
+	s.logger.Error("Connection to NATSS has been lost, attempting to reconnect.", zap.Error(reason))
+	s.signalReconnect()
 }
 
 func (s *SubscriptionsSupervisor) connectWithRetry(stopCh <-chan struct{}) {
+	// Closing the previous connection, if it is not closed already, so that the server does not
+	// reject the new one for reusing its client ID.
+	s.natssConnMux.Lock()
+	previousNatssConn := s.natssConn
+	s.natssConn = nil
+	s.natssConnMux.Unlock()
+	if previousNatssConn != nil {
+		stanutil.Close(previousNatssConn, s.logger.Sugar())
+	}
+
 	// re-attempting evey 1 second until the connection is established.
 	ticker := time.NewTicker(retryInterval)
 	defer ticker.Stop()
 	for {
-		nConn, err := stanutil.Connect(clusterchannelprovisioner.ClusterId, clientID, s.natssURL, s.logger.Sugar())
+		nConn, err := s.connectFunc(s.connectionLost)
 		if err == nil {
-			// Locking here in order to reduce time in locked state.
-			s.natssConnMux.Lock()
-			s.natssConn = nConn
-			s.natssConnInProgress = false
-			s.natssConnMux.Unlock()
-			return
+			if err = s.useConnection(nConn, stopCh); err == nil {
+				s.natssConnMux.Lock()
+				s.natssConnInProgress = false
+				s.natssConnMux.Unlock()
+				return
+			}
+			stanutil.Close(nConn, s.logger.Sugar())
+			if err == errStopped {
+				return
+			}
 		}
 		s.logger.Sugar().Errorf("Connect() failed with error: %+v, retrying in %s", err, retryInterval.String())
 		select {
@@ -147,6 +266,33 @@ func (s *SubscriptionsSupervisor) connectWithRetry(stopCh <-chan struct{}) {
 	}
 }
 
+// useConnection makes nConn the current connection and restores the subscriptions on it. It
+// returns errStopped, without using nConn, if stopCh is closed, since Start closes the current
+// connection once stopped.
+func (s *SubscriptionsSupervisor) useConnection(nConn *stan.Conn, stopCh <-chan struct{}) error {
+	// Holding subscriptionsMux until the subscriptions are restored, so that UpdateSubscriptions
+	// does not create them on the new connection in the meantime.
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+
+	s.natssConnMux.Lock()
+	select {
+	case <-stopCh:
+		s.natssConnMux.Unlock()
+		return errStopped
+	default:
+	}
+	s.natssConn = nConn
+	s.natssConnMux.Unlock()
+	if err := s.resubscribe(); err != nil {
+		s.natssConnMux.Lock()
+		s.natssConn = nil
+		s.natssConnMux.Unlock()
+		return err
+	}
+	return nil
+}
+
 // Connect is called for initial connection as well as after every disconnect
 func (s *SubscriptionsSupervisor) Connect(stopCh <-chan struct{}) {
 	for {
@@ -189,19 +335,42 @@ func (s *SubscriptionsSupervisor) UpdateSubscriptions(channel *eventingv1alpha1.
 		return nil
 	}
 
+	channelOptions, err := stanutil.ChannelOptions(channel.Spec.Arguments)
+	if err != nil {
+		s.logger.Error("Invalid channel arguments", zap.Any("channel", cRef), zap.Error(err))
+		return err
+	}
+
 	subscriptions := channel.Spec.Subscribable.Subscribers
 	activeSubs := make(map[subscriptionReference]bool) // it's logically a set
+	durables := make(map[string]bool)
+	for _, sub := range subscriptions {
+		subRef := newSubscriptionReference(sub, channelOptions)
+		activeSubs[subRef] = true
+		durables[subRef.String()] = true
+	}
 
 	chMap, ok := s.subscriptions[cRef]
 	if !ok {
 		chMap = make(map[subscriptionReference]*stan.Subscription)
 		s.subscriptions[cRef] = chMap
 	}
+	// Unsubscribe for deleted subscriptions, and close the changed ones so that they are
+	// recreated below, resuming their durable subscription with the new options.
+	for sub := range chMap {
+		if activeSubs[sub] {
+			continue
+		}
+		if durables[sub.String()] {
+			s.closeSubscription(cRef, sub)
+		} else {
+			s.unsubscribe(cRef, sub)
+		}
+	}
 	for _, sub := range subscriptions {
 		// check if the subscription already exist and do nothing in this case
-		subRef := newSubscriptionReference(sub)
+		subRef := newSubscriptionReference(sub, channelOptions)
 		if _, ok := chMap[subRef]; ok {
-			activeSubs[subRef] = true
 			s.logger.Sugar().Infof("Subscription: %v already active for channel: %v", sub, cRef)
 			continue
 		}
@@ -211,13 +380,6 @@ func (s *SubscriptionsSupervisor) UpdateSubscriptions(channel *eventingv1alpha1.
 			return err
 		}
 		chMap[subRef] = natssSub
-		activeSubs[subRef] = true
-	}
-	// Unsubscribe for deleted subscriptions
-	for sub := range chMap {
-		if ok := activeSubs[sub]; !ok {
-			s.unsubscribe(cRef, sub)
-		}
 	}
 	// delete the channel from s.subscriptions if chMap is empty
 	if len(s.subscriptions[cRef]) == 0 {
@@ -229,15 +391,14 @@ func (s *SubscriptionsSupervisor) UpdateSubscriptions(channel *eventingv1alpha1.
 func (s *SubscriptionsSupervisor) subscribe(channel provisioners.ChannelReference, subscription subscriptionReference) (*stan.Subscription, error) {
 	s.logger.Info("Subscribe to channel:", zap.Any("channel", channel), zap.Any("subscription", subscription))
 
+	dl := newDelivery(s, channel, subscription)
 	mcb := func(msg *stan.Msg) {
-		s.logger.Sugar().Infof("NATSS message received from subject: %v; sequence: %v; timestamp: %v, data: %s", msg.Subject, msg.Sequence, msg.Timestamp, string(msg.Data))
-		message := provisioners.Message{}
-		if err := json.Unmarshal(msg.Data, &message); err != nil {
-			s.logger.Error("Failed to unmarshal message: ", zap.Error(err))
+		if !s.beginHandling() {
+			// NATSS redelivers the message after AckWait.
 			return
 		}
-		if err := s.dispatcher.DispatchMessage(&message, subscription.SubscriberURI, subscription.ReplyURI, provisioners.DispatchDefaults{Namespace: subscription.Namespace}); err != nil {
-			s.logger.Error("Failed to dispatch message: ", zap.Error(err))
+		defer s.handling.Done()
+		if !dl.handle(msg) {
 			return
 		}
 		if err := msg.Ack(); err != nil {
@@ -253,7 +414,7 @@ func (s *SubscriptionsSupervisor) subscribe(channel provisioners.ChannelReferenc
 	if currentNatssConn == nil {
 		return nil, fmt.Errorf("No Connection to NATSS")
 	}
-	natssSub, err := (*currentNatssConn).Subscribe(ch, mcb, stan.DurableName(sub), stan.SetManualAckMode(), stan.AckWait(1*time.Minute))
+	natssSub, err := (*currentNatssConn).Subscribe(ch, mcb, subscription.Options.StanOptions(sub)...)
 	if err != nil {
 		s.logger.Error(" Create new NATSS Subscription failed: ", zap.Error(err))
 		if err.Error() == stan.ErrConnectionClosed.Error() {
@@ -268,6 +429,37 @@ func (s *SubscriptionsSupervisor) subscribe(channel provisioners.ChannelReferenc
 	return &natssSub, nil
 }
 
+// resubscribe recreates the subscriptions on the current connection, after reconnecting. They are
+// durable, so they resume after the last acknowledged message. It must be called while holding
+// subscriptionsMux.
+func (s *SubscriptionsSupervisor) resubscribe() error {
+	for cRef, chMap := range s.subscriptions {
+		for subRef := range chMap {
+			natssSub, err := s.subscribe(cRef, subRef)
+			if err != nil {
+				return err
+			}
+			chMap[subRef] = natssSub
+		}
+	}
+	return nil
+}
+
+// closeSubscription stops the delivery of subscription, keeping its durable subscription on the
+// server so that it can be resumed. It should be called only while holding subscriptionsMux.
+func (s *SubscriptionsSupervisor) closeSubscription(channel provisioners.ChannelReference, subscription subscriptionReference) error {
+	s.logger.Info("Close subscription to channel:", zap.Any("channel", channel), zap.Any("subscription", subscription))
+
+	if stanSub, ok := s.subscriptions[channel][subscription]; ok {
+		if err := (*stanSub).Close(); err != nil {
+			s.logger.Error("Closing NATSS Streaming subscription failed: ", zap.Error(err))
+			return err
+		}
+		delete(s.subscriptions[channel], subscription)
+	}
+	return nil
+}
+
 // should be called only while holding subscriptionsMux
 func (s *SubscriptionsSupervisor) unsubscribe(channel provisioners.ChannelReference, subscription subscriptionReference) error {
 	s.logger.Info("Unsubscribe from channel:", zap.Any("channel", channel), zap.Any("subscription", subscription))
@@ -86,7 +86,7 @@ func TestMain(m *testing.M) {
 	}
 	defer stopNatss(stanServer)
 	// Create and start Dispatcher.
-	s, err = NewDispatcher(natssTestURL, testLogger)
+	s, err = NewDispatcher(natssTestURL, testLogger, nil)
 	if err != nil {
 		logger.Fatalf("Unable to create NATSS dispatcher: %v", err)
 	}
@@ -130,6 +130,20 @@ func TestSubscribeUnsubscribe(t *testing.T) {
 	}
 }
 
+func TestCheckConnection(t *testing.T) {
+	if err := s.checkConnection(); err != nil {
+		t.Errorf("Expected a healthy connection, got: %v", err)
+	}
+
+	notConnected, err := NewDispatcher(natssTestURL, testLogger, nil)
+	if err != nil {
+		t.Fatalf("Unable to create NATSS dispatcher: %v", err)
+	}
+	if err := notConnected.checkConnection(); err == nil {
+		t.Error("Expected an error for a dispatcher that never connected")
+	}
+}
+
 func TestMalformedMessage(t *testing.T) {
 	logger.Info("TestMalformedMessage()")
 
@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+	stan "github.com/nats-io/go-nats-streaming"
+	"github.com/nats-io/go-nats-streaming/pb"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestUpdateSubscriptions_Options(t *testing.T) {
+	server := &fakeServer{}
+	d, err := NewDispatcher(natssTestURL, zap.NewNop(), nil)
+	if err != nil {
+		t.Fatalf("Unable to create NATSS dispatcher: %v", err)
+	}
+	d.connectFunc = server.connect
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go d.Connect(stopCh)
+	d.signalReconnect()
+	waitFor(t, "the connection", func() bool { return d.checkConnection() == nil })
+	conn := server.conn(0)
+
+	c := makeChannel()
+	c.Spec.Arguments = &runtime.RawExtension{Raw: []byte(`{"AckWait": "30s", "MaxInFlight": 10, "Start": "Earliest"}`)}
+	c.Spec.Subscribable = &v1alpha1.Subscribable{
+		Subscribers: []v1alpha1.ChannelSubscriberSpec{{
+			Ref: &corev1.ObjectReference{Name: "sub-name1", Namespace: "sub-namespace1"},
+		}, {
+			Ref:      &corev1.ObjectReference{Name: "sub-name2", Namespace: "sub-namespace2"},
+			Delivery: &v1alpha1.DeliverySpec{AckWait: "2m", MaxInFlight: 1},
+			Start:    &v1alpha1.StartSpec{Sequence: 42},
+		}},
+	}
+	if err := d.UpdateSubscriptions(c, false); err != nil {
+		t.Fatalf("UpdateSubscriptions failed: %v", err)
+	}
+
+	sub1 := conn.subscription("sub-name1.sub-namespace1")
+	if sub1 == nil {
+		t.Fatal("sub-name1 was not subscribed")
+	}
+	if sub1.opts.AckWait != 30*time.Second || sub1.opts.MaxInflight != 10 || sub1.opts.StartAt != pb.StartPosition_First {
+		t.Errorf("sub-name1 does not have the Channel's options: %+v", sub1.opts)
+	}
+	sub2 := conn.subscription("sub-name2.sub-namespace2")
+	if sub2 == nil {
+		t.Fatal("sub-name2 was not subscribed")
+	}
+	if sub2.opts.AckWait != 2*time.Minute || sub2.opts.MaxInflight != 1 || sub2.opts.StartAt != pb.StartPosition_SequenceStart || sub2.opts.StartSequence != 42 {
+		t.Errorf("sub-name2 does not have its own options: %+v", sub2.opts)
+	}
+
+	// Changing the Channel's options recreates sub-name1, keeping its durable subscription, and
+	// leaves sub-name2 alone. Removing sub-name2 then deletes its durable subscription.
+	c.Spec.Arguments = &runtime.RawExtension{Raw: []byte(`{"AckWait": "45s"}`)}
+	if err := d.UpdateSubscriptions(c, false); err != nil {
+		t.Fatalf("UpdateSubscriptions failed: %v", err)
+	}
+	if !sub1.closed || sub1.unsubscribed {
+		t.Errorf("sub-name1 was not closed: closed = %v, unsubscribed = %v", sub1.closed, sub1.unsubscribed)
+	}
+	newSub1 := conn.subscription("sub-name1.sub-namespace1")
+	if newSub1 == sub1 || newSub1.opts.AckWait != 45*time.Second || newSub1.opts.MaxInflight != stan.DefaultMaxInflight {
+		t.Errorf("sub-name1 was not recreated with the new options: %+v", newSub1.opts)
+	}
+	if sub2.closed || sub2.unsubscribed || conn.subscription("sub-name2.sub-namespace2") != sub2 {
+		t.Error("sub-name2 should not have been recreated")
+	}
+
+	c.Spec.Subscribable.Subscribers = c.Spec.Subscribable.Subscribers[:1]
+	if err := d.UpdateSubscriptions(c, false); err != nil {
+		t.Fatalf("UpdateSubscriptions failed: %v", err)
+	}
+	if !sub2.unsubscribed {
+		t.Error("sub-name2 was not unsubscribed")
+	}
+	if newSub1.closed || newSub1.unsubscribed {
+		t.Error("sub-name1 should not have been recreated")
+	}
+
+	c.Spec.Arguments = &runtime.RawExtension{Raw: []byte(`{"MaxInFlight": -1}`)}
+	if err := d.UpdateSubscriptions(c, false); err == nil {
+		t.Error("Expected an error for invalid arguments")
+	}
+}
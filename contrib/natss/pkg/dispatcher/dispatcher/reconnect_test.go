@@ -0,0 +1,338 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knative/eventing/pkg/provisioners"
+	"github.com/nats-io/go-nats"
+	stan "github.com/nats-io/go-nats-streaming"
+	"go.uber.org/zap"
+)
+
+// fakeConn is a stan.Conn recording its subscriptions and the messages published, without a
+// NATSS server.
+type fakeConn struct {
+	lost stan.ConnectionLostHandler
+
+	mux       sync.Mutex
+	closed    bool
+	durable   map[string]*fakeSubscription
+	published []fakeMessage
+}
+
+type fakeMessage struct {
+	subject string
+	data    []byte
+}
+
+var _ stan.Conn = (*fakeConn)(nil)
+
+func (c *fakeConn) Publish(subject string, data []byte) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.closed {
+		return stan.ErrConnectionClosed
+	}
+	c.published = append(c.published, fakeMessage{subject: subject, data: data})
+	return nil
+}
+
+func (c *fakeConn) PublishAsync(subject string, data []byte, ah stan.AckHandler) (string, error) {
+	return "", nil
+}
+
+func (c *fakeConn) Subscribe(subject string, cb stan.MsgHandler, opts ...stan.SubscriptionOption) (stan.Subscription, error) {
+	o := stan.DefaultSubscriptionOptions
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.closed {
+		return nil, stan.ErrConnectionClosed
+	}
+	sub := &fakeSubscription{subject: subject, opts: o}
+	c.durable[o.DurableName] = sub
+	return sub, nil
+}
+
+func (c *fakeConn) QueueSubscribe(subject, qgroup string, cb stan.MsgHandler, opts ...stan.SubscriptionOption) (stan.Subscription, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *fakeConn) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *fakeConn) NatsConn() *nats.Conn {
+	return nil
+}
+
+// lose closes the connection and reports it lost, as the NATSS client does when the server stops
+// answering its pings.
+func (c *fakeConn) lose() {
+	c.Close()
+	c.lost(c, stan.ErrConnectionClosed)
+}
+
+func (c *fakeConn) subscription(durable string) *fakeSubscription {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.durable[durable]
+}
+
+// fakeSubscription is a stan.Subscription of a fakeConn. Its methods other than Unsubscribe and
+// Close are not implemented.
+type fakeSubscription struct {
+	stan.Subscription
+	subject string
+	opts    stan.SubscriptionOptions
+
+	closed       bool
+	unsubscribed bool
+}
+
+func (s *fakeSubscription) Unsubscribe() error {
+	s.unsubscribed = true
+	return nil
+}
+
+func (s *fakeSubscription) Close() error {
+	s.closed = true
+	return nil
+}
+
+// fakeServer hands out fakeConns, failing while it is down.
+type fakeServer struct {
+	mux   sync.Mutex
+	down  bool
+	conns []*fakeConn
+}
+
+func (f *fakeServer) connect(lost stan.ConnectionLostHandler) (*stan.Conn, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.down {
+		return nil, errors.New("connection refused")
+	}
+	c := &fakeConn{lost: lost, durable: make(map[string]*fakeSubscription)}
+	f.conns = append(f.conns, c)
+	var sc stan.Conn = c
+	return &sc, nil
+}
+
+func (f *fakeServer) setDown(down bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.down = down
+}
+
+func (f *fakeServer) conn(i int) *fakeConn {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if i >= len(f.conns) {
+		return nil
+	}
+	return f.conns[i]
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for !cond() {
+		select {
+		case <-timeout:
+			t.Fatalf("Timeout waiting for %s", what)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestResubscribeAfterReconnect(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = 10 * time.Millisecond
+
+	server := &fakeServer{}
+	d, err := NewDispatcher(natssTestURL, zap.NewNop(), nil)
+	if err != nil {
+		t.Fatalf("Unable to create NATSS dispatcher: %v", err)
+	}
+	d.connectFunc = server.connect
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go d.Connect(stopCh)
+	d.signalReconnect()
+	waitFor(t, "the connection", func() bool { return d.checkConnection() == nil })
+
+	c := makeChannelWithSubscribers()
+	if err := d.UpdateSubscriptions(c, false); err != nil {
+		t.Fatalf("UpdateSubscriptions failed: %v", err)
+	}
+	cRef := provisioners.ChannelReference{Namespace: c.Namespace, Name: c.Name}
+
+	checkSubscriptions := func(conn *fakeConn) {
+		t.Helper()
+		d.subscriptionsMux.Lock()
+		defer d.subscriptionsMux.Unlock()
+		if len(d.subscriptions[cRef]) != len(subscribers.Subscribers) {
+			t.Fatalf("Wrong number of subscriptions: %v", d.subscriptions[cRef])
+		}
+		for subRef, sub := range d.subscriptions[cRef] {
+			want := conn.subscription(subRef.String())
+			if want == nil {
+				t.Errorf("Subscription %v was not created on the connection", subRef)
+				continue
+			}
+			if want.subject != getSubject(cRef) {
+				t.Errorf("Subscription %v has subject %q, want %q", subRef, want.subject, getSubject(cRef))
+			}
+			if *sub != stan.Subscription(want) {
+				t.Errorf("Subscription %v does not belong to the connection", subRef)
+			}
+		}
+	}
+	checkSubscriptions(server.conn(0))
+
+	// Losing the connection while the server is down.
+	server.setDown(true)
+	server.conn(0).lose()
+	if err := d.checkConnection(); err == nil {
+		t.Error("Expected an error while the connection is lost")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := d.checkConnection(); err == nil {
+		t.Error("Expected an error while the server is down")
+	}
+	// Reconnecting does not block the updates of the subscriptions.
+	updated := make(chan error)
+	go func() { updated <- d.UpdateSubscriptions(c, false) }()
+	select {
+	case err := <-updated:
+		if err != nil {
+			t.Errorf("UpdateSubscriptions failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("UpdateSubscriptions blocked while reconnecting")
+	}
+
+	server.setDown(false)
+	waitFor(t, "the new connection", func() bool { return d.checkConnection() == nil })
+	checkSubscriptions(server.conn(1))
+
+	// Publishing on a connection closed behind the client's back.
+	server.conn(1).Close()
+	d.signalReconnect()
+	waitFor(t, "the third connection", func() bool { return server.conn(2) != nil && d.checkConnection() == nil })
+	checkSubscriptions(server.conn(2))
+}
+
+func TestConnectAfterStop(t *testing.T) {
+	server := &fakeServer{}
+	d, err := NewDispatcher(natssTestURL, zap.NewNop(), nil)
+	if err != nil {
+		t.Fatalf("Unable to create NATSS dispatcher: %v", err)
+	}
+	stopCh := make(chan struct{})
+	release := make(chan struct{})
+	d.connectFunc = func(lost stan.ConnectionLostHandler) (*stan.Conn, error) {
+		<-release
+		return server.connect(lost)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.connectWithRetry(stopCh)
+		close(done)
+	}()
+	// The dispatcher is stopped while connecting.
+	close(stopCh)
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for connectWithRetry to return")
+	}
+
+	d.natssConnMux.Lock()
+	defer d.natssConnMux.Unlock()
+	if d.natssConn != nil {
+		t.Error("Connection used after the dispatcher was stopped")
+	}
+	if conn := server.conn(0); conn == nil || !conn.closed {
+		t.Errorf("Connection opened after the dispatcher was stopped was not closed: %+v", conn)
+	}
+}
+
+func TestDrainSubscriptions(t *testing.T) {
+	server := &fakeServer{}
+	d, err := NewDispatcher(natssTestURL, zap.NewNop(), nil)
+	if err != nil {
+		t.Fatalf("Unable to create NATSS dispatcher: %v", err)
+	}
+	d.connectFunc = server.connect
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go d.Connect(stopCh)
+	d.signalReconnect()
+	waitFor(t, "the connection", func() bool { return d.checkConnection() == nil })
+	if err := d.UpdateSubscriptions(makeChannelWithSubscribers(), false); err != nil {
+		t.Fatalf("UpdateSubscriptions failed: %v", err)
+	}
+
+	// A message is being handled, the drain gives up on it at the end of the timeout.
+	if !d.beginHandling() {
+		t.Fatal("Expected the message to be handled before draining")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	d.drainSubscriptions(ctx)
+	if ctx.Err() == nil {
+		t.Error("Drain returned while a message was being handled")
+	}
+	conn := server.conn(0)
+	conn.mux.Lock()
+	for durable, sub := range conn.durable {
+		if !sub.closed || sub.unsubscribed {
+			t.Errorf("Expected subscription %s to be closed and kept. Actual %+v", durable, sub)
+		}
+	}
+	conn.mux.Unlock()
+	if d.beginHandling() {
+		t.Error("Expected no message to be handled while draining")
+	}
+
+	// Once the message is handled, the drain returns right away.
+	d.handling.Done()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	d.drainSubscriptions(ctx)
+	if ctx.Err() != nil {
+		t.Error("Timed out draining without any message being handled")
+	}
+}
@@ -19,7 +19,10 @@ package dispatcher
 import (
 	"fmt"
 
+	"github.com/knative/eventing/contrib/natss/pkg/stanutil"
 	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
+	corev1 "k8s.io/api/core/v1"
 )
 
 type subscriptionReference struct {
@@ -27,15 +30,49 @@ type subscriptionReference struct {
 	Namespace     string
 	SubscriberURI string
 	ReplyURI      string
+	// TLSSecretRef and AuthSecretRef are held by value, rather than by pointer, so that
+	// subscriptionReference can be compared and used as a map key. They are empty if the
+	// subscriber does not use TLS or authentication.
+	TLSSecretRef  corev1.LocalObjectReference
+	AuthType      string
+	AuthSecretRef corev1.LocalObjectReference
+	// Options are the delivery options of the subscription, the Channel's overridden by the
+	// subscriber's.
+	Options stanutil.SubscriptionOptions
 }
 
-func newSubscriptionReference(spec eventingduck.ChannelSubscriberSpec) subscriptionReference {
-	return subscriptionReference{
+// newSubscriptionReference returns the reference of the subscriber spec, channelOptions being the
+// delivery options set by the Channel's arguments.
+func newSubscriptionReference(spec eventingduck.ChannelSubscriberSpec, channelOptions stanutil.SubscriptionOptions) subscriptionReference {
+	r := subscriptionReference{
 		Name:          spec.Ref.Name,
 		Namespace:     spec.Ref.Namespace,
 		SubscriberURI: spec.SubscriberURI,
 		ReplyURI:      spec.ReplyURI,
+		Options:       channelOptions.Override(spec),
 	}
+	if spec.TLSSecretRef != nil {
+		r.TLSSecretRef = *spec.TLSSecretRef
+	}
+	if spec.AuthSecretRef != nil {
+		r.AuthType = spec.AuthType
+		r.AuthSecretRef = *spec.AuthSecretRef
+	}
+	return r
+}
+
+// dispatchDefaults returns the DispatchDefaults used to send messages to this subscription of
+// channel. The subscription's Secrets are read in the namespace of channel.
+func (r *subscriptionReference) dispatchDefaults(channel provisioners.ChannelReference) provisioners.DispatchDefaults {
+	defaults := provisioners.DispatchDefaults{Namespace: channel.Namespace}
+	if r.TLSSecretRef.Name != "" {
+		defaults.TLSSecretRef = &r.TLSSecretRef
+	}
+	if r.AuthSecretRef.Name != "" {
+		defaults.AuthType = r.AuthType
+		defaults.AuthSecretRef = &r.AuthSecretRef
+	}
+	return defaults
 }
 
 func (r *subscriptionReference) String() string {
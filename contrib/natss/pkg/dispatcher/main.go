@@ -24,11 +24,13 @@ import (
 	"github.com/knative/eventing/contrib/natss/pkg/dispatcher/dispatcher"
 	"github.com/knative/pkg/signals"
 	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	"github.com/knative/eventing/contrib/natss/pkg/controller/clusterchannelprovisioner"
 	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
 	"github.com/knative/eventing/pkg/utils"
 )
 
@@ -39,7 +41,9 @@ func main() {
 		log.Fatalf("Unable to create logger: %v", err)
 	}
 
-	mgr, err := manager.New(config.GetConfigOrDie(), manager.Options{})
+	mgr, err := manager.New(config.GetConfigOrDie(), manager.Options{
+		MetricsBindAddress: provisioners.MetricsBindAddress,
+	})
 	if err != nil {
 		logger.Fatal("Error starting up.", zap.Error(err))
 	}
@@ -51,12 +55,21 @@ func main() {
 
 	logger.Info("Dispatcher starting...")
 	natssUrl := fmt.Sprintf(clusterchannelprovisioner.NatssUrlTmpl, utils.GetClusterDomainName())
-	d, err := dispatcher.NewDispatcher(natssUrl, logger)
+	receiverOpts, err := provisioners.ReceiverOptionsFromEnv()
+	if err != nil {
+		logger.Fatal("Unable to read the MessageReceiver options", zap.Error(err))
+	}
+	kc, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		logger.Fatal("Unable to create kubernetes client.", zap.Error(err))
+	}
+	d, err := dispatcher.NewDispatcher(natssUrl, logger, kc.CoreV1(), receiverOpts...)
 	if err != nil {
 		logger.Fatal("Unable to create NATSS dispatcher.", zap.Error(err))
 	}
 
-	if err = mgr.Add(d); err != nil {
+	drainingDispatcher := utils.NewDrainingStart(logger, d)
+	if err = mgr.Add(drainingDispatcher); err != nil {
 		logger.Fatal("Unable to add the dispatcher", zap.Error(err))
 	}
 
@@ -71,4 +84,7 @@ func main() {
 	if err != nil {
 		logger.Fatal("Manager.Start() returned an error", zap.Error(err))
 	}
+	logger.Info("Draining...")
+	drainingDispatcher.Wait()
+	logger.Info("Exiting...")
 }
@@ -0,0 +1,162 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stanutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	stan "github.com/nats-io/go-nats-streaming"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+)
+
+const (
+	// DefaultAckWait is how long a subscriber has to acknowledge a message before it is
+	// redelivered, unless the Channel or the Subscription sets it.
+	DefaultAckWait = 1 * time.Minute
+)
+
+// channelArgs are the arguments of a NATSS Channel. They are the delivery options of its
+// subscriptions, which each Subscription can override.
+type channelArgs struct {
+	// AckWait is how long a subscriber has to acknowledge a message before it is redelivered, as
+	// a Go duration of at least 1s. Defaults to DefaultAckWait.
+	AckWait string
+	// MaxInFlight is the number of messages delivered to a subscriber and not acknowledged yet
+	// above which the delivery pauses. Defaults to the NATSS client's default.
+	MaxInFlight int
+	// Start is where new subscriptions start: Latest, Earliest, an RFC 3339 time or a sequence
+	// number. Defaults to Latest.
+	Start string
+	// MaxAttempts is the number of times delivering a message is attempted before giving up on
+	// it. Defaults to 0, retrying forever.
+	MaxAttempts int32
+	// DeadLetter, if true, publishes the messages given up on to a dead-letter subject dedicated
+	// to the subscription. Otherwise they are dropped.
+	DeadLetter bool
+}
+
+// SubscriptionOptions are the delivery options of a NATSS subscription. They are comparable, so
+// that they can be part of a map key.
+type SubscriptionOptions struct {
+	// AckWait is 0 for DefaultAckWait.
+	AckWait time.Duration
+	// MaxInFlight is 0 for the NATSS client's default.
+	MaxInFlight int
+	// Start is where the subscription starts if it is new, in the form of
+	// eventingduck.StartSpec.String. The server resumes existing durable subscriptions after
+	// their last acknowledged message.
+	Start string
+	// MaxAttempts is 0 to retry forever.
+	MaxAttempts int32
+	// DeadLetter publishes the messages given up on to the subscription's dead-letter subject,
+	// rather than dropping them.
+	DeadLetter bool
+}
+
+// ChannelOptions returns the delivery options set by the arguments of a NATSS Channel, or an
+// error if they are invalid.
+func ChannelOptions(arguments *runtime.RawExtension) (SubscriptionOptions, error) {
+	opts := SubscriptionOptions{
+		AckWait: DefaultAckWait,
+		Start:   eventingduck.StartSpec{}.String(),
+	}
+	if arguments == nil || len(arguments.Raw) == 0 {
+		return opts, nil
+	}
+	var args channelArgs
+	if err := json.Unmarshal(arguments.Raw, &args); err != nil {
+		return opts, fmt.Errorf("error unmarshalling arguments: %s", err)
+	}
+	if args.AckWait != "" {
+		ackWait, err := time.ParseDuration(args.AckWait)
+		if err != nil || ackWait < time.Second {
+			return opts, fmt.Errorf("invalid AckWait %q: it must be a duration of at least 1s, such as 30s", args.AckWait)
+		}
+		opts.AckWait = ackWait
+	}
+	if args.MaxInFlight < 0 {
+		return opts, fmt.Errorf("invalid MaxInFlight %d: it must not be negative", args.MaxInFlight)
+	}
+	opts.MaxInFlight = args.MaxInFlight
+	if args.MaxAttempts < 0 {
+		return opts, fmt.Errorf("invalid MaxAttempts %d: it must not be negative", args.MaxAttempts)
+	}
+	opts.MaxAttempts = args.MaxAttempts
+	opts.DeadLetter = args.DeadLetter
+	if args.Start != "" {
+		start, err := eventingduck.ParseStartSpec(args.Start)
+		if err != nil {
+			return opts, fmt.Errorf("invalid Start: %s", err)
+		}
+		opts.Start = start.String()
+	}
+	return opts, nil
+}
+
+// Override returns o overridden by the delivery options set on a subscriber. Invalid options,
+// that the webhook rejects, are ignored.
+func (o SubscriptionOptions) Override(spec eventingduck.ChannelSubscriberSpec) SubscriptionOptions {
+	if d := spec.Delivery; d != nil {
+		if ackWait, err := time.ParseDuration(d.AckWait); err == nil && ackWait >= time.Second {
+			o.AckWait = ackWait
+		}
+		if d.MaxInFlight > 0 {
+			o.MaxInFlight = int(d.MaxInFlight)
+		}
+		if d.MaxAttempts > 0 {
+			o.MaxAttempts = d.MaxAttempts
+		}
+		if d.DeadLetter {
+			o.DeadLetter = true
+		}
+	}
+	if spec.Start != nil {
+		o.Start = spec.Start.String()
+	}
+	return o
+}
+
+// StanOptions returns the options of a manually acknowledged durable subscription named durable,
+// delivering messages according to o.
+func (o SubscriptionOptions) StanOptions(durable string) []stan.SubscriptionOption {
+	ackWait := o.AckWait
+	if ackWait <= 0 {
+		ackWait = DefaultAckWait
+	}
+	opts := []stan.SubscriptionOption{stan.DurableName(durable), stan.SetManualAckMode(), stan.AckWait(ackWait)}
+	if o.MaxInFlight > 0 {
+		opts = append(opts, stan.MaxInflight(o.MaxInFlight))
+	}
+	start, err := eventingduck.ParseStartSpec(o.Start)
+	if err != nil {
+		// New subscriptions start after the last message by default.
+		return opts
+	}
+	switch {
+	case start.Sequence > 0:
+		opts = append(opts, stan.StartAtSequence(uint64(start.Sequence)))
+	case start.Time != nil:
+		opts = append(opts, stan.StartAtTime(start.Time.Time))
+	case start.Position == eventingduck.StartPositionEarliest:
+		opts = append(opts, stan.DeliverAllAvailable())
+	}
+	return opts
+}
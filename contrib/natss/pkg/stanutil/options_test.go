@@ -0,0 +1,183 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stanutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	stan "github.com/nats-io/go-nats-streaming"
+	"github.com/nats-io/go-nats-streaming/pb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+)
+
+func TestChannelOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments *runtime.RawExtension
+		want      SubscriptionOptions
+		wantErr   string
+	}{{
+		name: "no arguments",
+		want: SubscriptionOptions{AckWait: DefaultAckWait, Start: "Latest"},
+	}, {
+		name:      "all arguments",
+		arguments: &runtime.RawExtension{Raw: []byte(`{"AckWait": "30s", "MaxInFlight": 10, "Start": "Earliest", "MaxAttempts": 5, "DeadLetter": true}`)},
+		want:      SubscriptionOptions{AckWait: 30 * time.Second, MaxInFlight: 10, Start: "Earliest", MaxAttempts: 5, DeadLetter: true},
+	}, {
+		name:      "sequence",
+		arguments: &runtime.RawExtension{Raw: []byte(`{"Start": "42"}`)},
+		want:      SubscriptionOptions{AckWait: DefaultAckWait, Start: "42"},
+	}, {
+		name:      "invalid json",
+		arguments: &runtime.RawExtension{Raw: []byte(`{"AckWait": 30}`)},
+		wantErr:   "error unmarshalling arguments: json: cannot unmarshal number into Go struct field channelArgs.AckWait of type string",
+	}, {
+		name:      "short AckWait",
+		arguments: &runtime.RawExtension{Raw: []byte(`{"AckWait": "100ms"}`)},
+		wantErr:   `invalid AckWait "100ms": it must be a duration of at least 1s, such as 30s`,
+	}, {
+		name:      "negative MaxInFlight",
+		arguments: &runtime.RawExtension{Raw: []byte(`{"MaxInFlight": -1}`)},
+		wantErr:   "invalid MaxInFlight -1: it must not be negative",
+	}, {
+		name:      "negative MaxAttempts",
+		arguments: &runtime.RawExtension{Raw: []byte(`{"MaxAttempts": -1}`)},
+		wantErr:   "invalid MaxAttempts -1: it must not be negative",
+	}, {
+		name:      "invalid Start",
+		arguments: &runtime.RawExtension{Raw: []byte(`{"Start": "yesterday"}`)},
+		wantErr:   `invalid Start: "yesterday" is neither Latest, Earliest, an RFC 3339 time nor a positive sequence number`,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ChannelOptions(test.arguments)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("ChannelOptions() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ChannelOptions() unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("ChannelOptions() (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestOverride(t *testing.T) {
+	channel := SubscriptionOptions{AckWait: 30 * time.Second, MaxInFlight: 10, Start: "Earliest"}
+	tests := []struct {
+		name string
+		spec eventingduck.ChannelSubscriberSpec
+		want SubscriptionOptions
+	}{{
+		name: "no overrides",
+		want: channel,
+	}, {
+		name: "overrides",
+		spec: eventingduck.ChannelSubscriberSpec{
+			Delivery: &eventingduck.DeliverySpec{AckWait: "2m", MaxInFlight: 1, MaxAttempts: 3, DeadLetter: true},
+			Start:    &eventingduck.StartSpec{Sequence: 42},
+		},
+		want: SubscriptionOptions{AckWait: 2 * time.Minute, MaxInFlight: 1, Start: "42", MaxAttempts: 3, DeadLetter: true},
+	}, {
+		name: "invalid overrides",
+		spec: eventingduck.ChannelSubscriberSpec{
+			Delivery: &eventingduck.DeliverySpec{AckWait: "soon", MaxInFlight: -1},
+		},
+		want: channel,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.want, channel.Override(test.spec)); diff != "" {
+				t.Errorf("Override() (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestStanOptions(t *testing.T) {
+	start := time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		opts SubscriptionOptions
+		want stan.SubscriptionOptions
+	}{{
+		name: "defaults",
+		opts: SubscriptionOptions{},
+		want: stan.SubscriptionOptions{
+			DurableName: "durable",
+			ManualAcks:  true,
+			AckWait:     DefaultAckWait,
+			MaxInflight: stan.DefaultMaxInflight,
+			StartAt:     pb.StartPosition_NewOnly,
+		},
+	}, {
+		name: "earliest",
+		opts: SubscriptionOptions{AckWait: 30 * time.Second, MaxInFlight: 10, Start: "Earliest"},
+		want: stan.SubscriptionOptions{
+			DurableName: "durable",
+			ManualAcks:  true,
+			AckWait:     30 * time.Second,
+			MaxInflight: 10,
+			StartAt:     pb.StartPosition_First,
+		},
+	}, {
+		name: "sequence",
+		opts: SubscriptionOptions{AckWait: DefaultAckWait, Start: "42"},
+		want: stan.SubscriptionOptions{
+			DurableName:   "durable",
+			ManualAcks:    true,
+			AckWait:       DefaultAckWait,
+			MaxInflight:   stan.DefaultMaxInflight,
+			StartAt:       pb.StartPosition_SequenceStart,
+			StartSequence: 42,
+		},
+	}, {
+		name: "time",
+		opts: SubscriptionOptions{AckWait: DefaultAckWait, Start: eventingduck.StartSpec{Time: &metav1.Time{Time: start}}.String()},
+		want: stan.SubscriptionOptions{
+			DurableName: "durable",
+			ManualAcks:  true,
+			AckWait:     DefaultAckWait,
+			MaxInflight: stan.DefaultMaxInflight,
+			StartAt:     pb.StartPosition_TimeDeltaStart,
+			StartTime:   start,
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := stan.DefaultSubscriptionOptions
+			for _, opt := range test.opts.StanOptions("durable") {
+				if err := opt(&got); err != nil {
+					t.Fatalf("Unexpected option error: %v", err)
+				}
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("StanOptions() (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
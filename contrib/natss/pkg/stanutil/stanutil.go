@@ -24,10 +24,10 @@ import (
 	"go.uber.org/zap"
 )
 
-// Connect creates a new NATS-Streaming connection
-func Connect(clusterId string, clientId string, natsUrl string, logger *zap.SugaredLogger) (*stan.Conn, error) {
+// Connect creates a new NATS-Streaming connection. opts are applied after the URL.
+func Connect(clusterId string, clientId string, natsUrl string, logger *zap.SugaredLogger, opts ...stan.Option) (*stan.Conn, error) {
 	logger.Infof("Connect(): clusterId: %v; clientId: %v; natssUrl: %v", clusterId, clientId, natsUrl)
-	sc, err := stan.Connect(clusterId, clientId, stan.NatsURL(natsUrl))
+	sc, err := stan.Connect(clusterId, clientId, append([]stan.Option{stan.NatsURL(natsUrl)}, opts...)...)
 	if err != nil {
 		logger.Errorf("Connect(): create new connection failed: %v", err)
 		return nil, err
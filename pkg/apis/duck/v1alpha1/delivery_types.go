@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DeliveryStrategy is how a Channel retries delivering an event to a subscriber.
+type DeliveryStrategy string
+
+const (
+	// DeliveryStrategyRetry retries delivering the event in place, holding back the events
+	// behind it until it is delivered or given up on.
+	DeliveryStrategyRetry DeliveryStrategy = "Retry"
+	// DeliveryStrategyRequeue republishes the event to a retry queue dedicated to the
+	// subscriber, so that the events behind it are not held back while it is retried.
+	DeliveryStrategyRequeue DeliveryStrategy = "Requeue"
+)
+
+// DeliverySpec configures how a Channel handles the events it fails to deliver to a
+// subscriber. Support for each field depends on the Channel's provisioner. All the fields are
+// optional, the provisioner picks defaults for those that are not set.
+type DeliverySpec struct {
+	// Strategy is how the delivery of an event is retried. Defaults to Retry.
+	// +optional
+	Strategy DeliveryStrategy `json:"strategy,omitempty"`
+	// MaxAttempts is the number of times delivering an event is attempted before giving up.
+	// +optional
+	MaxAttempts int32 `json:"maxAttempts,omitempty"`
+	// Backoff is the delay before the first retry, as a Go duration such as "1s". It doubles
+	// after every retry.
+	// +optional
+	Backoff string `json:"backoff,omitempty"`
+	// DeadLetter, if true, keeps the events that were given up on in a dead-letter queue
+	// dedicated to the subscriber. Otherwise they are dropped.
+	// +optional
+	DeadLetter bool `json:"deadLetter,omitempty"`
+	// AckWait is how long the subscriber has to acknowledge an event, as a Go duration such as
+	// "30s", before it is delivered again.
+	// +optional
+	AckWait string `json:"ackWait,omitempty"`
+	// MaxInFlight is the number of events delivered to the subscriber and not acknowledged yet
+	// above which the delivery pauses.
+	// +optional
+	MaxInFlight int32 `json:"maxInFlight,omitempty"`
+}
+
+// StartPosition is a position in the history of a Channel.
+type StartPosition string
+
+const (
+	// StartPositionLatest is after the last event of the Channel, only the events sent from
+	// then on are delivered.
+	StartPositionLatest StartPosition = "Latest"
+	// StartPositionEarliest is the first event the Channel still retains.
+	StartPositionEarliest StartPosition = "Earliest"
+)
+
+// StartSpec is where a subscriber starts receiving the events of a Channel: either a
+// Position, the first event sent at or after a Time, or the event numbered Sequence. Support
+// depends on the Channel's provisioner.
+type StartSpec struct {
+	// Position is Latest or Earliest. Defaults to Latest when neither Time nor Sequence is set.
+	// +optional
+	Position StartPosition `json:"position,omitempty"`
+	// Time starts the delivery at the first event sent at or after it. It can not be set
+	// with Position.
+	// +optional
+	Time *metav1.Time `json:"time,omitempty"`
+	// Sequence starts the delivery at the event with this sequence number, for Channels
+	// numbering their events in a single sequence. It can not be set with Position or Time.
+	// +optional
+	Sequence int64 `json:"sequence,omitempty"`
+}
+
+// String returns the Position, the RFC 3339 Time or the Sequence of s, which identifies it.
+func (s StartSpec) String() string {
+	if s.Sequence > 0 {
+		return strconv.FormatInt(s.Sequence, 10)
+	}
+	if s.Time != nil {
+		return s.Time.UTC().Format(time.RFC3339)
+	}
+	if s.Position == "" {
+		return string(StartPositionLatest)
+	}
+	return string(s.Position)
+}
+
+// ParseStartSpec parses the Position, RFC 3339 time or sequence number returned by
+// StartSpec.String.
+func ParseStartSpec(s string) (*StartSpec, error) {
+	switch StartPosition(s) {
+	case StartPositionLatest, StartPositionEarliest:
+		return &StartSpec{Position: StartPosition(s)}, nil
+	}
+	if seq, err := strconv.ParseInt(s, 10, 64); err == nil && seq > 0 {
+		return &StartSpec{Sequence: seq}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither Latest, Earliest, an RFC 3339 time nor a positive sequence number", s)
+	}
+	return &StartSpec{Time: &metav1.Time{Time: t}}, nil
+}
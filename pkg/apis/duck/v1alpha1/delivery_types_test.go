@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStartSpecRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		start StartSpec
+		want  string
+	}{{
+		name:  "empty",
+		start: StartSpec{},
+		want:  "Latest",
+	}, {
+		name:  "earliest",
+		start: StartSpec{Position: StartPositionEarliest},
+		want:  "Earliest",
+	}, {
+		name:  "time",
+		start: StartSpec{Time: &metav1.Time{Time: time.Date(2019, 3, 1, 1, 0, 0, 0, time.FixedZone("CET", 3600))}},
+		want:  "2019-03-01T00:00:00Z",
+	}, {
+		name:  "sequence",
+		start: StartSpec{Sequence: 42},
+		want:  "42",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.start.String()
+			if got != test.want {
+				t.Fatalf("String() = %q, want %q", got, test.want)
+			}
+			parsed, err := ParseStartSpec(got)
+			if err != nil {
+				t.Fatalf("ParseStartSpec(%q) = %v", got, err)
+			}
+			if diff := cmp.Diff(got, parsed.String()); diff != "" {
+				t.Errorf("ParseStartSpec(%q) (-want, +got) = %v", got, diff)
+			}
+		})
+	}
+}
+
+func TestParseStartSpecInvalid(t *testing.T) {
+	for _, s := range []string{"yesterday", "0", "-1"} {
+		if _, err := ParseStartSpec(s); err == nil {
+			t.Errorf("ParseStartSpec(%s) = nil error, want error", s)
+		}
+	}
+}
@@ -17,6 +17,8 @@
 package v1alpha1
 
 import (
+	"time"
+
 	"github.com/knative/pkg/apis/duck"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -36,6 +38,13 @@ type Subscribable struct {
 // SubscriberURI is the endpoint for the subscriber
 // ReplyURI is the endpoint for the reply
 // At least one of SubscriberURI and ReplyURI must be present
+// TLSSecretRef is a reference to the Secret, in the Channel's namespace, holding
+// the TLS material used to deliver events to SubscriberURI
+// AuthType and AuthSecretRef are the scheme and the Secret, in the Channel's
+// namespace, holding the credentials used to authenticate to SubscriberURI
+// Delivery configures how events that can not be delivered are retried
+// Start is where the subscriber starts receiving events the first time
+// Reset, when set, moves the subscriber to a new position once per value
 type ChannelSubscriberSpec struct {
 	// +optional
 	Ref *corev1.ObjectReference `json:"ref,omitempty"`
@@ -43,6 +52,18 @@ type ChannelSubscriberSpec struct {
 	SubscriberURI string `json:"subscriberURI,omitempty"`
 	// +optional
 	ReplyURI string `json:"replyURI,omitempty"`
+	// +optional
+	TLSSecretRef *corev1.LocalObjectReference `json:"tlsSecretRef,omitempty"`
+	// +optional
+	AuthType string `json:"authType,omitempty"`
+	// +optional
+	AuthSecretRef *corev1.LocalObjectReference `json:"authSecretRef,omitempty"`
+	// +optional
+	Delivery *DeliverySpec `json:"delivery,omitempty"`
+	// +optional
+	Start *StartSpec `json:"start,omitempty"`
+	// +optional
+	Reset *StartSpec `json:"reset,omitempty"`
 }
 
 // Channel is a skeleton type wrapping Subscribable in the manner we expect resource writers
@@ -81,6 +102,27 @@ func (c *Channel) Populate() {
 			},
 			SubscriberURI: "call1",
 			ReplyURI:      "sink2",
+			TLSSecretRef: &corev1.LocalObjectReference{
+				Name: "subscriber-tls",
+			},
+			AuthType: "Bearer",
+			AuthSecretRef: &corev1.LocalObjectReference{
+				Name: "subscriber-auth",
+			},
+			Delivery: &DeliverySpec{
+				Strategy:    DeliveryStrategyRequeue,
+				MaxAttempts: 5,
+				Backoff:     "1s",
+				DeadLetter:  true,
+				AckWait:     "30s",
+				MaxInFlight: 10,
+			},
+			Start: &StartSpec{
+				Position: StartPositionEarliest,
+			},
+			Reset: &StartSpec{
+				Time: &metav1.Time{Time: time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)},
+			},
 		}, {
 			Ref: &corev1.ObjectReference{
 				APIVersion: "eventing.knative.dev/v1alpha1",
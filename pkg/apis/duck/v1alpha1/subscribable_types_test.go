@@ -18,9 +18,11 @@ package v1alpha1
 
 import (
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestGetFullType(t *testing.T) {
@@ -59,6 +61,27 @@ func TestPopulate(t *testing.T) {
 					},
 					SubscriberURI: "call1",
 					ReplyURI:      "sink2",
+					TLSSecretRef: &corev1.LocalObjectReference{
+						Name: "subscriber-tls",
+					},
+					AuthType: "Bearer",
+					AuthSecretRef: &corev1.LocalObjectReference{
+						Name: "subscriber-auth",
+					},
+					Delivery: &DeliverySpec{
+						Strategy:    DeliveryStrategyRequeue,
+						MaxAttempts: 5,
+						Backoff:     "1s",
+						DeadLetter:  true,
+						AckWait:     "30s",
+						MaxInFlight: 10,
+					},
+					Start: &StartSpec{
+						Position: StartPositionEarliest,
+					},
+					Reset: &StartSpec{
+						Time: &metav1.Time{Time: time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)},
+					},
 				}, {
 					Ref: &corev1.ObjectReference{
 						APIVersion: "eventing.knative.dev/v1alpha1",
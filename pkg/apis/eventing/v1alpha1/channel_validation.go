@@ -26,7 +26,19 @@ import (
 )
 
 func (c *Channel) Validate(ctx context.Context) *apis.FieldError {
-	return c.Spec.Validate(ctx).ViaField("spec")
+	errs := c.Spec.Validate(ctx).ViaField("spec")
+	// The dispatchers read the subscribers' Secrets in the Channel's namespace, so a subscriber
+	// can only come from a Subscription of that namespace.
+	if c.Spec.Subscribable != nil {
+		for i, subscriber := range c.Spec.Subscribable.Subscribers {
+			if subscriber.Ref != nil && subscriber.Ref.Namespace != "" && subscriber.Ref.Namespace != c.Namespace {
+				fe := apis.ErrInvalidValue(subscriber.Ref.Namespace, "namespace")
+				fe.Details = "subscribers must be in the Channel's namespace"
+				errs = errs.Also(fe.ViaField("ref").ViaField(fmt.Sprintf("subscriber[%d]", i)).ViaField("subscribable").ViaField("spec"))
+			}
+		}
+	}
+	return errs
 }
 
 func (cs *ChannelSpec) Validate(ctx context.Context) *apis.FieldError {
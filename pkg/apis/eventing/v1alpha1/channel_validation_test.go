@@ -24,6 +24,7 @@ import (
 	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
 	"github.com/knative/pkg/apis"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -102,6 +103,38 @@ func TestChannelValidation(t *testing.T) {
 			errs = errs.Also(fe)
 			return errs
 		}(),
+	}, {
+		name: "subscriber in another namespace",
+		cr: &Channel{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "test-namespace",
+			},
+			Spec: ChannelSpec{
+				Provisioner: &corev1.ObjectReference{
+					Name: "foo",
+				},
+				Subscribable: &eventingduck.Subscribable{
+					Subscribers: []eventingduck.ChannelSubscriberSpec{{
+						Ref: &corev1.ObjectReference{
+							Namespace: "test-namespace",
+							Name:      "sub-1",
+						},
+						SubscriberURI: "subscriberendpoint",
+					}, {
+						Ref: &corev1.ObjectReference{
+							Namespace: "kube-system",
+							Name:      "sub-2",
+						},
+						SubscriberURI: "subscriberendpoint",
+					}},
+				},
+			},
+		},
+		want: func() *apis.FieldError {
+			fe := apis.ErrInvalidValue("kube-system", "spec.subscribable.subscriber[1].ref.namespace")
+			fe.Details = "subscribers must be in the Channel's namespace"
+			return fe
+		}(),
 	}}
 
 	doValidateTest(t, tests)
@@ -17,6 +17,9 @@
 package v1alpha1
 
 import (
+	"fmt"
+
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
 	"github.com/knative/pkg/apis"
 	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
 	"github.com/knative/pkg/webhook"
@@ -46,6 +49,12 @@ var _ apis.Immutable = (*Subscription)(nil)
 var _ runtime.Object = (*Subscription)(nil)
 var _ webhook.GenericCRD = (*Subscription)(nil)
 
+// ResetPositionAnnotation, set on a Subscription, moves the subscriber to a new position in
+// the Channel's history: Latest, Earliest, an RFC 3339 time or a sequence number. The Channel
+// applies each value once, so replaying from the same position again requires removing the
+// annotation first.
+const ResetPositionAnnotation = "eventing.knative.dev/resetPosition"
+
 // SubscriptionSpec specifies the Channel for incoming events, a Subscriber target
 // for processing those events and where to put the result of the processing. Only
 // From (where the events are coming from) is always required. You can optionally
@@ -99,6 +108,16 @@ type SubscriptionSpec struct {
 	// the Subscriber target.
 	// +optional
 	Reply *ReplyStrategy `json:"reply,omitempty"`
+
+	// Delivery configures how the Channel retries the events it fails to
+	// deliver to the Subscriber, and what happens to those it gives up on.
+	// +optional
+	Delivery *eventingduck.DeliverySpec `json:"delivery,omitempty"`
+
+	// Start is where the Subscriber starts receiving the events of the
+	// Channel when the Subscription is created. Defaults to Latest.
+	// +optional
+	Start *eventingduck.StartSpec `json:"start,omitempty"`
 }
 
 // SubscriberSpec specifies the reference to an object that's expected to
@@ -143,6 +162,60 @@ type SubscriberSpec struct {
 	// http://myexternalhandler.example.com/foo/bar
 	// +optional
 	DNSName *string `json:"dnsName,omitempty"`
+
+	// TLS configures the TLS material used when delivering events to the
+	// subscriber over HTTPS, for example a custom CA bundle or a client
+	// certificate for mutual TLS.
+	// +optional
+	TLS *SubscriberTLS `json:"tls,omitempty"`
+
+	// Auth configures the credentials used to authenticate to the
+	// subscriber. The credentials are read by the dispatcher and are never
+	// copied into the Channel.
+	// +optional
+	Auth *SubscriberAuth `json:"auth,omitempty"`
+}
+
+// SubscriberTLS references a Secret, in the same namespace as the object
+// that specifies it, holding the TLS material used to connect to the
+// subscriber. The following keys of the Secret are used:
+//   - ca.crt: a PEM encoded CA bundle used to verify the subscriber.
+//   - tls.crt and tls.key: a PEM encoded client certificate and key
+//     presented to the subscriber for mutual TLS. Both must be set, or
+//     neither.
+type SubscriberTLS struct {
+	// SecretRef is the name of the Secret holding the TLS material.
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+}
+
+// SubscriberAuthType is the scheme used to authenticate to a subscriber.
+type SubscriberAuthType string
+
+const (
+	// SubscriberAuthBearer sends the static token in the Secret's "token"
+	// key as a bearer token.
+	SubscriberAuthBearer SubscriberAuthType = "Bearer"
+
+	// SubscriberAuthBasic uses HTTP basic authentication with the Secret's
+	// "username" and "password" keys.
+	SubscriberAuthBasic SubscriberAuthType = "Basic"
+
+	// SubscriberAuthOAuth2 sends bearer tokens obtained with the OAuth2
+	// client credentials grant. The Secret's "clientID", "clientSecret" and
+	// "tokenURL" keys are required, its "scopes" key is an optional space
+	// separated list of scopes. Tokens are refreshed before they expire.
+	SubscriberAuthOAuth2 SubscriberAuthType = "OAuth2"
+)
+
+// SubscriberAuth references a Secret, in the same namespace as the object
+// that specifies it, holding the credentials used to authenticate to the
+// subscriber.
+type SubscriberAuth struct {
+	// Type is the authentication scheme, one of Bearer, Basic or OAuth2.
+	Type SubscriberAuthType `json:"type"`
+
+	// SecretRef is the name of the Secret holding the credentials.
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
 }
 
 // ReplyStrategy specifies the handling of the SubscriberSpec's returned replies.
@@ -196,6 +269,12 @@ const (
 	// SubscriptionConditionChannelReady has status True when controller has successfully added a
 	// subscription to the spec.channel resource.
 	SubscriptionConditionChannelReady duckv1alpha1.ConditionType = "ChannelReady"
+
+	// SubscriptionConditionLagging has status True when the subscriber is further behind the
+	// Channel than the threshold of the Channel's provisioner. It is only set by the provisioners
+	// that track how far behind subscribers are, and does not affect the Subscription's
+	// readiness.
+	SubscriptionConditionLagging duckv1alpha1.ConditionType = "Lagging"
 )
 
 // GetCondition returns the condition currently associated with the given type, or nil.
@@ -218,6 +297,33 @@ func (ss *SubscriptionStatus) MarkReferencesResolved() {
 	subCondSet.Manage(ss).MarkTrue(SubscriptionConditionReferencesResolved)
 }
 
+// MarkReferencesNotResolved sets the ReferencesResolved condition to False state.
+func (ss *SubscriptionStatus) MarkReferencesNotResolved(reason, messageFormat string, messageA ...interface{}) {
+	subCondSet.Manage(ss).MarkFalse(SubscriptionConditionReferencesResolved, reason, messageFormat, messageA...)
+}
+
+// MarkLagging sets the Lagging condition to True state.
+func (ss *SubscriptionStatus) MarkLagging(reason, messageFormat string, messageA ...interface{}) {
+	ss.setLagging(corev1.ConditionTrue, reason, fmt.Sprintf(messageFormat, messageA...))
+}
+
+// MarkNotLagging sets the Lagging condition to False state.
+func (ss *SubscriptionStatus) MarkNotLagging() {
+	ss.setLagging(corev1.ConditionFalse, "", "")
+}
+
+// setLagging sets the Lagging condition with SetCondition, as MarkTrue and MarkFalse would also
+// update the Ready condition.
+func (ss *SubscriptionStatus) setLagging(status corev1.ConditionStatus, reason, message string) {
+	subCondSet.Manage(ss).SetCondition(duckv1alpha1.Condition{
+		Type:     SubscriptionConditionLagging,
+		Status:   status,
+		Severity: duckv1alpha1.ConditionSeverityInfo,
+		Reason:   reason,
+		Message:  message,
+	})
+}
+
 // MarkChannelReady sets the ChannelReady condition to True state.
 func (ss *SubscriptionStatus) MarkChannelReady() {
 	subCondSet.Manage(ss).MarkTrue(SubscriptionConditionChannelReady)
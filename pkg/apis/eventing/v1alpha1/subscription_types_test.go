@@ -229,3 +229,28 @@ func TestSubscriptionIsReady(t *testing.T) {
 		})
 	}
 }
+
+func TestSubscriptionLagging(t *testing.T) {
+	ss := &SubscriptionStatus{}
+	ss.MarkReferencesResolved()
+	ss.MarkLagging("LagAboveThreshold", "lag %d is above %d", 20, 10)
+	if ss.IsReady() {
+		t.Errorf("Expected the Subscription not to be ready when lagging before the channel is ready")
+	}
+	cond := ss.GetCondition(SubscriptionConditionLagging)
+	if !cond.IsTrue() || cond.Message != "lag 20 is above 10" || cond.Severity != duckv1alpha1.ConditionSeverityInfo {
+		t.Errorf("Unexpected Lagging condition %v", cond)
+	}
+
+	ss.MarkChannelReady()
+	if !ss.IsReady() {
+		t.Errorf("Expected the Subscription to be ready while lagging")
+	}
+	ss.MarkNotLagging()
+	if cond := ss.GetCondition(SubscriptionConditionLagging); !cond.IsFalse() {
+		t.Errorf("Unexpected Lagging condition %v", cond)
+	}
+	if !ss.IsReady() {
+		t.Errorf("Expected the Subscription to stay ready")
+	}
+}
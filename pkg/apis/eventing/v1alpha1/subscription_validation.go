@@ -18,15 +18,27 @@ package v1alpha1
 
 import (
 	"context"
+	"fmt"
+	"time"
+
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
 	"github.com/knative/pkg/apis"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 )
 
 func (s *Subscription) Validate(ctx context.Context) *apis.FieldError {
-	return s.Spec.Validate(ctx).ViaField("spec")
+	errs := s.Spec.Validate(ctx).ViaField("spec")
+	if reset, ok := s.Annotations[ResetPositionAnnotation]; ok {
+		if _, err := eventingduck.ParseStartSpec(reset); err != nil {
+			fe := apis.ErrInvalidValue(reset, ResetPositionAnnotation)
+			fe.Details = err.Error()
+			errs = errs.Also(fe.ViaField("annotations").ViaField("metadata"))
+		}
+	}
+	return errs
 }
 
 // We require always Channel
@@ -61,6 +73,18 @@ func (ss *SubscriptionSpec) Validate(ctx context.Context) *apis.FieldError {
 		}
 	}
 
+	if ss.Delivery != nil {
+		if fe := isValidDelivery(*ss.Delivery); fe != nil {
+			errs = errs.Also(fe.ViaField("delivery"))
+		}
+	}
+
+	if ss.Start != nil {
+		if fe := isValidStart(*ss.Start); fe != nil {
+			errs = errs.Also(fe.ViaField("start"))
+		}
+	}
+
 	return errs
 }
 
@@ -83,9 +107,93 @@ func isValidSubscriberSpec(s SubscriberSpec) *apis.FieldError {
 			errs = errs.Also(fe.ViaField("ref"))
 		}
 	}
+
+	if s.TLS != nil {
+		if s.TLS.SecretRef == nil || s.TLS.SecretRef.Name == "" {
+			errs = errs.Also(apis.ErrMissingField("secretRef.name").ViaField("tls"))
+		}
+	}
+
+	if s.Auth != nil {
+		if fe := isValidSubscriberAuth(*s.Auth); fe != nil {
+			errs = errs.Also(fe.ViaField("auth"))
+		}
+	}
+	return errs
+}
+
+func isValidSubscriberAuth(a SubscriberAuth) *apis.FieldError {
+	var errs *apis.FieldError
+	switch a.Type {
+	case SubscriberAuthBearer, SubscriberAuthBasic, SubscriberAuthOAuth2:
+	case "":
+		errs = errs.Also(apis.ErrMissingField("type"))
+	default:
+		fe := apis.ErrInvalidValue(string(a.Type), "type")
+		fe.Details = "only Bearer, Basic and OAuth2 are supported"
+		errs = errs.Also(fe)
+	}
+	if a.SecretRef == nil || a.SecretRef.Name == "" {
+		errs = errs.Also(apis.ErrMissingField("secretRef.name"))
+	}
+	return errs
+}
+
+func isValidDelivery(d eventingduck.DeliverySpec) *apis.FieldError {
+	var errs *apis.FieldError
+	switch d.Strategy {
+	case "", eventingduck.DeliveryStrategyRetry, eventingduck.DeliveryStrategyRequeue:
+	default:
+		fe := apis.ErrInvalidValue(string(d.Strategy), "strategy")
+		fe.Details = "only Retry and Requeue are supported"
+		errs = errs.Also(fe)
+	}
+	if d.MaxAttempts < 0 {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%d", d.MaxAttempts), "maxAttempts"))
+	}
+	if d.Backoff != "" {
+		if backoff, err := time.ParseDuration(d.Backoff); err != nil || backoff <= 0 {
+			fe := apis.ErrInvalidValue(d.Backoff, "backoff")
+			fe.Details = "must be a positive duration, such as 1s"
+			errs = errs.Also(fe)
+		}
+	}
+	if d.AckWait != "" {
+		if ackWait, err := time.ParseDuration(d.AckWait); err != nil || ackWait < time.Second {
+			fe := apis.ErrInvalidValue(d.AckWait, "ackWait")
+			fe.Details = "must be a duration of at least 1s, such as 30s"
+			errs = errs.Also(fe)
+		}
+	}
+	if d.MaxInFlight < 0 {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%d", d.MaxInFlight), "maxInFlight"))
+	}
 	return errs
 }
 
+func isValidStart(s eventingduck.StartSpec) *apis.FieldError {
+	switch s.Position {
+	case "":
+	case eventingduck.StartPositionLatest, eventingduck.StartPositionEarliest:
+		if s.Time != nil {
+			return apis.ErrMultipleOneOf("position", "time")
+		}
+	default:
+		fe := apis.ErrInvalidValue(string(s.Position), "position")
+		fe.Details = "only Latest and Earliest are supported"
+		return fe
+	}
+	switch {
+	case s.Sequence < 0:
+		return apis.ErrInvalidValue(fmt.Sprintf("%d", s.Sequence), "sequence")
+	case s.Sequence > 0 && s.Position != "":
+		return apis.ErrMultipleOneOf("position", "sequence")
+	case s.Sequence > 0 && s.Time != nil:
+		return apis.ErrMultipleOneOf("sequence", "time")
+	}
+	return nil
+}
+
 func isReplyStrategyNilOrEmpty(r *ReplyStrategy) bool {
 	return r == nil || equality.Semantic.DeepEqual(r, &ReplyStrategy{}) || equality.Semantic.DeepEqual(r.Channel, &corev1.ObjectReference{})
 }
@@ -117,8 +225,8 @@ func (current *Subscription) CheckImmutableFields(ctx context.Context, og apis.I
 		return nil
 	}
 
-	// Only Subscriber and Reply are mutable.
-	ignoreArguments := cmpopts.IgnoreFields(SubscriptionSpec{}, "Subscriber", "Reply")
+	// Only Subscriber, Reply and Delivery are mutable.
+	ignoreArguments := cmpopts.IgnoreFields(SubscriptionSpec{}, "Subscriber", "Reply", "Delivery")
 	if diff := cmp.Diff(original.Spec, current.Spec, ignoreArguments); diff != "" {
 		return &apis.FieldError{
 			Message: "Immutable fields changed (-old +new)",
@@ -18,10 +18,13 @@ package v1alpha1
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
 	"github.com/knative/pkg/apis"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
@@ -90,6 +93,46 @@ func TestSubscriptionValidation(t *testing.T) {
 
 }
 
+func TestSubscriptionResetPositionValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		reset string
+		want  *apis.FieldError
+	}{{
+		name:  "earliest",
+		reset: "Earliest",
+	}, {
+		name:  "time",
+		reset: "2019-03-01T00:00:00Z",
+	}, {
+		name:  "invalid",
+		reset: "yesterday",
+		want: func() *apis.FieldError {
+			fe := apis.ErrInvalidValue("yesterday", "metadata.annotations."+ResetPositionAnnotation)
+			fe.Details = `"yesterday" is neither Latest, Earliest, an RFC 3339 time nor a positive sequence number`
+			return fe
+		}(),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Subscription{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{ResetPositionAnnotation: test.reset},
+				},
+				Spec: SubscriptionSpec{
+					Channel:    getValidChannelRef(),
+					Subscriber: getValidSubscriberSpec(),
+				},
+			}
+			got := s.Validate(context.TODO())
+			if diff := cmp.Diff(test.want.Error(), got.Error()); diff != "" {
+				t.Errorf("Subscription.Validate (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
 func TestSubscriptionSpecValidation(t *testing.T) {
 	tests := []struct {
 		name string
@@ -228,6 +271,110 @@ func TestSubscriptionSpecValidation(t *testing.T) {
 			fe := apis.ErrMissingField("reply.channel.name")
 			return fe
 		}(),
+	}, {
+		name: "valid Delivery",
+		c: &SubscriptionSpec{
+			Channel:    getValidChannelRef(),
+			Subscriber: getValidSubscriberSpec(),
+			Delivery: &eventingduck.DeliverySpec{
+				Strategy:    eventingduck.DeliveryStrategyRequeue,
+				MaxAttempts: 5,
+				Backoff:     "500ms",
+				DeadLetter:  true,
+				AckWait:     "30s",
+				MaxInFlight: 10,
+			},
+		},
+		want: nil,
+	}, {
+		name: "invalid Delivery",
+		c: &SubscriptionSpec{
+			Channel:    getValidChannelRef(),
+			Subscriber: getValidSubscriberSpec(),
+			Delivery: &eventingduck.DeliverySpec{
+				Strategy:    "Forever",
+				MaxAttempts: -1,
+				Backoff:     "soon",
+			},
+		},
+		want: func() *apis.FieldError {
+			strategy := apis.ErrInvalidValue("Forever", "delivery.strategy")
+			strategy.Details = "only Retry and Requeue are supported"
+			backoff := apis.ErrInvalidValue("soon", "delivery.backoff")
+			backoff.Details = "must be a positive duration, such as 1s"
+			return strategy.Also(apis.ErrInvalidValue("-1", "delivery.maxAttempts")).Also(backoff)
+		}(),
+	}, {
+		name: "invalid Delivery acknowledgement",
+		c: &SubscriptionSpec{
+			Channel:    getValidChannelRef(),
+			Subscriber: getValidSubscriberSpec(),
+			Delivery: &eventingduck.DeliverySpec{
+				AckWait:     "500ms",
+				MaxInFlight: -1,
+			},
+		},
+		want: func() *apis.FieldError {
+			ackWait := apis.ErrInvalidValue("500ms", "delivery.ackWait")
+			ackWait.Details = "must be a duration of at least 1s, such as 30s"
+			return ackWait.Also(apis.ErrInvalidValue("-1", "delivery.maxInFlight"))
+		}(),
+	}, {
+		name: "valid Start",
+		c: &SubscriptionSpec{
+			Channel:    getValidChannelRef(),
+			Subscriber: getValidSubscriberSpec(),
+			Start: &eventingduck.StartSpec{
+				Time: &metav1.Time{Time: time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)},
+			},
+		},
+		want: nil,
+	}, {
+		name: "invalid Start position",
+		c: &SubscriptionSpec{
+			Channel:    getValidChannelRef(),
+			Subscriber: getValidSubscriberSpec(),
+			Start: &eventingduck.StartSpec{
+				Position: "Middle",
+			},
+		},
+		want: func() *apis.FieldError {
+			fe := apis.ErrInvalidValue("Middle", "start.position")
+			fe.Details = "only Latest and Earliest are supported"
+			return fe
+		}(),
+	}, {
+		name: "Start position and time",
+		c: &SubscriptionSpec{
+			Channel:    getValidChannelRef(),
+			Subscriber: getValidSubscriberSpec(),
+			Start: &eventingduck.StartSpec{
+				Position: eventingduck.StartPositionEarliest,
+				Time:     &metav1.Time{Time: time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)},
+			},
+		},
+		want: apis.ErrMultipleOneOf("start.position", "start.time"),
+	}, {
+		name: "valid Start sequence",
+		c: &SubscriptionSpec{
+			Channel:    getValidChannelRef(),
+			Subscriber: getValidSubscriberSpec(),
+			Start: &eventingduck.StartSpec{
+				Sequence: 42,
+			},
+		},
+		want: nil,
+	}, {
+		name: "Start sequence and time",
+		c: &SubscriptionSpec{
+			Channel:    getValidChannelRef(),
+			Subscriber: getValidSubscriberSpec(),
+			Start: &eventingduck.StartSpec{
+				Sequence: 42,
+				Time:     &metav1.Time{Time: time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)},
+			},
+		},
+		want: apis.ErrMultipleOneOf("start.sequence", "start.time"),
 	}}
 
 	for _, test := range tests {
@@ -338,6 +485,24 @@ func TestSubscriptionImmutable(t *testing.T) {
 			},
 		},
 		want: nil,
+	}, {
+		name: "valid, new Delivery",
+		c: &Subscription{
+			Spec: SubscriptionSpec{
+				Channel:    getValidChannelRef(),
+				Subscriber: getValidSubscriberSpec(),
+				Delivery: &eventingduck.DeliverySpec{
+					MaxAttempts: 5,
+				},
+			},
+		},
+		og: &Subscription{
+			Spec: SubscriptionSpec{
+				Channel:    getValidChannelRef(),
+				Subscriber: getValidSubscriberSpec(),
+			},
+		},
+		want: nil,
 	}, {
 		name: "Channel changed",
 		c: &Subscription{
@@ -601,6 +766,93 @@ func TestValidgetValidSubscriber(t *testing.T) {
 			},
 		},
 		want: nil,
+	}, {
+		name: "valid tls",
+		s: SubscriberSpec{
+			DNSName: &dnsName,
+			TLS: &SubscriberTLS{
+				SecretRef: &corev1.LocalObjectReference{
+					Name: "subscriber-tls",
+				},
+			},
+		},
+		want: nil,
+	}, {
+		name: "missing tls secretRef",
+		s: SubscriberSpec{
+			DNSName: &dnsName,
+			TLS:     &SubscriberTLS{},
+		},
+		want: func() *apis.FieldError {
+			fe := apis.ErrMissingField("tls.secretRef.name")
+			return fe
+		}(),
+	}, {
+		name: "missing tls secretRef name",
+		s: SubscriberSpec{
+			DNSName: &dnsName,
+			TLS: &SubscriberTLS{
+				SecretRef: &corev1.LocalObjectReference{},
+			},
+		},
+		want: func() *apis.FieldError {
+			fe := apis.ErrMissingField("tls.secretRef.name")
+			return fe
+		}(),
+	}, {
+		name: "valid auth",
+		s: SubscriberSpec{
+			DNSName: &dnsName,
+			Auth: &SubscriberAuth{
+				Type: SubscriberAuthOAuth2,
+				SecretRef: &corev1.LocalObjectReference{
+					Name: "subscriber-auth",
+				},
+			},
+		},
+		want: nil,
+	}, {
+		name: "missing auth type",
+		s: SubscriberSpec{
+			DNSName: &dnsName,
+			Auth: &SubscriberAuth{
+				SecretRef: &corev1.LocalObjectReference{
+					Name: "subscriber-auth",
+				},
+			},
+		},
+		want: func() *apis.FieldError {
+			fe := apis.ErrMissingField("auth.type")
+			return fe
+		}(),
+	}, {
+		name: "invalid auth type",
+		s: SubscriberSpec{
+			DNSName: &dnsName,
+			Auth: &SubscriberAuth{
+				Type: "Digest",
+				SecretRef: &corev1.LocalObjectReference{
+					Name: "subscriber-auth",
+				},
+			},
+		},
+		want: func() *apis.FieldError {
+			fe := apis.ErrInvalidValue("Digest", "auth.type")
+			fe.Details = "only Bearer, Basic and OAuth2 are supported"
+			return fe
+		}(),
+	}, {
+		name: "missing auth secretRef",
+		s: SubscriberSpec{
+			DNSName: &dnsName,
+			Auth: &SubscriberAuth{
+				Type: SubscriberAuthBearer,
+			},
+		},
+		want: func() *apis.FieldError {
+			fe := apis.ErrMissingField("auth.secretRef.name")
+			return fe
+		}(),
 	}}
 
 	for _, test := range tests {
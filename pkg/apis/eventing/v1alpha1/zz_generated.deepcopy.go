@@ -387,6 +387,31 @@ func (in *ReplyStrategy) DeepCopy() *ReplyStrategy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SubscriberAuth) DeepCopyInto(out *SubscriberAuth) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.LocalObjectReference)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SubscriberAuth.
+func (in *SubscriberAuth) DeepCopy() *SubscriberAuth {
+	if in == nil {
+		return nil
+	}
+	out := new(SubscriberAuth)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SubscriberSpec) DeepCopyInto(out *SubscriberSpec) {
 	*out = *in
@@ -408,6 +433,24 @@ func (in *SubscriberSpec) DeepCopyInto(out *SubscriberSpec) {
 			**out = **in
 		}
 	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(SubscriberTLS)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.Auth != nil {
+		in, out := &in.Auth, &out.Auth
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(SubscriberAuth)
+			(*in).DeepCopyInto(*out)
+		}
+	}
 	return
 }
 
@@ -421,6 +464,31 @@ func (in *SubscriberSpec) DeepCopy() *SubscriberSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SubscriberTLS) DeepCopyInto(out *SubscriberTLS) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.LocalObjectReference)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SubscriberTLS.
+func (in *SubscriberTLS) DeepCopy() *SubscriberTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(SubscriberTLS)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Subscription) DeepCopyInto(out *Subscription) {
 	*out = *in
@@ -504,6 +572,24 @@ func (in *SubscriptionSpec) DeepCopyInto(out *SubscriptionSpec) {
 			(*in).DeepCopyInto(*out)
 		}
 	}
+	if in.Delivery != nil {
+		in, out := &in.Delivery, &out.Delivery
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(duck_v1alpha1.DeliverySpec)
+			**out = **in
+		}
+	}
+	if in.Start != nil {
+		in, out := &in.Start, &out.Start
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(duck_v1alpha1.StartSpec)
+			(*in).DeepCopyInto(*out)
+		}
+	}
 	return
 }
 
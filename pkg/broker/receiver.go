@@ -30,6 +30,7 @@ import (
 	"github.com/knative/eventing/pkg/provisioners"
 	"go.uber.org/zap"
 	"k8s.io/apimachinery/pkg/types"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -41,15 +42,18 @@ const (
 
 // Receiver parses Cloud Events, determines if they pass a filter, and sends them to a subscriber.
 type Receiver struct {
-	logger   *zap.Logger
-	client   client.Client
-	ceClient ceclient.Client
-	ceHTTP   *cehttp.Transport
+	logger     *zap.Logger
+	client     client.Client
+	ceClient   ceclient.Client
+	ceHTTP     *cehttp.Transport
+	tlsClients *provisioners.TLSClientCache
+	auth       *provisioners.AuthCache
 }
 
-// New creates a new Receiver and its associated MessageReceiver. The caller is responsible for
-// Start()ing the returned MessageReceiver.
-func New(logger *zap.Logger, client client.Client) (*Receiver, error) {
+// New creates a new Receiver and its associated MessageReceiver. secrets is used to read the TLS
+// and credentials Secrets referenced by Triggers. The caller is responsible for Start()ing the
+// returned MessageReceiver.
+func New(logger *zap.Logger, client client.Client, secrets corev1client.SecretsGetter) (*Receiver, error) {
 	ceHTTP, err := cehttp.New(cehttp.WithBinaryEncoding(), cehttp.WithPort(defaultPort))
 	if err != nil {
 		return nil, err
@@ -59,11 +63,14 @@ func New(logger *zap.Logger, client client.Client) (*Receiver, error) {
 		return nil, err
 	}
 
+	cache := provisioners.NewSecretCache(secrets)
 	r := &Receiver{
-		logger:   logger,
-		client:   client,
-		ceClient: ceClient,
-		ceHTTP:   ceHTTP,
+		logger:     logger,
+		client:     client,
+		ceClient:   ceClient,
+		ceHTTP:     ceHTTP,
+		tlsClients: provisioners.NewTLSClientCache(cache),
+		auth:       provisioners.NewAuthCache(cache),
 	}
 	err = r.initClient()
 	if err != nil {
@@ -181,8 +188,41 @@ func (r *Receiver) sendEvent(ctx context.Context, tctx cehttp.TransportContext,
 		return nil, nil
 	}
 
+	sender, err := r.sender(t)
+	if err != nil {
+		r.logger.Error("Unable to configure the sender", zap.Error(err), zap.Any("triggerRef", trigger))
+		return nil, err
+	}
+
 	sendingCTX := SendingContext(ctx, tctx, subscriberURI)
-	return r.ceHTTP.Send(sendingCTX, *event)
+	if sub := t.Spec.Subscriber; sub != nil && sub.Auth != nil && sub.Auth.SecretRef != nil {
+		authorization, err := r.auth.AuthorizationHeader(t.Namespace, string(sub.Auth.Type), sub.Auth.SecretRef)
+		if err != nil {
+			r.logger.Error("Unable to authenticate to the subscriber", zap.Error(err), zap.Any("triggerRef", trigger))
+			return nil, err
+		}
+		sendingCTX = cehttp.ContextWithHeader(sendingCTX, "Authorization", authorization)
+	}
+	return sender.Send(sendingCTX, *event)
+}
+
+// sender returns the transport used to send events to t's subscriber. Subscribers that configure
+// TLS get a transport using the HTTP client built from their TLS Secret. That client is cached,
+// so connections are still reused across events.
+func (r *Receiver) sender(t *eventingv1alpha1.Trigger) (*cehttp.Transport, error) {
+	if t.Spec.Subscriber == nil || t.Spec.Subscriber.TLS == nil || t.Spec.Subscriber.TLS.SecretRef == nil {
+		return r.ceHTTP, nil
+	}
+	httpClient, err := r.tlsClients.HTTPClient(t.Namespace, t.Spec.Subscriber.TLS.SecretRef)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := cehttp.New(cehttp.WithBinaryEncoding())
+	if err != nil {
+		return nil, err
+	}
+	sender.Client = httpClient
+	return sender, nil
 }
 
 func (r *Receiver) getTrigger(ctx context.Context, ref provisioners.ChannelReference) (*eventingv1alpha1.Trigger, error) {
@@ -30,9 +30,11 @@ import (
 	"github.com/cloudevents/sdk-go/pkg/cloudevents/types"
 	"github.com/google/go-cmp/cmp"
 	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
 	controllertesting "github.com/knative/eventing/pkg/reconciler/testing"
 	"github.com/knative/eventing/pkg/utils"
 	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -46,6 +48,9 @@ const (
 	eventType   = `com.example.someevent`
 	eventSource = `/mycontext`
 
+	tlsSecretName  = "subscriber-tls"
+	authSecretName = "subscriber-auth"
+
 	toBeReplaced = "toBeReplaced"
 )
 
@@ -61,6 +66,7 @@ func init() {
 func TestReceiver(t *testing.T) {
 	testCases := map[string]struct {
 		triggers         []*eventingv1alpha1.Trigger
+		secrets          []*corev1.Secret
 		mocks            controllertesting.Mocks
 		tctx             *cehttp.TransportContext
 		requestFails     bool
@@ -156,6 +162,41 @@ func TestReceiver(t *testing.T) {
 			},
 			expectedDispatch: true,
 		},
+		"Dispatch succeeded - TLS": {
+			triggers: []*eventingv1alpha1.Trigger{
+				makeTriggerWithTLS(),
+			},
+			secrets: []*corev1.Secret{
+				makeTLSSecret(),
+			},
+			expectedDispatch: true,
+		},
+		"TLS Secret does not exist": {
+			triggers: []*eventingv1alpha1.Trigger{
+				makeTriggerWithTLS(),
+			},
+			expectedErr:      true,
+			expectedDispatch: false,
+		},
+		"Dispatch succeeded - Auth": {
+			triggers: []*eventingv1alpha1.Trigger{
+				makeTriggerWithAuth(),
+			},
+			secrets: []*corev1.Secret{
+				makeAuthSecret(),
+			},
+			expectedHeaders: http.Header{
+				"Authorization": []string{"Bearer secret-token"},
+			},
+			expectedDispatch: true,
+		},
+		"Auth Secret does not exist": {
+			triggers: []*eventingv1alpha1.Trigger{
+				makeTriggerWithAuth(),
+			},
+			expectedErr:      true,
+			expectedDispatch: false,
+		},
 		"Returned Cloud Event": {
 			triggers: []*eventingv1alpha1.Trigger{
 				makeTrigger("Any", "Any"),
@@ -217,7 +258,8 @@ func TestReceiver(t *testing.T) {
 
 			r, err := New(
 				zap.NewNop(),
-				getClient(correctURI, tc.mocks))
+				getClient(correctURI, tc.mocks),
+				controllertesting.NewFakeSecrets(tc.secrets...))
 			if tc.expectNewToFail {
 				if err == nil {
 					t.Fatal("Expected New to fail, it didn't")
@@ -342,6 +384,60 @@ func makeTrigger(t, s string) *eventingv1alpha1.Trigger {
 	}
 }
 
+func makeTriggerWithTLS() *eventingv1alpha1.Trigger {
+	t := makeTrigger("Any", "Any")
+	t.Spec.Subscriber = &eventingv1alpha1.SubscriberSpec{
+		TLS: &eventingv1alpha1.SubscriberTLS{
+			SecretRef: &corev1.LocalObjectReference{
+				Name: tlsSecretName,
+			},
+		},
+	}
+	return t
+}
+
+func makeTLSSecret() *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: v1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Namespace: testNS,
+			Name:      tlsSecretName,
+		},
+	}
+}
+
+func makeTriggerWithAuth() *eventingv1alpha1.Trigger {
+	t := makeTrigger("Any", "Any")
+	t.Spec.Subscriber = &eventingv1alpha1.SubscriberSpec{
+		Auth: &eventingv1alpha1.SubscriberAuth{
+			Type: eventingv1alpha1.SubscriberAuthBearer,
+			SecretRef: &corev1.LocalObjectReference{
+				Name: authSecretName,
+			},
+		},
+	}
+	return t
+}
+
+func makeAuthSecret() *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: v1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Namespace: testNS,
+			Name:      authSecretName,
+		},
+		Data: map[string][]byte{
+			provisioners.AuthTokenKey: []byte("secret-token"),
+		},
+	}
+}
+
 func makeTriggerWithoutFilter() *eventingv1alpha1.Trigger {
 	t := makeTrigger("Any", "Any")
 	t.Spec.Filter = nil
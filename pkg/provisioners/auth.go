@@ -0,0 +1,197 @@
+/*
+ * Copyright 2019 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provisioners
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// AuthTokenKey is the key in a Bearer credentials Secret that holds the token.
+	AuthTokenKey = "token"
+	// AuthClientIDKey is the key in an OAuth2 credentials Secret that holds the client ID.
+	AuthClientIDKey = "clientID"
+	// AuthClientSecretKey is the key in an OAuth2 credentials Secret that holds the client secret.
+	AuthClientSecretKey = "clientSecret"
+	// AuthTokenURLKey is the key in an OAuth2 credentials Secret that holds the token endpoint.
+	AuthTokenURLKey = "tokenURL"
+	// AuthScopesKey is the optional key in an OAuth2 credentials Secret that holds the space
+	// separated scopes to request.
+	AuthScopesKey = "scopes"
+
+	authorizationHeaderName = "Authorization"
+
+	tokenRequestTimeout = 30 * time.Second
+)
+
+// authorizer returns the value of the Authorization header sent to a subscriber.
+type authorizer func() (string, error)
+
+// AuthCache builds the Authorization headers sent to subscribers from their credentials Secrets.
+// The authorizers are cached, so OAuth2 tokens are reused until they are about to expire. A
+// cached authorizer is rebuilt whenever its Secret's resourceVersion changes.
+type AuthCache struct {
+	secrets *SecretCache
+
+	authorizersLock sync.Mutex
+	authorizers     map[authKey]*cachedAuthorizer
+}
+
+type authKey struct {
+	secret   types.NamespacedName
+	authType string
+}
+
+type cachedAuthorizer struct {
+	resourceVersion string
+	authorize       authorizer
+}
+
+// NewAuthCache creates an AuthCache that reads Secrets from secrets.
+func NewAuthCache(secrets *SecretCache) *AuthCache {
+	return &AuthCache{
+		secrets:     secrets,
+		authorizers: make(map[authKey]*cachedAuthorizer),
+	}
+}
+
+// AuthorizationHeader returns the value of the Authorization header for the credentials of type
+// authType held in the Secret referenced by ref, in namespace.
+func (c *AuthCache) AuthorizationHeader(namespace, authType string, ref *corev1.LocalObjectReference) (string, error) {
+	key := authKey{
+		secret:   types.NamespacedName{Namespace: namespace, Name: ref.Name},
+		authType: authType,
+	}
+	if c == nil {
+		return "", fmt.Errorf("unable to load credentials Secret %v: %v", key.secret, errNoSecrets)
+	}
+	secret, err := c.secrets.Get(key.secret)
+	if err != nil {
+		return "", fmt.Errorf("unable to get credentials Secret %v: %v", key.secret, err)
+	}
+
+	authorize, err := c.authorizer(key, secret)
+	if err != nil {
+		return "", fmt.Errorf("invalid credentials Secret %v: %v", key.secret, err)
+	}
+	// Called outside of the lock, as fetching an OAuth2 token may take a while.
+	return authorize()
+}
+
+func (c *AuthCache) authorizer(key authKey, secret *corev1.Secret) (authorizer, error) {
+	c.authorizersLock.Lock()
+	defer c.authorizersLock.Unlock()
+	if cached, present := c.authorizers[key]; present && cached.resourceVersion == secret.ResourceVersion {
+		return cached.authorize, nil
+	}
+	authorize, err := newAuthorizer(key.authType, secret)
+	if err != nil {
+		return nil, err
+	}
+	c.authorizers[key] = &cachedAuthorizer{
+		resourceVersion: secret.ResourceVersion,
+		authorize:       authorize,
+	}
+	return authorize, nil
+}
+
+// ValidateAuthSecret checks that secret holds the credentials required by authType. It does not
+// contact the OAuth2 token endpoint.
+func ValidateAuthSecret(authType string, secret *corev1.Secret) error {
+	_, err := newAuthorizer(authType, secret)
+	return err
+}
+
+func newAuthorizer(authType string, secret *corev1.Secret) (authorizer, error) {
+	switch eventingv1alpha1.SubscriberAuthType(authType) {
+	case eventingv1alpha1.SubscriberAuthBearer:
+		token, err := secretValue(secret, AuthTokenKey)
+		if err != nil {
+			return nil, err
+		}
+		return func() (string, error) {
+			return "Bearer " + token, nil
+		}, nil
+
+	case eventingv1alpha1.SubscriberAuthBasic:
+		username, err := secretValue(secret, corev1.BasicAuthUsernameKey)
+		if err != nil {
+			return nil, err
+		}
+		password, err := secretValue(secret, corev1.BasicAuthPasswordKey)
+		if err != nil {
+			return nil, err
+		}
+		credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		return func() (string, error) {
+			return "Basic " + credentials, nil
+		}, nil
+
+	case eventingv1alpha1.SubscriberAuthOAuth2:
+		conf := &clientcredentials.Config{
+			Scopes: strings.Fields(string(secret.Data[AuthScopesKey])),
+		}
+		var err error
+		if conf.ClientID, err = secretValue(secret, AuthClientIDKey); err != nil {
+			return nil, err
+		}
+		if conf.ClientSecret, err = secretValue(secret, AuthClientSecretKey); err != nil {
+			return nil, err
+		}
+		if conf.TokenURL, err = secretValue(secret, AuthTokenURLKey); err != nil {
+			return nil, err
+		}
+		if u, err := url.Parse(conf.TokenURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("%q must be an absolute HTTP(S) URL", AuthTokenURLKey)
+		}
+		// The token endpoint is called with the HTTP client of the context, and only when the
+		// cached token is about to expire.
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: tokenRequestTimeout})
+		ts := conf.TokenSource(ctx)
+		return func() (string, error) {
+			token, err := ts.Token()
+			if err != nil {
+				return "", fmt.Errorf("unable to get an OAuth2 token: %v", err)
+			}
+			return token.Type() + " " + token.AccessToken, nil
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported authentication type %q", authType)
+	}
+}
+
+func secretValue(secret *corev1.Secret, key string) (string, error) {
+	value := secret.Data[key]
+	if len(value) == 0 {
+		return "", fmt.Errorf("missing %q", key)
+	}
+	return string(value), nil
+}
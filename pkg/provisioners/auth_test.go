@@ -0,0 +1,324 @@
+/*
+ * Copyright 2019 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provisioners
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	controllertesting "github.com/knative/eventing/pkg/reconciler/testing"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	authSecretNamespace = "test-namespace"
+	authSecretName      = "subscriber-auth"
+)
+
+func TestValidateAuthSecret(t *testing.T) {
+	testCases := map[string]struct {
+		authType    string
+		data        map[string][]byte
+		expectedErr bool
+	}{
+		"bearer": {
+			authType: "Bearer",
+			data: map[string][]byte{
+				AuthTokenKey: []byte("token"),
+			},
+		},
+		"bearer without token": {
+			authType:    "Bearer",
+			expectedErr: true,
+		},
+		"basic": {
+			authType: "Basic",
+			data: map[string][]byte{
+				corev1.BasicAuthUsernameKey: []byte("user"),
+				corev1.BasicAuthPasswordKey: []byte("pass"),
+			},
+		},
+		"basic without password": {
+			authType: "Basic",
+			data: map[string][]byte{
+				corev1.BasicAuthUsernameKey: []byte("user"),
+			},
+			expectedErr: true,
+		},
+		"oauth2": {
+			authType: "OAuth2",
+			data: map[string][]byte{
+				AuthClientIDKey:     []byte("id"),
+				AuthClientSecretKey: []byte("secret"),
+				AuthTokenURLKey:     []byte("https://auth.example.com/token"),
+			},
+		},
+		"oauth2 without client secret": {
+			authType: "OAuth2",
+			data: map[string][]byte{
+				AuthClientIDKey: []byte("id"),
+				AuthTokenURLKey: []byte("https://auth.example.com/token"),
+			},
+			expectedErr: true,
+		},
+		"oauth2 with relative token URL": {
+			authType: "OAuth2",
+			data: map[string][]byte{
+				AuthClientIDKey:     []byte("id"),
+				AuthClientSecretKey: []byte("secret"),
+				AuthTokenURLKey:     []byte("/token"),
+			},
+			expectedErr: true,
+		},
+		"unknown type": {
+			authType: "Digest",
+			data: map[string][]byte{
+				AuthTokenKey: []byte("token"),
+			},
+			expectedErr: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			err := ValidateAuthSecret(tc.authType, &corev1.Secret{Data: tc.data})
+			if tc.expectedErr != (err != nil) {
+				t.Errorf("Unexpected error. Expected %v. Actual %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestDispatchMessageWithAuth(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenRequests, 1)
+		if id, secret, ok := r.BasicAuth(); !ok || id != "id" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("token-%s", r.PostForm.Get("scope")),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	defer tokenServer.Close()
+
+	var authorization atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization.Store(r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	testCases := map[string]struct {
+		authType              string
+		data                  map[string][]byte
+		noSecret              bool
+		expectedAuthorization string
+		expectedErr           bool
+	}{
+		"bearer": {
+			authType: "Bearer",
+			data: map[string][]byte{
+				AuthTokenKey: []byte("secret-token"),
+			},
+			expectedAuthorization: "Bearer secret-token",
+		},
+		"basic": {
+			authType: "Basic",
+			data: map[string][]byte{
+				corev1.BasicAuthUsernameKey: []byte("user"),
+				corev1.BasicAuthPasswordKey: []byte("pass"),
+			},
+			expectedAuthorization: "Basic dXNlcjpwYXNz",
+		},
+		"oauth2": {
+			authType: "OAuth2",
+			data: map[string][]byte{
+				AuthClientIDKey:     []byte("id"),
+				AuthClientSecretKey: []byte("secret"),
+				AuthTokenURLKey:     []byte(tokenServer.URL),
+				AuthScopesKey:       []byte("events"),
+			},
+			expectedAuthorization: "Bearer token-events",
+		},
+		"oauth2 rejected client": {
+			authType: "OAuth2",
+			data: map[string][]byte{
+				AuthClientIDKey:     []byte("id"),
+				AuthClientSecretKey: []byte("wrong"),
+				AuthTokenURLKey:     []byte(tokenServer.URL),
+			},
+			expectedErr: true,
+		},
+		"secret does not exist": {
+			authType:    "Bearer",
+			noSecret:    true,
+			expectedErr: true,
+		},
+		"invalid secret": {
+			authType:    "Bearer",
+			data:        map[string][]byte{},
+			expectedErr: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			authorization.Store("")
+			fakeSecrets := controllertesting.NewFakeSecrets()
+			if !tc.noSecret {
+				fakeSecrets = controllertesting.NewFakeSecrets(makeAuthSecret("1", tc.data))
+			}
+			defaults := DispatchDefaults{
+				Namespace: authSecretNamespace,
+				AuthType:  tc.authType,
+				AuthSecretRef: &corev1.LocalObjectReference{
+					Name: authSecretName,
+				},
+			}
+
+			md := NewMessageDispatcher(zap.NewNop().Sugar(), WithSecretClient(fakeSecrets))
+			err := md.DispatchMessage(&Message{Payload: []byte("payload")}, server.URL, "", defaults)
+			if tc.expectedErr != (err != nil) {
+				t.Errorf("Unexpected error from DispatchMessage. Expected %v. Actual: %v", tc.expectedErr, err)
+			}
+			if actual := authorization.Load().(string); actual != tc.expectedAuthorization {
+				t.Errorf("Unexpected Authorization header. Expected %q. Actual %q", tc.expectedAuthorization, actual)
+			}
+		})
+	}
+}
+
+func TestDispatchMessageWithAuth_NoSecretClient(t *testing.T) {
+	md := NewMessageDispatcher(zap.NewNop().Sugar())
+	defaults := DispatchDefaults{
+		Namespace: authSecretNamespace,
+		AuthType:  "Bearer",
+		AuthSecretRef: &corev1.LocalObjectReference{
+			Name: authSecretName,
+		},
+	}
+	if err := md.DispatchMessage(&Message{}, "http://example.com", "", defaults); err == nil {
+		t.Errorf("Expected an error, actually nil")
+	}
+}
+
+func TestAuthCache(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&tokenRequests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("token-%d", n),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	defer tokenServer.Close()
+
+	data := map[string][]byte{
+		AuthClientIDKey:     []byte("id"),
+		AuthClientSecretKey: []byte("secret"),
+		AuthTokenURLKey:     []byte(tokenServer.URL),
+	}
+	fakeSecrets := controllertesting.NewFakeSecrets(makeAuthSecret("1", data))
+	secrets := NewSecretCache(fakeSecrets)
+	cache := NewAuthCache(secrets)
+	ref := &corev1.LocalObjectReference{
+		Name: authSecretName,
+	}
+
+	for i := 0; i < 2; i++ {
+		header, err := cache.AuthorizationHeader(authSecretNamespace, "OAuth2", ref)
+		if err != nil {
+			t.Fatalf("Unexpected error getting the Authorization header: %v", err)
+		}
+		if header != "Bearer token-1" {
+			t.Errorf("Expected the cached token to be reused. Actual %q", header)
+		}
+	}
+
+	fakeSecrets.Update(makeAuthSecret("2", data))
+	waitForSecret(t, secrets, makeAuthSecret("2", data))
+	header, err := cache.AuthorizationHeader(authSecretNamespace, "OAuth2", ref)
+	if err != nil {
+		t.Fatalf("Unexpected error getting the Authorization header: %v", err)
+	}
+	if header != "Bearer token-2" {
+		t.Errorf("Expected a new token after the Secret changed. Actual %q", header)
+	}
+}
+
+func TestOAuth2Authorizer_Expiry(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&tokenRequests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("token-%d", n),
+			"token_type":   "Bearer",
+			// Expires within the refresh margin, so it is never reused.
+			"expires_in": 1,
+		})
+	}))
+	defer tokenServer.Close()
+
+	authorize, err := newAuthorizer("OAuth2", &corev1.Secret{Data: map[string][]byte{
+		AuthClientIDKey:     []byte("id"),
+		AuthClientSecretKey: []byte("secret"),
+		AuthTokenURLKey:     []byte(tokenServer.URL),
+	}})
+	if err != nil {
+		t.Fatalf("Unexpected error creating the authorizer: %v", err)
+	}
+	for i := 1; i <= 2; i++ {
+		header, err := authorize()
+		if err != nil {
+			t.Fatalf("Unexpected error getting the Authorization header: %v", err)
+		}
+		if expected := fmt.Sprintf("Bearer token-%d", i); header != expected {
+			t.Errorf("Unexpected Authorization header. Expected %q. Actual %q", expected, header)
+		}
+	}
+}
+
+func makeAuthSecret(resourceVersion string, data map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       authSecretNamespace,
+			Name:            authSecretName,
+			ResourceVersion: resourceVersion,
+		},
+		Data: data,
+	}
+}
@@ -38,6 +38,15 @@ const (
 	OldEventingProvisionerLabel = "provisioner"
 )
 
+const (
+	// AddressingModeAnnotation is the Channel annotation that selects how the Channel is addressed.
+	AddressingModeAnnotation = "eventing.knative.dev/addressingMode"
+	// PathAddressingMode addresses the Channel by the path /<namespace>/<channel> on its
+	// provisioner's dispatcher Service, instead of by its own hostname. This does not rely on the
+	// Host header, so it works with IPs, port-forwarding, external gateways and non-Istio ingress.
+	PathAddressingMode = "path"
+)
+
 // AddFinalizerResult is used indicate whether a finalizer was added or already present.
 type AddFinalizerResult bool
 
@@ -70,6 +79,17 @@ func CreateK8sService(ctx context.Context, client runtimeClient.Client, c *event
 	return createK8sService(ctx, client, getSvc, newK8sService(c))
 }
 
+// ChannelAddressHostName returns the hostname to set as the Channel's status Address. Channels are
+// addressed by their K8s Service, unless they are annotated for path addressing, in which case the
+// hostname is the provisioner's dispatcher Service followed by the Channel's path.
+func ChannelAddressHostName(c *eventingv1alpha1.Channel, svc *corev1.Service) string {
+	if c.Annotations[AddressingModeAnnotation] == PathAddressingMode {
+		dispatcherHost := names.ServiceHostName(channelDispatcherServiceName(c.Spec.Provisioner.Name), system.Namespace())
+		return fmt.Sprintf("%s/%s/%s", dispatcherHost, c.Namespace, c.Name)
+	}
+	return names.ServiceHostName(svc.Name, svc.Namespace)
+}
+
 func getK8sService(ctx context.Context, client runtimeClient.Client, c *eventingv1alpha1.Channel) (*corev1.Service, error) {
 	list := &corev1.ServiceList{}
 	opts := &runtimeClient.ListOptions{
@@ -25,6 +25,7 @@ import (
 
 	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
 	"github.com/knative/eventing/pkg/utils"
+	"github.com/knative/pkg/system"
 	_ "github.com/knative/pkg/system/testing"
 )
 
@@ -438,6 +439,23 @@ func TestChannelNames(t *testing.T) {
 	}
 }
 
+func TestChannelAddressHostName(t *testing.T) {
+	svc := makeK8sService()
+	svc.Name = "test-channel-channel-abcde"
+
+	hostAddressed := getNewChannel()
+	if got, want := ChannelAddressHostName(hostAddressed, svc), "test-channel-channel-abcde.test-namespace.svc."+utils.GetClusterDomainName(); got != want {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	pathAddressed := getNewChannel()
+	pathAddressed.Annotations = map[string]string{AddressingModeAnnotation: PathAddressingMode}
+	want := fmt.Sprintf("%s-dispatcher.%s.svc.%s/%s/%s", clusterChannelProvisionerName, system.Namespace(), utils.GetClusterDomainName(), testNS, channelName)
+	if got := ChannelAddressHostName(pathAddressed, svc); got != want {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
 func TestExpectedLabelsPresent(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -18,6 +18,8 @@ package channel
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
@@ -31,7 +33,6 @@ import (
 	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
 	util "github.com/knative/eventing/pkg/provisioners"
 	ccpcontroller "github.com/knative/eventing/pkg/provisioners/inmemory/clusterchannelprovisioner"
-	"github.com/knative/eventing/pkg/reconciler/names"
 	"github.com/knative/eventing/pkg/sidecar/configmap"
 	"github.com/knative/eventing/pkg/sidecar/fanout"
 	"github.com/knative/eventing/pkg/sidecar/multichannelfanout"
@@ -45,10 +46,21 @@ const (
 	channelConfigSyncFailed    = "ChannelConfigSyncFailed"
 	k8sServiceCreateFailed     = "K8sServiceCreateFailed"
 	virtualServiceCreateFailed = "VirtualServiceCreateFailed"
+	channelArgumentsInvalid    = "ChannelArgumentsInvalid"
 	// TODO after in-memory-channel is retired, asyncProvisionerName should be removed
 	defaultProvisionerName = "in-memory-channel"
 )
 
+// channelArgs are the arguments of in-memory Channels.
+type channelArgs struct {
+	// Ordering is the order in which messages are delivered to each subscriber, either
+	// "subscriber" or "partitionKey". By default, messages are delivered in no particular order.
+	Ordering fanout.Ordering `json:"ordering,omitempty"`
+	// PartitionKeyExtension is the CloudEvents extension holding the partition key, when Ordering
+	// is "partitionKey". It defaults to "partitionkey".
+	PartitionKeyExtension string `json:"partitionKeyExtension,omitempty"`
+}
+
 type reconciler struct {
 	client   client.Client
 	recorder record.EventRecorder
@@ -117,6 +129,11 @@ func (r *reconciler) Reconcile(request reconcile.Request) (reconcile.Result, err
 // shouldReconcile determines if this Controller should control (and therefore reconcile) a given
 // ClusterChannelProvisioner. This Controller only handles in-memory channels.
 func (r *reconciler) shouldReconcile(c *eventingv1alpha1.Channel) bool {
+	return IsControlled(c)
+}
+
+// IsControlled returns true if c is an in-memory channel.
+func IsControlled(c *eventingv1alpha1.Channel) bool {
 	if c.Spec.Provisioner != nil {
 		return ccpcontroller.IsControlled(c.Spec.Provisioner)
 	}
@@ -149,15 +166,22 @@ func (r *reconciler) reconcile(ctx context.Context, c *eventingv1alpha1.Channel)
 
 	util.AddFinalizer(c, finalizerName)
 
+	if _, err := unmarshalArguments(c); err != nil {
+		logger.Info("Invalid Channel arguments", zap.Error(err))
+		r.recorder.Eventf(c, corev1.EventTypeWarning, channelArgumentsInvalid, "Invalid Channel arguments: %v", err)
+		c.Status.MarkNotProvisioned(channelArgumentsInvalid, "Invalid Channel arguments: %v", err)
+		return err
+	}
+
 	svc, err := util.CreateK8sService(ctx, r.client, c)
 	if err != nil {
 		logger.Info("Error creating the Channel's K8s Service", zap.Error(err))
 		r.recorder.Eventf(c, corev1.EventTypeWarning, k8sServiceCreateFailed, "Failed to reconcile Channel's K8s Service: %v", err)
 		return err
 	}
-	c.Status.SetAddress(names.ServiceHostName(svc.Name, svc.Namespace))
 
 	if c.Spec.Provisioner.Name == defaultProvisionerName {
+		c.Status.SetAddress(util.ChannelAddressHostName(c, svc))
 		_, err = util.CreateVirtualService(ctx, r.client, c, svc)
 		if err != nil {
 			logger.Info("Error creating the Virtual Service for the Channel", zap.Error(err))
@@ -171,6 +195,7 @@ func (r *reconciler) reconcile(ctx context.Context, c *eventingv1alpha1.Channel)
 		// dispatcher Service's name.
 		cCopy := c.DeepCopy()
 		cCopy.Spec.Provisioner.Name = defaultProvisionerName
+		c.Status.SetAddress(util.ChannelAddressHostName(cCopy, svc))
 		_, err = util.CreateVirtualService(ctx, r.client, cCopy, svc)
 		if err != nil {
 			logger.Info("Error creating the Virtual Service for the Channel", zap.Error(err))
@@ -189,7 +214,7 @@ func (r *reconciler) syncChannelConfig(ctx context.Context) error {
 		r.logger.Info("Unable to list channels", zap.Error(err))
 		return err
 	}
-	config := multiChannelFanoutConfig(channels)
+	config := MultiChannelFanoutConfig(channels)
 	return r.writeConfigMap(ctx, config)
 }
 
@@ -232,29 +257,46 @@ func (r *reconciler) createNewConfigMap(data map[string]string) *corev1.ConfigMa
 	}
 }
 
-func multiChannelFanoutConfig(channels []eventingv1alpha1.Channel) *multichannelfanout.Config {
-	cc := make([]multichannelfanout.ChannelConfig, 0)
-	for _, c := range channels {
-		channelConfig := multichannelfanout.ChannelConfig{
-			Namespace: c.Namespace,
-			Name:      c.Name,
+// MultiChannelFanoutConfig creates the dispatcher's configuration for the in-memory channels.
+func MultiChannelFanoutConfig(channels []eventingv1alpha1.Channel) *multichannelfanout.Config {
+	config := multichannelfanout.NewConfigFromChannels(channels)
+	for i, c := range channels {
+		if c.Spec.Subscribable == nil {
+			continue
 		}
-		if c.Spec.Subscribable != nil {
-			// TODO After in-memory-channel is retired, this logic must be refactored.
-			asyncHandler := false
-			if c.Spec.Provisioner.Name != defaultProvisionerName {
-				asyncHandler = true
-			}
-			channelConfig.FanoutConfig = fanout.Config{
-				Subscriptions: c.Spec.Subscribable.Subscribers,
-				AsyncHandler:  asyncHandler,
-			}
+		// TODO After in-memory-channel is retired, this logic must be refactored.
+		if c.Spec.Provisioner.Name != defaultProvisionerName {
+			config.ChannelConfigs[i].FanoutConfig.AsyncHandler = true
+		}
+		// Invalid arguments are reported on the Channel by the controller. Until they are fixed,
+		// the Channel is dispatched without them.
+		if args, err := unmarshalArguments(&c); err == nil {
+			config.ChannelConfigs[i].FanoutConfig.Ordering = args.Ordering
+			config.ChannelConfigs[i].FanoutConfig.PartitionKeyExtension = args.PartitionKeyExtension
 		}
-		cc = append(cc, channelConfig)
 	}
-	return &multichannelfanout.Config{
-		ChannelConfigs: cc,
+	return config
+}
+
+// unmarshalArguments parses and validates the arguments of c.
+func unmarshalArguments(c *eventingv1alpha1.Channel) (channelArgs, error) {
+	var args channelArgs
+	if c.Spec.Arguments == nil || len(c.Spec.Arguments.Raw) == 0 {
+		return args, nil
+	}
+	if err := json.Unmarshal(c.Spec.Arguments.Raw, &args); err != nil {
+		return args, fmt.Errorf("error unmarshalling arguments: %s", err)
+	}
+	switch args.Ordering {
+	case fanout.OrderingNone, fanout.OrderingSubscriber:
+		if args.PartitionKeyExtension != "" {
+			return args, fmt.Errorf("partitionKeyExtension requires ordering %q", fanout.OrderingPartitionKey)
+		}
+	case fanout.OrderingPartitionKey:
+	default:
+		return args, fmt.Errorf("unknown ordering %q, must be %q or %q", args.Ordering, fanout.OrderingSubscriber, fanout.OrderingPartitionKey)
 	}
+	return args, nil
 }
 
 func (r *reconciler) listAllChannels(ctx context.Context) ([]eventingv1alpha1.Channel, error) {
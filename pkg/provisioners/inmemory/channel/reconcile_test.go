@@ -188,6 +188,7 @@ var (
 		channelConfigSyncFailed:    {Reason: channelConfigSyncFailed, Type: corev1.EventTypeWarning},
 		k8sServiceCreateFailed:     {Reason: k8sServiceCreateFailed, Type: corev1.EventTypeWarning},
 		virtualServiceCreateFailed: {Reason: virtualServiceCreateFailed, Type: corev1.EventTypeWarning},
+		channelArgumentsInvalid:    {Reason: channelArgumentsInvalid, Type: corev1.EventTypeWarning},
 	}
 )
 
@@ -332,6 +333,20 @@ func TestReconcile(t *testing.T) {
 				events[channelConfigSyncFailed],
 			},
 		},
+		{
+			Name: "Channel arguments invalid",
+			InitialState: []runtime.Object{
+				makeChannelWithArguments(`{"ordering":"random"}`),
+				makeConfigMap(),
+			},
+			WantPresent: []runtime.Object{
+				makeChannelWithInvalidArguments(`{"ordering":"random"}`),
+			},
+			WantErrMsg: `unknown ordering "random", must be "subscriber" or "partitionKey"`,
+			WantEvent: []corev1.Event{
+				events[channelArgumentsInvalid],
+			},
+		},
 		{
 			Name: "K8s service get fails",
 			InitialState: []runtime.Object{
@@ -638,6 +653,20 @@ func makeChannelWithWrongProvisionerName() *eventingv1alpha1.Channel {
 	return c
 }
 
+func makeChannelWithArguments(args string) *eventingv1alpha1.Channel {
+	c := makeChannel()
+	c.Spec.Arguments = &runtime.RawExtension{Raw: []byte(args)}
+	return c
+}
+
+func makeChannelWithInvalidArguments(args string) *eventingv1alpha1.Channel {
+	c := makeChannelWithArguments(args)
+	c.Finalizers = []string{finalizerName}
+	_, err := unmarshalArguments(c)
+	c.Status.MarkNotProvisioned(channelArgumentsInvalid, "Invalid Channel arguments: %v", err)
+	return c
+}
+
 func makeChannelWithFinalizer() *eventingv1alpha1.Channel {
 	c := makeChannel()
 	c.Finalizers = []string{finalizerName}
@@ -936,3 +965,70 @@ func verifyConfigMapData(expected multichannelfanout.Config) []controllertesting
 		},
 	}
 }
+
+func TestMultiChannelFanoutConfig(t *testing.T) {
+	subscribable := &eventingduck.Subscribable{
+		Subscribers: []eventingduck.ChannelSubscriberSpec{
+			{
+				SubscriberURI: "foo",
+			},
+		},
+	}
+	testCases := map[string]struct {
+		provisioner string
+		args        string
+		expected    fanout.Config
+	}{
+		"no arguments": {
+			provisioner: ccpName,
+			expected: fanout.Config{
+				Subscriptions: subscribable.Subscribers,
+			},
+		},
+		"async": {
+			provisioner: asyncCCPName,
+			expected: fanout.Config{
+				Subscriptions: subscribable.Subscribers,
+				AsyncHandler:  true,
+			},
+		},
+		"ordered per subscriber": {
+			provisioner: ccpName,
+			args:        `{"ordering": "subscriber"}`,
+			expected: fanout.Config{
+				Subscriptions: subscribable.Subscribers,
+				Ordering:      fanout.OrderingSubscriber,
+			},
+		},
+		"ordered per partition key": {
+			provisioner: asyncCCPName,
+			args:        `{"ordering": "partitionKey", "partitionKeyExtension": "customerid"}`,
+			expected: fanout.Config{
+				Subscriptions:         subscribable.Subscribers,
+				AsyncHandler:          true,
+				Ordering:              fanout.OrderingPartitionKey,
+				PartitionKeyExtension: "customerid",
+			},
+		},
+		"invalid arguments are ignored": {
+			provisioner: ccpName,
+			args:        `{"ordering": "subscriber", "partitionKeyExtension": "customerid"}`,
+			expected: fanout.Config{
+				Subscriptions: subscribable.Subscribers,
+			},
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			c := makeChannel(tc.provisioner)
+			c.Spec.Subscribable = subscribable
+			if tc.args != "" {
+				c.Spec.Arguments = &runtime.RawExtension{Raw: []byte(tc.args)}
+			}
+			config := MultiChannelFanoutConfig([]eventingv1alpha1.Channel{*c})
+			if diff := cmp.Diff(tc.expected, config.ChannelConfigs[0].FanoutConfig); diff != "" {
+				t.Errorf("Unexpected fanout config (-want, +got): %s", diff)
+			}
+		})
+	}
+}
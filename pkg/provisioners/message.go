@@ -18,6 +18,7 @@ package provisioners
 
 import (
 	"errors"
+	"net/url"
 	"regexp"
 	"strings"
 )
@@ -27,6 +28,15 @@ const (
 	// This is an experimental header: https://github.com/knative/eventing/issues/638
 	MessageHistoryHeader    = "ce-knativehistory"
 	MessageHistorySeparator = "; "
+
+	// FailedSubscribersHeader is the response header listing, comma separated, the subscribers
+	// that did not receive a message only delivered to some of the Channel's subscribers.
+	FailedSubscribersHeader = "knative-failed-subscribers"
+	// SubscribersHeader is the request header restricting the delivery of a message to the
+	// subscribers it lists, comma separated. Senders retrying a message refused with
+	// FailedSubscribersHeader copy its value here, so that the subscribers that already received
+	// the message do not receive it again. The header is not sent to the subscribers.
+	SubscribersHeader = "knative-subscribers"
 )
 
 var historySplitter = regexp.MustCompile(`\s*` + regexp.QuoteMeta(MessageHistorySeparator) + `\s*`)
@@ -66,6 +76,53 @@ type Message struct {
 // channel that does not exist.
 var ErrUnknownChannel = errors.New("unknown channel")
 
+// ErrQueueFull is returned when a message is received by a channel dispatcher that can not accept
+// more messages until it delivered some of those already queued.
+var ErrQueueFull = errors.New("queue full")
+
+// ErrUnavailable is returned when a message is received by a channel dispatcher that can not
+// persist it right now, for instance because its backing store is not reachable. The sender should
+// retry later.
+var ErrUnavailable = errors.New("temporarily unavailable")
+
+// ErrMessageTooLarge is returned when a message is received by a channel dispatcher whose backing
+// store refuses it because of its size. Sending it again does not help.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// ErrInvalidMessage is returned when a message is received by a channel dispatcher whose backing
+// store refuses it as invalid. Sending it again does not help.
+var ErrInvalidMessage = errors.New("invalid message")
+
+// PartialFailure is the error returned by a receiver function that delivered a message to some of
+// the Channel's subscribers only.
+type PartialFailure interface {
+	error
+	// FailedSubscribers returns the subscribers that did not receive the message, as listed in
+	// FailedSubscribersHeader and SubscribersHeader.
+	FailedSubscribers() []string
+}
+
+// FormatSubscribers encodes keys as the value of FailedSubscribersHeader or SubscribersHeader.
+func FormatSubscribers(keys []string) string {
+	escaped := make([]string, 0, len(keys))
+	for _, k := range keys {
+		escaped = append(escaped, url.QueryEscape(k))
+	}
+	return strings.Join(escaped, ",")
+}
+
+// ParseSubscribers decodes the keys listed in the value of FailedSubscribersHeader or
+// SubscribersHeader.
+func ParseSubscribers(value string) []string {
+	keys := make([]string, 0)
+	for _, escaped := range strings.Split(value, ",") {
+		if k, err := url.QueryUnescape(strings.TrimSpace(escaped)); err == nil && k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
 // History returns the list of hosts where the message has been into
 func (m *Message) History() []string {
 	if m.Headers == nil {
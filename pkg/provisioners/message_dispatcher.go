@@ -18,6 +18,7 @@ package provisioners
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -26,7 +27,9 @@ import (
 	"strings"
 
 	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 
 	"github.com/knative/eventing/pkg/utils"
 )
@@ -42,8 +45,15 @@ type Dispatcher interface {
 	DispatchMessage(message *Message, destination, reply string, defaults DispatchDefaults) error
 }
 
+// ContextDispatcher is a Dispatcher whose requests can be cancelled.
+type ContextDispatcher interface {
+	Dispatcher
+	// DispatchMessageWithContext is DispatchMessage, abandoning the requests when ctx is done.
+	DispatchMessageWithContext(ctx context.Context, message *Message, destination, reply string, defaults DispatchDefaults) error
+}
+
 // MessageDispatcher is the 'real' Dispatcher used everywhere except unit tests.
-var _ Dispatcher = &MessageDispatcher{}
+var _ ContextDispatcher = &MessageDispatcher{}
 
 // MessageDispatcher dispatches messages to a destination over HTTP.
 type MessageDispatcher struct {
@@ -51,25 +61,54 @@ type MessageDispatcher struct {
 	forwardHeaders   sets.String
 	forwardPrefixes  []string
 	supportedSchemes sets.String
+	tlsClients       *TLSClientCache
+	auth             *AuthCache
 
 	logger *zap.SugaredLogger
 }
 
 // DispatchDefaults provides default parameter values used when dispatching a message.
 type DispatchDefaults struct {
+	// Namespace is the namespace of the Channel the message is dispatched for. Destinations with a
+	// single label are expanded in it, and the Secrets referenced below are read from it.
 	Namespace string
+	// TLSSecretRef, if set, references the Secret holding the TLS material used to connect to
+	// the destination. It is not used for the reply.
+	TLSSecretRef *corev1.LocalObjectReference
+	// AuthType and AuthSecretRef, if set, select the credentials used to authenticate to the
+	// destination. They are not used for the reply.
+	AuthType      string
+	AuthSecretRef *corev1.LocalObjectReference
+}
+
+// DispatcherOption configures a MessageDispatcher.
+type DispatcherOption func(*MessageDispatcher)
+
+// WithSecretClient lets the MessageDispatcher read the TLS and credentials Secrets referenced by
+// DispatchDefaults.TLSSecretRef and DispatchDefaults.AuthSecretRef using secrets. Each Secret is
+// watched by name once it is used, so secrets only needs the get and watch verbs.
+func WithSecretClient(secrets corev1client.SecretsGetter) DispatcherOption {
+	return func(d *MessageDispatcher) {
+		cache := NewSecretCache(secrets)
+		d.tlsClients = NewTLSClientCache(cache)
+		d.auth = NewAuthCache(cache)
+	}
 }
 
 // NewMessageDispatcher creates a new message dispatcher that can dispatch
 // messages to HTTP destinations.
-func NewMessageDispatcher(logger *zap.SugaredLogger) *MessageDispatcher {
-	return &MessageDispatcher{
+func NewMessageDispatcher(logger *zap.SugaredLogger, opts ...DispatcherOption) *MessageDispatcher {
+	d := &MessageDispatcher{
 		httpClient:       &http.Client{},
 		forwardHeaders:   sets.NewString(forwardHeaders...),
 		forwardPrefixes:  forwardPrefixes,
 		supportedSchemes: sets.NewString("http", "https"),
 		logger:           logger,
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
 }
 
 // DispatchMessage dispatches a message to a destination over HTTP.
@@ -78,13 +117,32 @@ func NewMessageDispatcher(logger *zap.SugaredLogger) *MessageDispatcher {
 // the default namespace is used to expand it into a fully qualified name
 // within the cluster.
 func (d *MessageDispatcher) DispatchMessage(message *Message, destination, reply string, defaults DispatchDefaults) error {
+	return d.DispatchMessageWithContext(context.Background(), message, destination, reply, defaults)
+}
+
+// DispatchMessageWithContext is DispatchMessage, abandoning the requests when ctx is done.
+func (d *MessageDispatcher) DispatchMessageWithContext(ctx context.Context, message *Message, destination, reply string, defaults DispatchDefaults) error {
 	var err error
 	// Default to replying with the original message. If there is a destination, then replace it
 	// with the response from the call to the destination instead.
 	response := message
 	if destination != "" {
+		httpClient := d.httpClient
+		if defaults.TLSSecretRef != nil {
+			httpClient, err = d.tlsClients.HTTPClient(defaults.Namespace, defaults.TLSSecretRef)
+			if err != nil {
+				return fmt.Errorf("Unable to configure TLS %v", err)
+			}
+		}
+		authorization := ""
+		if defaults.AuthSecretRef != nil {
+			authorization, err = d.auth.AuthorizationHeader(defaults.Namespace, defaults.AuthType, defaults.AuthSecretRef)
+			if err != nil {
+				return fmt.Errorf("Unable to authenticate %v", err)
+			}
+		}
 		destinationURL := d.resolveURL(destination, defaults.Namespace)
-		response, err = d.executeRequest(destinationURL, message)
+		response, err = d.executeRequest(ctx, httpClient, destinationURL, authorization, message)
 		if err != nil {
 			return fmt.Errorf("Unable to complete request %v", err)
 		}
@@ -92,7 +150,7 @@ func (d *MessageDispatcher) DispatchMessage(message *Message, destination, reply
 
 	if reply != "" && response != nil {
 		replyURL := d.resolveURL(reply, defaults.Namespace)
-		_, err = d.executeRequest(replyURL, response)
+		_, err = d.executeRequest(ctx, d.httpClient, replyURL, "", response)
 		if err != nil {
 			return fmt.Errorf("Failed to forward reply %v", err)
 		}
@@ -100,14 +158,18 @@ func (d *MessageDispatcher) DispatchMessage(message *Message, destination, reply
 	return nil
 }
 
-func (d *MessageDispatcher) executeRequest(url *url.URL, message *Message) (*Message, error) {
+func (d *MessageDispatcher) executeRequest(ctx context.Context, httpClient *http.Client, url *url.URL, authorization string, message *Message) (*Message, error) {
 	d.logger.Infof("Dispatching message to %s", url.String())
 	req, err := http.NewRequest(http.MethodPost, url.String(), bytes.NewReader(message.Payload))
 	if err != nil {
 		return nil, fmt.Errorf("unable to create request %v", err)
 	}
+	req = req.WithContext(ctx)
 	req.Header = d.toHTTPHeaders(message.Headers)
-	res, err := d.httpClient.Do(req)
+	if authorization != "" {
+		req.Header.Set(authorizationHeaderName, authorization)
+	}
+	res, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
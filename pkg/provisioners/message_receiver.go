@@ -17,10 +17,17 @@
 package provisioners
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -30,43 +37,176 @@ import (
 // message is emitted via the receiver function.
 const (
 	MessageReceiverPort = 8080
+
+	// LivenessPath is the path of the liveness endpoint served by Start.
+	LivenessPath = "/healthz"
+	// ReadinessPath is the path of the readiness endpoint served by Start.
+	ReadinessPath = "/readyz"
+
+	// MaxPayloadSizeEnv is the environment variable holding the maximum accepted payload size,
+	// in bytes. See ReceiverOptionsFromEnv.
+	MaxPayloadSizeEnv = "MAX_PAYLOAD_SIZE"
+	// ValidateCloudEventsEnv is the environment variable that enables CloudEvents validation
+	// when set to true. See ReceiverOptionsFromEnv.
+	ValidateCloudEventsEnv = "VALIDATE_CLOUDEVENTS"
+	// ShutdownTimeoutEnv is the environment variable holding, as a Go duration, how long a
+	// dispatcher has to drain once it is asked to stop. See ShutdownTimeoutFromEnv.
+	ShutdownTimeoutEnv = "SHUTDOWN_TIMEOUT"
+
+	// defaultShutdownTimeout is kept below the default Pod termination grace period (30s), so
+	// that in-flight requests finish before the container is killed.
+	defaultShutdownTimeout = 25 * time.Second
 )
 
+// HealthCheck reports whether a dependency of the receiver, such as the connection to the
+// backing messaging system, is healthy. A nil error means healthy.
+type HealthCheck func() error
+
+// ReceiverOption configures optional behavior of a MessageReceiver.
+type ReceiverOption func(*MessageReceiver)
+
+// WithLivenessCheck makes the liveness endpoint report the result of check.
+func WithLivenessCheck(check HealthCheck) ReceiverOption {
+	return func(r *MessageReceiver) {
+		r.livenessCheck = check
+	}
+}
+
+// WithReadinessCheck makes the readiness endpoint report the result of check.
+func WithReadinessCheck(check HealthCheck) ReceiverOption {
+	return func(r *MessageReceiver) {
+		r.readinessCheck = check
+	}
+}
+
+// WithShutdownTimeout sets how long the receiver waits for in-flight requests
+// to finish once it is asked to stop.
+func WithShutdownTimeout(timeout time.Duration) ReceiverOption {
+	return func(r *MessageReceiver) {
+		r.shutdownTimeout = timeout
+	}
+}
+
+// WithMaxPayloadSize makes the receiver reject messages whose payload is larger
+// than maxBytes with 413 Request Entity Too Large. A value of zero or less means
+// there is no limit.
+func WithMaxPayloadSize(maxBytes int64) ReceiverOption {
+	return func(r *MessageReceiver) {
+		r.maxPayloadSize = maxBytes
+	}
+}
+
+// WithCloudEventsValidation makes the receiver reject messages that are not
+// CloudEvents carrying all of their required attributes, in either binary or
+// structured mode, with 400 Bad Request.
+func WithCloudEventsValidation() ReceiverOption {
+	return func(r *MessageReceiver) {
+		r.validateCloudEvents = true
+	}
+}
+
+// ShutdownTimeoutFromEnv returns the shutdown timeout set by the
+// ShutdownTimeoutEnv environment variable, or the default one if it is not set.
+func ShutdownTimeoutFromEnv() (time.Duration, error) {
+	v, ok := os.LookupEnv(ShutdownTimeoutEnv)
+	if !ok || v == "" {
+		return defaultShutdownTimeout, nil
+	}
+	timeout, err := time.ParseDuration(v)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: it must be a positive duration", ShutdownTimeoutEnv, v)
+	}
+	return timeout, nil
+}
+
+// ReceiverOptionsFromEnv builds the ReceiverOptions configured by the
+// MaxPayloadSizeEnv, ValidateCloudEventsEnv and ShutdownTimeoutEnv environment
+// variables.
+func ReceiverOptionsFromEnv() ([]ReceiverOption, error) {
+	timeout, err := ShutdownTimeoutFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	opts := []ReceiverOption{WithShutdownTimeout(timeout)}
+	if v, ok := os.LookupEnv(MaxPayloadSizeEnv); ok && v != "" {
+		maxBytes, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", MaxPayloadSizeEnv, v, err)
+		}
+		opts = append(opts, WithMaxPayloadSize(maxBytes))
+	}
+	if v, ok := os.LookupEnv(ValidateCloudEventsEnv); ok && v != "" {
+		validate, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", ValidateCloudEventsEnv, v, err)
+		}
+		if validate {
+			opts = append(opts, WithCloudEventsValidation())
+		}
+	}
+	return opts, nil
+}
+
+// errPayloadTooLarge is returned when a request's payload exceeds the maximum
+// payload size.
+var errPayloadTooLarge = errors.New("payload too large")
+
 // Message receiver receives messages.
 type MessageReceiver struct {
 	receiverFunc    func(ChannelReference, *Message) error
 	forwardHeaders  sets.String
 	forwardPrefixes []string
 
+	livenessCheck       HealthCheck
+	readinessCheck      HealthCheck
+	shutdownTimeout     time.Duration
+	maxPayloadSize      int64
+	validateCloudEvents bool
+	// draining is set to 1 once the receiver has been asked to stop. It is
+	// accessed atomically.
+	draining int32
+	// drainDeadline is when the drain started by Start must be over.
+	drainDeadline time.Time
+
 	logger *zap.SugaredLogger
 }
 
 // NewMessageReceiver creates a message receiver passing new messages to the
 // receiverFunc.
-func NewMessageReceiver(receiverFunc func(ChannelReference, *Message) error, logger *zap.SugaredLogger) *MessageReceiver {
+func NewMessageReceiver(receiverFunc func(ChannelReference, *Message) error, logger *zap.SugaredLogger, opts ...ReceiverOption) *MessageReceiver {
 	receiver := &MessageReceiver{
 		receiverFunc:    receiverFunc,
 		forwardHeaders:  sets.NewString(forwardHeaders...),
 		forwardPrefixes: forwardPrefixes,
+		shutdownTimeout: defaultShutdownTimeout,
 
 		logger: logger,
 	}
+	for _, opt := range opts {
+		opt(receiver)
+	}
 	return receiver
 }
 
 // Start begings to receive messages for the receiver.
 //
-// Only HTTP POST requests to the root path (/) are accepted. If other paths or
-// methods are needed, use the HandleRequest method directly with another HTTP
-// server.
+// Only HTTP POST requests to the root path (/), which address the channel by
+// host, or to a channel path (/<namespace>/<channel>) are accepted. If other
+// paths or methods are needed, use the HandleRequest method directly with
+// another HTTP server.
+//
+// Liveness and readiness are served on LivenessPath and ReadinessPath.
 //
-// This method will block until a message is received on the stop channel.
+// This method will block until a message is received on the stop channel. The
+// receiver then drains: it reports itself as not ready, stops accepting new
+// connections and waits up to the shutdown timeout for in-flight requests to
+// finish. Use DrainContext to drain what the receiver fed within the same
+// timeout.
 func (r *MessageReceiver) Start(stopCh <-chan struct{}) error {
 	svr := r.start()
-	defer r.stop(svr)
 
 	<-stopCh
-	return nil
+	return r.stop(svr)
 }
 
 func (r *MessageReceiver) start() *http.Server {
@@ -83,17 +223,50 @@ func (r *MessageReceiver) start() *http.Server {
 	return srv
 }
 
-func (r *MessageReceiver) stop(srv *http.Server) {
-	r.logger.Info("Shutdown web server")
-	if err := srv.Shutdown(nil); err != nil {
-		r.logger.Fatal(err)
+func (r *MessageReceiver) stop(srv *http.Server) error {
+	r.logger.Info("Draining web server")
+	atomic.StoreInt32(&r.draining, 1)
+	r.drainDeadline = time.Now().Add(r.shutdownTimeout)
+
+	ctx, cancel := r.DrainContext()
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		r.logger.Errorf("Web server did not drain within %v: %v", r.shutdownTimeout, err)
+		srv.Close()
+		return err
 	}
+	r.logger.Info("Web server drained")
+	return nil
+}
+
+// DrainContext returns a context that is done once the shutdown timeout,
+// started when Start was asked to stop, elapsed. It must only be called after
+// Start returned.
+func (r *MessageReceiver) DrainContext() (context.Context, context.CancelFunc) {
+	return context.WithDeadline(context.Background(), r.drainDeadline)
+}
+
+// isDraining returns true once the receiver has been asked to stop.
+func (r *MessageReceiver) isDraining() bool {
+	return atomic.LoadInt32(&r.draining) == 1
 }
 
 // handler creates the http.Handler used by the http.Server started in MessageReceiver.Run.
 func (r *MessageReceiver) handler() http.Handler {
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		if req.URL.Path != "/" {
+		switch req.URL.Path {
+		case LivenessPath:
+			r.serveHealth(res, r.livenessCheck)
+			return
+		case ReadinessPath:
+			if r.isDraining() {
+				res.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			r.serveHealth(res, r.readinessCheck)
+			return
+		}
+		if req.URL.Path != "/" && !isChannelPath(req.URL.Path) {
 			res.WriteHeader(http.StatusNotFound)
 			return
 		}
@@ -102,21 +275,51 @@ func (r *MessageReceiver) handler() http.Handler {
 			return
 		}
 
+		if r.isDraining() {
+			res.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
 		r.HandleRequest(res, req)
 	})
 }
 
+// serveHealth responds with 200 if check passes, or if there is no check,
+// and with 503 otherwise.
+func (r *MessageReceiver) serveHealth(res http.ResponseWriter, check HealthCheck) {
+	if check != nil {
+		if err := check(); err != nil {
+			r.logger.Infof("Health check failed: %v", err)
+			res.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+	res.WriteHeader(http.StatusOK)
+}
+
 // HandleRequest is an http.Handler function. The request is converted to a
 // Message and emitted to the receiver func.
 //
+// Requests to the root path are addressed by their Host header. Requests to
+// any other path are addressed by that path, which must have the form
+// /<namespace>/<channel>.
+//
 // The response status codes:
 //   202 - the message was sent to subscribers
+//   400 - the message is not a valid CloudEvent (only when validation is enabled)
 //   404 - the request was for an unknown channel
-//   500 - an error occurred processing the request
+//   413 - the payload is larger than the maximum payload size
+//   429 - the channel's queue is full, the message should be retried later
+//   500 - an error occurred processing the request. If the message was delivered to some of
+//         the subscribers only, FailedSubscribersHeader lists the others.
+//   503 - the message could not be persisted, it should be retried later
 func (r *MessageReceiver) HandleRequest(res http.ResponseWriter, req *http.Request) {
 	host := req.Host
+	if req.URL.Path != "/" {
+		host += req.URL.Path
+	}
 	r.logger.Infof("Received request for %s", host)
-	channel, err := ParseChannel(host)
+	channel, err := ParseChannelFromRequest(req)
 	if err != nil {
 		r.logger.Info("Could not extract channel", zap.Error(err))
 		res.WriteHeader(http.StatusInternalServerError)
@@ -124,10 +327,23 @@ func (r *MessageReceiver) HandleRequest(res http.ResponseWriter, req *http.Reque
 	}
 
 	message, err := r.fromRequest(req)
-	if err != nil {
+	if err == errPayloadTooLarge {
+		r.logger.Infof("Rejecting message for %s larger than %d bytes", host, r.maxPayloadSize)
+		rejectedMessages.WithLabelValues(rejectedPayloadTooLarge).Inc()
+		res.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
 		res.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if r.validateCloudEvents {
+		if err := validateCloudEvent(req.Header, message.Payload); err != nil {
+			r.logger.Info("Rejecting invalid CloudEvent", zap.Error(err))
+			rejectedMessages.WithLabelValues(rejectedInvalidEvent).Inc()
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
 	// setting common channel information in the request
 	message.AppendToHistory(host)
 
@@ -135,6 +351,19 @@ func (r *MessageReceiver) HandleRequest(res http.ResponseWriter, req *http.Reque
 	if err != nil {
 		if err == ErrUnknownChannel {
 			res.WriteHeader(http.StatusNotFound)
+		} else if err == ErrQueueFull {
+			res.Header().Set("Retry-After", "1")
+			res.WriteHeader(http.StatusTooManyRequests)
+		} else if err == ErrUnavailable {
+			res.Header().Set("Retry-After", "1")
+			res.WriteHeader(http.StatusServiceUnavailable)
+		} else if err == ErrMessageTooLarge {
+			res.WriteHeader(http.StatusRequestEntityTooLarge)
+		} else if err == ErrInvalidMessage {
+			res.WriteHeader(http.StatusBadRequest)
+		} else if pf, ok := err.(PartialFailure); ok {
+			res.Header().Set(FailedSubscribersHeader, FormatSubscribers(pf.FailedSubscribers()))
+			res.WriteHeader(http.StatusInternalServerError)
 		} else {
 			res.WriteHeader(http.StatusInternalServerError)
 		}
@@ -145,10 +374,21 @@ func (r *MessageReceiver) HandleRequest(res http.ResponseWriter, req *http.Reque
 }
 
 func (r *MessageReceiver) fromRequest(req *http.Request) (*Message, error) {
-	body, err := ioutil.ReadAll(req.Body)
+	var reader io.Reader = req.Body
+	if r.maxPayloadSize > 0 {
+		if req.ContentLength > r.maxPayloadSize {
+			return nil, errPayloadTooLarge
+		}
+		// Read one byte past the limit, so that payloads over it can be told apart.
+		reader = io.LimitReader(req.Body, r.maxPayloadSize+1)
+	}
+	body, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return nil, err
 	}
+	if r.maxPayloadSize > 0 && int64(len(body)) > r.maxPayloadSize {
+		return nil, errPayloadTooLarge
+	}
 	headers := r.fromHTTPHeaders(req.Header)
 	message := &Message{
 		Headers: headers,
@@ -195,3 +435,36 @@ func ParseChannel(host string) (ChannelReference, error) {
 		Namespace: chunks[1],
 	}, nil
 }
+
+// ParseChannelFromPath converts a channel path of the form
+// /<namespace>/<channel> into a channel reference.
+func ParseChannelFromPath(path string) (ChannelReference, error) {
+	if !isChannelPath(path) {
+		return ChannelReference{}, fmt.Errorf("bad path format '%s'", path)
+	}
+	chunks := strings.Split(strings.Trim(path, "/"), "/")
+	return ChannelReference{
+		Name:      chunks[1],
+		Namespace: chunks[0],
+	}, nil
+}
+
+// ParseChannelFromRequest determines the channel a request is addressed to.
+// Requests to the root path are addressed by their Host header, all others by
+// their path.
+func ParseChannelFromRequest(req *http.Request) (ChannelReference, error) {
+	if req.URL.Path == "" || req.URL.Path == "/" {
+		return ParseChannel(req.Host)
+	}
+	return ParseChannelFromPath(req.URL.Path)
+}
+
+// isChannelPath returns true if the path has the form /<namespace>/<channel>.
+// A single trailing slash is tolerated.
+func isChannelPath(path string) bool {
+	if !strings.HasPrefix(path, "/") {
+		return false
+	}
+	chunks := strings.Split(strings.TrimSuffix(path[1:], "/"), "/")
+	return len(chunks) == 2 && chunks[0] != "" && chunks[1] != ""
+}
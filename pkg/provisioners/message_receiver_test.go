@@ -22,8 +22,10 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/knative/eventing/pkg/utils"
@@ -34,14 +36,16 @@ import (
 
 func TestMessageReceiver_HandleRequest(t *testing.T) {
 	testCases := map[string]struct {
-		method       string
-		host         string
-		path         string
-		header       http.Header
-		body         string
-		bodyReader   io.Reader
-		expected     int
-		receiverFunc func(ChannelReference, *Message) error
+		method         string
+		host           string
+		path           string
+		header         http.Header
+		body           string
+		bodyReader     io.Reader
+		opts           []ReceiverOption
+		expected       int
+		expectedHeader http.Header
+		receiverFunc   func(ChannelReference, *Message) error
 	}{
 		"non '/' path": {
 			path:     "/something",
@@ -55,22 +59,101 @@ func TestMessageReceiver_HandleRequest(t *testing.T) {
 			host:     "no-dot",
 			expected: http.StatusInternalServerError,
 		},
+		"path with too many segments": {
+			path:     "/test-namespace/test-name/something",
+			expected: http.StatusNotFound,
+		},
+		"path addressed channel": {
+			host: "in-memory-channel-dispatcher",
+			path: "/test-namespace/test-name",
+			receiverFunc: func(r ChannelReference, m *Message) error {
+				if r.Namespace != "test-namespace" || r.Name != "test-name" {
+					return fmt.Errorf("test receiver func -- bad reference: %v", r)
+				}
+				if h := m.Headers[MessageHistoryHeader]; h != "in-memory-channel-dispatcher/test-namespace/test-name" {
+					return fmt.Errorf("test receiver func -- bad history: %v", h)
+				}
+				return nil
+			},
+			expected: http.StatusAccepted,
+		},
 		"unreadable body": {
 			bodyReader: &errorReader{},
 			expected:   http.StatusInternalServerError,
 		},
+		"payload too large": {
+			body:     "message-body",
+			opts:     []ReceiverOption{WithMaxPayloadSize(4)},
+			expected: http.StatusRequestEntityTooLarge,
+		},
+		"payload within limit": {
+			body:     "message-body",
+			opts:     []ReceiverOption{WithMaxPayloadSize(12)},
+			expected: http.StatusAccepted,
+		},
+		"invalid CloudEvent": {
+			header: map[string][]string{
+				"Ce-Specversion": {"0.2"},
+				"Ce-Id":          {"1234"},
+			},
+			opts:     []ReceiverOption{WithCloudEventsValidation()},
+			expected: http.StatusBadRequest,
+		},
+		"valid CloudEvent": {
+			header: map[string][]string{
+				"Ce-Specversion": {"0.2"},
+				"Ce-Id":          {"1234"},
+				"Ce-Type":        {"dev.knative.test"},
+				"Ce-Source":      {"/test"},
+			},
+			opts:     []ReceiverOption{WithCloudEventsValidation()},
+			expected: http.StatusAccepted,
+		},
 		"unknown channel error": {
 			receiverFunc: func(_ ChannelReference, _ *Message) error {
 				return ErrUnknownChannel
 			},
 			expected: http.StatusNotFound,
 		},
+		"queue full error": {
+			receiverFunc: func(_ ChannelReference, _ *Message) error {
+				return ErrQueueFull
+			},
+			expected: http.StatusTooManyRequests,
+		},
+		"unavailable error": {
+			receiverFunc: func(_ ChannelReference, _ *Message) error {
+				return ErrUnavailable
+			},
+			expected: http.StatusServiceUnavailable,
+		},
+		"message too large error": {
+			receiverFunc: func(_ ChannelReference, _ *Message) error {
+				return ErrMessageTooLarge
+			},
+			expected: http.StatusRequestEntityTooLarge,
+		},
+		"invalid message error": {
+			receiverFunc: func(_ ChannelReference, _ *Message) error {
+				return ErrInvalidMessage
+			},
+			expected: http.StatusBadRequest,
+		},
 		"other receiver function error": {
 			receiverFunc: func(_ ChannelReference, _ *Message) error {
 				return errors.New("test induced receiver function error")
 			},
 			expected: http.StatusInternalServerError,
 		},
+		"partial failure": {
+			receiverFunc: func(_ ChannelReference, _ *Message) error {
+				return &partialFailure{failed: []string{"uid-1", "subscriber.example.com reply,1"}}
+			},
+			expected: http.StatusInternalServerError,
+			expectedHeader: http.Header{
+				"Knative-Failed-Subscribers": {"uid-1,subscriber.example.com+reply%2C1"},
+			},
+		},
 		"headers and body pass through": {
 			// The header, body, and host values set here are verified in the receiverFunc. Altering
 			// them here will require the same alteration in the receiverFunc.
@@ -126,7 +209,12 @@ func TestMessageReceiver_HandleRequest(t *testing.T) {
 			}
 
 			f := tc.receiverFunc
-			r := NewMessageReceiver(f, zap.NewNop().Sugar())
+			if f == nil {
+				f = func(_ ChannelReference, _ *Message) error {
+					return nil
+				}
+			}
+			r := NewMessageReceiver(f, zap.NewNop().Sugar(), tc.opts...)
 			h := r.handler()
 
 			body := tc.bodyReader
@@ -143,6 +231,179 @@ func TestMessageReceiver_HandleRequest(t *testing.T) {
 			if resp.Code != tc.expected {
 				t.Fatalf("Unexpected status code. Expected %v. Actual %v", tc.expected, resp.Code)
 			}
+			for k, v := range tc.expectedHeader {
+				if diff := cmp.Diff(v, resp.Header()[k]); diff != "" {
+					t.Errorf("Unexpected %s header (-want, +got): %s", k, diff)
+				}
+			}
+		})
+	}
+}
+
+type partialFailure struct {
+	failed []string
+}
+
+func (e *partialFailure) Error() string {
+	return "partial failure"
+}
+
+func (e *partialFailure) FailedSubscribers() []string {
+	return e.failed
+}
+
+func TestMessageReceiver_Health(t *testing.T) {
+	failing := func() error {
+		return errors.New("test induced health check failure")
+	}
+	testCases := map[string]struct {
+		path     string
+		opts     []ReceiverOption
+		draining bool
+		expected int
+	}{
+		"liveness without check": {
+			path:     LivenessPath,
+			expected: http.StatusOK,
+		},
+		"liveness failing": {
+			path:     LivenessPath,
+			opts:     []ReceiverOption{WithLivenessCheck(failing)},
+			expected: http.StatusServiceUnavailable,
+		},
+		"liveness while draining": {
+			path:     LivenessPath,
+			draining: true,
+			expected: http.StatusOK,
+		},
+		"readiness without check": {
+			path:     ReadinessPath,
+			expected: http.StatusOK,
+		},
+		"readiness failing": {
+			path:     ReadinessPath,
+			opts:     []ReceiverOption{WithReadinessCheck(failing)},
+			expected: http.StatusServiceUnavailable,
+		},
+		"readiness while draining": {
+			path:     ReadinessPath,
+			draining: true,
+			expected: http.StatusServiceUnavailable,
+		},
+		"message while draining": {
+			path:     "/",
+			draining: true,
+			expected: http.StatusServiceUnavailable,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			r := NewMessageReceiver(func(_ ChannelReference, _ *Message) error {
+				return nil
+			}, zap.NewNop().Sugar(), tc.opts...)
+			if tc.draining {
+				r.draining = 1
+			}
+			h := r.handler()
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.path == "/" {
+				req = httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(""))
+				req.Host = "test-channel.test-namespace.svc." + utils.GetClusterDomainName()
+			}
+
+			resp := httptest.NewRecorder()
+			h.ServeHTTP(resp, req)
+			if resp.Code != tc.expected {
+				t.Fatalf("Unexpected status code. Expected %v. Actual %v", tc.expected, resp.Code)
+			}
+		})
+	}
+}
+
+func TestShutdownTimeoutFromEnv(t *testing.T) {
+	testCases := map[string]struct {
+		value    string
+		set      bool
+		expected time.Duration
+		wantErr  bool
+	}{
+		"unset": {
+			expected: defaultShutdownTimeout,
+		},
+		"set": {
+			value:    "1m",
+			set:      true,
+			expected: time.Minute,
+		},
+		"invalid": {
+			value:   "soon",
+			set:     true,
+			wantErr: true,
+		},
+		"negative": {
+			value:   "-1s",
+			set:     true,
+			wantErr: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			if tc.set {
+				os.Setenv(ShutdownTimeoutEnv, tc.value)
+				defer os.Unsetenv(ShutdownTimeoutEnv)
+			}
+			timeout, err := ShutdownTimeoutFromEnv()
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("Unexpected error. Expected an error: %v. Actual %v", tc.wantErr, err)
+			}
+			if timeout != tc.expected {
+				t.Errorf("Unexpected timeout. Expected %v. Actual %v", tc.expected, timeout)
+			}
+		})
+	}
+}
+
+func TestParseChannelFromPath(t *testing.T) {
+	testCases := map[string]struct {
+		path    string
+		want    ChannelReference
+		wantErr bool
+	}{
+		"namespace and name": {
+			path: "/test-namespace/test-name",
+			want: ChannelReference{Namespace: "test-namespace", Name: "test-name"},
+		},
+		"trailing slash": {
+			path: "/test-namespace/test-name/",
+			want: ChannelReference{Namespace: "test-namespace", Name: "test-name"},
+		},
+		"root": {
+			path:    "/",
+			wantErr: true,
+		},
+		"name only": {
+			path:    "/test-name",
+			wantErr: true,
+		},
+		"empty namespace": {
+			path:    "//test-name",
+			wantErr: true,
+		},
+		"too many segments": {
+			path:    "/test-namespace/test-name/extra",
+			wantErr: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			got, err := ParseChannelFromPath(tc.path)
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("Unexpected error. Expected error %v. Actual %v", tc.wantErr, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Unexpected channel reference (-want, +got): %s", diff)
+			}
 		})
 	}
 }
@@ -18,6 +18,8 @@ package provisioners
 
 import (
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestMessageHistory(t *testing.T) {
@@ -102,3 +104,14 @@ func TestMessageHistory(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSubscribers(t *testing.T) {
+	keys := []string{"uid-1", "subscriber.example.com reply,1", "subscriber.example.com "}
+	value := FormatSubscribers(keys)
+	if diff := cmp.Diff(keys, ParseSubscribers(value)); diff != "" {
+		t.Errorf("Unexpected keys (-want, +got): %s", diff)
+	}
+	if diff := cmp.Diff([]string{"uid-1", "uid-2"}, ParseSubscribers(" uid-1 ,, uid-2")); diff != "" {
+		t.Errorf("Unexpected keys (-want, +got): %s", diff)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2019 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provisioners
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	// structuredContentType is the media type of a CloudEvent sent in structured mode.
+	structuredContentType = "application/cloudevents+json"
+)
+
+// requiredAttributes are the attributes every CloudEvent must carry, keyed by the attribute that
+// identifies the spec version. The first attribute in each list is the spec version itself.
+var requiredAttributes = [][]string{
+	// CloudEvents v0.2
+	{"specversion", "id", "type", "source"},
+	// CloudEvents v0.1
+	{"cloudEventsVersion", "eventID", "eventType", "source"},
+}
+
+// validateCloudEvent checks that the request carries a CloudEvent with all of its required
+// attributes, either in binary mode (as ce- prefixed headers) or in structured mode (as a JSON
+// body).
+func validateCloudEvent(headers http.Header, body []byte) error {
+	if mediaType, _, err := mime.ParseMediaType(headers.Get("Content-Type")); err == nil && mediaType == structuredContentType {
+		return validateStructuredCloudEvent(body)
+	}
+	return validateBinaryCloudEvent(headers)
+}
+
+func validateBinaryCloudEvent(headers http.Header) error {
+	for _, attributes := range requiredAttributes {
+		if headers.Get("ce-"+attributes[0]) == "" {
+			continue
+		}
+		for _, attribute := range attributes[1:] {
+			if headers.Get("ce-"+attribute) == "" {
+				return fmt.Errorf("missing required CloudEvents header %q", "ce-"+strings.ToLower(attribute))
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("missing CloudEvents spec version header")
+}
+
+func validateStructuredCloudEvent(body []byte) error {
+	event := make(map[string]interface{})
+	if err := json.Unmarshal(body, &event); err != nil {
+		return fmt.Errorf("structured CloudEvent is not a JSON object: %v", err)
+	}
+	for _, attributes := range requiredAttributes {
+		if !hasStringAttribute(event, attributes[0]) {
+			continue
+		}
+		for _, attribute := range attributes[1:] {
+			if !hasStringAttribute(event, attribute) {
+				return fmt.Errorf("missing required CloudEvents attribute %q", attribute)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("missing CloudEvents spec version attribute")
+}
+
+// hasStringAttribute returns true if the event has a non-empty string attribute with the given
+// name.
+func hasStringAttribute(event map[string]interface{}, name string) bool {
+	v, ok := event[name].(string)
+	return ok && v != ""
+}
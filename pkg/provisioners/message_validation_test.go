@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioners
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestValidateCloudEvent(t *testing.T) {
+	testCases := map[string]struct {
+		header  http.Header
+		body    string
+		wantErr bool
+	}{
+		"binary v0.2": {
+			header: http.Header{
+				"Ce-Specversion": {"0.2"},
+				"Ce-Id":          {"1234"},
+				"Ce-Type":        {"dev.knative.test"},
+				"Ce-Source":      {"/test"},
+			},
+		},
+		"binary v0.1": {
+			header: http.Header{
+				"Ce-Cloudeventsversion": {"0.1"},
+				"Ce-Eventid":            {"1234"},
+				"Ce-Eventtype":          {"dev.knative.test"},
+				"Ce-Source":             {"/test"},
+			},
+		},
+		"binary missing source": {
+			header: http.Header{
+				"Ce-Specversion": {"0.2"},
+				"Ce-Id":          {"1234"},
+				"Ce-Type":        {"dev.knative.test"},
+			},
+			wantErr: true,
+		},
+		"binary missing spec version": {
+			header: http.Header{
+				"Ce-Id":     {"1234"},
+				"Ce-Type":   {"dev.knative.test"},
+				"Ce-Source": {"/test"},
+			},
+			wantErr: true,
+		},
+		"not a CloudEvent": {
+			header: http.Header{
+				"Content-Type": {"application/json"},
+			},
+			body:    `{"hello": "world"}`,
+			wantErr: true,
+		},
+		"structured v0.2": {
+			header: http.Header{
+				"Content-Type": {"application/cloudevents+json; charset=utf-8"},
+			},
+			body: `{"specversion": "0.2", "id": "1234", "type": "dev.knative.test", "source": "/test"}`,
+		},
+		"structured v0.1": {
+			header: http.Header{
+				"Content-Type": {"application/cloudevents+json"},
+			},
+			body: `{"cloudEventsVersion": "0.1", "eventID": "1234", "eventType": "dev.knative.test", "source": "/test"}`,
+		},
+		"structured missing type": {
+			header: http.Header{
+				"Content-Type": {"application/cloudevents+json"},
+			},
+			body:    `{"specversion": "0.2", "id": "1234", "source": "/test"}`,
+			wantErr: true,
+		},
+		"structured not JSON": {
+			header: http.Header{
+				"Content-Type": {"application/cloudevents+json"},
+			},
+			body:    `not json`,
+			wantErr: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			err := validateCloudEvent(tc.header, []byte(tc.body))
+			if tc.wantErr != (err != nil) {
+				t.Errorf("Unexpected error. Expected error %v. Actual %v", tc.wantErr, err)
+			}
+		})
+	}
+}
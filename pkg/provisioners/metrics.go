@@ -0,0 +1,53 @@
+/*
+ * Copyright 2019 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provisioners
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// MetricsBindAddress is the address on which dispatchers serve their Prometheus metrics, via
+	// the controller-runtime manager.
+	MetricsBindAddress = ":9090"
+
+	metricsNamespace = "knative_eventing"
+
+	// Reasons a message is rejected by a MessageReceiver.
+	rejectedPayloadTooLarge = "payload_too_large"
+	rejectedInvalidEvent    = "invalid_cloudevent"
+)
+
+var (
+	// rejectedMessages counts the messages a MessageReceiver refused to accept. They are only
+	// labelled by reason: the channel is taken from the request, before it is known to exist, so
+	// labelling it would let clients create any number of time series.
+	rejectedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "message_receiver",
+			Name:      "rejected_messages_total",
+			Help:      "Number of messages rejected by the channel message receiver.",
+		},
+		[]string{"reason"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(rejectedMessages)
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2019 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provisioners
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// secretIdleTimeout is how long a Secret stays watched after it was last used.
+	secretIdleTimeout = 10 * time.Minute
+	// secretIdleCheckInterval is how often the watched Secrets are checked for idleness.
+	secretIdleCheckInterval = 1 * time.Minute
+)
+
+// errNoSecrets is returned by a SecretCache that was not given a way to read Secrets.
+var errNoSecrets = errors.New("Secrets can not be read by this dispatcher")
+
+// SecretCache keeps the Secrets referenced by subscribers in memory. Each Secret is read when it
+// is first used, then kept up to date by a watch on that Secret alone, so that the dispatcher
+// neither reads it for every message nor watches all the Secrets it may read. A Secret that is
+// deleted, whose watch ends, or that is not used for secretIdleTimeout, is forgotten and read
+// again the next time it is used.
+type SecretCache struct {
+	secrets           corev1client.SecretsGetter
+	now               func() time.Time
+	idleCheckInterval time.Duration
+
+	lock    sync.Mutex
+	watched map[types.NamespacedName]*watchedSecret
+}
+
+type watchedSecret struct {
+	secret   *corev1.Secret
+	lastUsed time.Time
+}
+
+// NewSecretCache creates a SecretCache that reads and watches Secrets using secrets. If secrets
+// is nil, reading a Secret fails.
+func NewSecretCache(secrets corev1client.SecretsGetter) *SecretCache {
+	return &SecretCache{
+		secrets:           secrets,
+		now:               time.Now,
+		idleCheckInterval: secretIdleCheckInterval,
+		watched:           make(map[types.NamespacedName]*watchedSecret),
+	}
+}
+
+// Get returns the Secret named key. The returned Secret must not be modified.
+func (c *SecretCache) Get(key types.NamespacedName) (*corev1.Secret, error) {
+	if c == nil || c.secrets == nil {
+		return nil, errNoSecrets
+	}
+	c.lock.Lock()
+	if w, present := c.watched[key]; present {
+		w.lastUsed = c.now()
+		secret := w.secret
+		c.lock.Unlock()
+		return secret, nil
+	}
+	c.lock.Unlock()
+
+	secret, err := c.secrets.Secrets(key.Namespace).Get(key.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	watcher, err := c.secrets.Secrets(key.Namespace).Watch(metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", key.Name).String(),
+		ResourceVersion: secret.ResourceVersion,
+	})
+	if err != nil {
+		// The Secret is read again the next time it is used.
+		return secret, nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if w, present := c.watched[key]; present {
+		// Another caller started watching it meanwhile.
+		watcher.Stop()
+		return w.secret, nil
+	}
+	c.watched[key] = &watchedSecret{secret: secret, lastUsed: c.now()}
+	go c.watch(key, watcher)
+	return secret, nil
+}
+
+// watch keeps the Secret named key up to date with the events of watcher, until the Secret is
+// forgotten.
+func (c *SecretCache) watch(key types.NamespacedName, watcher watch.Interface) {
+	defer func() {
+		watcher.Stop()
+		c.lock.Lock()
+		delete(c.watched, key)
+		c.lock.Unlock()
+	}()
+	ticker := time.NewTicker(c.idleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-watcher.ResultChan():
+			if !ok || event.Type == watch.Error {
+				return
+			}
+			secret, isSecret := event.Object.(*corev1.Secret)
+			if !isSecret || secret.Namespace != key.Namespace || secret.Name != key.Name {
+				continue
+			}
+			if event.Type == watch.Deleted {
+				return
+			}
+			c.lock.Lock()
+			c.watched[key].secret = secret
+			c.lock.Unlock()
+		case <-ticker.C:
+			c.lock.Lock()
+			idle := c.now().Sub(c.watched[key].lastUsed) >= secretIdleTimeout
+			c.lock.Unlock()
+			if idle {
+				return
+			}
+		}
+	}
+}
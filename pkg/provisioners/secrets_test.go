@@ -0,0 +1,125 @@
+/*
+ * Copyright 2019 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provisioners
+
+import (
+	"testing"
+	"time"
+
+	controllertesting "github.com/knative/eventing/pkg/reconciler/testing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestSecretCache(t *testing.T) {
+	fakeSecrets := controllertesting.NewFakeSecrets(makeSecret("1"))
+	cache := NewSecretCache(fakeSecrets)
+	key := types.NamespacedName{Namespace: "test-namespace", Name: "test-secret"}
+
+	for i := 0; i < 2; i++ {
+		secret, err := cache.Get(key)
+		if err != nil {
+			t.Fatalf("Unexpected error getting the Secret: %v", err)
+		}
+		if secret.ResourceVersion != "1" {
+			t.Errorf("Unexpected resourceVersion. Expected %q. Actual %q", "1", secret.ResourceVersion)
+		}
+	}
+	if gets := fakeSecrets.Gets(); gets != 1 {
+		t.Errorf("Expected the watched Secret to be read once. Actual %d", gets)
+	}
+
+	// An update is observed by the watch.
+	fakeSecrets.Update(makeSecret("2"))
+	waitForSecret(t, cache, makeSecret("2"))
+	if gets := fakeSecrets.Gets(); gets != 1 {
+		t.Errorf("Expected the updated Secret not to be read. Actual %d reads", gets)
+	}
+
+	// A deleted Secret is forgotten.
+	fakeSecrets.Delete(makeSecret("2"))
+	waitFor(t, "the Secret to be forgotten", func() bool {
+		_, err := cache.Get(key)
+		return err != nil
+	})
+}
+
+func TestSecretCache_Idle(t *testing.T) {
+	fakeSecrets := controllertesting.NewFakeSecrets(makeSecret("1"))
+	cache := NewSecretCache(fakeSecrets)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	cache.idleCheckInterval = 10 * time.Millisecond
+	key := types.NamespacedName{Namespace: "test-namespace", Name: "test-secret"}
+	if _, err := cache.Get(key); err != nil {
+		t.Fatalf("Unexpected error getting the Secret: %v", err)
+	}
+
+	cache.lock.Lock()
+	now = now.Add(secretIdleTimeout)
+	cache.lock.Unlock()
+	waitFor(t, "the idle Secret to be forgotten", func() bool {
+		cache.lock.Lock()
+		defer cache.lock.Unlock()
+		return len(cache.watched) == 0
+	})
+	if _, err := cache.Get(key); err != nil {
+		t.Fatalf("Unexpected error getting the Secret: %v", err)
+	}
+	if gets := fakeSecrets.Gets(); gets != 2 {
+		t.Errorf("Expected the forgotten Secret to be read again. Actual %d reads", gets)
+	}
+}
+
+func TestSecretCache_NoSecrets(t *testing.T) {
+	if _, err := NewSecretCache(nil).Get(types.NamespacedName{Name: "test-secret"}); err == nil {
+		t.Errorf("Expected an error, actually nil")
+	}
+}
+
+// waitForSecret waits until cache returns the resourceVersion of secret.
+func waitForSecret(t *testing.T, cache *SecretCache, secret *corev1.Secret) {
+	t.Helper()
+	key := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+	waitFor(t, "the Secret "+secret.ResourceVersion, func() bool {
+		s, err := cache.Get(key)
+		return err == nil && s.ResourceVersion == secret.ResourceVersion
+	})
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for !cond() {
+		select {
+		case <-timeout:
+			t.Fatalf("Timeout waiting for %s", what)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func makeSecret(resourceVersion string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "test-namespace",
+			Name:            "test-secret",
+			ResourceVersion: resourceVersion,
+		},
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright 2019 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provisioners
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// TLSCACertKey is the key in a subscriber TLS Secret that holds the PEM encoded CA bundle used
+// to verify the subscriber.
+const TLSCACertKey = "ca.crt"
+
+// TLSClientCache builds HTTP clients from subscriber TLS Secrets and caches them, so that
+// connections to the same destinations are reused across messages. A cached client is rebuilt
+// whenever its Secret's resourceVersion changes, so rotated certificates are picked up without
+// restarting the dispatcher.
+type TLSClientCache struct {
+	secrets *SecretCache
+
+	clientsLock sync.Mutex
+	clients     map[types.NamespacedName]*tlsHTTPClient
+}
+
+type tlsHTTPClient struct {
+	resourceVersion string
+	httpClient      *http.Client
+}
+
+// NewTLSClientCache creates a TLSClientCache that reads Secrets from secrets.
+func NewTLSClientCache(secrets *SecretCache) *TLSClientCache {
+	return &TLSClientCache{
+		secrets: secrets,
+		clients: make(map[types.NamespacedName]*tlsHTTPClient),
+	}
+}
+
+// HTTPClient returns an HTTP client configured with the TLS material in the Secret referenced by
+// ref, in namespace.
+func (c *TLSClientCache) HTTPClient(namespace string, ref *corev1.LocalObjectReference) (*http.Client, error) {
+	key := types.NamespacedName{Namespace: namespace, Name: ref.Name}
+	if c == nil {
+		return nil, fmt.Errorf("unable to load TLS Secret %v: %v", key, errNoSecrets)
+	}
+	secret, err := c.secrets.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get TLS Secret %v: %v", key, err)
+	}
+
+	c.clientsLock.Lock()
+	defer c.clientsLock.Unlock()
+	cached, present := c.clients[key]
+	if present && cached.resourceVersion == secret.ResourceVersion {
+		return cached.httpClient, nil
+	}
+	tlsConfig, err := TLSConfigFromSecret(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS Secret %v: %v", key, err)
+	}
+	if present {
+		// The Secret changed, stop reusing connections established with the old material.
+		if t, ok := cached.httpClient.Transport.(*http.Transport); ok {
+			t.CloseIdleConnections()
+		}
+	}
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	c.clients[key] = &tlsHTTPClient{
+		resourceVersion: secret.ResourceVersion,
+		httpClient:      httpClient,
+	}
+	return httpClient, nil
+}
+
+// TLSConfigFromSecret builds a client TLS configuration from secret. The CA bundle in the
+// TLSCACertKey key, if present, replaces the system roots when verifying the server. The
+// certificate and key in the corev1.TLSCertKey and corev1.TLSPrivateKeyKey keys, if present, are
+// presented to the server for mutual TLS.
+func TLSConfigFromSecret(secret *corev1.Secret) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if ca, present := secret.Data[TLSCACertKey]; present {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("%q does not contain a PEM encoded certificate", TLSCACertKey)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	cert, hasCert := secret.Data[corev1.TLSCertKey]
+	key, hasKey := secret.Data[corev1.TLSPrivateKeyKey]
+	if hasCert != hasKey {
+		return nil, errors.New("a client certificate requires both " + corev1.TLSCertKey + " and " + corev1.TLSPrivateKeyKey)
+	}
+	if hasCert {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,271 @@
+/*
+ * Copyright 2019 The Knative Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provisioners
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	controllertesting "github.com/knative/eventing/pkg/reconciler/testing"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	tlsSecretNamespace = "test-namespace"
+	tlsSecretName      = "subscriber-tls"
+)
+
+func TestTLSConfigFromSecret(t *testing.T) {
+	caPEM, _ := generateCertificate(t)
+	certPEM, keyPEM := generateCertificate(t)
+	testCases := map[string]struct {
+		data             map[string][]byte
+		expectedErr      bool
+		expectedRootCAs  bool
+		expectedCertsLen int
+	}{
+		"empty": {},
+		"ca only": {
+			data: map[string][]byte{
+				TLSCACertKey: caPEM,
+			},
+			expectedRootCAs: true,
+		},
+		"ca and client certificate": {
+			data: map[string][]byte{
+				TLSCACertKey:            caPEM,
+				corev1.TLSCertKey:       certPEM,
+				corev1.TLSPrivateKeyKey: keyPEM,
+			},
+			expectedRootCAs:  true,
+			expectedCertsLen: 1,
+		},
+		"invalid ca": {
+			data: map[string][]byte{
+				TLSCACertKey: []byte("not a certificate"),
+			},
+			expectedErr: true,
+		},
+		"client certificate without key": {
+			data: map[string][]byte{
+				corev1.TLSCertKey: certPEM,
+			},
+			expectedErr: true,
+		},
+		"mismatched client certificate and key": {
+			data: map[string][]byte{
+				corev1.TLSCertKey:       certPEM,
+				corev1.TLSPrivateKeyKey: []byte("not a key"),
+			},
+			expectedErr: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			tlsConfig, err := TLSConfigFromSecret(&corev1.Secret{Data: tc.data})
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("Unexpected error. Expected %v. Actual %v", tc.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if tc.expectedRootCAs != (tlsConfig.RootCAs != nil) {
+				t.Errorf("Unexpected RootCAs. Expected %v. Actual %v", tc.expectedRootCAs, tlsConfig.RootCAs)
+			}
+			if len(tlsConfig.Certificates) != tc.expectedCertsLen {
+				t.Errorf("Unexpected number of certificates. Expected %d. Actual %d", tc.expectedCertsLen, len(tlsConfig.Certificates))
+			}
+		})
+	}
+}
+
+func TestDispatchMessageWithTLS(t *testing.T) {
+	clientCertPEM, clientKeyPEM := generateCertificate(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(clientCertPEM)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+	serverCAPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	testCases := map[string]struct {
+		data        map[string][]byte
+		noSecretRef bool
+		noSecret    bool
+		expectedErr bool
+	}{
+		"mutual TLS": {
+			data: map[string][]byte{
+				TLSCACertKey:            serverCAPEM,
+				corev1.TLSCertKey:       clientCertPEM,
+				corev1.TLSPrivateKeyKey: clientKeyPEM,
+			},
+		},
+		"no secret ref": {
+			noSecretRef: true,
+			expectedErr: true,
+		},
+		"secret does not exist": {
+			noSecret:    true,
+			expectedErr: true,
+		},
+		"no client certificate": {
+			data: map[string][]byte{
+				TLSCACertKey: serverCAPEM,
+			},
+			expectedErr: true,
+		},
+		"unknown server CA": {
+			data: map[string][]byte{
+				corev1.TLSCertKey:       clientCertPEM,
+				corev1.TLSPrivateKeyKey: clientKeyPEM,
+			},
+			expectedErr: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			fakeSecrets := controllertesting.NewFakeSecrets()
+			if !tc.noSecret {
+				fakeSecrets = controllertesting.NewFakeSecrets(makeTLSSecret("1", tc.data))
+			}
+			defaults := DispatchDefaults{Namespace: tlsSecretNamespace}
+			if !tc.noSecretRef {
+				defaults.TLSSecretRef = &corev1.LocalObjectReference{
+					Name: tlsSecretName,
+				}
+			}
+
+			md := NewMessageDispatcher(zap.NewNop().Sugar(), WithSecretClient(fakeSecrets))
+			err := md.DispatchMessage(&Message{Payload: []byte("payload")}, server.URL, "", defaults)
+			if tc.expectedErr != (err != nil) {
+				t.Errorf("Unexpected error from DispatchMessage. Expected %v. Actual: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestDispatchMessageWithTLS_NoSecretClient(t *testing.T) {
+	md := NewMessageDispatcher(zap.NewNop().Sugar())
+	defaults := DispatchDefaults{
+		Namespace: tlsSecretNamespace,
+		TLSSecretRef: &corev1.LocalObjectReference{
+			Name: tlsSecretName,
+		},
+	}
+	if err := md.DispatchMessage(&Message{}, "https://example.com", "", defaults); err == nil {
+		t.Errorf("Expected an error, actually nil")
+	}
+}
+
+func TestTLSClientCache(t *testing.T) {
+	caPEM, _ := generateCertificate(t)
+	data := map[string][]byte{
+		TLSCACertKey: caPEM,
+	}
+	fakeSecrets := controllertesting.NewFakeSecrets(makeTLSSecret("1", data))
+	secrets := NewSecretCache(fakeSecrets)
+	cache := NewTLSClientCache(secrets)
+	ref := &corev1.LocalObjectReference{
+		Name: tlsSecretName,
+	}
+
+	first, err := cache.HTTPClient(tlsSecretNamespace, ref)
+	if err != nil {
+		t.Fatalf("Unexpected error getting the HTTP client: %v", err)
+	}
+	second, err := cache.HTTPClient(tlsSecretNamespace, ref)
+	if err != nil {
+		t.Fatalf("Unexpected error getting the HTTP client: %v", err)
+	}
+	if first != second {
+		t.Errorf("Expected the cached HTTP client to be reused")
+	}
+
+	fakeSecrets.Update(makeTLSSecret("2", data))
+	waitForSecret(t, secrets, makeTLSSecret("2", data))
+	third, err := cache.HTTPClient(tlsSecretNamespace, ref)
+	if err != nil {
+		t.Fatalf("Unexpected error getting the HTTP client: %v", err)
+	}
+	if third == first {
+		t.Errorf("Expected a new HTTP client after the Secret changed")
+	}
+}
+
+func makeTLSSecret(resourceVersion string, data map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       tlsSecretNamespace,
+			Name:            tlsSecretName,
+			ResourceVersion: resourceVersion,
+		},
+		Data: data,
+	}
+}
+
+// generateCertificate generates a self-signed certificate, usable both as a CA and as a client
+// certificate, and returns it and its private key PEM encoded.
+func generateCertificate(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate a key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unable to create a certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unable to marshal the key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
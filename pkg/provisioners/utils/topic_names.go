@@ -24,3 +24,9 @@ func topicName(channelSeparator string, pieces ...string) string {
 	parts = append(parts, pieces...)
 	return strings.Join(parts, channelSeparator)
 }
+
+// IsChannelTopicName returns true if name has the prefix of the topic names returned by TopicName
+// and TopicNameWithUID.
+func IsChannelTopicName(name string) bool {
+	return strings.HasPrefix(name, knativeChannelPrefix)
+}
@@ -29,3 +29,15 @@ func TestTopicNameWithUID(t *testing.T) {
 		t.Errorf("Expected '%s'. Actual '%s'", expected, actual)
 	}
 }
+
+func TestIsChannelTopicName(t *testing.T) {
+	for name, expected := range map[string]bool{
+		TopicName(".", "channel-namespace", "channel-name"):           true,
+		TopicNameWithUID("_", "gcp", types.UID("78f8428c-15c3-11e9")): true,
+		"orders": false,
+	} {
+		if actual := IsChannelTopicName(name); expected != actual {
+			t.Errorf("IsChannelTopicName(%q). Expected %v. Actual %v", name, expected, actual)
+		}
+	}
+}
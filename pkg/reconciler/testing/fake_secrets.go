@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// FakeSecrets is an in-memory corev1client.SecretsGetter. Only Get and Watch are implemented by
+// the SecretInterfaces it returns; the Secrets are changed with Update and Delete.
+type FakeSecrets struct {
+	lock     sync.Mutex
+	secrets  map[types.NamespacedName]*corev1.Secret
+	watchers map[string][]*fakeSecretWatch
+	gets     int
+}
+
+var _ corev1client.SecretsGetter = (*FakeSecrets)(nil)
+
+// NewFakeSecrets creates a FakeSecrets holding secrets.
+func NewFakeSecrets(secrets ...*corev1.Secret) *FakeSecrets {
+	f := &FakeSecrets{
+		secrets:  make(map[types.NamespacedName]*corev1.Secret),
+		watchers: make(map[string][]*fakeSecretWatch),
+	}
+	for _, s := range secrets {
+		f.secrets[types.NamespacedName{Namespace: s.Namespace, Name: s.Name}] = s.DeepCopy()
+	}
+	return f
+}
+
+// Secrets returns the SecretInterface of namespace.
+func (f *FakeSecrets) Secrets(namespace string) corev1client.SecretInterface {
+	return &fakeSecretInterface{fake: f, namespace: namespace}
+}
+
+// Gets returns the number of Secrets read with Get so far.
+func (f *FakeSecrets) Gets() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.gets
+}
+
+// Update creates or replaces secret, and sends it to the watchers of its namespace.
+func (f *FakeSecrets) Update(secret *corev1.Secret) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.secrets[types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}] = secret.DeepCopy()
+	f.send(secret.Namespace, watch.Event{Type: watch.Modified, Object: secret.DeepCopy()})
+}
+
+// Delete removes secret, and sends its deletion to the watchers of its namespace.
+func (f *FakeSecrets) Delete(secret *corev1.Secret) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.secrets, types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name})
+	f.send(secret.Namespace, watch.Event{Type: watch.Deleted, Object: secret.DeepCopy()})
+}
+
+// send sends event to the watchers of namespace that were not stopped. f.lock must be held.
+func (f *FakeSecrets) send(namespace string, event watch.Event) {
+	watching := f.watchers[namespace][:0]
+	for _, w := range f.watchers[namespace] {
+		select {
+		case <-w.stopped:
+			continue
+		case w.result <- event:
+		}
+		watching = append(watching, w)
+	}
+	f.watchers[namespace] = watching
+}
+
+type fakeSecretInterface struct {
+	// The methods other than Get and Watch are not implemented.
+	corev1client.SecretInterface
+	fake      *FakeSecrets
+	namespace string
+}
+
+func (i *fakeSecretInterface) Get(name string, options metav1.GetOptions) (*corev1.Secret, error) {
+	i.fake.lock.Lock()
+	defer i.fake.lock.Unlock()
+	i.fake.gets++
+	secret, present := i.fake.secrets[types.NamespacedName{Namespace: i.namespace, Name: name}]
+	if !present {
+		return nil, apierrors.NewNotFound(corev1.Resource("secrets"), name)
+	}
+	return secret.DeepCopy(), nil
+}
+
+// Watch watches all the Secrets of the namespace, whatever opts.
+func (i *fakeSecretInterface) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	i.fake.lock.Lock()
+	defer i.fake.lock.Unlock()
+	w := &fakeSecretWatch{
+		result:  make(chan watch.Event, 100),
+		stopped: make(chan struct{}),
+	}
+	i.fake.watchers[i.namespace] = append(i.fake.watchers[i.namespace], w)
+	return w, nil
+}
+
+// fakeSecretWatch is a watch.Interface that can be stopped while events are sent to it.
+type fakeSecretWatch struct {
+	result   chan watch.Event
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func (w *fakeSecretWatch) Stop() {
+	w.stopOnce.Do(func() { close(w.stopped) })
+}
+
+func (w *fakeSecretWatch) ResultChan() <-chan watch.Event {
+	return w.result
+}
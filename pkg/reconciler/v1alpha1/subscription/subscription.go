@@ -23,6 +23,7 @@ import (
 	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
 	"github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
 	"github.com/knative/eventing/pkg/logging"
+	"github.com/knative/eventing/pkg/provisioners"
 	"github.com/knative/eventing/pkg/utils/resolve"
 	"github.com/knative/pkg/apis/duck"
 	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
@@ -57,7 +58,11 @@ const (
 	physicalChannelSyncFailed      = "PhysicalChannelSyncFailed"
 	channelReferenceFetchFailed    = "ChannelReferenceFetchFailed"
 	subscriberResolveFailed        = "SubscriberResolveFailed"
+	subscriberCredentialsFailed    = "SubscriberCredentialsResolveFailed"
 	resultResolveFailed            = "ResultResolveFailed"
+
+	// Reason of the ReferencesResolved condition when the subscriber's Secrets are unusable.
+	credentialsNotResolved = "CredentialsNotResolved"
 )
 
 type reconciler struct {
@@ -74,11 +79,12 @@ var _ reconcile.Reconciler = &reconciler{}
 // ProvideController returns a Subscription controller.
 func ProvideController(mgr manager.Manager, logger *zap.Logger) (controller.Controller, error) {
 	// Setup a new controller to Reconcile Subscriptions.
+	r := &reconciler{
+		recorder: mgr.GetRecorder(controllerAgentName),
+		logger:   logger,
+	}
 	c, err := controller.New(controllerAgentName, mgr, controller.Options{
-		Reconciler: &reconciler{
-			recorder: mgr.GetRecorder(controllerAgentName),
-			logger:   logger,
-		},
+		Reconciler: r,
 	})
 	if err != nil {
 		return nil, err
@@ -89,9 +95,69 @@ func ProvideController(mgr manager.Manager, logger *zap.Logger) (controller.Cont
 		return nil, err
 	}
 
+	// Watch for Secret changes. E.g. if a missing or invalid credentials Secret is fixed, the
+	// Subscriptions referencing it need to be reconciled again to become Ready.
+	if err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: &mapSecretToSubscriptions{r: r}}); err != nil {
+		return nil, err
+	}
+
 	return c, nil
 }
 
+// mapSecretToSubscriptions maps Secret changes to all the Subscriptions whose subscriber references
+// that Secret.
+type mapSecretToSubscriptions struct {
+	r *reconciler
+}
+
+func (m *mapSecretToSubscriptions) Map(o handler.MapObject) []reconcile.Request {
+	ctx := context.Background()
+	subscriptions := make([]reconcile.Request, 0)
+
+	opts := &client.ListOptions{
+		Namespace: o.Meta.GetNamespace(),
+		// Set Raw because if we need to get more than one page, then we will put the continue token
+		// into opts.Raw.Continue.
+		Raw: &metav1.ListOptions{},
+	}
+	for {
+		sl := &v1alpha1.SubscriptionList{}
+		if err := m.r.client.List(ctx, opts, sl); err != nil {
+			m.r.logger.Error("Error listing Subscriptions when Secret changed. Some Subscriptions may not be reconciled.", zap.Error(err), zap.String("secret", o.Meta.GetName()))
+			return subscriptions
+		}
+
+		for i := range sl.Items {
+			sub := &sl.Items[i]
+			if referencesSecret(sub, o.Meta.GetName()) {
+				subscriptions = append(subscriptions, reconcile.Request{
+					NamespacedName: types.NamespacedName{
+						Namespace: sub.Namespace,
+						Name:      sub.Name,
+					},
+				})
+			}
+		}
+		if sl.Continue != "" {
+			opts.Raw.Continue = sl.Continue
+		} else {
+			return subscriptions
+		}
+	}
+}
+
+// referencesSecret returns true if the TLS or credentials Secret of sub's subscriber is the Secret
+// named name, in sub's namespace.
+func referencesSecret(sub *v1alpha1.Subscription, name string) bool {
+	if ref := subscriberTLSSecretRef(sub); ref != nil && ref.Name == name {
+		return true
+	}
+	if _, ref := subscriberAuth(sub); ref != nil && ref.Name == name {
+		return true
+	}
+	return false
+}
+
 // Reconcile compares the actual state with the desired, and attempts to
 // converge the two. It then updates the Status block of the Subscription resource
 // with the current status of the resource.
@@ -170,6 +236,15 @@ func (r *reconciler) reconcile(ctx context.Context, subscription *v1alpha1.Subsc
 		logging.FromContext(ctx).Debug("Resolved Subscriber", zap.String("subscriberURI", subscriberURI))
 	}
 
+	if err := r.resolveSubscriberCredentials(ctx, subscription); err != nil {
+		logging.FromContext(ctx).Warn("Failed to resolve Subscriber credentials",
+			zap.Error(err),
+			zap.Any("subscriber", subscription.Spec.Subscriber))
+		r.recorder.Eventf(subscription, corev1.EventTypeWarning, subscriberCredentialsFailed, "Failed to resolve spec.subscriber credentials: %v", err)
+		subscription.Status.MarkReferencesNotResolved(credentialsNotResolved, "Failed to resolve spec.subscriber credentials: %v", err)
+		return err
+	}
+
 	if replyURI, err := r.resolveResult(ctx, subscription.Namespace, subscription.Spec.Reply); err != nil {
 		logging.FromContext(ctx).Warn("Failed to resolve reply",
 			zap.Error(err),
@@ -327,7 +402,7 @@ func (r *reconciler) createSubscribable(subs []v1alpha1.Subscription) *eventingd
 	rv := &eventingduck.Subscribable{}
 	for _, sub := range subs {
 		if sub.Status.PhysicalSubscription.SubscriberURI != "" || sub.Status.PhysicalSubscription.ReplyURI != "" {
-			rv.Subscribers = append(rv.Subscribers, eventingduck.ChannelSubscriberSpec{
+			subscriber := eventingduck.ChannelSubscriberSpec{
 				Ref: &corev1.ObjectReference{
 					APIVersion: sub.APIVersion,
 					Kind:       sub.Kind,
@@ -337,12 +412,89 @@ func (r *reconciler) createSubscribable(subs []v1alpha1.Subscription) *eventingd
 				},
 				SubscriberURI: sub.Status.PhysicalSubscription.SubscriberURI,
 				ReplyURI:      sub.Status.PhysicalSubscription.ReplyURI,
-			})
+				TLSSecretRef:  subscriberTLSSecretRef(&sub),
+				Delivery:      sub.Spec.Delivery.DeepCopy(),
+				Start:         sub.Spec.Start.DeepCopy(),
+				Reset:         subscriberReset(&sub),
+			}
+			subscriber.AuthType, subscriber.AuthSecretRef = subscriberAuth(&sub)
+			rv.Subscribers = append(rv.Subscribers, subscriber)
 		}
 	}
 	return rv
 }
 
+// subscriberReset returns the position sub's subscriber is reset to, or nil if sub does not
+// have a valid reset annotation. The webhook rejects invalid annotations, so those are
+// ignored.
+func subscriberReset(sub *v1alpha1.Subscription) *eventingduck.StartSpec {
+	reset, ok := sub.Annotations[v1alpha1.ResetPositionAnnotation]
+	if !ok {
+		return nil
+	}
+	start, err := eventingduck.ParseStartSpec(reset)
+	if err != nil {
+		return nil
+	}
+	return start
+}
+
+// subscriberTLSSecretRef returns a reference to the Secret, in sub's namespace, holding the TLS
+// material used to deliver events to sub's subscriber, or nil if sub does not configure any.
+func subscriberTLSSecretRef(sub *v1alpha1.Subscription) *corev1.LocalObjectReference {
+	if sub.Spec.Subscriber == nil || sub.Spec.Subscriber.TLS == nil || sub.Spec.Subscriber.TLS.SecretRef == nil {
+		return nil
+	}
+	return &corev1.LocalObjectReference{
+		Name: sub.Spec.Subscriber.TLS.SecretRef.Name,
+	}
+}
+
+// subscriberAuth returns the authentication type and a reference to the Secret, in sub's
+// namespace, holding the credentials used to deliver events to sub's subscriber, or nil if sub
+// does not configure any.
+func subscriberAuth(sub *v1alpha1.Subscription) (string, *corev1.LocalObjectReference) {
+	if sub.Spec.Subscriber == nil || sub.Spec.Subscriber.Auth == nil || sub.Spec.Subscriber.Auth.SecretRef == nil {
+		return "", nil
+	}
+	return string(sub.Spec.Subscriber.Auth.Type), &corev1.LocalObjectReference{
+		Name: sub.Spec.Subscriber.Auth.SecretRef.Name,
+	}
+}
+
+// resolveSubscriberCredentials verifies that the TLS and credentials Secrets referenced by sub's
+// subscriber exist and hold usable values. The Secrets themselves are only read by the
+// dispatchers, they are never copied into the Channel.
+func (r *reconciler) resolveSubscriberCredentials(ctx context.Context, sub *v1alpha1.Subscription) error {
+	if ref := subscriberTLSSecretRef(sub); ref != nil {
+		secret, err := r.getSecret(ctx, sub.Namespace, ref.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := provisioners.TLSConfigFromSecret(secret); err != nil {
+			return fmt.Errorf("invalid TLS Secret %q: %v", ref.Name, err)
+		}
+	}
+	if authType, ref := subscriberAuth(sub); ref != nil {
+		secret, err := r.getSecret(ctx, sub.Namespace, ref.Name)
+		if err != nil {
+			return err
+		}
+		if err := provisioners.ValidateAuthSecret(authType, secret); err != nil {
+			return fmt.Errorf("invalid credentials Secret %q: %v", ref.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *reconciler) getSecret(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := r.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret); err != nil {
+		return nil, fmt.Errorf("unable to get Secret %q: %v", name, err)
+	}
+	return secret, nil
+}
+
 func (r *reconciler) patchPhysicalFrom(ctx context.Context, namespace string, physicalFrom corev1.ObjectReference, subs *eventingduck.Subscribable) error {
 	// First get the original object and convert it to only the bits we care about
 	s, err := resolve.ObjectReference(ctx, r.dynamicClient, namespace, &physicalFrom)
@@ -19,7 +19,9 @@ package subscription
 import (
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"go.uber.org/zap"
 
 	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
@@ -32,9 +34,12 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
@@ -52,6 +57,7 @@ var (
 		physicalChannelSyncFailed:      {Reason: physicalChannelSyncFailed, Type: corev1.EventTypeWarning},
 		channelReferenceFetchFailed:    {Reason: channelReferenceFetchFailed, Type: corev1.EventTypeWarning},
 		subscriberResolveFailed:        {Reason: subscriberResolveFailed, Type: corev1.EventTypeWarning},
+		subscriberCredentialsFailed:    {Reason: subscriberCredentialsFailed, Type: corev1.EventTypeWarning},
 		resultResolveFailed:            {Reason: resultResolveFailed, Type: corev1.EventTypeWarning},
 	}
 )
@@ -69,6 +75,7 @@ const (
 	subscriptionName  = "testsubscription"
 	testNS            = "testnamespace"
 	k8sServiceName    = "testk8sservice"
+	authSecretName    = "subscriber-auth"
 )
 
 var (
@@ -230,6 +237,97 @@ func TestAllCases(t *testing.T) {
 					},
 				},
 			},
+		}, {
+			Name: "Valid channel and subscriber, credentials Secret does not exist",
+			InitialState: []runtime.Object{
+				Subscription().Auth(),
+			},
+			WantErrMsg: `unable to get Secret "subscriber-auth": secrets "subscriber-auth" not found`,
+			WantPresent: []runtime.Object{
+				Subscription().Auth().UnknownConditions().PhysicalSubscriber(targetDNS).CredentialsNotResolved(`unable to get Secret "subscriber-auth": secrets "subscriber-auth" not found`),
+			},
+			WantEvent: []corev1.Event{
+				events[subscriberCredentialsFailed],
+			},
+			IgnoreTimes: true,
+			Objects: []runtime.Object{
+				// Source channel
+				&unstructured.Unstructured{
+					Object: map[string]interface{}{
+						"apiVersion": eventingv1alpha1.SchemeGroupVersion.String(),
+						"kind":       channelKind,
+						"metadata": map[string]interface{}{
+							"namespace": testNS,
+							"name":      fromChannelName,
+						},
+						"spec": map[string]interface{}{
+							"subscribable": map[string]interface{}{},
+						},
+					},
+				},
+				// Subscriber (using knative route)
+				&unstructured.Unstructured{
+					Object: map[string]interface{}{
+						"apiVersion": "serving.knative.dev/v1alpha1",
+						"kind":       routeKind,
+						"metadata": map[string]interface{}{
+							"namespace": testNS,
+							"name":      routeName,
+						},
+						"status": map[string]interface{}{
+							"address": map[string]interface{}{
+								"hostname": targetDNS,
+							},
+						},
+					},
+				},
+			},
+		}, {
+			Name: "Valid channel and subscriber, credentials Secret is invalid",
+			InitialState: []runtime.Object{
+				Subscription().Auth(),
+				getAuthSecret(map[string][]byte{}),
+			},
+			WantErrMsg: `invalid credentials Secret "subscriber-auth": missing "token"`,
+			WantPresent: []runtime.Object{
+				Subscription().Auth().UnknownConditions().PhysicalSubscriber(targetDNS).CredentialsNotResolved(`invalid credentials Secret "subscriber-auth": missing "token"`),
+			},
+			WantEvent: []corev1.Event{
+				events[subscriberCredentialsFailed],
+			},
+			IgnoreTimes: true,
+			Objects: []runtime.Object{
+				// Source channel
+				&unstructured.Unstructured{
+					Object: map[string]interface{}{
+						"apiVersion": eventingv1alpha1.SchemeGroupVersion.String(),
+						"kind":       channelKind,
+						"metadata": map[string]interface{}{
+							"namespace": testNS,
+							"name":      fromChannelName,
+						},
+						"spec": map[string]interface{}{
+							"subscribable": map[string]interface{}{},
+						},
+					},
+				},
+				// Subscriber (using knative route)
+				&unstructured.Unstructured{
+					Object: map[string]interface{}{
+						"apiVersion": "serving.knative.dev/v1alpha1",
+						"kind":       routeKind,
+						"metadata": map[string]interface{}{
+							"namespace": testNS,
+							"name":      routeName,
+						},
+						"status": map[string]interface{}{
+							"address": map[string]interface{}{
+								"hostname": targetDNS,
+							},
+						},
+					},
+				},
+			},
 		}, {
 			Name: "Valid channel and subscriber, result does not exist",
 			InitialState: []runtime.Object{
@@ -1149,6 +1247,181 @@ func TestFinalizers(t *testing.T) {
 	}
 }
 
+func TestCreateSubscribableWithCredentials(t *testing.T) {
+	sub := eventingv1alpha1.Subscription{
+		TypeMeta:   subscriptionType(),
+		ObjectMeta: om(testNS, subscriptionName),
+		Spec: eventingv1alpha1.SubscriptionSpec{
+			Subscriber: &eventingv1alpha1.SubscriberSpec{
+				DNSName: &targetDNS,
+				TLS: &eventingv1alpha1.SubscriberTLS{
+					SecretRef: &corev1.LocalObjectReference{
+						Name: "subscriber-tls",
+					},
+				},
+				Auth: &eventingv1alpha1.SubscriberAuth{
+					Type: eventingv1alpha1.SubscriberAuthOAuth2,
+					SecretRef: &corev1.LocalObjectReference{
+						Name: authSecretName,
+					},
+				},
+			},
+		},
+		Status: eventingv1alpha1.SubscriptionStatus{
+			PhysicalSubscription: eventingv1alpha1.SubscriptionStatusPhysicalSubscription{
+				SubscriberURI: targetDNS,
+			},
+		},
+	}
+
+	r := &reconciler{}
+	subscribable := r.createSubscribable([]eventingv1alpha1.Subscription{sub})
+
+	want := []eventingduck.ChannelSubscriberSpec{{
+		Ref: &corev1.ObjectReference{
+			APIVersion: eventingv1alpha1.SchemeGroupVersion.String(),
+			Kind:       subscriptionKind,
+			Namespace:  testNS,
+			Name:       subscriptionName,
+		},
+		SubscriberURI: targetDNS,
+		TLSSecretRef: &corev1.LocalObjectReference{
+			Name: "subscriber-tls",
+		},
+		AuthType: "OAuth2",
+		AuthSecretRef: &corev1.LocalObjectReference{
+			Name: authSecretName,
+		},
+	}}
+	if diff := cmp.Diff(want, subscribable.Subscribers); diff != "" {
+		t.Errorf("Unexpected subscribers (-want +got): %v", diff)
+	}
+}
+
+func TestMapSecretToSubscriptions(t *testing.T) {
+	r := &reconciler{
+		client: fake.NewFakeClient(
+			Subscription().Auth().Build(),
+			Subscription().Renamed().Build(),
+		),
+		logger: zap.NewNop(),
+	}
+	m := &mapSecretToSubscriptions{r: r}
+
+	testCases := map[string]struct {
+		secret *corev1.Secret
+		want   []reconcile.Request
+	}{
+		"referenced": {
+			secret: getAuthSecret(nil),
+			want: []reconcile.Request{{
+				NamespacedName: types.NamespacedName{Namespace: testNS, Name: subscriptionName},
+			}},
+		},
+		"not referenced": {
+			secret: &corev1.Secret{ObjectMeta: om(testNS, "other-secret")},
+			want:   []reconcile.Request{},
+		},
+		"other namespace": {
+			secret: &corev1.Secret{ObjectMeta: om("other-namespace", authSecretName)},
+			want:   []reconcile.Request{},
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			got := m.Map(handler.MapObject{Meta: tc.secret.GetObjectMeta(), Object: tc.secret})
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Unexpected requests (-want +got): %v", diff)
+			}
+		})
+	}
+}
+
+func TestCreateSubscribableWithDelivery(t *testing.T) {
+	delivery := &eventingduck.DeliverySpec{
+		Strategy:    eventingduck.DeliveryStrategyRequeue,
+		MaxAttempts: 5,
+		Backoff:     "2s",
+		DeadLetter:  true,
+	}
+	sub := eventingv1alpha1.Subscription{
+		TypeMeta:   subscriptionType(),
+		ObjectMeta: om(testNS, subscriptionName),
+		Spec: eventingv1alpha1.SubscriptionSpec{
+			Subscriber: &eventingv1alpha1.SubscriberSpec{
+				DNSName: &targetDNS,
+			},
+			Delivery: delivery,
+		},
+		Status: eventingv1alpha1.SubscriptionStatus{
+			PhysicalSubscription: eventingv1alpha1.SubscriptionStatusPhysicalSubscription{
+				SubscriberURI: targetDNS,
+			},
+		},
+	}
+
+	r := &reconciler{}
+	subscribable := r.createSubscribable([]eventingv1alpha1.Subscription{sub})
+
+	want := []eventingduck.ChannelSubscriberSpec{{
+		Ref: &corev1.ObjectReference{
+			APIVersion: eventingv1alpha1.SchemeGroupVersion.String(),
+			Kind:       subscriptionKind,
+			Namespace:  testNS,
+			Name:       subscriptionName,
+		},
+		SubscriberURI: targetDNS,
+		Delivery:      delivery,
+	}}
+	if diff := cmp.Diff(want, subscribable.Subscribers); diff != "" {
+		t.Errorf("Unexpected subscribers (-want +got): %v", diff)
+	}
+}
+
+func TestCreateSubscribableWithStartAndReset(t *testing.T) {
+	start := &eventingduck.StartSpec{
+		Position: eventingduck.StartPositionEarliest,
+	}
+	sub := eventingv1alpha1.Subscription{
+		TypeMeta:   subscriptionType(),
+		ObjectMeta: om(testNS, subscriptionName),
+		Spec: eventingv1alpha1.SubscriptionSpec{
+			Subscriber: &eventingv1alpha1.SubscriberSpec{
+				DNSName: &targetDNS,
+			},
+			Start: start,
+		},
+		Status: eventingv1alpha1.SubscriptionStatus{
+			PhysicalSubscription: eventingv1alpha1.SubscriptionStatusPhysicalSubscription{
+				SubscriberURI: targetDNS,
+			},
+		},
+	}
+	sub.Annotations = map[string]string{
+		eventingv1alpha1.ResetPositionAnnotation: "2019-03-01T00:00:00Z",
+	}
+
+	r := &reconciler{}
+	subscribable := r.createSubscribable([]eventingv1alpha1.Subscription{sub})
+
+	want := []eventingduck.ChannelSubscriberSpec{{
+		Ref: &corev1.ObjectReference{
+			APIVersion: eventingv1alpha1.SchemeGroupVersion.String(),
+			Kind:       subscriptionKind,
+			Namespace:  testNS,
+			Name:       subscriptionName,
+		},
+		SubscriberURI: targetDNS,
+		Start:         start,
+		Reset: &eventingduck.StartSpec{
+			Time: &metav1.Time{Time: time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}}
+	if diff := cmp.Diff(want, subscribable.Subscribers); diff != "" {
+		t.Errorf("Unexpected subscribers (-want +got): %v", diff)
+	}
+}
+
 func getNewFromChannel() *eventingv1alpha1.Channel {
 	return getNewChannel(fromChannelName)
 }
@@ -1273,6 +1546,21 @@ func (s *SubscriptionBuilder) ReferencesResolved() *SubscriptionBuilder {
 	return s
 }
 
+func (s *SubscriptionBuilder) CredentialsNotResolved(message string) *SubscriptionBuilder {
+	s.Status.MarkReferencesNotResolved(credentialsNotResolved, "Failed to resolve spec.subscriber credentials: %v", message)
+	return s
+}
+
+func (s *SubscriptionBuilder) Auth() *SubscriptionBuilder {
+	s.Spec.Subscriber.Auth = &eventingv1alpha1.SubscriberAuth{
+		Type: eventingv1alpha1.SubscriberAuthBearer,
+		SecretRef: &corev1.LocalObjectReference{
+			Name: authSecretName,
+		},
+	}
+	return s
+}
+
 func (s *SubscriptionBuilder) Reply() *SubscriptionBuilder {
 	s.Status.PhysicalSubscription.ReplyURI = resolve.DomainToURL(sinkableDNS)
 	return s
@@ -1333,6 +1621,17 @@ func getK8sService() *corev1.Service {
 	}
 }
 
+func getAuthSecret(data map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: om(testNS, authSecretName),
+		Data:       data,
+	}
+}
+
 func getChannelWithMultipleSubscriptions() *eventingv1alpha1.Channel {
 	return &eventingv1alpha1.Channel{
 		TypeMeta: metav1.TypeMeta{
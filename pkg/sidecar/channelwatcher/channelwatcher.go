@@ -0,0 +1,153 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package channelwatcher builds a dispatcher's configuration directly from the Channels, as an
+// alternative to reading it from the ConfigMap written by the provisioner's controller.
+package channelwatcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+	"github.com/knative/eventing/pkg/sidecar/multichannelfanout"
+	"github.com/knative/eventing/pkg/sidecar/swappable"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// controllerAgentName is the string used by this controller to identify itself.
+	controllerAgentName = "channel-watcher"
+
+	// ConfigSourceEnv is the environment variable choosing where a dispatcher reads the Channels'
+	// configuration from, either ConfigSourceChannels or ConfigSourceConfigMap. See
+	// ConfigSourceFromEnv.
+	ConfigSourceEnv = "CONFIG_SOURCE"
+	// ConfigSourceChannels reads the configuration from the Channels, with New.
+	ConfigSourceChannels = "channels"
+	// ConfigSourceConfigMap reads the configuration from the ConfigMap written by the
+	// provisioner's controller.
+	ConfigSourceConfigMap = "configmap"
+)
+
+var (
+	// configKey is the single key every Channel event is mapped to. The whole configuration is
+	// rebuilt from all the Channels on every event, so a burst of events is handled once.
+	configKey = reconcile.Request{NamespacedName: types.NamespacedName{Name: "config"}}
+)
+
+// ConfigSourceFromEnv returns the configuration source set by the ConfigSourceEnv environment
+// variable, or ConfigSourceConfigMap if it is not set.
+func ConfigSourceFromEnv() (string, error) {
+	v := os.Getenv(ConfigSourceEnv)
+	switch v {
+	case "":
+		return ConfigSourceConfigMap, nil
+	case ConfigSourceChannels, ConfigSourceConfigMap:
+		return v, nil
+	default:
+		return "", fmt.Errorf("invalid %s %q: it must be %q or %q", ConfigSourceEnv, v, ConfigSourceChannels, ConfigSourceConfigMap)
+	}
+}
+
+// ShouldWatchFunc returns true if the subscribers of c are part of the dispatcher's
+// configuration, typically because c belongs to the dispatcher's provisioner.
+type ShouldWatchFunc func(c *eventingv1alpha1.Channel) bool
+
+// ConfigFunc builds the dispatcher's configuration from the watched Channels.
+type ConfigFunc func(channels []eventingv1alpha1.Channel) *multichannelfanout.Config
+
+type reconciler struct {
+	client        client.Client
+	logger        *zap.Logger
+	shouldWatch   ShouldWatchFunc
+	toConfig      ConfigFunc
+	configUpdated swappable.UpdateConfig
+}
+
+// Verify the struct implements reconcile.Reconciler
+var _ reconcile.Reconciler = &reconciler{}
+
+// New creates a controller, run by mgr, that watches the Channels through mgr's informers.
+// Whenever any Channel changes, configUpdated is called with the configuration built by toConfig
+// from all the Channels for which shouldWatch returns true.
+func New(mgr manager.Manager, logger *zap.Logger, shouldWatch ShouldWatchFunc, toConfig ConfigFunc, configUpdated swappable.UpdateConfig) error {
+	r := &reconciler{
+		client:        mgr.GetClient(),
+		logger:        logger,
+		shouldWatch:   shouldWatch,
+		toConfig:      toConfig,
+		configUpdated: configUpdated,
+	}
+	c, err := controller.New(controllerAgentName, mgr, controller.Options{
+		Reconciler: r,
+	})
+	if err != nil {
+		logger.Error("Unable to create controller.", zap.Error(err))
+		return err
+	}
+
+	err = c.Watch(&source.Kind{
+		Type: &eventingv1alpha1.Channel{},
+	}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(handler.MapObject) []reconcile.Request {
+			return []reconcile.Request{configKey}
+		}),
+	})
+	if err != nil {
+		logger.Error("Unable to watch Channels.", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// Reconcile rebuilds the dispatcher's configuration from the watched Channels.
+func (r *reconciler) Reconcile(reconcile.Request) (reconcile.Result, error) {
+	ctx := context.TODO()
+	channels, err := ListChannels(ctx, r.client, r.shouldWatch)
+	if err != nil {
+		r.logger.Info("Unable to list channels", zap.Error(err))
+		return reconcile.Result{}, err
+	}
+	if err := r.configUpdated(r.toConfig(channels)); err != nil {
+		r.logger.Error("Unable to update config", zap.Error(err))
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, nil
+}
+
+// ListChannels lists all the Channels for which shouldWatch returns true.
+func ListChannels(ctx context.Context, c client.Client, shouldWatch ShouldWatchFunc) ([]eventingv1alpha1.Channel, error) {
+	channels := make([]eventingv1alpha1.Channel, 0)
+	cl := &eventingv1alpha1.ChannelList{}
+	if err := c.List(ctx, &client.ListOptions{}, cl); err != nil {
+		return nil, err
+	}
+	for _, ch := range cl.Items {
+		if shouldWatch(&ch) {
+			channels = append(channels, ch)
+		}
+	}
+	return channels, nil
+}
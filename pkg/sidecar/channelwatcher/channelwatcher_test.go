@@ -0,0 +1,182 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package channelwatcher
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+	"github.com/knative/eventing/pkg/sidecar/fanout"
+	"github.com/knative/eventing/pkg/sidecar/multichannelfanout"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func init() {
+	// Add types to scheme.
+	eventingv1alpha1.AddToScheme(scheme.Scheme)
+}
+
+func makeChannel(namespace, name, provisioner string, subscribers ...string) *eventingv1alpha1.Channel {
+	c := &eventingv1alpha1.Channel{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: eventingv1alpha1.SchemeGroupVersion.String(),
+			Kind:       "Channel",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Spec: eventingv1alpha1.ChannelSpec{
+			Provisioner: &corev1.ObjectReference{Name: provisioner},
+		},
+	}
+	if len(subscribers) > 0 {
+		c.Spec.Subscribable = &eventingduck.Subscribable{}
+		for _, s := range subscribers {
+			c.Spec.Subscribable.Subscribers = append(c.Spec.Subscribable.Subscribers, eventingduck.ChannelSubscriberSpec{SubscriberURI: s})
+		}
+	}
+	return c
+}
+
+func isTestProvisioner(c *eventingv1alpha1.Channel) bool {
+	return c.Spec.Provisioner.Name == "test"
+}
+
+func TestReconcile(t *testing.T) {
+	testCases := map[string]struct {
+		objects     []runtime.Object
+		updateErr   error
+		expected    *multichannelfanout.Config
+		expectedErr bool
+	}{
+		"no channels": {
+			expected: &multichannelfanout.Config{
+				ChannelConfigs: []multichannelfanout.ChannelConfig{},
+			},
+		},
+		"only the watched channels": {
+			objects: []runtime.Object{
+				makeChannel("ns", "a", "test", "sub1", "sub2"),
+				makeChannel("ns", "b", "other", "sub3"),
+				makeChannel("other-ns", "c", "test"),
+			},
+			expected: &multichannelfanout.Config{
+				ChannelConfigs: []multichannelfanout.ChannelConfig{
+					{
+						Namespace: "ns",
+						Name:      "a",
+						FanoutConfig: fanout.Config{
+							Subscriptions: []eventingduck.ChannelSubscriberSpec{
+								{SubscriberURI: "sub1"},
+								{SubscriberURI: "sub2"},
+							},
+						},
+					},
+					{
+						Namespace: "other-ns",
+						Name:      "c",
+					},
+				},
+			},
+		},
+		"update fails": {
+			objects: []runtime.Object{
+				makeChannel("ns", "a", "test", "sub1"),
+			},
+			updateErr:   errors.New("test-induced-error"),
+			expectedErr: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			var updated *multichannelfanout.Config
+			r := &reconciler{
+				client:      fake.NewFakeClient(tc.objects...),
+				logger:      zap.NewNop(),
+				shouldWatch: isTestProvisioner,
+				toConfig:    multichannelfanout.NewConfigFromChannels,
+				configUpdated: func(config *multichannelfanout.Config) error {
+					updated = config
+					return tc.updateErr
+				},
+			}
+			_, err := r.Reconcile(configKey)
+			if tc.expectedErr != (err != nil) {
+				t.Errorf("Unexpected error. Expected error %v, Actual %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr {
+				return
+			}
+			if diff := cmp.Diff(tc.expected, updated); diff != "" {
+				t.Errorf("Unexpected config (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestConfigSourceFromEnv(t *testing.T) {
+	testCases := map[string]struct {
+		value    string
+		set      bool
+		expected string
+		wantErr  bool
+	}{
+		"unset": {
+			expected: ConfigSourceConfigMap,
+		},
+		"channels": {
+			value:    "channels",
+			set:      true,
+			expected: ConfigSourceChannels,
+		},
+		"configmap": {
+			value:    "configmap",
+			set:      true,
+			expected: ConfigSourceConfigMap,
+		},
+		"invalid": {
+			value:   "secret",
+			set:     true,
+			wantErr: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			if tc.set {
+				os.Setenv(ConfigSourceEnv, tc.value)
+				defer os.Unsetenv(ConfigSourceEnv)
+			}
+			source, err := ConfigSourceFromEnv()
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("Unexpected error. Expected an error: %v. Actual %v", tc.wantErr, err)
+			}
+			if source != tc.expected {
+				t.Errorf("Unexpected config source. Expected %q. Actual %q", tc.expected, source)
+			}
+		})
+	}
+}
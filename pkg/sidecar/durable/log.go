@@ -0,0 +1,313 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package durable
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/knative/eventing/pkg/provisioners"
+)
+
+const (
+	segmentSuffix = ".log"
+
+	// recordHeaderSize is the size of the header preceding each record: the length of the
+	// payload and its CRC32 checksum.
+	recordHeaderSize = 8
+
+	defaultMaxSegmentBytes = 16 * 1024 * 1024
+)
+
+var (
+	// errNoMessage is returned by Log.Read for offsets that have not been written yet.
+	errNoMessage = errors.New("no message at offset")
+	// errLogClosed is returned when a closed Log is used.
+	errLogClosed = errors.New("log closed")
+)
+
+// Log is an append-only log of messages, split into segment files. Each message is identified by
+// its offset, which starts at zero and increases by one with every appended message. Segments
+// are named after the offset of their first message, and are deleted once all the subscribers
+// have consumed them.
+//
+// Each record is made of the length of the encoded message and its CRC32 checksum, both 32 bit
+// big endian integers, followed by the JSON encoded message. A record torn by a crash is
+// discarded when the log is opened.
+type Log struct {
+	dir             string
+	maxSegmentBytes int64
+
+	// filesLock is held for reading while a segment file is read outside of lock, and for writing
+	// while segment files are closed. It is acquired before lock.
+	filesLock sync.RWMutex
+	lock      sync.Mutex
+	segments  []*segment
+	closed    bool
+	// appended is closed, and replaced, whenever a message is appended.
+	appended chan struct{}
+}
+
+type segment struct {
+	baseOffset int64
+	file       *os.File
+	size       int64
+	// positions holds the position of each record in the file.
+	positions []int64
+}
+
+func (s *segment) nextOffset() int64 {
+	return s.baseOffset + int64(len(s.positions))
+}
+
+// OpenLog opens the Log stored in dir, creating it if needed.
+func OpenLog(dir string, maxSegmentBytes int64) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := &Log{
+		dir:             dir,
+		maxSegmentBytes: maxSegmentBytes,
+		appended:        make(chan struct{}),
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var bases []int64
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), segmentSuffix) {
+			continue
+		}
+		base, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	for _, base := range bases {
+		s, err := l.openSegment(base)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.segments = append(l.segments, s)
+	}
+	if len(l.segments) == 0 {
+		s, err := l.openSegment(0)
+		if err != nil {
+			return nil, err
+		}
+		l.segments = append(l.segments, s)
+	}
+	return l, nil
+}
+
+func (l *Log) segmentPath(base int64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", base, segmentSuffix))
+}
+
+// openSegment opens, or creates, the segment starting at base and indexes its records. Anything
+// after the last complete record is truncated.
+func (l *Log) openSegment(base int64) (*segment, error) {
+	f, err := os.OpenFile(l.segmentPath(base), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s := &segment{baseOffset: base, file: f}
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := f.ReadAt(header, s.size); err != nil {
+			break
+		}
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		if s.size+recordHeaderSize+length > info.Size() {
+			// A torn, or corrupted, length. It is not trusted to size the payload.
+			break
+		}
+		payload := make([]byte, length)
+		if _, err := f.ReadAt(payload, s.size+recordHeaderSize); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			break
+		}
+		s.positions = append(s.positions, s.size)
+		s.size += recordHeaderSize + length
+	}
+	if err := f.Truncate(s.size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Append durably appends m to the log and returns its offset.
+func (l *Log) Append(m *provisioners.Message) (int64, error) {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return 0, err
+	}
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return 0, errLogClosed
+	}
+	active := l.segments[len(l.segments)-1]
+	if active.size > 0 && active.size+int64(len(record)) > l.maxSegmentBytes {
+		s, err := l.openSegment(active.nextOffset())
+		if err != nil {
+			return 0, err
+		}
+		l.segments = append(l.segments, s)
+		active = s
+	}
+	if _, err := active.file.WriteAt(record, active.size); err != nil {
+		return 0, err
+	}
+	if err := active.file.Sync(); err != nil {
+		return 0, err
+	}
+	offset := active.nextOffset()
+	active.positions = append(active.positions, active.size)
+	active.size += int64(len(record))
+
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return offset, nil
+}
+
+// Read returns the message at offset. It returns errNoMessage if no message was appended at
+// offset yet. Reading an offset that was already deleted returns the oldest message instead, along
+// with its offset.
+func (l *Log) Read(offset int64) (*provisioners.Message, int64, error) {
+	// The segment must not be closed, by DeleteBefore or Close, while its file is read.
+	l.filesLock.RLock()
+	defer l.filesLock.RUnlock()
+	l.lock.Lock()
+	if l.closed {
+		l.lock.Unlock()
+		return nil, offset, errLogClosed
+	}
+	i := sort.Search(len(l.segments), func(i int) bool { return l.segments[i].nextOffset() > offset })
+	if i == len(l.segments) {
+		l.lock.Unlock()
+		return nil, offset, errNoMessage
+	}
+	s := l.segments[i]
+	if offset < s.baseOffset {
+		offset = s.baseOffset
+	}
+	position := s.positions[offset-s.baseOffset]
+	l.lock.Unlock()
+
+	header := make([]byte, recordHeaderSize)
+	if _, err := s.file.ReadAt(header, position); err != nil {
+		return nil, offset, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := s.file.ReadAt(payload, position+recordHeaderSize); err != nil && err != io.EOF {
+		return nil, offset, err
+	}
+	m := &provisioners.Message{}
+	if err := json.Unmarshal(payload, m); err != nil {
+		return nil, offset, err
+	}
+	return m, offset, nil
+}
+
+// NextOffset returns the offset the next appended message will get.
+func (l *Log) NextOffset() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.segments[len(l.segments)-1].nextOffset()
+}
+
+// Appended returns a channel that is closed once a message is appended at or after offset.
+func (l *Log) Appended(offset int64) <-chan struct{} {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.segments[len(l.segments)-1].nextOffset() > offset {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	return l.appended
+}
+
+// DeleteBefore deletes the segments holding only messages older than offset. The active segment
+// is never deleted.
+func (l *Log) DeleteBefore(offset int64) error {
+	l.filesLock.Lock()
+	defer l.filesLock.Unlock()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return nil
+	}
+	for len(l.segments) > 1 && l.segments[1].baseOffset <= offset {
+		s := l.segments[0]
+		l.segments = l.segments[1:]
+		s.file.Close()
+		if err := os.Remove(l.segmentPath(s.baseOffset)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the segment files, once the reads in progress are done. Using the Log afterwards
+// returns errLogClosed.
+func (l *Log) Close() error {
+	l.filesLock.Lock()
+	defer l.filesLock.Unlock()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	var firstErr error
+	for _, s := range l.segments {
+		if err := s.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
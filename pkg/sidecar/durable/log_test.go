@@ -0,0 +1,222 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package durable
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/knative/eventing/pkg/provisioners"
+)
+
+func makeMessage(i int) *provisioners.Message {
+	return &provisioners.Message{
+		Headers: map[string]string{"Ce-Id": fmt.Sprintf("%d", i)},
+		Payload: []byte(fmt.Sprintf(`{"i":%d}`, i)),
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "durable")
+	if err != nil {
+		t.Fatalf("Unable to create a temporary directory: %v", err)
+	}
+	return dir
+}
+
+func appendMessages(t *testing.T, l *Log, from, to int) {
+	for i := from; i < to; i++ {
+		offset, err := l.Append(makeMessage(i))
+		if err != nil {
+			t.Fatalf("Unexpected error appending message %d: %v", i, err)
+		}
+		if offset != int64(i) {
+			t.Fatalf("Unexpected offset. Expected %d, Actual %d", i, offset)
+		}
+	}
+}
+
+func assertRead(t *testing.T, l *Log, offset int64, expected int, expectedOffset int64) {
+	t.Helper()
+	m, at, err := l.Read(offset)
+	if err != nil {
+		t.Fatalf("Unexpected error reading offset %d: %v", offset, err)
+	}
+	if at != expectedOffset {
+		t.Errorf("Unexpected offset read. Expected %d, Actual %d", expectedOffset, at)
+	}
+	if diff := cmp.Diff(makeMessage(expected), m); diff != "" {
+		t.Errorf("Unexpected message at offset %d (-want, +got): %s", offset, diff)
+	}
+}
+
+func TestLog_AppendAndRead(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := OpenLog(dir, defaultMaxSegmentBytes)
+	if err != nil {
+		t.Fatalf("Unexpected error opening the log: %v", err)
+	}
+	if _, _, err := l.Read(0); err != errNoMessage {
+		t.Errorf("Unexpected error reading an empty log. Expected %v, Actual %v", errNoMessage, err)
+	}
+	appended := l.Appended(0)
+	appendMessages(t, l, 0, 3)
+	select {
+	case <-appended:
+	default:
+		t.Errorf("Expected the appended channel to be closed")
+	}
+	for i := 0; i < 3; i++ {
+		assertRead(t, l, int64(i), i, int64(i))
+	}
+	if l.NextOffset() != 3 {
+		t.Errorf("Unexpected next offset. Expected 3, Actual %d", l.NextOffset())
+	}
+	l.Close()
+
+	// The messages survive reopening the log.
+	l, err = OpenLog(dir, defaultMaxSegmentBytes)
+	if err != nil {
+		t.Fatalf("Unexpected error reopening the log: %v", err)
+	}
+	defer l.Close()
+	assertRead(t, l, 1, 1, 1)
+	appendMessages(t, l, 3, 4)
+	assertRead(t, l, 3, 3, 3)
+}
+
+func TestLog_TornRecordIsDiscarded(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := OpenLog(dir, defaultMaxSegmentBytes)
+	if err != nil {
+		t.Fatalf("Unexpected error opening the log: %v", err)
+	}
+	appendMessages(t, l, 0, 2)
+	l.Close()
+
+	// Simulate a crash in the middle of writing a record.
+	f, err := os.OpenFile(filepath.Join(dir, "00000000000000000000.log"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Unable to open the segment: %v", err)
+	}
+	if _, err := f.Write([]byte{0, 0, 1, 0, 1, 2, 3}); err != nil {
+		t.Fatalf("Unable to write to the segment: %v", err)
+	}
+	f.Close()
+
+	l, err = OpenLog(dir, defaultMaxSegmentBytes)
+	if err != nil {
+		t.Fatalf("Unexpected error reopening the log: %v", err)
+	}
+	defer l.Close()
+	if l.NextOffset() != 2 {
+		t.Errorf("Unexpected next offset. Expected 2, Actual %d", l.NextOffset())
+	}
+	appendMessages(t, l, 2, 3)
+	assertRead(t, l, 2, 2, 2)
+}
+
+func TestLog_CorruptLengthIsDiscarded(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := OpenLog(dir, defaultMaxSegmentBytes)
+	if err != nil {
+		t.Fatalf("Unexpected error opening the log: %v", err)
+	}
+	appendMessages(t, l, 0, 2)
+	l.Close()
+
+	// A record header announcing a payload of 4 GiB, larger than the segment.
+	f, err := os.OpenFile(filepath.Join(dir, "00000000000000000000.log"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Unable to open the segment: %v", err)
+	}
+	if _, err := f.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 1, 2, 3}); err != nil {
+		t.Fatalf("Unable to write to the segment: %v", err)
+	}
+	f.Close()
+
+	l, err = OpenLog(dir, defaultMaxSegmentBytes)
+	if err != nil {
+		t.Fatalf("Unexpected error reopening the log: %v", err)
+	}
+	defer l.Close()
+	if l.NextOffset() != 2 {
+		t.Errorf("Unexpected next offset. Expected 2, Actual %d", l.NextOffset())
+	}
+}
+
+func TestLog_Closed(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := OpenLog(dir, defaultMaxSegmentBytes)
+	if err != nil {
+		t.Fatalf("Unexpected error opening the log: %v", err)
+	}
+	appendMessages(t, l, 0, 1)
+	l.Close()
+	if _, err := l.Append(makeMessage(1)); err != errLogClosed {
+		t.Errorf("Unexpected error appending to a closed log. Expected %v, Actual %v", errLogClosed, err)
+	}
+	if _, _, err := l.Read(0); err != errLogClosed {
+		t.Errorf("Unexpected error reading a closed log. Expected %v, Actual %v", errLogClosed, err)
+	}
+}
+
+func TestLog_SegmentsAndDeleteBefore(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// Small enough for a segment to hold a single message.
+	l, err := OpenLog(dir, 1)
+	if err != nil {
+		t.Fatalf("Unexpected error opening the log: %v", err)
+	}
+	defer l.Close()
+	appendMessages(t, l, 0, 4)
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if len(segments) != 4 {
+		t.Errorf("Unexpected number of segments. Expected 4, Actual %d", len(segments))
+	}
+
+	if err := l.DeleteBefore(2); err != nil {
+		t.Fatalf("Unexpected error deleting segments: %v", err)
+	}
+	segments, _ = filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if len(segments) != 2 {
+		t.Errorf("Unexpected number of segments. Expected 2, Actual %d", len(segments))
+	}
+	// Reading a deleted offset returns the oldest message left.
+	assertRead(t, l, 0, 2, 2)
+	assertRead(t, l, 3, 3, 3)
+
+	// The active segment is kept.
+	if err := l.DeleteBefore(10); err != nil {
+		t.Fatalf("Unexpected error deleting segments: %v", err)
+	}
+	assertRead(t, l, 3, 3, 3)
+}
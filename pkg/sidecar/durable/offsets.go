@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package durable
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// offsets persists, for each subscriber of a Channel, the offset of the next message to deliver
+// to it. The file is replaced atomically on every change. It is not synced, as losing the latest
+// changes in a crash only causes messages to be delivered again.
+type offsets struct {
+	path string
+
+	lock   sync.Mutex
+	values map[string]int64
+}
+
+func loadOffsets(path string) (*offsets, error) {
+	o := &offsets{
+		path:   path,
+		values: make(map[string]int64),
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return o, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &o.values); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *offsets) get(key string) (int64, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	v, present := o.values[key]
+	return v, present
+}
+
+func (o *offsets) set(key string, offset int64) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.values[key] = offset
+	return o.save()
+}
+
+func (o *offsets) remove(key string) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	delete(o.values, key)
+	return o.save()
+}
+
+// min returns the smallest offset, or false if there are none.
+func (o *offsets) min() (int64, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	first := true
+	var min int64
+	for _, v := range o.values {
+		if first || v < min {
+			min = v
+			first = false
+		}
+	}
+	return min, !first
+}
+
+func (o *offsets) save() error {
+	b, err := json.Marshal(o.values)
+	if err != nil {
+		return err
+	}
+	tmp := o.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, o.path)
+}
@@ -0,0 +1,537 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package durable provides a fanout.MessageLog that persists the messages sent to each Channel
+// in a segmented log on disk, and delivers them to the Channel's subscribers from there. Each
+// subscriber's progress is tracked independently, so undelivered messages are delivered again
+// after the process restarts, giving at-least-once delivery.
+package durable
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
+	"github.com/knative/eventing/pkg/sidecar/fanout"
+	"github.com/knative/eventing/pkg/sidecar/multichannelfanout"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	offsetsFileName = "offsets.json"
+	deadLetterDir   = "dlq"
+
+	// defaultMaxAttempts and defaultBackoff apply to the subscribers whose DeliverySpec does not
+	// set them.
+	defaultMaxAttempts = 3
+	defaultBackoff     = 100 * time.Millisecond
+	maxRetryBackoff    = 1 * time.Minute
+
+	// attemptsHeader and errorHeader hold, in a dead-lettered message, the number of delivery
+	// attempts and the error of the last one.
+	attemptsHeader = "knative-durable-attempts"
+	errorHeader    = "knative-durable-error"
+
+	// DefaultGracePeriod is how long the Log of a Channel, or the progress of a subscriber, is
+	// kept after it disappears from the config, unless WithGracePeriod is used.
+	DefaultGracePeriod = 10 * time.Minute
+	// sweepInterval is how often the Logs and progress whose grace period ended are deleted.
+	sweepInterval = 1 * time.Minute
+)
+
+// Store is a fanout.MessageLog keeping one Log per Channel under a directory. The Channels and
+// their subscribers are configured by UpdateConfig, which starts delivering each Channel's Log
+// to its subscribers.
+type Store struct {
+	dir             string
+	maxSegmentBytes int64
+	gracePeriod     time.Duration
+	now             func() time.Time
+	dispatcher      provisioners.Dispatcher
+	logger          *zap.Logger
+
+	lock     sync.Mutex
+	channels map[provisioners.ChannelReference]*channel
+	// configured is set once a config was applied. Nothing is deleted before, as the first
+	// config may be incomplete, for instance while the config source is syncing.
+	configured bool
+	// removed is when each Channel with a Log on disk was first missing from the config.
+	removed map[provisioners.ChannelReference]time.Time
+}
+
+// StoreOption configures a Store.
+type StoreOption func(*Store)
+
+// WithGracePeriod sets how long the Log of a Channel, or the progress of a subscriber, is kept
+// after it disappears from the config. If it comes back before then, it resumes where it was.
+func WithGracePeriod(d time.Duration) StoreOption {
+	return func(s *Store) {
+		s.gracePeriod = d
+	}
+}
+
+var _ fanout.MessageLog = &Store{}
+
+// channel is the Log of a single Channel and the deliveries to its subscribers.
+type channel struct {
+	ref provisioners.ChannelReference
+	// appendLock is held for reading while a message is appended to log, and for writing while
+	// log is closed.
+	appendLock sync.RWMutex
+	log        *Log
+	offsets    *offsets
+	// subscribers and removedSubscribers are only accessed with the Store's lock held.
+	subscribers map[string]*subscriber
+	// removedSubscribers is when each subscriber with a saved offset was first missing from the
+	// config.
+	removedSubscribers map[string]time.Time
+	// stopLock makes stopping a subscriber atomic with respect to saving its offset, so that a
+	// stopped subscriber never saves its offset.
+	stopLock sync.Mutex
+	// deliveries counts the running deliveries, including those of stopped subscribers that did
+	// not return yet.
+	deliveries sync.WaitGroup
+}
+
+// subscriber delivers a channel's Log to a single subscriber.
+type subscriber struct {
+	key    string
+	spec   eventingduck.ChannelSubscriberSpec
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+// NewStore creates a Store keeping its Logs under dir, and delivering messages with dispatcher.
+func NewStore(logger *zap.Logger, dir string, dispatcher provisioners.Dispatcher, opts ...StoreOption) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:             dir,
+		maxSegmentBytes: defaultMaxSegmentBytes,
+		gracePeriod:     DefaultGracePeriod,
+		now:             time.Now,
+		dispatcher:      dispatcher,
+		logger:          logger,
+		channels:        make(map[provisioners.ChannelReference]*channel),
+		removed:         make(map[provisioners.ChannelReference]time.Time),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// Append implements fanout.MessageLog.
+func (s *Store) Append(ref provisioners.ChannelReference, m *provisioners.Message) error {
+	s.lock.Lock()
+	c, present := s.channels[ref]
+	if !present {
+		s.lock.Unlock()
+		return provisioners.ErrUnknownChannel
+	}
+	// Locking c before releasing the Store's lock keeps c's Log open until m is appended.
+	c.appendLock.RLock()
+	s.lock.Unlock()
+	defer c.appendLock.RUnlock()
+	_, err := c.log.Append(m)
+	return err
+}
+
+// UpdateConfig opens the Logs of the Channels in config and delivers them to their subscribers.
+// Subscribers that were delivered to before resume from the first message they did not
+// acknowledge, new subscribers start with the next appended message. The Logs of Channels, and
+// the progress of subscribers, that are no longer in config are deleted once they have been
+// missing for the grace period, but never by the first call.
+func (s *Store) UpdateConfig(config *multichannelfanout.Config) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	wanted := make(map[provisioners.ChannelReference]bool, len(config.ChannelConfigs))
+	for _, cc := range config.ChannelConfigs {
+		ref := provisioners.ChannelReference{Namespace: cc.Namespace, Name: cc.Name}
+		wanted[ref] = true
+		delete(s.removed, ref)
+		c, present := s.channels[ref]
+		if !present {
+			var err error
+			if c, err = s.openChannel(ref); err != nil {
+				s.logger.Error("Unable to open the Channel's log", zap.Any("channel", ref), zap.Error(err))
+				return err
+			}
+			s.channels[ref] = c
+		}
+		if err := s.updateSubscribers(c, cc.FanoutConfig.Subscriptions); err != nil {
+			return err
+		}
+	}
+
+	for ref, c := range s.channels {
+		if !wanted[ref] {
+			s.logger.Info("Closing the log of a removed Channel", zap.Any("channel", ref), zap.Duration("gracePeriod", s.gracePeriod))
+			s.closeChannel(c)
+			delete(s.channels, ref)
+		}
+	}
+	// Also look for the Logs of Channels removed while the process was not running.
+	now := s.now()
+	namespaces, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, ns := range namespaces {
+		names, err := ioutil.ReadDir(filepath.Join(s.dir, ns.Name()))
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			ref := provisioners.ChannelReference{Namespace: ns.Name(), Name: name.Name()}
+			if _, present := s.removed[ref]; !wanted[ref] && !present {
+				s.removed[ref] = now
+			}
+		}
+	}
+	if err := s.deleteRemovedChannels(); err != nil {
+		return err
+	}
+	s.configured = true
+	return nil
+}
+
+// expired returns whether something missing from the config since removedAt can be deleted.
+func (s *Store) expired(removedAt time.Time) bool {
+	return s.configured && !s.now().Before(removedAt.Add(s.gracePeriod))
+}
+
+// deleteRemovedChannels deletes the Logs of the Channels whose grace period ended.
+func (s *Store) deleteRemovedChannels() error {
+	for ref, removedAt := range s.removed {
+		if !s.expired(removedAt) {
+			continue
+		}
+		s.logger.Info("Deleting the log of a removed Channel", zap.Any("channel", ref))
+		if err := os.RemoveAll(s.channelDir(ref)); err != nil {
+			return err
+		}
+		delete(s.removed, ref)
+	}
+	return nil
+}
+
+// deleteRemovedSubscribers forgets the progress of c's subscribers whose grace period ended.
+func (s *Store) deleteRemovedSubscribers(c *channel) error {
+	for key, removedAt := range c.removedSubscribers {
+		if !s.expired(removedAt) {
+			continue
+		}
+		s.logger.Info("Forgetting a removed subscriber", zap.Any("channel", c.ref), zap.String("subscriber", key))
+		if err := c.offsets.remove(key); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(s.deadLetterDir(c.ref, key)); err != nil {
+			return err
+		}
+		delete(c.removedSubscribers, key)
+	}
+	return nil
+}
+
+// deleteRemoved deletes the Logs of Channels, and the progress of subscribers, whose grace
+// period ended since the last config.
+func (s *Store) deleteRemoved() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, c := range s.channels {
+		if err := s.deleteRemovedSubscribers(c); err != nil {
+			s.logger.Error("Unable to forget removed subscribers", zap.Any("channel", c.ref), zap.Error(err))
+		}
+		s.deleteDelivered(c)
+	}
+	if err := s.deleteRemovedChannels(); err != nil {
+		s.logger.Error("Unable to delete the logs of removed Channels", zap.Error(err))
+	}
+}
+
+func (s *Store) channelDir(ref provisioners.ChannelReference) string {
+	return filepath.Join(s.dir, ref.Namespace, ref.Name)
+}
+
+func (s *Store) openChannel(ref provisioners.ChannelReference) (*channel, error) {
+	dir := s.channelDir(ref)
+	log, err := OpenLog(dir, s.maxSegmentBytes)
+	if err != nil {
+		return nil, err
+	}
+	o, err := loadOffsets(filepath.Join(dir, offsetsFileName))
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+	return &channel{
+		ref:                ref,
+		log:                log,
+		offsets:            o,
+		subscribers:        make(map[string]*subscriber),
+		removedSubscribers: make(map[string]time.Time),
+	}, nil
+}
+
+// closeChannel stops the deliveries of c and closes its Log, keeping it on disk. The Log is closed
+// once the deliveries, including those of the subscribers stopped before, and the appends in
+// progress are done. It must be called with the Store's
+// lock held, and c removed from the Store's channels before the lock is released.
+func (s *Store) closeChannel(c *channel) {
+	for _, sub := range c.subscribers {
+		c.stopSubscriber(sub)
+	}
+	c.deliveries.Wait()
+	c.appendLock.Lock()
+	defer c.appendLock.Unlock()
+	if err := c.log.Close(); err != nil {
+		s.logger.Error("Unable to close the Channel's log", zap.Any("channel", c.ref), zap.Error(err))
+	}
+}
+
+func (s *Store) updateSubscribers(c *channel, specs []eventingduck.ChannelSubscriberSpec) error {
+	wanted := sets.NewString()
+	for _, spec := range specs {
+		key := fanout.SubscriberKey(spec)
+		wanted.Insert(key)
+		delete(c.removedSubscribers, key)
+		existing, present := c.subscribers[key]
+		if present && reflect.DeepEqual(existing.spec, spec) {
+			continue
+		}
+		if present {
+			// The subscriber changed, for instance its URI. Keep its progress.
+			c.stopSubscriber(existing)
+		}
+		offset, known := c.offsets.get(key)
+		if !known {
+			offset = c.log.NextOffset()
+			if err := c.offsets.set(key, offset); err != nil {
+				return err
+			}
+		}
+		sub := &subscriber{
+			key:    key,
+			spec:   spec,
+			stopCh: make(chan struct{}),
+		}
+		c.subscribers[key] = sub
+		c.deliveries.Add(1)
+		go s.deliver(c, sub, offset)
+	}
+
+	for key, sub := range c.subscribers {
+		if !wanted.Has(key) {
+			c.stopSubscriber(sub)
+			delete(c.subscribers, key)
+		}
+	}
+	// Also look for subscribers removed while the process was not running.
+	now := s.now()
+	c.offsets.lock.Lock()
+	for key := range c.offsets.values {
+		if _, present := c.removedSubscribers[key]; !wanted.Has(key) && !present {
+			c.removedSubscribers[key] = now
+		}
+	}
+	c.offsets.lock.Unlock()
+	if err := s.deleteRemovedSubscribers(c); err != nil {
+		return err
+	}
+	s.deleteDelivered(c)
+	return nil
+}
+
+// deliver sends the messages of c's Log to sub, starting at offset, until sub is stopped. A
+// message that can not be delivered is retried, with an exponential backoff, up to the
+// subscriber's maximum number of attempts. It is then dead-lettered or dropped, and the delivery
+// moves on to the next message.
+func (s *Store) deliver(c *channel, sub *subscriber, offset int64) {
+	defer c.deliveries.Done()
+	logger := s.logger.With(zap.Any("channel", c.ref), zap.String("subscriber", sub.key))
+	defaults := provisioners.DispatchDefaults{
+		Namespace:     c.ref.Namespace,
+		TLSSecretRef:  sub.spec.TLSSecretRef,
+		AuthType:      sub.spec.AuthType,
+		AuthSecretRef: sub.spec.AuthSecretRef,
+	}
+	maxAttempts, minBackoff := deliveryLimits(sub.spec.Delivery)
+	backoff := minBackoff
+	var attempts int32
+	for {
+		select {
+		case <-sub.stopCh:
+			return
+		default:
+		}
+
+		m, at, err := c.log.Read(offset)
+		if err == errNoMessage {
+			select {
+			case <-c.log.Appended(offset):
+			case <-sub.stopCh:
+				return
+			}
+			continue
+		}
+		if err == nil {
+			err = s.dispatcher.DispatchMessage(m, sub.spec.SubscriberURI, sub.spec.ReplyURI, defaults)
+		}
+		if attempts++; err != nil && attempts >= maxAttempts {
+			err = s.deadLetter(c, sub, m, attempts, err, logger.With(zap.Int64("offset", at)))
+		}
+		if err != nil {
+			logger.Warn("Unable to deliver message, retrying", zap.Int64("offset", at), zap.Int32("attempts", attempts), zap.Duration("backoff", backoff), zap.Error(err))
+			select {
+			case <-time.After(backoff):
+			case <-sub.stopCh:
+				return
+			}
+			if backoff *= 2; backoff > maxRetryBackoff {
+				backoff = maxRetryBackoff
+			}
+			continue
+		}
+
+		attempts = 0
+		backoff = minBackoff
+		offset = at + 1
+		if !c.saveOffset(sub, offset, logger) {
+			// A replacement may already be delivering, it owns the offset now.
+			return
+		}
+		s.deleteDelivered(c)
+	}
+}
+
+// deliveryLimits returns the maximum number of attempts to deliver a message, and the delay
+// before the first retry, set by spec or the defaults.
+func deliveryLimits(spec *eventingduck.DeliverySpec) (int32, time.Duration) {
+	maxAttempts, backoff := int32(defaultMaxAttempts), defaultBackoff
+	if spec == nil {
+		return maxAttempts, backoff
+	}
+	if spec.MaxAttempts > 0 {
+		maxAttempts = spec.MaxAttempts
+	}
+	if d, err := time.ParseDuration(spec.Backoff); err == nil && d > 0 {
+		backoff = d
+	}
+	return maxAttempts, backoff
+}
+
+// deadLetter gives up on m after attempts failed attempts, the last one with err. It appends m to
+// sub's dead-letter Log if sub's DeliverySpec asks for one, or drops it. m is nil if it could not
+// be read, in which case it is always dropped.
+func (s *Store) deadLetter(c *channel, sub *subscriber, m *provisioners.Message, attempts int32, err error, logger *zap.Logger) error {
+	if m == nil || sub.spec.Delivery == nil || !sub.spec.Delivery.DeadLetter {
+		logger.Error("Unable to deliver message, dropping it", zap.Int32("attempts", attempts), zap.Error(err))
+		return nil
+	}
+	logger.Warn("Unable to deliver message, dead-lettering it", zap.Int32("attempts", attempts), zap.Error(err))
+	log, openErr := OpenLog(s.deadLetterDir(c.ref, sub.key), s.maxSegmentBytes)
+	if openErr != nil {
+		return openErr
+	}
+	defer log.Close()
+	headers := make(map[string]string, len(m.Headers)+2)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	headers[attemptsHeader] = strconv.Itoa(int(attempts))
+	headers[errorHeader] = err.Error()
+	_, err = log.Append(&provisioners.Message{Headers: headers, Payload: m.Payload})
+	return err
+}
+
+// deadLetterDir is the directory of the dead-letter Log of the subscriber identified by key.
+func (s *Store) deadLetterDir(ref provisioners.ChannelReference, key string) string {
+	return filepath.Join(s.channelDir(ref), deadLetterDir, url.PathEscape(key))
+}
+
+// saveOffset saves offset as the next message to deliver to sub. It returns false, without saving
+// it, if sub was stopped.
+func (c *channel) saveOffset(sub *subscriber, offset int64, logger *zap.Logger) bool {
+	c.stopLock.Lock()
+	defer c.stopLock.Unlock()
+	select {
+	case <-sub.stopCh:
+		return false
+	default:
+	}
+	if err := c.offsets.set(sub.key, offset); err != nil {
+		logger.Error("Unable to save the subscriber's offset", zap.Int64("offset", offset), zap.Error(err))
+	}
+	return true
+}
+
+// stopSubscriber stops delivering to sub. A delivery in progress is not waited for, but its
+// offset is not saved, so the message is delivered again by sub's replacement, if any.
+func (c *channel) stopSubscriber(sub *subscriber) {
+	c.stopLock.Lock()
+	defer c.stopLock.Unlock()
+	close(sub.stopCh)
+}
+
+// deleteDelivered deletes the segments of c's Log that were delivered to all of its subscribers.
+func (s *Store) deleteDelivered(c *channel) {
+	min, present := c.offsets.min()
+	if !present {
+		// Nobody is subscribed, there is nothing to keep.
+		min = c.log.NextOffset()
+	}
+	if err := c.log.DeleteBefore(min); err != nil {
+		s.logger.Error("Unable to delete delivered segments", zap.Any("channel", c.ref), zap.Error(err))
+	}
+}
+
+// Start implements manager.Runnable. Until stopCh is closed, it periodically deletes the Logs
+// and progress whose grace period ended. It does not close the Store, which may still be appended
+// to while the receiving server drains, the caller must Close it once the server is shut down.
+func (s *Store) Start(stopCh <-chan struct{}) error {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.deleteRemoved()
+		case <-stopCh:
+			return nil
+		}
+	}
+}
+
+// Close stops all the deliveries and closes the Logs, keeping them on disk. Messages appended
+// afterwards are refused with provisioners.ErrUnknownChannel.
+func (s *Store) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for ref, c := range s.channels {
+		s.closeChannel(c)
+		delete(s.channels, ref)
+	}
+}
@@ -0,0 +1,422 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package durable
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
+	"github.com/knative/eventing/pkg/sidecar/fanout"
+	"github.com/knative/eventing/pkg/sidecar/multichannelfanout"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var testChannel = provisioners.ChannelReference{Namespace: "ns", Name: "c"}
+
+// fakeDispatcher records the messages dispatched to each subscriber, failing while the
+// subscriber is in failing.
+type fakeDispatcher struct {
+	lock       sync.Mutex
+	failing    map[string]bool
+	dispatched map[string][]string
+	notify     chan struct{}
+}
+
+func newFakeDispatcher() *fakeDispatcher {
+	return &fakeDispatcher{
+		failing:    make(map[string]bool),
+		dispatched: make(map[string][]string),
+		notify:     make(chan struct{}, 100),
+	}
+}
+
+func (d *fakeDispatcher) DispatchMessage(m *provisioners.Message, destination, _ string, _ provisioners.DispatchDefaults) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.failing[destination] {
+		return errors.New("subscriber unavailable")
+	}
+	d.dispatched[destination] = append(d.dispatched[destination], m.Headers["Ce-Id"])
+	d.notify <- struct{}{}
+	return nil
+}
+
+func (d *fakeDispatcher) setFailing(destination string, failing bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.failing[destination] = failing
+}
+
+// waitFor waits until destination received count messages, and returns them.
+func (d *fakeDispatcher) waitFor(t *testing.T, destination string, count int) []string {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		d.lock.Lock()
+		got := append([]string(nil), d.dispatched[destination]...)
+		d.lock.Unlock()
+		if len(got) >= count {
+			return got
+		}
+		select {
+		case <-d.notify:
+		case <-timeout:
+			t.Fatalf("Timed out waiting for %d messages to %s, Actual %v", count, destination, got)
+		}
+	}
+}
+
+// makeConfig returns the config of testChannel with subscribers, which are retried until they
+// accept a message.
+func makeConfig(subscribers ...string) *multichannelfanout.Config {
+	var subs []eventingduck.ChannelSubscriberSpec
+	for _, s := range subscribers {
+		subs = append(subs, eventingduck.ChannelSubscriberSpec{
+			Ref:           &corev1.ObjectReference{Name: s},
+			SubscriberURI: s,
+			Delivery:      &eventingduck.DeliverySpec{MaxAttempts: math.MaxInt32},
+		})
+	}
+	return makeSubscribersConfig(subs...)
+}
+
+func makeSubscribersConfig(subs ...eventingduck.ChannelSubscriberSpec) *multichannelfanout.Config {
+	return &multichannelfanout.Config{
+		ChannelConfigs: []multichannelfanout.ChannelConfig{
+			{
+				Namespace: testChannel.Namespace,
+				Name:      testChannel.Name,
+				FanoutConfig: fanout.Config{
+					Subscriptions: subs,
+				},
+			},
+		},
+	}
+}
+
+func newTestStore(t *testing.T, dir string, d provisioners.Dispatcher, config *multichannelfanout.Config) *Store {
+	s, err := NewStore(zap.NewNop(), dir, d)
+	if err != nil {
+		t.Fatalf("Unexpected error creating the store: %v", err)
+	}
+	if err := s.UpdateConfig(config); err != nil {
+		t.Fatalf("Unexpected error updating the config: %v", err)
+	}
+	return s
+}
+
+func appendToStore(t *testing.T, s *Store, from, to int) {
+	for i := from; i < to; i++ {
+		if err := s.Append(testChannel, makeMessage(i)); err != nil {
+			t.Fatalf("Unexpected error appending message %d: %v", i, err)
+		}
+	}
+}
+
+func TestStore_UnknownChannel(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s := newTestStore(t, dir, newFakeDispatcher(), makeConfig("a"))
+	defer s.Close()
+
+	err := s.Append(provisioners.ChannelReference{Namespace: "ns", Name: "other"}, makeMessage(0))
+	if err != provisioners.ErrUnknownChannel {
+		t.Errorf("Unexpected error. Expected %v, Actual %v", provisioners.ErrUnknownChannel, err)
+	}
+}
+
+func TestStore_AppendWhileClosing(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	d := newFakeDispatcher()
+	s := newTestStore(t, dir, d, makeConfig("a"))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := s.Append(testChannel, makeMessage(i)); err != nil && err != provisioners.ErrUnknownChannel {
+				errs <- err
+			}
+		}(i)
+	}
+	// Removing the Channel, then closing the Store, waits for the appends in progress.
+	if err := s.UpdateConfig(&multichannelfanout.Config{}); err != nil {
+		t.Fatalf("Unexpected error updating the config: %v", err)
+	}
+	s.Close()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Unexpected error appending: %v", err)
+	}
+}
+
+func TestStore_DeliversToEachSubscriber(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	d := newFakeDispatcher()
+	d.setFailing("b", true)
+	s := newTestStore(t, dir, d, makeConfig("a", "b"))
+	defer s.Close()
+
+	appendToStore(t, s, 0, 3)
+	if got := d.waitFor(t, "a", 3); len(got) != 3 || got[0] != "0" || got[2] != "2" {
+		t.Errorf("Unexpected messages delivered to a: %v", got)
+	}
+	// b is retried until it recovers, without holding a back.
+	d.setFailing("b", false)
+	if got := d.waitFor(t, "b", 3); len(got) != 3 || got[0] != "0" || got[2] != "2" {
+		t.Errorf("Unexpected messages delivered to b: %v", got)
+	}
+}
+
+func TestStore_ReplaysAfterRestart(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	d := newFakeDispatcher()
+	s := newTestStore(t, dir, d, makeConfig("a", "b"))
+	appendToStore(t, s, 0, 1)
+	d.waitFor(t, "a", 1)
+	d.waitFor(t, "b", 1)
+
+	d.setFailing("b", true)
+	appendToStore(t, s, 1, 3)
+	d.waitFor(t, "a", 3)
+	s.Close()
+
+	// After the restart, b only receives the messages it did not acknowledge.
+	d.setFailing("b", false)
+	s = newTestStore(t, dir, d, makeConfig("a", "b"))
+	defer s.Close()
+	if got := d.waitFor(t, "b", 3); len(got) != 3 || got[1] != "1" || got[2] != "2" {
+		t.Errorf("Unexpected messages delivered to b: %v", got)
+	}
+	appendToStore(t, s, 3, 4)
+	if got := d.waitFor(t, "a", 4); len(got) != 4 || got[3] != "3" {
+		t.Errorf("Unexpected messages delivered to a: %v", got)
+	}
+}
+
+func TestStore_NewSubscriberStartsWithNextMessage(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	d := newFakeDispatcher()
+	s := newTestStore(t, dir, d, makeConfig("a"))
+	defer s.Close()
+	appendToStore(t, s, 0, 2)
+	d.waitFor(t, "a", 2)
+
+	if err := s.UpdateConfig(makeConfig("a", "b")); err != nil {
+		t.Fatalf("Unexpected error updating the config: %v", err)
+	}
+	appendToStore(t, s, 2, 3)
+	if got := d.waitFor(t, "b", 1); len(got) != 1 || got[0] != "2" {
+		t.Errorf("Unexpected messages delivered to b: %v", got)
+	}
+}
+
+// fakeClock is a clock that only moves when advanced.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newGracefulStore(t *testing.T, dir string, clock *fakeClock) *Store {
+	s, err := NewStore(zap.NewNop(), dir, newFakeDispatcher(), WithGracePeriod(time.Minute))
+	if err != nil {
+		t.Fatalf("Unexpected error creating the store: %v", err)
+	}
+	s.now = clock.Now
+	return s
+}
+
+func updateConfig(t *testing.T, s *Store, config *multichannelfanout.Config) {
+	t.Helper()
+	if err := s.UpdateConfig(config); err != nil {
+		t.Fatalf("Unexpected error updating the config: %v", err)
+	}
+}
+
+func TestStore_RemovedChannelIsDeletedAfterGracePeriod(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	s := newGracefulStore(t, dir, clock)
+	defer s.Close()
+	updateConfig(t, s, makeConfig("a"))
+	appendToStore(t, s, 0, 1)
+
+	channelDir := filepath.Join(dir, testChannel.Namespace, testChannel.Name)
+	updateConfig(t, s, &multichannelfanout.Config{})
+	if err := s.Append(testChannel, makeMessage(1)); err != provisioners.ErrUnknownChannel {
+		t.Errorf("Unexpected error. Expected %v, Actual %v", provisioners.ErrUnknownChannel, err)
+	}
+	clock.now = clock.now.Add(30 * time.Second)
+	s.deleteRemoved()
+	if _, err := os.Stat(channelDir); err != nil {
+		t.Fatalf("Expected the Channel's log to be kept during the grace period: %v", err)
+	}
+
+	clock.now = clock.now.Add(30 * time.Second)
+	s.deleteRemoved()
+	if _, err := os.Stat(channelDir); !os.IsNotExist(err) {
+		t.Errorf("Expected the Channel's log to be deleted, Actual %v", err)
+	}
+}
+
+func TestStore_RemovedChannelComesBack(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	s := newGracefulStore(t, dir, clock)
+	defer s.Close()
+	updateConfig(t, s, makeConfig("a"))
+	appendToStore(t, s, 0, 1)
+
+	updateConfig(t, s, &multichannelfanout.Config{})
+	clock.now = clock.now.Add(30 * time.Second)
+	updateConfig(t, s, makeConfig("a"))
+	clock.now = clock.now.Add(time.Hour)
+	s.deleteRemoved()
+
+	c := s.channels[testChannel]
+	if c == nil {
+		t.Fatalf("Expected the Channel to be open again")
+	}
+	if next := c.log.NextOffset(); next != 1 {
+		t.Errorf("Expected the Channel's log to be kept. Expected next offset 1, Actual %d", next)
+	}
+}
+
+func TestStore_FirstConfigDeletesNothing(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s := newTestStore(t, dir, newFakeDispatcher(), makeConfig("a", "b"))
+	appendToStore(t, s, 0, 1)
+	s.Close()
+
+	// After a restart, the first config is missing the Channel, for instance because the config
+	// source is not synced yet.
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	s = newGracefulStore(t, dir, clock)
+	defer s.Close()
+	s.gracePeriod = 0
+	updateConfig(t, s, &multichannelfanout.Config{})
+	channelDir := filepath.Join(dir, testChannel.Namespace, testChannel.Name)
+	if _, err := os.Stat(channelDir); err != nil {
+		t.Fatalf("Expected the first config to keep the Channel's log: %v", err)
+	}
+
+	s.Close()
+
+	// After another restart, the first config lists the Channel, but is missing a subscriber.
+	s = newGracefulStore(t, dir, clock)
+	defer s.Close()
+	s.gracePeriod = 0
+	b := fanout.SubscriberKey(eventingduck.ChannelSubscriberSpec{SubscriberURI: "b"})
+	updateConfig(t, s, makeConfig("a"))
+	if _, known := s.channels[testChannel].offsets.get(b); !known {
+		t.Errorf("Expected the first config to keep the progress of b")
+	}
+	updateConfig(t, s, makeConfig("a"))
+	if _, known := s.channels[testChannel].offsets.get(b); known {
+		t.Errorf("Expected the progress of b to be forgotten")
+	}
+}
+
+func TestStore_GivesUpAfterMaxAttempts(t *testing.T) {
+	testCases := map[string]struct {
+		deadLetter bool
+	}{
+		"drop": {},
+		"dead-letter": {
+			deadLetter: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			d := newFakeDispatcher()
+			d.setFailing("a", true)
+			spec := eventingduck.ChannelSubscriberSpec{
+				SubscriberURI: "a",
+				Delivery: &eventingduck.DeliverySpec{
+					MaxAttempts: 2,
+					Backoff:     "1ms",
+					DeadLetter:  tc.deadLetter,
+				},
+			}
+			s := newTestStore(t, dir, d, makeSubscribersConfig(spec))
+			defer s.Close()
+			appendToStore(t, s, 0, 1)
+
+			// Once the first message is given up on, the next one is delivered.
+			c := s.channels[testChannel]
+			key := fanout.SubscriberKey(spec)
+			timeout := time.After(5 * time.Second)
+			for {
+				if offset, _ := c.offsets.get(key); offset == 1 {
+					break
+				}
+				select {
+				case <-time.After(10 * time.Millisecond):
+				case <-timeout:
+					t.Fatalf("Timed out waiting for the first message to be given up on")
+				}
+			}
+			d.setFailing("a", false)
+			appendToStore(t, s, 1, 2)
+			if got := d.waitFor(t, "a", 1); len(got) != 1 || got[0] != "1" {
+				t.Errorf("Unexpected messages delivered to a: %v", got)
+			}
+
+			log, err := OpenLog(s.deadLetterDir(testChannel, key), defaultMaxSegmentBytes)
+			if err != nil {
+				t.Fatalf("Unexpected error opening the dead-letter log: %v", err)
+			}
+			defer log.Close()
+			m, _, err := log.Read(0)
+			if !tc.deadLetter {
+				if err != errNoMessage {
+					t.Errorf("Expected no dead-lettered message, Actual %v, %v", m, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error reading the dead-letter log: %v", err)
+			}
+			if m.Headers["Ce-Id"] != "0" || m.Headers[attemptsHeader] != "2" || m.Headers[errorHeader] != "subscriber unavailable" {
+				t.Errorf("Unexpected dead-lettered message: %v", m.Headers)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fanout
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultBackoff    = 50 * time.Millisecond
+
+	// deliveryLogSize is the number of messages whose successful deliveries are remembered.
+	deliveryLogSize = 10000
+)
+
+// RetryConfig configures how a Handler retries delivering a message to a single subscriber. Each
+// subscriber is retried independently of the others.
+type RetryConfig struct {
+	// MaxRetries is the number of times delivery is retried after the first failed attempt.
+	MaxRetries int
+	// Backoff is the delay before the first retry. It doubles after every retry.
+	Backoff time.Duration
+}
+
+// SubscriberError is the failure to deliver a message to a single subscriber.
+type SubscriberError struct {
+	Subscriber eventingduck.ChannelSubscriberSpec
+	Err        error
+}
+
+func (e SubscriberError) Error() string {
+	return fmt.Sprintf("%s: %v", subscriberName(e.Subscriber), e.Err)
+}
+
+// DispatchError is returned when a message could not be delivered to some of the subscribers.
+// The subscribers that are not listed received the message. The MessageReceiver reports them to
+// the sender in the provisioners.FailedSubscribersHeader, so that they are the only ones the
+// message is sent to again.
+type DispatchError struct {
+	Errors []SubscriberError
+	// Total is the number of subscribers the message was fanned out to.
+	Total int
+}
+
+func (e *DispatchError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("failed to deliver to %d of %d subscribers: %s", len(e.Errors), e.Total, strings.Join(msgs, "; "))
+}
+
+var _ provisioners.PartialFailure = (*DispatchError)(nil)
+
+// FailedSubscribers returns the SubscriberKeys of the subscribers that did not receive the message.
+func (e *DispatchError) FailedSubscribers() []string {
+	keys := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		keys = append(keys, SubscriberKey(err.Subscriber))
+	}
+	return keys
+}
+
+// subscriberName identifies sub in logs and errors.
+func subscriberName(sub eventingduck.ChannelSubscriberSpec) string {
+	if sub.Ref != nil && sub.Ref.Name != "" {
+		return fmt.Sprintf("%s/%s", sub.Ref.Namespace, sub.Ref.Name)
+	}
+	return fmt.Sprintf("subscriber=%q reply=%q", sub.SubscriberURI, sub.ReplyURI)
+}
+
+// SubscriberKey identifies sub across configuration changes. It is the UID of the Subscription
+// sub was resolved from, when known.
+func SubscriberKey(sub eventingduck.ChannelSubscriberSpec) string {
+	if sub.Ref != nil && sub.Ref.UID != "" {
+		return string(sub.Ref.UID)
+	}
+	return sub.SubscriberURI + " " + sub.ReplyURI
+}
+
+// messageID returns the identity of the CloudEvent carried by m, made of its source and id, or
+// the empty string if m does not carry an identifiable CloudEvent.
+func messageID(m *provisioners.Message) string {
+	var source, id, contentType string
+	for k, v := range m.Headers {
+		switch strings.ToLower(k) {
+		case "ce-source":
+			source = v
+		case "ce-id", "ce-eventid":
+			id = v
+		case "content-type":
+			contentType = v
+		}
+	}
+	if id == "" && strings.HasPrefix(contentType, "application/cloudevents+json") {
+		var event struct {
+			Source  string `json:"source"`
+			ID      string `json:"id"`
+			EventID string `json:"eventID"`
+		}
+		if err := json.Unmarshal(m.Payload, &event); err == nil {
+			source, id = event.Source, event.ID
+			if id == "" {
+				id = event.EventID
+			}
+		}
+	}
+	if id == "" {
+		return ""
+	}
+	return source + " " + id
+}
+
+// selectedSubscribers returns the SubscriberKeys listed in the provisioners.SubscribersHeader of m,
+// or nil if m has no such header, and m without that header.
+func selectedSubscribers(m *provisioners.Message) (sets.String, *provisioners.Message) {
+	for k, v := range m.Headers {
+		if !strings.EqualFold(k, provisioners.SubscribersHeader) {
+			continue
+		}
+		headers := make(map[string]string, len(m.Headers)-1)
+		for hk, hv := range m.Headers {
+			if hk != k {
+				headers[hk] = hv
+			}
+		}
+		return sets.NewString(provisioners.ParseSubscribers(v)...), &provisioners.Message{Headers: headers, Payload: m.Payload}
+	}
+	return nil, m
+}
+
+// deliveryLog remembers which subscribers each recent message was delivered to, so that a message
+// redelivered after a partial failure is only sent to the subscribers that did not get it yet.
+// It only covers identifiable CloudEvents redelivered to the same Dispatcher shortly after, so it
+// is a fallback for the senders that do not set provisioners.SubscribersHeader.
+type deliveryLog struct {
+	lock      sync.Mutex
+	capacity  int
+	order     *list.List
+	delivered map[string]*deliveryLogEntry
+}
+
+type deliveryLogEntry struct {
+	subscribers sets.String
+	element     *list.Element
+}
+
+func newDeliveryLog(capacity int) *deliveryLog {
+	return &deliveryLog{
+		capacity:  capacity,
+		order:     list.New(),
+		delivered: make(map[string]*deliveryLogEntry),
+	}
+}
+
+// isDelivered returns true if the message identified by id was delivered to the subscriber
+// identified by key.
+func (l *deliveryLog) isDelivered(id, key string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	entry, present := l.delivered[id]
+	return present && entry.subscribers.Has(key)
+}
+
+// markDelivered records that the message identified by id was delivered to the subscriber
+// identified by key. The least recently delivered messages are forgotten once the log is full.
+func (l *deliveryLog) markDelivered(id, key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if entry, present := l.delivered[id]; present {
+		entry.subscribers.Insert(key)
+		l.order.MoveToBack(entry.element)
+		return
+	}
+	l.delivered[id] = &deliveryLogEntry{
+		subscribers: sets.NewString(key),
+		element:     l.order.PushBack(id),
+	}
+	for l.order.Len() > l.capacity {
+		oldest := l.order.Front()
+		l.order.Remove(oldest)
+		delete(l.delivered, oldest.Value.(string))
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fanout
+
+import (
+	"testing"
+
+	"github.com/knative/eventing/pkg/provisioners"
+)
+
+func TestMessageID(t *testing.T) {
+	testCases := map[string]struct {
+		message  *provisioners.Message
+		expected string
+	}{
+		"no headers": {
+			message: &provisioners.Message{},
+		},
+		"binary v0.2": {
+			message: &provisioners.Message{
+				Headers: map[string]string{
+					"Ce-Source": "/mycontext",
+					"Ce-Id":     "1234",
+				},
+			},
+			expected: "/mycontext 1234",
+		},
+		"binary v0.1": {
+			message: &provisioners.Message{
+				Headers: map[string]string{
+					"ce-source":  "/mycontext",
+					"ce-eventid": "1234",
+				},
+			},
+			expected: "/mycontext 1234",
+		},
+		"structured": {
+			message: &provisioners.Message{
+				Headers: map[string]string{
+					"Content-Type": "application/cloudevents+json",
+				},
+				Payload: []byte(`{"specversion":"0.2","source":"/mycontext","id":"1234"}`),
+			},
+			expected: "/mycontext 1234",
+		},
+		"not a CloudEvent": {
+			message: &provisioners.Message{
+				Headers: map[string]string{
+					"Content-Type": "application/json",
+				},
+				Payload: []byte(`{"id":"1234"}`),
+			},
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			if actual := messageID(tc.message); actual != tc.expected {
+				t.Errorf("Unexpected message ID. Expected %q, Actual %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDeliveryLog(t *testing.T) {
+	l := newDeliveryLog(2)
+	l.markDelivered("1", "a")
+	l.markDelivered("1", "b")
+	l.markDelivered("2", "a")
+	if !l.isDelivered("1", "a") || !l.isDelivered("1", "b") || !l.isDelivered("2", "a") {
+		t.Errorf("Expected the deliveries to be remembered")
+	}
+	if l.isDelivered("2", "b") {
+		t.Errorf("Unexpected delivery of message 2 to b")
+	}
+
+	// Message 1 is the least recently delivered, so it is forgotten first.
+	l.markDelivered("3", "a")
+	if l.isDelivered("1", "a") {
+		t.Errorf("Expected message 1 to be forgotten")
+	}
+	if !l.isDelivered("2", "a") || !l.isDelivered("3", "a") {
+		t.Errorf("Expected messages 2 and 3 to be remembered")
+	}
+}
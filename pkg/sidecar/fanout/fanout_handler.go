@@ -22,8 +22,13 @@ limitations under the License.
 package fanout
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
@@ -35,6 +40,15 @@ const (
 	defaultTimeout = 1 * time.Minute
 
 	messageBufferSize = 500
+
+	defaultAsyncConcurrency = 10
+
+	// AsyncConcurrencyEnv is the environment variable holding the maximum number of messages each
+	// Channel dispatches concurrently when its Config.AsyncHandler is set.
+	AsyncConcurrencyEnv = "ASYNC_CONCURRENCY"
+	// AsyncQueueDepthEnv is the environment variable holding the maximum number of messages each
+	// Channel queues, when its Config.AsyncHandler is set, before it refuses new ones.
+	AsyncQueueDepthEnv = "ASYNC_QUEUE_DEPTH"
 )
 
 // Config for a fanout.Handler.
@@ -43,19 +57,46 @@ type Config struct {
 	// AsyncHandler controls whether the Subscriptions are called synchronous or asynchronously.
 	// It is expected to be false when used as a sidecar.
 	AsyncHandler bool `json:"asyncHandler,omitempty"`
+	// Ordering is the order in which messages are delivered to each subscriber. Ordering is
+	// preserved as long as deliveries succeed: a message that could not be delivered does not
+	// hold back the following ones.
+	Ordering Ordering `json:"ordering,omitempty"`
+	// PartitionKeyExtension is the CloudEvents extension holding the partition key, when Ordering
+	// is OrderingPartitionKey. It defaults to DefaultPartitionKeyExtension.
+	PartitionKeyExtension string `json:"partitionKeyExtension,omitempty"`
 }
 
 // Handler is a http.Handler that takes a single request in and fans it out to N other servers.
 type Handler struct {
 	config Config
 
+	// receivedMessages queues the messages to dispatch asynchronously. They are dispatched by at
+	// most asyncConcurrency workers, started on demand. Workers exit once the queue is empty.
 	receivedMessages chan *forwardMessage
-	receiver         *provisioners.MessageReceiver
-	dispatcher       *provisioners.MessageDispatcher
+	asyncQueueDepth  int
+	asyncConcurrency int
+	workersLock      sync.Mutex
+	workers          int
+	// inFlight, if set, counts the queued messages until they are dispatched.
+	inFlight *InFlight
+
+	receiver     *provisioners.MessageReceiver
+	receiverOpts []provisioners.ReceiverOption
+	dispatcher   provisioners.Dispatcher
 
-	// TODO: Plumb context through the receiver and dispatcher and use that to store the timeout,
-	// rather than a member variable.
+	// TODO: Plumb context through the receiver and use that to store the timeout, rather than a
+	// member variable.
+	// timeout bounds the delivery of a message, including its retries, to each subscriber. The
+	// requests still running when it expires are cancelled, if the dispatcher is a
+	// provisioners.ContextDispatcher.
 	timeout time.Duration
+	retry   RetryConfig
+
+	delivered *deliveryLog
+	// ordered runs the deliveries that must be ordered, according to config.Ordering.
+	ordered *OrderingQueues
+
+	messageLog MessageLog
 
 	logger *zap.Logger
 }
@@ -64,36 +105,134 @@ var _ http.Handler = &Handler{}
 
 // forwardMessage is passed between the Receiver and the Dispatcher.
 type forwardMessage struct {
-	msg  *provisioners.Message
-	done chan<- error
+	channel provisioners.ChannelReference
+	msg     *provisioners.Message
+}
+
+// MessageLog durably stores messages, to be delivered to the Channel's subscribers later.
+type MessageLog interface {
+	// Append stores m, sent to channel. Once it returned nil, m is delivered to all the
+	// subscribers of channel at least once, even if the process restarts.
+	Append(channel provisioners.ChannelReference, m *provisioners.Message) error
+}
+
+// HandlerOption configures a Handler.
+type HandlerOption func(*Handler)
+
+// WithReceiverOptions applies opts to the Handler's MessageReceiver.
+func WithReceiverOptions(opts ...provisioners.ReceiverOption) HandlerOption {
+	return func(h *Handler) {
+		h.receiverOpts = append(h.receiverOpts, opts...)
+	}
+}
+
+// WithDispatcher makes the Handler send messages to its subscribers using d, rather than a
+// MessageDispatcher of its own. This allows several Handlers to share d.
+func WithDispatcher(d provisioners.Dispatcher) HandlerOption {
+	return func(h *Handler) {
+		h.dispatcher = d
+	}
+}
+
+// WithOrderingQueues makes the Handler run its ordered deliveries in q, rather than in queues of
+// its own. A Handler replacing another one, for a new Config, keeps delivering in order behind the
+// messages the replaced Handler is still delivering if they share q.
+func WithOrderingQueues(q *OrderingQueues) HandlerOption {
+	return func(h *Handler) {
+		h.ordered = q
+	}
+}
+
+// WithRetry sets how delivery to each subscriber is retried.
+func WithRetry(c RetryConfig) HandlerOption {
+	return func(h *Handler) {
+		h.retry = c
+	}
+}
+
+// WithAsyncQueue bounds the resources used when Config.AsyncHandler is set. At most concurrency
+// messages are dispatched at once, and at most queueDepth more wait to be dispatched. Further
+// messages are refused until the queue drains.
+func WithAsyncQueue(concurrency, queueDepth int) HandlerOption {
+	return func(h *Handler) {
+		h.asyncConcurrency = concurrency
+		h.asyncQueueDepth = queueDepth
+	}
+}
+
+// HandlerOptionsFromEnv builds the HandlerOptions configured by the AsyncConcurrencyEnv and
+// AsyncQueueDepthEnv environment variables.
+func HandlerOptionsFromEnv() ([]HandlerOption, error) {
+	concurrency, err := positiveIntFromEnv(AsyncConcurrencyEnv, defaultAsyncConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	queueDepth, err := positiveIntFromEnv(AsyncQueueDepthEnv, messageBufferSize)
+	if err != nil {
+		return nil, err
+	}
+	return []HandlerOption{WithAsyncQueue(concurrency, queueDepth)}, nil
+}
+
+func positiveIntFromEnv(name string, defaultValue int) (int, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive integer", name, v)
+	}
+	return i, nil
+}
+
+// WithMessageLog makes the Handler append the messages it receives to l, which is then
+// responsible for delivering them, rather than dispatching them itself. Config.AsyncHandler is
+// ignored.
+func WithMessageLog(l MessageLog) HandlerOption {
+	return func(h *Handler) {
+		h.messageLog = l
+	}
 }
 
 // NewHandler creates a new fanout.Handler.
-func NewHandler(logger *zap.Logger, config Config) *Handler {
+func NewHandler(logger *zap.Logger, config Config, opts ...HandlerOption) *Handler {
 	handler := &Handler{
 		logger:           logger,
 		config:           config,
-		dispatcher:       provisioners.NewMessageDispatcher(logger.Sugar()),
-		receivedMessages: make(chan *forwardMessage, messageBufferSize),
+		asyncQueueDepth:  messageBufferSize,
+		asyncConcurrency: defaultAsyncConcurrency,
 		timeout:          defaultTimeout,
+		retry: RetryConfig{
+			MaxRetries: defaultMaxRetries,
+			Backoff:    defaultBackoff,
+		},
+		delivered: newDeliveryLog(deliveryLogSize),
+		ordered:   NewOrderingQueues(),
+	}
+	for _, opt := range opts {
+		opt(handler)
+	}
+	handler.receivedMessages = make(chan *forwardMessage, handler.asyncQueueDepth)
+	if handler.dispatcher == nil {
+		handler.dispatcher = provisioners.NewMessageDispatcher(logger.Sugar())
 	}
 	// The receiver function needs to point back at the handler itself, so set it up after
 	// initialization.
-	handler.receiver = provisioners.NewMessageReceiver(createReceiverFunction(handler), logger.Sugar())
+	handler.receiver = provisioners.NewMessageReceiver(createReceiverFunction(handler), logger.Sugar(), handler.receiverOpts...)
 
 	return handler
 }
 
 func createReceiverFunction(f *Handler) func(provisioners.ChannelReference, *provisioners.Message) error {
-	return func(_ provisioners.ChannelReference, m *provisioners.Message) error {
+	return func(c provisioners.ChannelReference, m *provisioners.Message) error {
+		if f.messageLog != nil {
+			return f.messageLog.Append(c, m)
+		}
 		if f.config.AsyncHandler {
-			go func() {
-				// Any returned error is already logged in f.dispatch().
-				_ = f.dispatch(m)
-			}()
-			return nil
+			return f.enqueue(c, m)
 		}
-		return f.dispatch(m)
+		return f.dispatch(c, m)
 	}
 }
 
@@ -101,34 +240,190 @@ func (f *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	f.receiver.HandleRequest(w, r)
 }
 
-// dispatch takes the request, fans it out to each subscription in f.config. If all the fanned out
-// requests return successfully, then return nil. Else, return an error.
-func (f *Handler) dispatch(msg *provisioners.Message) error {
-	errorCh := make(chan error, len(f.config.Subscriptions))
-	for _, sub := range f.config.Subscriptions {
-		go func(s eventingduck.ChannelSubscriberSpec) {
-			errorCh <- f.makeFanoutRequest(*msg, s)
-		}(sub)
+// enqueue queues m to be dispatched asynchronously, starting a worker if fewer than
+// f.asyncConcurrency are running. It returns provisioners.ErrQueueFull if the queue is full.
+func (f *Handler) enqueue(c provisioners.ChannelReference, m *provisioners.Message) error {
+	queued := queueLength.WithLabelValues(c.Namespace, c.Name)
+	queued.Inc()
+	f.inFlight.add()
+	select {
+	case f.receivedMessages <- &forwardMessage{channel: c, msg: m}:
+	default:
+		f.inFlight.done()
+		queued.Dec()
+		droppedMessages.WithLabelValues(c.Namespace, c.Name).Inc()
+		f.logger.Warn("Refusing message, the queue is full", zap.Any("channel", c))
+		return provisioners.ErrQueueFull
 	}
 
-	for range f.config.Subscriptions {
+	f.workersLock.Lock()
+	defer f.workersLock.Unlock()
+	if f.workers < f.asyncConcurrency {
+		f.workers++
+		go f.work()
+	}
+	return nil
+}
+
+// work dispatches the queued messages until the queue is empty.
+func (f *Handler) work() {
+	for {
+		// The lock makes checking for an empty queue and exiting atomic with respect to enqueue,
+		// so a message is never left in the queue without a worker.
+		f.workersLock.Lock()
 		select {
-		case err := <-errorCh:
-			if err != nil {
-				f.logger.Error("Fanout had an error", zap.Error(err))
-				return err
+		case fm := <-f.receivedMessages:
+			// Starting the deliveries before releasing the lock keeps ordered deliveries in the
+			// order the messages were queued.
+			d := f.startDispatch(fm.channel, fm.msg)
+			f.workersLock.Unlock()
+			queueLength.WithLabelValues(fm.channel.Namespace, fm.channel.Name).Dec()
+			// Any returned error is already logged in f.waitDispatch().
+			_ = f.waitDispatch(d)
+			f.inFlight.done()
+		default:
+			f.workers--
+			f.workersLock.Unlock()
+			return
+		}
+	}
+}
+
+// dispatch takes the request sent to the channel c, fans it out to each subscription in f.config.
+// Each subscription is delivered to, and retried, independently of the others. If all the fanned
+// out requests return successfully, then return nil. Else, return a *DispatchError listing the
+// subscriptions that did not receive the message.
+//
+// If msg has a provisioners.SubscribersHeader, it is only sent to the subscriptions listed there,
+// the others received it already. Successful deliveries of CloudEvents are also remembered, so if
+// the message is redelivered without that header, it is only sent to the subscriptions that did
+// not receive it yet.
+func (f *Handler) dispatch(c provisioners.ChannelReference, msg *provisioners.Message) error {
+	return f.waitDispatch(f.startDispatch(c, msg))
+}
+
+// pendingDispatch is a message being fanned out by dispatch.
+type pendingDispatch struct {
+	channel provisioners.ChannelReference
+	subs    []eventingduck.ChannelSubscriberSpec
+	id      string
+	// ctx is cancelled at the deadline of the deliveries, or once they are all done.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	resultCh chan dispatchResult
+	// started holds the indexes in subs of the deliveries that were started.
+	started map[int]bool
+}
+
+type dispatchResult struct {
+	index int
+	err   error
+}
+
+// startDispatch starts delivering msg to each subscription, without waiting for the deliveries.
+// Deliveries that must be ordered are queued behind the ones started before.
+func (f *Handler) startDispatch(c provisioners.ChannelReference, msg *provisioners.Message) *pendingDispatch {
+	selected, msg := selectedSubscribers(msg)
+	d := &pendingDispatch{
+		channel: c,
+		subs:    f.config.Subscriptions,
+		id:      messageID(msg),
+		started: make(map[int]bool),
+	}
+	d.ctx, d.cancel = context.WithTimeout(context.Background(), f.timeout)
+	d.resultCh = make(chan dispatchResult, len(d.subs))
+	for i, sub := range d.subs {
+		if selected != nil && !selected.Has(SubscriberKey(sub)) {
+			f.logger.Debug("Skipping subscriber not selected by the sender", zap.String("subscriber", subscriberName(sub)))
+			continue
+		}
+		if d.id != "" && f.delivered.isDelivered(d.id, SubscriberKey(sub)) {
+			f.logger.Debug("Skipping subscriber that already received the message", zap.String("subscriber", subscriberName(sub)))
+			continue
+		}
+		d.started[i] = true
+		i, s := i, sub
+		task := func() {
+			err := f.deliver(d.ctx, d.channel, msg, s)
+			if err == nil && d.id != "" {
+				f.delivered.markDelivered(d.id, SubscriberKey(s))
 			}
-		case <-time.After(f.timeout):
-			f.logger.Error("Fanout timed out")
-			return errors.New("fanout timed out")
+			d.resultCh <- dispatchResult{index: i, err: err}
+		}
+		if key := f.orderingKey(c, msg, sub); key != "" {
+			f.ordered.submit(key, task)
+		} else {
+			go task()
 		}
 	}
-	// All Subscriptions returned err = nil.
-	return nil
+	return d
+}
+
+// waitDispatch waits for all the deliveries started by startDispatch. The deliveries still running
+// at their deadline are cancelled rather than abandoned, so a subscriber reported as failed, and
+// targeted by the sender's retry, did not receive the message in the meantime.
+func (f *Handler) waitDispatch(d *pendingDispatch) error {
+	defer d.cancel()
+	failed := make(map[int]error)
+	for pending := len(d.started); pending > 0; pending-- {
+		r := <-d.resultCh
+		if r.err != nil {
+			failed[r.index] = r.err
+		}
+	}
+	if d.ctx.Err() == context.DeadlineExceeded && len(failed) > 0 {
+		f.logger.Error("Fanout timed out")
+	}
+	if len(failed) == 0 {
+		// All Subscriptions returned err = nil.
+		return nil
+	}
+
+	dispatchErr := &DispatchError{Total: len(d.subs)}
+	for i, sub := range d.subs {
+		if err, present := failed[i]; present {
+			f.logger.Error("Fanout to subscriber failed", zap.String("subscriber", subscriberName(sub)), zap.Error(err))
+			dispatchErr.Errors = append(dispatchErr.Errors, SubscriberError{Subscriber: sub, Err: err})
+		}
+	}
+	return dispatchErr
+}
+
+// deliver sends the message to a single subscription, retrying with an exponential backoff until
+// it succeeds, the retries are exhausted or the next attempt would start after the deadline of ctx.
+func (f *Handler) deliver(ctx context.Context, c provisioners.ChannelReference, msg *provisioners.Message, sub eventingduck.ChannelSubscriberSpec) error {
+	if ctx.Err() != nil {
+		// The delivery waited too long behind ordered ones.
+		return errors.New("fanout timed out")
+	}
+	deadline, _ := ctx.Deadline()
+	backoff := f.retry.Backoff
+	for attempt := 0; ; attempt++ {
+		err := f.makeFanoutRequest(ctx, c, *msg, sub)
+		if err == nil {
+			return nil
+		}
+		if attempt >= f.retry.MaxRetries || time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		f.logger.Debug("Retrying delivery to subscriber", zap.String("subscriber", subscriberName(sub)), zap.Int("attempt", attempt+1), zap.Error(err))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
 }
 
 // makeFanoutRequest sends the request to exactly one subscription. It handles both the `call` and
-// the `sink` portions of the subscription.
-func (f *Handler) makeFanoutRequest(m provisioners.Message, sub eventingduck.ChannelSubscriberSpec) error {
-	return f.dispatcher.DispatchMessage(&m, sub.SubscriberURI, sub.ReplyURI, provisioners.DispatchDefaults{})
+// the `sink` portions of the subscription. The subscription's Secrets are read in the namespace of
+// the channel c. The request is cancelled when ctx is done, if the dispatcher supports it.
+func (f *Handler) makeFanoutRequest(ctx context.Context, c provisioners.ChannelReference, m provisioners.Message, sub eventingduck.ChannelSubscriberSpec) error {
+	defaults := provisioners.DispatchDefaults{
+		Namespace:     c.Namespace,
+		TLSSecretRef:  sub.TLSSecretRef,
+		AuthType:      sub.AuthType,
+		AuthSecretRef: sub.AuthSecretRef,
+	}
+	if d, ok := f.dispatcher.(provisioners.ContextDispatcher); ok {
+		return d.DispatchMessageWithContext(ctx, &m, sub.SubscriberURI, sub.ReplyURI, defaults)
+	}
+	return f.dispatcher.DispatchMessage(&m, sub.SubscriberURI, sub.ReplyURI, defaults)
 }
@@ -17,12 +17,16 @@ limitations under the License.
 package fanout
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -279,3 +283,476 @@ func callableSucceed(writer http.ResponseWriter, _ *http.Request) {
 	writer.WriteHeader(http.StatusOK)
 	writer.Write([]byte(cloudEvent))
 }
+
+func TestFanoutHandler_RetriesEachSubscriberIndependently(t *testing.T) {
+	var healthyCalls, flakyCalls atomic.Int32
+	healthy := httptest.NewServer(&fakeHandler{
+		handler: func(w http.ResponseWriter, _ *http.Request) {
+			healthyCalls.Inc()
+			w.WriteHeader(http.StatusAccepted)
+		},
+	})
+	defer healthy.Close()
+	flaky := httptest.NewServer(&fakeHandler{
+		handler: func(w http.ResponseWriter, _ *http.Request) {
+			// Fail the first two attempts.
+			if flakyCalls.Inc() <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		},
+	})
+	defer flaky.Close()
+
+	h := NewHandler(zap.NewNop(), Config{
+		Subscriptions: []eventingduck.ChannelSubscriberSpec{
+			{SubscriberURI: healthy.URL[7:]},
+			{SubscriberURI: flaky.URL[7:]},
+		},
+	}, WithRetry(RetryConfig{MaxRetries: 3, Backoff: time.Millisecond}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, makeCloudEventRequest("retry-1"))
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Unexpected status code. Expected %v, Actual %v", http.StatusAccepted, w.Code)
+	}
+	if healthyCalls.Load() != 1 {
+		t.Errorf("Unexpected calls to the healthy subscriber. Expected 1, Actual %v", healthyCalls.Load())
+	}
+	if flakyCalls.Load() != 3 {
+		t.Errorf("Unexpected calls to the flaky subscriber. Expected 3, Actual %v", flakyCalls.Load())
+	}
+}
+
+func TestFanoutHandler_RedeliveryOnlyToFailedSubscribers(t *testing.T) {
+	var healthyCalls, failingCalls atomic.Int32
+	var failing atomic.Bool
+	failing.Store(true)
+	healthy := httptest.NewServer(&fakeHandler{
+		handler: func(w http.ResponseWriter, _ *http.Request) {
+			healthyCalls.Inc()
+			w.WriteHeader(http.StatusAccepted)
+		},
+	})
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(&fakeHandler{
+		handler: func(w http.ResponseWriter, _ *http.Request) {
+			failingCalls.Inc()
+			if failing.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		},
+	})
+	defer unhealthy.Close()
+
+	subs := []eventingduck.ChannelSubscriberSpec{
+		{SubscriberURI: healthy.URL[7:]},
+		{SubscriberURI: unhealthy.URL[7:]},
+	}
+	h := NewHandler(zap.NewNop(), Config{Subscriptions: subs}, WithRetry(RetryConfig{}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, makeCloudEventRequest("redelivery-1"))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Unexpected status code. Expected %v, Actual %v", http.StatusInternalServerError, w.Code)
+	}
+
+	// The sender redelivers the same event once the failing subscriber recovered.
+	failing.Store(false)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, makeCloudEventRequest("redelivery-1"))
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Unexpected status code. Expected %v, Actual %v", http.StatusAccepted, w.Code)
+	}
+	if healthyCalls.Load() != 1 {
+		t.Errorf("Expected the healthy subscriber to receive the event once, Actual %v", healthyCalls.Load())
+	}
+	if failingCalls.Load() != 2 {
+		t.Errorf("Expected the failing subscriber to receive the event twice, Actual %v", failingCalls.Load())
+	}
+
+	// A different event is delivered to everyone.
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, makeCloudEventRequest("redelivery-2"))
+	if healthyCalls.Load() != 2 {
+		t.Errorf("Expected the healthy subscriber to receive the second event, Actual %v", healthyCalls.Load())
+	}
+}
+
+func TestFanoutHandler_RetryOnlyToFailedSubscribers(t *testing.T) {
+	var healthyCalls, failingCalls atomic.Int32
+	var forwarded atomic.String
+	var failing atomic.Bool
+	failing.Store(true)
+	healthy := httptest.NewServer(&fakeHandler{
+		handler: func(w http.ResponseWriter, _ *http.Request) {
+			healthyCalls.Inc()
+			w.WriteHeader(http.StatusAccepted)
+		},
+	})
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(&fakeHandler{
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			failingCalls.Inc()
+			forwarded.Store(r.Header.Get(provisioners.SubscribersHeader))
+			if failing.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		},
+	})
+	defer unhealthy.Close()
+
+	subs := []eventingduck.ChannelSubscriberSpec{
+		{SubscriberURI: healthy.URL[7:]},
+		{SubscriberURI: unhealthy.URL[7:]},
+	}
+	h := NewHandler(zap.NewNop(), Config{Subscriptions: subs}, WithRetry(RetryConfig{}))
+
+	// The message is not a CloudEvent, so only the header tells which subscribers received it.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "http://channelname.channelnamespace/", body("message")))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Unexpected status code. Expected %v, Actual %v", http.StatusInternalServerError, w.Code)
+	}
+	failed := w.Header().Get(provisioners.FailedSubscribersHeader)
+	if expected := provisioners.FormatSubscribers([]string{SubscriberKey(subs[1])}); failed != expected {
+		t.Errorf("Unexpected %s header. Expected %q, Actual %q", provisioners.FailedSubscribersHeader, expected, failed)
+	}
+
+	// The sender retries the failed subscribers once they recovered.
+	failing.Store(false)
+	req := httptest.NewRequest("POST", "http://channelname.channelnamespace/", body("message"))
+	req.Header.Set(provisioners.SubscribersHeader, failed)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Unexpected status code. Expected %v, Actual %v", http.StatusAccepted, w.Code)
+	}
+	if healthyCalls.Load() != 1 {
+		t.Errorf("Expected the healthy subscriber to receive the message once, Actual %v", healthyCalls.Load())
+	}
+	if failingCalls.Load() != 2 {
+		t.Errorf("Expected the failing subscriber to receive the message twice, Actual %v", failingCalls.Load())
+	}
+	if forwarded.Load() != "" {
+		t.Errorf("Expected the %s header not to be sent to subscribers, Actual %q", provisioners.SubscribersHeader, forwarded.Load())
+	}
+}
+
+func TestFanoutHandler_DispatchReportsFailedSubscribers(t *testing.T) {
+	healthy := httptest.NewServer(&fakeHandler{handler: callableSucceed})
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(&fakeHandler{
+		handler: func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		},
+	})
+	defer unhealthy.Close()
+	var slowCompleted atomic.Bool
+	slow := httptest.NewServer(&fakeHandler{
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(50 * time.Millisecond):
+				slowCompleted.Store(true)
+				w.WriteHeader(http.StatusAccepted)
+			case <-r.Context().Done():
+			}
+		},
+	})
+	defer slow.Close()
+
+	subs := []eventingduck.ChannelSubscriberSpec{
+		{SubscriberURI: healthy.URL[7:]},
+		{SubscriberURI: unhealthy.URL[7:]},
+		{SubscriberURI: slow.URL[7:]},
+	}
+	h := NewHandler(zap.NewNop(), Config{Subscriptions: subs}, WithRetry(RetryConfig{}))
+	h.timeout = 10 * time.Millisecond
+
+	err := h.dispatch(provisioners.ChannelReference{}, &provisioners.Message{})
+	dispatchErr, ok := err.(*DispatchError)
+	if !ok {
+		t.Fatalf("Expected a *DispatchError, Actual %T: %v", err, err)
+	}
+	if dispatchErr.Total != 3 {
+		t.Errorf("Unexpected Total. Expected 3, Actual %v", dispatchErr.Total)
+	}
+	if len(dispatchErr.Errors) != 2 {
+		t.Fatalf("Unexpected number of failed subscribers. Expected 2, Actual %v", dispatchErr.Errors)
+	}
+	if dispatchErr.Errors[0].Subscriber.SubscriberURI != subs[1].SubscriberURI {
+		t.Errorf("Expected the unhealthy subscriber to fail, Actual %v", dispatchErr.Errors[0])
+	}
+	if dispatchErr.Errors[1].Subscriber.SubscriberURI != subs[2].SubscriberURI {
+		t.Errorf("Expected the slow subscriber to time out, Actual %v", dispatchErr.Errors[1])
+	}
+	// The sender retries the slow subscriber, its timed out request must not be delivered too.
+	time.Sleep(100 * time.Millisecond)
+	if slowCompleted.Load() {
+		t.Errorf("Expected the timed out request to the slow subscriber to be cancelled")
+	}
+}
+
+func makeCloudEventRequest(id string) *http.Request {
+	req := httptest.NewRequest("POST", "http://channelname.channelnamespace/", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Ce-Specversion", "0.2")
+	req.Header.Set("Ce-Type", "com.example.someevent")
+	req.Header.Set("Ce-Source", "/mycontext")
+	req.Header.Set("Ce-Id", id)
+	return req
+}
+
+func TestFanoutHandler_AsyncQueueFull(t *testing.T) {
+	received := make(chan struct{}, 10)
+	release := make(chan struct{})
+	subscriber := httptest.NewServer(&fakeHandler{
+		handler: func(w http.ResponseWriter, _ *http.Request) {
+			received <- struct{}{}
+			<-release
+			w.WriteHeader(http.StatusAccepted)
+		},
+	})
+	defer subscriber.Close()
+
+	h := NewHandler(zap.NewNop(), Config{
+		Subscriptions: []eventingduck.ChannelSubscriberSpec{
+			{SubscriberURI: subscriber.URL[7:]},
+		},
+		AsyncHandler: true,
+	}, WithAsyncQueue(1, 1))
+
+	// The first message is taken by the only worker, which blocks in the subscriber.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, makeCloudEventRequest("queue-1"))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Unexpected status code. Expected %v, Actual %v", http.StatusAccepted, w.Code)
+	}
+	<-received
+
+	// The second message waits in the queue.
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, makeCloudEventRequest("queue-2"))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Unexpected status code. Expected %v, Actual %v", http.StatusAccepted, w.Code)
+	}
+
+	// The third message does not fit.
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, makeCloudEventRequest("queue-3"))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Unexpected status code. Expected %v, Actual %v", http.StatusTooManyRequests, w.Code)
+	}
+
+	close(release)
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Errorf("Timed out waiting for the queued message to be dispatched")
+	}
+}
+
+func TestFanoutHandler_AsyncConcurrency(t *testing.T) {
+	const concurrency = 2
+	var inFlight, maxInFlight atomic.Int32
+	var wg sync.WaitGroup
+	subscriber := httptest.NewServer(&fakeHandler{
+		handler: func(w http.ResponseWriter, _ *http.Request) {
+			defer wg.Done()
+			n := inFlight.Inc()
+			for {
+				max := maxInFlight.Load()
+				if n <= max || maxInFlight.CAS(max, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			inFlight.Dec()
+			w.WriteHeader(http.StatusAccepted)
+		},
+	})
+	defer subscriber.Close()
+
+	h := NewHandler(zap.NewNop(), Config{
+		Subscriptions: []eventingduck.ChannelSubscriberSpec{
+			{SubscriberURI: subscriber.URL[7:]},
+		},
+		AsyncHandler: true,
+	}, WithAsyncQueue(concurrency, 100))
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, makeCloudEventRequest(fmt.Sprintf("concurrency-%d", i)))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Unexpected status code. Expected %v, Actual %v", http.StatusAccepted, w.Code)
+		}
+	}
+	wg.Wait()
+	if maxInFlight.Load() > concurrency {
+		t.Errorf("Too many concurrent dispatches. Expected at most %d, Actual %d", concurrency, maxInFlight.Load())
+	}
+}
+
+func TestFanoutHandler_InFlight(t *testing.T) {
+	release := make(chan struct{})
+	subscriber := httptest.NewServer(&fakeHandler{
+		handler: func(w http.ResponseWriter, _ *http.Request) {
+			<-release
+			w.WriteHeader(http.StatusAccepted)
+		},
+	})
+	defer subscriber.Close()
+
+	inFlight := NewInFlight()
+	if err := inFlight.Wait(context.Background()); err != nil {
+		t.Fatalf("Unexpected error waiting without any message: %v", err)
+	}
+	h := NewHandler(zap.NewNop(), Config{
+		Subscriptions: []eventingduck.ChannelSubscriberSpec{
+			{SubscriberURI: subscriber.URL[7:]},
+		},
+		AsyncHandler: true,
+	}, WithInFlight(inFlight))
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, makeCloudEventRequest(fmt.Sprintf("in-flight-%d", i)))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Unexpected status code. Expected %v, Actual %v", http.StatusAccepted, w.Code)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := inFlight.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error while messages are in flight. Expected %v, Actual %v", context.DeadlineExceeded, err)
+	}
+	close(release)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := inFlight.Wait(ctx); err != nil {
+		t.Errorf("Unexpected error waiting for the messages: %v", err)
+	}
+}
+
+func TestHandlerOptionsFromEnv(t *testing.T) {
+	testCases := map[string]struct {
+		env                 map[string]string
+		expectedErr         bool
+		expectedConcurrency int
+		expectedQueueDepth  int
+	}{
+		"defaults": {
+			expectedConcurrency: defaultAsyncConcurrency,
+			expectedQueueDepth:  messageBufferSize,
+		},
+		"configured": {
+			env: map[string]string{
+				AsyncConcurrencyEnv: "3",
+				AsyncQueueDepthEnv:  "42",
+			},
+			expectedConcurrency: 3,
+			expectedQueueDepth:  42,
+		},
+		"invalid concurrency": {
+			env: map[string]string{
+				AsyncConcurrencyEnv: "zero",
+			},
+			expectedErr: true,
+		},
+		"non-positive queue depth": {
+			env: map[string]string{
+				AsyncQueueDepthEnv: "0",
+			},
+			expectedErr: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			for _, name := range []string{AsyncConcurrencyEnv, AsyncQueueDepthEnv} {
+				if v, ok := tc.env[name]; ok {
+					os.Setenv(name, v)
+				} else {
+					os.Unsetenv(name)
+				}
+				defer os.Unsetenv(name)
+			}
+			opts, err := HandlerOptionsFromEnv()
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("Unexpected error. Expected %v. Actual %v", tc.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			h := NewHandler(zap.NewNop(), Config{}, opts...)
+			if h.asyncConcurrency != tc.expectedConcurrency {
+				t.Errorf("Unexpected concurrency. Expected %v. Actual %v", tc.expectedConcurrency, h.asyncConcurrency)
+			}
+			if cap(h.receivedMessages) != tc.expectedQueueDepth {
+				t.Errorf("Unexpected queue depth. Expected %v. Actual %v", tc.expectedQueueDepth, cap(h.receivedMessages))
+			}
+		})
+	}
+}
+
+type fakeMessageLog struct {
+	err      error
+	appended []provisioners.ChannelReference
+}
+
+func (l *fakeMessageLog) Append(c provisioners.ChannelReference, _ *provisioners.Message) error {
+	l.appended = append(l.appended, c)
+	return l.err
+}
+
+func TestFanoutHandler_MessageLog(t *testing.T) {
+	testCases := map[string]struct {
+		err          error
+		expectedCode int
+	}{
+		"appended": {
+			expectedCode: http.StatusAccepted,
+		},
+		"append fails": {
+			err:          errors.New("disk full"),
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			var calls atomic.Int32
+			subscriber := httptest.NewServer(&fakeHandler{
+				handler: func(w http.ResponseWriter, _ *http.Request) {
+					calls.Inc()
+					w.WriteHeader(http.StatusAccepted)
+				},
+			})
+			defer subscriber.Close()
+
+			l := &fakeMessageLog{err: tc.err}
+			h := NewHandler(zap.NewNop(), Config{
+				Subscriptions: []eventingduck.ChannelSubscriberSpec{
+					{SubscriberURI: subscriber.URL[7:]},
+				},
+			}, WithMessageLog(l))
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, makeCloudEventRequest("log-1"))
+			if w.Code != tc.expectedCode {
+				t.Errorf("Unexpected status code. Expected %v, Actual %v", tc.expectedCode, w.Code)
+			}
+			expected := provisioners.ChannelReference{Namespace: "channelnamespace", Name: "channelname"}
+			if len(l.appended) != 1 || l.appended[0] != expected {
+				t.Errorf("Unexpected appended messages. Expected one for %v, Actual %v", expected, l.appended)
+			}
+			if calls.Load() != 0 {
+				t.Errorf("Expected the subscriber to be left to the log, Actual calls %v", calls.Load())
+			}
+		})
+	}
+}
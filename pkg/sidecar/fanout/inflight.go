@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fanout
+
+import (
+	"context"
+	"sync"
+)
+
+// InFlight counts the messages queued, or being dispatched, by the asynchronous Handlers it is
+// given to with WithInFlight, so that they can be waited for on shutdown. It is shared by all the
+// Handlers of a process, including those swapped out by a configuration change, which still
+// dispatch the messages they queued.
+type InFlight struct {
+	lock    sync.Mutex
+	pending int
+	// idle is closed once pending drops to zero.
+	idle chan struct{}
+}
+
+// NewInFlight creates an InFlight counting no message.
+func NewInFlight() *InFlight {
+	idle := make(chan struct{})
+	close(idle)
+	return &InFlight{idle: idle}
+}
+
+// WithInFlight makes the Handler count the messages it queues in f.
+func WithInFlight(f *InFlight) HandlerOption {
+	return func(h *Handler) {
+		h.inFlight = f
+	}
+}
+
+func (f *InFlight) add() {
+	if f == nil {
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.pending == 0 {
+		f.idle = make(chan struct{})
+	}
+	f.pending++
+}
+
+func (f *InFlight) done() {
+	if f == nil {
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.pending--; f.pending == 0 {
+		close(f.idle)
+	}
+}
+
+// Wait blocks until no message is queued or being dispatched, or until ctx is done, in which
+// case it returns ctx's error.
+func (f *InFlight) Wait(ctx context.Context) error {
+	f.lock.Lock()
+	idle := f.idle
+	f.lock.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
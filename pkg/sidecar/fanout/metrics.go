@@ -0,0 +1,50 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fanout
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// queueLength is the number of messages waiting to be dispatched asynchronously.
+	queueLength = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "knative_eventing",
+			Subsystem: "fanout",
+			Name:      "queue_length",
+			Help:      "Number of messages waiting in the channel's queue to be fanned out.",
+		},
+		[]string{"namespace", "channel"},
+	)
+
+	// droppedMessages counts the messages refused because the queue was full.
+	droppedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "knative_eventing",
+			Subsystem: "fanout",
+			Name:      "dropped_messages_total",
+			Help:      "Number of messages refused because the channel's queue was full.",
+		},
+		[]string{"namespace", "channel"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(queueLength, droppedMessages)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fanout
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
+)
+
+// Ordering is the order in which a Handler delivers messages to each subscriber.
+type Ordering string
+
+const (
+	// OrderingNone delivers messages concurrently, in no particular order.
+	OrderingNone Ordering = ""
+	// OrderingSubscriber delivers messages to each subscriber one at a time, in the order they
+	// were received.
+	OrderingSubscriber Ordering = "subscriber"
+	// OrderingPartitionKey delivers the messages having the same partition key to each
+	// subscriber one at a time, in the order they were received. Messages with different
+	// partition keys are delivered concurrently.
+	OrderingPartitionKey Ordering = "partitionKey"
+
+	// DefaultPartitionKeyExtension is the CloudEvents extension holding the partition key, unless
+	// Config.PartitionKeyExtension says otherwise.
+	DefaultPartitionKeyExtension = "partitionkey"
+)
+
+// orderingKey returns the key of the queue delivering m, sent to channel c, to sub, or the empty
+// string if the delivery does not need to be ordered. The key includes the channel, as the queues
+// may be shared by the Handlers of several channels.
+func (f *Handler) orderingKey(c provisioners.ChannelReference, m *provisioners.Message, sub eventingduck.ChannelSubscriberSpec) string {
+	key := c.Namespace + "/" + c.Name + "\x00" + SubscriberKey(sub)
+	switch f.config.Ordering {
+	case OrderingSubscriber:
+		return key
+	case OrderingPartitionKey:
+		extension := f.config.PartitionKeyExtension
+		if extension == "" {
+			extension = DefaultPartitionKeyExtension
+		}
+		return key + "\x00" + PartitionKey(m, extension)
+	default:
+		return ""
+	}
+}
+
+// PartitionKey returns the value of the CloudEvents attribute, or extension, named extension
+// carried by m, or the empty string if there is none.
+func PartitionKey(m *provisioners.Message, extension string) string {
+	var contentType string
+	for k, v := range m.Headers {
+		switch strings.ToLower(k) {
+		case "ce-" + extension, "ce-x-" + extension:
+			return v
+		case "content-type":
+			contentType = v
+		}
+	}
+	if !strings.HasPrefix(contentType, "application/cloudevents+json") {
+		return ""
+	}
+	var event map[string]interface{}
+	if err := json.Unmarshal(m.Payload, &event); err != nil {
+		return ""
+	}
+	if v, ok := event[extension].(string); ok {
+		return v
+	}
+	// Version 0.1 of the specification nests the extensions.
+	if extensions, ok := event["extensions"].(map[string]interface{}); ok {
+		if v, ok := extensions[extension].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// OrderingQueues runs the deliveries that must be ordered. The tasks submitted with the same key
+// run one at a time, in the order they were submitted. Tasks with different keys run
+// concurrently. Each key's goroutine exits once its queue is empty.
+type OrderingQueues struct {
+	lock sync.Mutex
+	// queues holds the tasks waiting for each key. A key is present while its goroutine runs.
+	queues map[string][]func()
+}
+
+// NewOrderingQueues creates empty OrderingQueues, to be shared with WithOrderingQueues.
+func NewOrderingQueues() *OrderingQueues {
+	return &OrderingQueues{
+		queues: make(map[string][]func()),
+	}
+}
+
+// submit queues task to run after all the tasks previously submitted with key.
+func (q *OrderingQueues) submit(key string, task func()) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	pending, running := q.queues[key]
+	q.queues[key] = append(pending, task)
+	if !running {
+		go q.run(key)
+	}
+}
+
+func (q *OrderingQueues) run(key string) {
+	for {
+		q.lock.Lock()
+		pending := q.queues[key]
+		if len(pending) == 0 {
+			delete(q.queues, key)
+			q.lock.Unlock()
+			return
+		}
+		task := pending[0]
+		q.queues[key] = pending[1:]
+		q.lock.Unlock()
+		task()
+	}
+}
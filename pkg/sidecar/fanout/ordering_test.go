@@ -0,0 +1,243 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fanout
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	eventingduck "github.com/knative/eventing/pkg/apis/duck/v1alpha1"
+	"github.com/knative/eventing/pkg/provisioners"
+	"go.uber.org/zap"
+)
+
+func TestPartitionKey(t *testing.T) {
+	testCases := map[string]struct {
+		message   *provisioners.Message
+		extension string
+		expected  string
+	}{
+		"no headers": {
+			message:   &provisioners.Message{},
+			extension: DefaultPartitionKeyExtension,
+		},
+		"binary v0.2": {
+			message: &provisioners.Message{
+				Headers: map[string]string{
+					"Ce-Partitionkey": "key1",
+				},
+			},
+			extension: DefaultPartitionKeyExtension,
+			expected:  "key1",
+		},
+		"binary v0.1": {
+			message: &provisioners.Message{
+				Headers: map[string]string{
+					"CE-X-Partitionkey": "key1",
+				},
+			},
+			extension: DefaultPartitionKeyExtension,
+			expected:  "key1",
+		},
+		"custom extension": {
+			message: &provisioners.Message{
+				Headers: map[string]string{
+					"Ce-Partitionkey": "key1",
+					"Ce-Customerid":   "customer1",
+				},
+			},
+			extension: "customerid",
+			expected:  "customer1",
+		},
+		"attribute": {
+			message: &provisioners.Message{
+				Headers: map[string]string{
+					"Ce-Subject": "order-42",
+				},
+			},
+			extension: "subject",
+			expected:  "order-42",
+		},
+		"structured v0.2": {
+			message: &provisioners.Message{
+				Headers: map[string]string{
+					"Content-Type": "application/cloudevents+json",
+				},
+				Payload: []byte(`{"specversion":"0.2","id":"1234","partitionkey":"key1"}`),
+			},
+			extension: DefaultPartitionKeyExtension,
+			expected:  "key1",
+		},
+		"structured v0.1": {
+			message: &provisioners.Message{
+				Headers: map[string]string{
+					"Content-Type": "application/cloudevents+json",
+				},
+				Payload: []byte(`{"cloudEventsVersion":"0.1","eventID":"1234","extensions":{"partitionkey":"key1"}}`),
+			},
+			extension: DefaultPartitionKeyExtension,
+			expected:  "key1",
+		},
+		"not a CloudEvent": {
+			message: &provisioners.Message{
+				Headers: map[string]string{
+					"Content-Type": "application/json",
+				},
+				Payload: []byte(`{"partitionkey":"key1"}`),
+			},
+			extension: DefaultPartitionKeyExtension,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			if actual := PartitionKey(tc.message, tc.extension); actual != tc.expected {
+				t.Errorf("Unexpected partition key. Expected %q, Actual %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestOrderingQueues(t *testing.T) {
+	q := NewOrderingQueues()
+	var lock sync.Mutex
+	ran := make(map[string][]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		for _, key := range []string{"a", "b"} {
+			wg.Add(1)
+			i, key := i, key
+			q.submit(key, func() {
+				defer wg.Done()
+				lock.Lock()
+				defer lock.Unlock()
+				ran[key] = append(ran[key], i)
+			})
+		}
+	}
+	wg.Wait()
+	for _, key := range []string{"a", "b"} {
+		for i, v := range ran[key] {
+			if i != v {
+				t.Fatalf("Tasks of %q ran out of order: %v", key, ran[key])
+			}
+		}
+	}
+}
+
+func makeOrderedRequest(i int, key string) *http.Request {
+	req := makeCloudEventRequest(fmt.Sprintf("ordered-%d", i))
+	req.Header.Set("Ce-Partitionkey", key)
+	return req
+}
+
+func TestFanoutHandler_OrderingSubscriber(t *testing.T) {
+	var lock sync.Mutex
+	var received []string
+	subscriber := httptest.NewServer(&fakeHandler{
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			// Make the earlier messages slower, so that they would be overtaken if they were
+			// delivered concurrently.
+			lock.Lock()
+			delay := time.Duration(10-len(received)) * time.Millisecond
+			lock.Unlock()
+			time.Sleep(delay)
+			lock.Lock()
+			received = append(received, r.Header.Get("Ce-Id"))
+			lock.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+		},
+	})
+	defer subscriber.Close()
+
+	h := NewHandler(zap.NewNop(), Config{
+		Subscriptions: []eventingduck.ChannelSubscriberSpec{
+			{SubscriberURI: subscriber.URL[7:]},
+		},
+		AsyncHandler: true,
+		Ordering:     OrderingSubscriber,
+	}, WithAsyncQueue(5, 100))
+
+	const count = 10
+	for i := 0; i < count; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, makeOrderedRequest(i, "key"))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Unexpected status code. Expected %v, Actual %v", http.StatusAccepted, w.Code)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lock.Lock()
+		got := append([]string(nil), received...)
+		lock.Unlock()
+		if len(got) == count {
+			for i, id := range got {
+				if expected := fmt.Sprintf("ordered-%d", i); id != expected {
+					t.Fatalf("Messages delivered out of order: %v", got)
+				}
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the messages, received %v", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFanoutHandler_OrderingPartitionKey(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan string, 10)
+	subscriber := httptest.NewServer(&fakeHandler{
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Ce-Partitionkey") == "blocked" {
+				<-release
+			}
+			received <- r.Header.Get("Ce-Id")
+			w.WriteHeader(http.StatusAccepted)
+		},
+	})
+	defer subscriber.Close()
+	defer close(release)
+
+	h := NewHandler(zap.NewNop(), Config{
+		Subscriptions: []eventingduck.ChannelSubscriberSpec{
+			{SubscriberURI: subscriber.URL[7:]},
+		},
+		AsyncHandler: true,
+		Ordering:     OrderingPartitionKey,
+	}, WithAsyncQueue(5, 100))
+
+	h.ServeHTTP(httptest.NewRecorder(), makeOrderedRequest(0, "blocked"))
+	h.ServeHTTP(httptest.NewRecorder(), makeOrderedRequest(1, "blocked"))
+	h.ServeHTTP(httptest.NewRecorder(), makeOrderedRequest(2, "free"))
+
+	// The message with another key is not held back by the blocked ones.
+	select {
+	case id := <-received:
+		if id != "ordered-2" {
+			t.Errorf("Unexpected message delivered. Expected ordered-2, Actual %v", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the message with another key")
+	}
+}
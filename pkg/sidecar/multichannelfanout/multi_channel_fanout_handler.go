@@ -45,6 +45,15 @@ type ChannelConfig struct {
 	Namespace    string        `json:"namespace"`
 	Name         string        `json:"name"`
 	FanoutConfig fanout.Config `json:"fanoutConfig"`
+	// Topic is the name of the topic, or the equivalent in the underlying messaging system, holding
+	// the events of the Channel. It is only set by the provisioners that record it.
+	Topic string `json:"topic,omitempty"`
+	// ExternalTopic is true if Topic is written by other systems rather than by the Channel.
+	ExternalTopic bool `json:"externalTopic,omitempty"`
+	// EventType and EventSource are the type and source of the CloudEvents wrapping the messages
+	// of the external topic that are not CloudEvents.
+	EventType   string `json:"eventType,omitempty"`
+	EventSource string `json:"eventSource,omitempty"`
 }
 
 // MakeChannelKey creates the key used for this Channel in the Handler's handlers map.
@@ -58,9 +67,10 @@ func makeChannelKeyFromConfig(config ChannelConfig) string {
 	return makeChannelKey(config.Namespace, config.Name)
 }
 
-// getChannelKey extracts the channel key from the given HTTP request.
+// getChannelKey extracts the channel key from the given HTTP request. The channel is identified by
+// the request's host, or by its path when it is path addressed.
 func getChannelKey(r *http.Request) (string, error) {
-	cr, err := provisioners.ParseChannel(r.Host)
+	cr, err := provisioners.ParseChannelFromRequest(r)
 	if err != nil {
 		return "", err
 	}
@@ -74,26 +84,54 @@ type Handler struct {
 	logger   *zap.Logger
 	handlers map[string]*fanout.Handler
 	config   Config
+	// handlerOpts are passed to every fanout.Handler.
+	handlerOpts []fanout.HandlerOption
+	// ordered is shared by all the fanout.Handlers, including those of the Handlers copied with
+	// CopyWithNewConfig, so that ordered deliveries keep their order when a Channel's handler is
+	// replaced.
+	ordered *fanout.OrderingQueues
 }
 
-// NewHandler creates a new Handler.
-func NewHandler(logger *zap.Logger, conf Config) (*Handler, error) {
-	handlers := make(map[string]*fanout.Handler, len(conf.ChannelConfigs))
+// NewHandler creates a new Handler. handlerOpts are applied to every Channel's fanout.Handler.
+func NewHandler(logger *zap.Logger, conf Config, handlerOpts ...fanout.HandlerOption) (*Handler, error) {
+	return newHandler(logger, conf, nil, handlerOpts)
+}
 
+// newHandler creates a new Handler. The fanout.Handlers of existing, if any, are reused for the
+// Channels whose configuration did not change. The new fanout.Handlers share the ordering queues of
+// existing, so that the messages existing is still dispatching keep their order with the following
+// ones.
+func newHandler(logger *zap.Logger, conf Config, existing *Handler, handlerOpts []fanout.HandlerOption) (*Handler, error) {
+	existingConfigs := make(map[string]fanout.Config)
+	ordered := fanout.NewOrderingQueues()
+	if existing != nil {
+		for _, cc := range existing.config.ChannelConfigs {
+			existingConfigs[makeChannelKeyFromConfig(cc)] = cc.FanoutConfig
+		}
+		ordered = existing.ordered
+	}
+	fanoutOpts := append(handlerOpts[:len(handlerOpts):len(handlerOpts)], fanout.WithOrderingQueues(ordered))
+
+	handlers := make(map[string]*fanout.Handler, len(conf.ChannelConfigs))
 	for _, cc := range conf.ChannelConfigs {
 		key := makeChannelKeyFromConfig(cc)
-		handler := fanout.NewHandler(logger, cc.FanoutConfig)
 		if _, present := handlers[key]; present {
 			logger.Error("Duplicate channel key", zap.String("channelKey", key))
 			return nil, fmt.Errorf("duplicate channel key: %v", key)
 		}
-		handlers[key] = handler
+		if ec, present := existingConfigs[key]; present && cmp.Equal(ec, cc.FanoutConfig) {
+			handlers[key] = existing.handlers[key]
+		} else {
+			handlers[key] = fanout.NewHandler(logger, cc.FanoutConfig, fanoutOpts...)
+		}
 	}
 
 	return &Handler{
-		logger:   logger,
-		config:   conf,
-		handlers: handlers,
+		logger:      logger,
+		config:      conf,
+		handlers:    handlers,
+		handlerOpts: handlerOpts,
+		ordered:     ordered,
 	}, nil
 }
 
@@ -105,9 +143,10 @@ func (h *Handler) ConfigDiff(updated Config) string {
 }
 
 // CopyWithNewConfig creates a new copy of this Handler with all the fields identical, except the
-// new Handler uses conf, rather than copying the existing Handler's config.
+// new Handler uses conf, rather than copying the existing Handler's config. The fanout.Handlers of
+// the Channels whose configuration did not change are shared with this Handler.
 func (h *Handler) CopyWithNewConfig(conf Config) (*Handler, error) {
-	return NewHandler(h.logger, conf)
+	return newHandler(h.logger, conf, h, h.handlerOpts)
 }
 
 // ServeHTTP delegates the actual handling of the request to a fanout.Handler, based on the
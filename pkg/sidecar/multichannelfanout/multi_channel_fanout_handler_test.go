@@ -155,6 +155,48 @@ func TestCopyWithNewConfig(t *testing.T) {
 	}
 }
 
+func TestCopyWithNewConfig_KeepsUnchangedChannels(t *testing.T) {
+	unchanged := ChannelConfig{
+		Namespace: "default",
+		Name:      "c1",
+		FanoutConfig: fanout.Config{
+			Subscriptions: []eventingduck.ChannelSubscriberSpec{
+				{
+					SubscriberURI: "subscriberdomain",
+				},
+			},
+			Ordering: fanout.OrderingSubscriber,
+		},
+	}
+	changed := ChannelConfig{
+		Namespace: "default",
+		Name:      "c2",
+	}
+	h, err := NewHandler(zap.NewNop(), Config{ChannelConfigs: []ChannelConfig{unchanged, changed}})
+	if err != nil {
+		t.Fatalf("Unable to create handler, %v", err)
+	}
+
+	changed.FanoutConfig.Subscriptions = []eventingduck.ChannelSubscriberSpec{
+		{
+			SubscriberURI: "anothersubscriberdomain",
+		},
+	}
+	newH, err := h.CopyWithNewConfig(Config{ChannelConfigs: []ChannelConfig{unchanged, changed}})
+	if err != nil {
+		t.Fatalf("Unable to copy handler: %v", err)
+	}
+	if newH.handlers["default/c1"] != h.handlers["default/c1"] {
+		t.Errorf("Expected the handler of the unchanged Channel to be kept")
+	}
+	if newH.handlers["default/c2"] == h.handlers["default/c2"] {
+		t.Errorf("Expected the handler of the changed Channel to be replaced")
+	}
+	if newH.ordered == nil || newH.ordered != h.ordered {
+		t.Errorf("Expected the ordering queues to be kept")
+	}
+}
+
 func TestConfigDiff(t *testing.T) {
 	config := Config{
 		ChannelConfigs: []ChannelConfig{
@@ -288,6 +330,37 @@ func TestServeHTTP(t *testing.T) {
 			key:                "second-channel.default",
 			expectedStatusCode: http.StatusAccepted,
 		},
+		"choose channel by path": {
+			config: Config{
+				ChannelConfigs: []ChannelConfig{
+					{
+						Namespace: "default",
+						Name:      "first-channel",
+						FanoutConfig: fanout.Config{
+							Subscriptions: []eventingduck.ChannelSubscriberSpec{
+								{
+									ReplyURI: "first-to-domain",
+								},
+							},
+						},
+					},
+					{
+						Namespace: "default",
+						Name:      "second-channel",
+						FanoutConfig: fanout.Config{
+							Subscriptions: []eventingduck.ChannelSubscriberSpec{
+								{
+									SubscriberURI: replaceDomain,
+								},
+							},
+						},
+					},
+				},
+			},
+			respStatusCode:     http.StatusOK,
+			key:                "in-memory-channel-dispatcher.knative-eventing/default/second-channel",
+			expectedStatusCode: http.StatusAccepted,
+		},
 	}
 	requestWithChannelKey := func(key string) *http.Request {
 		r := httptest.NewRequest("POST", fmt.Sprintf("http://%s/", key), strings.NewReader("{}"))
@@ -20,6 +20,8 @@ import (
 	"bytes"
 	"encoding/json"
 
+	eventingv1alpha1 "github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
+	"github.com/knative/eventing/pkg/sidecar/fanout"
 	"go.uber.org/zap"
 	"k8s.io/apimachinery/pkg/util/yaml"
 )
@@ -43,3 +45,24 @@ func unmarshalJsonDisallowUnknownFields(jb []byte, v interface{}) error {
 	d.DisallowUnknownFields()
 	return d.Decode(v)
 }
+
+// NewConfigFromChannels creates a multichannelfanout.Config holding the subscribers of each of
+// channels.
+func NewConfigFromChannels(channels []eventingv1alpha1.Channel) *Config {
+	cc := make([]ChannelConfig, 0)
+	for _, c := range channels {
+		channelConfig := ChannelConfig{
+			Namespace: c.Namespace,
+			Name:      c.Name,
+		}
+		if c.Spec.Subscribable != nil {
+			channelConfig.FanoutConfig = fanout.Config{
+				Subscriptions: c.Spec.Subscribable.Subscribers,
+			}
+		}
+		cc = append(cc, channelConfig)
+	}
+	return &Config{
+		ChannelConfigs: cc,
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/knative/eventing/pkg/sidecar/fanout"
 	"github.com/knative/eventing/pkg/sidecar/multichannelfanout"
 	"go.uber.org/zap"
 )
@@ -54,8 +55,10 @@ func NewHandler(handler *multichannelfanout.Handler, logger *zap.Logger) *Handle
 	return h
 }
 
-func NewEmptyHandler(logger *zap.Logger) (*Handler, error) {
-	h, err := multichannelfanout.NewHandler(logger, multichannelfanout.Config{})
+// NewEmptyHandler creates a new swappable.Handler with an empty configuration. handlerOpts are
+// applied to the fanout.Handlers of every configuration swapped in later.
+func NewEmptyHandler(logger *zap.Logger, handlerOpts ...fanout.HandlerOption) (*Handler, error) {
+	h, err := multichannelfanout.NewHandler(logger, multichannelfanout.Config{}, handlerOpts...)
 	if err != nil {
 		return nil, err
 	}
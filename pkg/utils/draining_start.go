@@ -0,0 +1,63 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"go.uber.org/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// DrainingStart wraps a manager.Runnable that keeps working after its stop channel is closed, such
+// as a receiver draining in-flight requests. manager.Manager.Start returns as soon as the stop
+// channel is closed, so callers use Wait to let the runnable finish before exiting.
+type DrainingStart struct {
+	logger  *zap.Logger
+	r       manager.Runnable
+	started chan struct{}
+	done    chan struct{}
+}
+
+var _ manager.Runnable = &DrainingStart{}
+
+// NewDrainingStart creates a wrapper around the provided runnable whose Wait method blocks until
+// the runnable's Start has returned.
+func NewDrainingStart(logger *zap.Logger, runnable manager.Runnable) *DrainingStart {
+	return &DrainingStart{
+		logger:  logger,
+		r:       runnable,
+		started: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (d *DrainingStart) Start(stopCh <-chan struct{}) error {
+	close(d.started)
+	defer close(d.done)
+	err := d.r.Start(stopCh)
+	d.logger.Debug("drainingStart finished", zap.Error(err))
+	return err
+}
+
+// Wait blocks until the wrapped runnable's Start has returned. It returns immediately if the
+// runnable was never started.
+func (d *DrainingStart) Wait() {
+	select {
+	case <-d.started:
+		<-d.done
+	default:
+	}
+}
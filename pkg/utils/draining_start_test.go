@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestDrainingStart_NotStarted(t *testing.T) {
+	ds := NewDrainingStart(zap.NewNop(), &runnableWrapper{f: blockUntilStopChCloses})
+
+	waited := make(chan struct{})
+	go func() {
+		ds.Wait()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatalf("Wait() should return immediately if the runnable was never started")
+	}
+}
+
+func TestDrainingStart_WaitsForDrain(t *testing.T) {
+	drain := make(chan struct{})
+	ds := NewDrainingStart(zap.NewNop(), &runnableWrapper{f: func(stopCh <-chan struct{}) error {
+		<-stopCh
+		<-drain
+		return nil
+	}})
+
+	stopCh := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		close(started)
+		ds.Start(stopCh)
+	}()
+	<-started
+	// Give Start a chance to run.
+	time.Sleep(50 * time.Millisecond)
+	close(stopCh)
+
+	waited := make(chan struct{})
+	go func() {
+		ds.Wait()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+		t.Fatalf("Wait() returned before the runnable finished draining")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(drain)
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatalf("Wait() did not return after the runnable finished draining")
+	}
+}
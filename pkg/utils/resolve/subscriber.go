@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"strings"
 
 	"github.com/knative/eventing/pkg/apis/eventing/v1alpha1"
 	"github.com/knative/eventing/pkg/logging"
@@ -34,13 +35,18 @@ import (
 	"k8s.io/client-go/dynamic"
 )
 
-// DomainToURL converts a domain into an HTTP URL.
+// DomainToURL converts a domain into an HTTP URL. The domain may be followed by a path, as is the
+// case for path addressed Channels.
 func DomainToURL(domain string) string {
 	u := url.URL{
 		Scheme: "http",
 		Host:   domain,
 		Path:   "/",
 	}
+	if i := strings.Index(domain, "/"); i >= 0 {
+		u.Host = domain[:i]
+		u.Path = domain[i:]
+	}
 	return u.String()
 }
 
@@ -63,6 +63,14 @@ func TestDomainToURL(t *testing.T) {
 	}
 }
 
+func TestDomainToURL_WithPath(t *testing.T) {
+	d := "in-memory-channel-dispatcher.knative-eventing.svc.cluster.local/default/my-channel"
+	e := fmt.Sprintf("http://%s", d)
+	if actual := DomainToURL(d); e != actual {
+		t.Fatalf("Unexpected domain. Expected '%v', actually '%v'", e, actual)
+	}
+}
+
 func TestResourceInterface_BadDynamicInterface(t *testing.T) {
 	actual, err := ResourceInterface(&badDynamicInterface{}, testNS, &corev1.ObjectReference{})
 	if err.Error() != "failed to create dynamic client resource" {